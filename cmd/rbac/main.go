@@ -0,0 +1,84 @@
+// Command rbac applies the roles and permissions policy file to the database
+// and reports drift from it.
+//
+//	rbac reconcile [-file rbac.yaml] [-dry-run] [-prune] [-json]
+//	rbac drift [-file rbac.yaml] [-json]
+//
+// Without -file the policy embedded in the server is used. drift exits with
+// status 1 when the database differs from the file.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/kelseyhightower/envconfig"
+	"go.uber.org/zap"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: rbac reconcile|drift [-file path] [-dry-run] [-prune] [-json]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	file := flags.String("file", os.Getenv("RBAC_POLICY_FILE"), "policy file, defaults to the embedded one")
+	asJSON := flags.Bool("json", false, "print changes as JSON")
+	dryRun := flags.Bool("dry-run", false, "show the changes without applying them")
+	prune := flags.Bool("prune", false, "delete roles and permissions missing from the file")
+	flags.Parse(os.Args[2:])
+
+	policy, err := permissions.LoadPolicyFile(*file)
+	if err != nil {
+		log.Fatalf("Failed to load policy file: %v", err)
+	}
+
+	cfg := &db.Config{}
+	if err := envconfig.Process("", cfg); err != nil {
+		log.Fatalf("Failed to load database configuration: %v", err)
+	}
+	conn, err := db.Connect(cfg, &db.Dependencies{Logger: zap.NewNop()})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	svc := permissions.NewService(conn.Conn)
+
+	var changes []permissions.Change
+	switch command {
+	case "reconcile":
+		changes, err = svc.Reconcile(policy, permissions.ReconcileOptions{DryRun: *dryRun, Prune: *prune})
+	case "drift":
+		changes, err = svc.Drift(policy)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s: %v", command, err)
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"changes": changes})
+	} else {
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if len(changes) == 0 {
+			fmt.Println("No changes")
+		}
+	}
+
+	if command == "drift" && len(changes) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessrequests"
+	"github.com/labstack/echo/v4"
+)
+
+// accessRequestError maps service errors to responses, or returns nil.
+func accessRequestError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, accessrequests.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Access request not found"})
+	case errors.Is(err, accessrequests.ErrRoleNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	case errors.Is(err, accessrequests.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	case errors.Is(err, accessrequests.ErrNoApprovers):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "This role cannot be requested"})
+	case errors.Is(err, accessrequests.ErrAlreadyHeld),
+		errors.Is(err, accessrequests.ErrAlreadyRequested),
+		errors.Is(err, accessrequests.ErrAlreadyApprover),
+		errors.Is(err, accessrequests.ErrNotPending):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, accessrequests.ErrNotApprover), errors.Is(err, accessrequests.ErrSelfApproval):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	return nil
+}
+
+// CreateAccessRequest asks the role's approvers to grant the caller a role.
+func (api *api) CreateAccessRequest(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.CreateAccessRequestRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	duration := time.Duration(req.DurationSecs) * time.Second
+	request, err := api.AccessRequestsSvc.CreateRequest(c.Request().Context(), user, req.RoleID, req.Justification, duration)
+	if resp := accessRequestError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create access request")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"accessRequest": request,
+	})
+}
+
+// GetAccessRequests lists the caller's own requests.
+func (api *api) GetAccessRequests(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	return api.listAccessRequests(c, accessrequests.Filter{UserID: user.ID})
+}
+
+// GetPendingAccessRequests lists pending requests the caller can decide.
+func (api *api) GetPendingAccessRequests(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	return api.listAccessRequests(c, accessrequests.Filter{ApproverID: user.ID, Status: models.AccessRequestPending})
+}
+
+// GetAllAccessRequests is the full history of requests and decisions.
+func (api *api) GetAllAccessRequests(c echo.Context) error {
+	return api.listAccessRequests(c, accessrequests.Filter{})
+}
+
+func (api *api) listAccessRequests(c echo.Context, filter accessrequests.Filter) error {
+	var query validator.AccessRequestQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		return validationFailed(c, err, "Invalid query")
+	}
+	if filter.Status == "" {
+		filter.Status = query.Status
+	}
+	if filter.UserID == 0 {
+		filter.UserID = query.UserID
+	}
+	filter.RoleID = query.RoleID
+
+	requests, err := api.AccessRequestsSvc.GetRequests(filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access requests")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accessRequests": requests,
+	})
+}
+
+func (api *api) ApproveAccessRequest(c echo.Context) error {
+	return api.decideAccessRequest(c, true)
+}
+
+func (api *api) DenyAccessRequest(c echo.Context) error {
+	return api.decideAccessRequest(c, false)
+}
+
+func (api *api) decideAccessRequest(c echo.Context, approve bool) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.AccessDecisionRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	request, err := api.AccessRequestsSvc.Decide(c.Request().Context(), req.ID, user, approve, req.Note)
+	if resp := accessRequestError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decide access request")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accessRequest": request,
+	})
+}
+
+func (api *api) CancelAccessRequest(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid access request ID")
+	}
+
+	err := api.AccessRequestsSvc.Cancel(c.Request().Context(), params.ID, user.ID)
+	if resp := accessRequestError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to cancel access request")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Access request cancelled",
+	})
+}
+
+func (api *api) GetRoleApprovers(c echo.Context) error {
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid role ID")
+	}
+
+	approvers, err := api.AccessRequestsSvc.GetApprovers(params.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get approvers")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"approvers": approvers,
+	})
+}
+
+func (api *api) AddRoleApprover(c echo.Context) error {
+	var req validator.RoleApproverRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	err := api.AccessRequestsSvc.AddApprover(req.ID, req.UserID)
+	if resp := accessRequestError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add approver")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message": "Approver added successfully",
+	})
+}
+
+func (api *api) RemoveRoleApprover(c echo.Context) error {
+	var params validator.RoleApproverParams
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	if err := api.AccessRequestsSvc.RemoveApprover(params.ID, params.UserID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove approver")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Approver removed successfully",
+	})
+}
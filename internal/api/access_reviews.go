@@ -0,0 +1,259 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessreviews"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+// reportSignatureHeader carries the HMAC-SHA256 of an access review report.
+const reportSignatureHeader = "X-Report-Signature"
+
+// accessReviewError maps service errors to responses, or returns nil.
+func accessReviewError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, accessreviews.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Access review not found"})
+	case errors.Is(err, accessreviews.ErrItemNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Access review item not found"})
+	case errors.Is(err, accessreviews.ErrRoleNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	case errors.Is(err, accessreviews.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	case errors.Is(err, accessreviews.ErrNoReviewers), errors.Is(err, accessreviews.ErrInvalidDecision):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, accessreviews.ErrClosed),
+		errors.Is(err, accessreviews.ErrAlreadyDecided),
+		errors.Is(err, accessreviews.ErrAlreadyReviewer):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, permissions.ErrLastAdmin):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "At least one active user must keep system:admin"})
+	case errors.Is(err, accessreviews.ErrNotReviewer), errors.Is(err, accessreviews.ErrSelfReview):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	return nil
+}
+
+// canViewAccessReview lets auditors and the campaign's reviewers see it.
+func (api *api) canViewAccessReview(c echo.Context, reviewID uint) (bool, error) {
+	user := c.Get("user").(*models.User)
+	if user.HasPermission(permissions.PermissionAuditRead) {
+		return true, nil
+	}
+	return api.AccessReviewsSvc.IsReviewer(c.Request().Context(), reviewID, user.ID)
+}
+
+// CreateAccessReview starts a campaign and snapshots the role assignments to
+// review.
+func (api *api) CreateAccessReview(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.CreateAccessReviewRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	review, err := api.AccessReviewsSvc.CreateCampaign(c.Request().Context(), accessreviews.CampaignSpec{
+		Name:        req.Name,
+		RoleID:      req.RoleID,
+		ReviewerIDs: req.ReviewerIDs,
+		AutoRevoke:  req.AutoRevoke,
+		DueAt:       req.DueAt,
+		RepeatDays:  req.RepeatDays,
+	}, user.ID)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create access review")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"accessReview": review,
+	})
+}
+
+// GetAccessReviews lists every campaign.
+func (api *api) GetAccessReviews(c echo.Context) error {
+	return api.listAccessReviews(c, 0)
+}
+
+// GetAssignedAccessReviews lists the campaigns the caller reviews.
+func (api *api) GetAssignedAccessReviews(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	return api.listAccessReviews(c, user.ID)
+}
+
+func (api *api) listAccessReviews(c echo.Context, reviewerID uint) error {
+	var query validator.AccessReviewQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		return validationFailed(c, err, "Invalid query")
+	}
+
+	reviews, err := api.AccessReviewsSvc.GetCampaigns(c.Request().Context(), query.Status, reviewerID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access reviews")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accessReviews": reviews,
+	})
+}
+
+func (api *api) GetAccessReview(c echo.Context) error {
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid access review ID")
+	}
+
+	allowed, err := api.canViewAccessReview(c, params.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access review")
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+	}
+
+	review, err := api.AccessReviewsSvc.GetCampaign(c.Request().Context(), params.ID)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access review")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accessReview": review,
+	})
+}
+
+func (api *api) GetAccessReviewItems(c echo.Context) error {
+	var query validator.AccessReviewItemsQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		return validationFailed(c, err, "Invalid query")
+	}
+
+	allowed, err := api.canViewAccessReview(c, query.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access review items")
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+	}
+
+	items, err := api.AccessReviewsSvc.GetItems(c.Request().Context(), query.ID, query.Decision)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get access review items")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": items,
+	})
+}
+
+// DecideAccessReviewItem keeps or revokes one role assignment.
+func (api *api) DecideAccessReviewItem(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.AccessReviewDecisionRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	item, err := api.AccessReviewsSvc.Decide(c.Request().Context(), req.ID, req.ItemID, user, req.Decision, req.Note)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record decision")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"item": item,
+	})
+}
+
+func (api *api) AddAccessReviewer(c echo.Context) error {
+	var req validator.AccessReviewerRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	err := api.AccessReviewsSvc.AddReviewer(c.Request().Context(), req.ID, req.UserID)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add reviewer")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message": "Reviewer added successfully",
+	})
+}
+
+func (api *api) CloseAccessReview(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid access review ID")
+	}
+
+	review, err := api.AccessReviewsSvc.Close(c.Request().Context(), params.ID, &user.ID)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to close access review")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accessReview": review,
+	})
+}
+
+// GetAccessReviewReport downloads the campaign outcome as a signed CSV.
+func (api *api) GetAccessReviewReport(c echo.Context) error {
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid access review ID")
+	}
+
+	report, signature, err := api.AccessReviewsSvc.Report(c.Request().Context(), params.ID)
+	if resp := accessReviewError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create report")
+	}
+
+	c.Response().Header().Set(reportSignatureHeader, signature)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("access-review-%d.csv", params.ID)))
+	return c.Blob(http.StatusOK, "text/csv", report)
+}
+
+// PostVerifyAccessReviewReport checks that a CSV report was signed by this
+// server and has not been changed.
+func (api *api) PostVerifyAccessReviewReport(c echo.Context) error {
+	signature := c.Request().Header.Get(reportSignatureHeader)
+	if signature == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": reportSignatureHeader + " header required"})
+	}
+
+	report, err := io.ReadAll(io.LimitReader(c.Request().Body, 10<<20))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid report")
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{
+		"valid": api.AccessReviewsSvc.Verify(report, signature),
+	})
+}
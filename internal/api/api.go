@@ -4,17 +4,24 @@ import (
 	"net/http"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/db"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessrequests"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessreviews"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/authentication"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/organizations"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/users"
 	"go.uber.org/zap"
 )
 
 type Config struct {
-	StaticDir      string `envconfig:"STATIC_DIR" default:"/html" required:"true"`
-	Port           string `envconfig:"PORT" required:"true"`
-	SwaggerEnabled bool   `envconfig:"SWAGGER_ENABLED" default:"false"`
-	APIServerHost  string `envconfig:"API_SERVER_HOST" default:""`
+	StaticDir        string `envconfig:"STATIC_DIR" default:"/html" required:"true"`
+	Port             string `envconfig:"PORT" required:"true"`
+	SwaggerEnabled   bool   `envconfig:"SWAGGER_ENABLED" default:"false"`
+	APIServerHost    string `envconfig:"API_SERVER_HOST" default:""`
+	StepUpMaxAgeSecs int    `envconfig:"STEP_UP_MAX_AGE_SEC" default:"300"` // 5 minutes default
+	TenantBaseDomain string `envconfig:"TENANT_BASE_DOMAIN" default:""`     // resolves <slug>.<domain> to an organization
+	RBACPolicyFile   string `envconfig:"RBAC_POLICY_FILE" default:""`       // embedded rbac.yaml when empty
 }
 
 type Dependencies struct {
@@ -23,6 +30,10 @@ type Dependencies struct {
 	AuthenticationSvc authentication.Service
 	UsersSvc          users.Service
 	PermissionsSvc    *permissions.Service
+	AuditSvc          *audit.Service
+	OrganizationsSvc  *organizations.Service
+	AccessRequestsSvc *accessrequests.Service
+	AccessReviewsSvc  *accessreviews.Service
 }
 
 type api struct {
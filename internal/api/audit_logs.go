@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/labstack/echo/v4"
+)
+
+func (api *api) GetAuditLogs(c echo.Context) error {
+	var query validator.AuditLogQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		if validationErr, ok := err.(*validator.ValidationErrors); ok {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error":   "Validation failed",
+				"details": validationErr.Errors,
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid query")
+	}
+
+	filter := audit.Filter{
+		UserID:   query.UserID,
+		Action:   query.Action,
+		Resource: query.Resource,
+		Page:     query.Page,
+		Limit:    query.Limit,
+	}
+
+	if query.StartDate != "" {
+		startDate, _ := time.Parse(time.RFC3339, query.StartDate)
+		filter.StartDate = &startDate
+	}
+	if query.EndDate != "" {
+		endDate, _ := time.Parse(time.RFC3339, query.EndDate)
+		filter.EndDate = &endDate
+	}
+
+	logs, total, err := api.AuditSvc.List(c.Request().Context(), filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit logs")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"auditLogs": logs,
+		"total":     total,
+	})
+}
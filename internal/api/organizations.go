@@ -0,0 +1,243 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/organizations"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+func validationFailed(c echo.Context, err error, message string) error {
+	if validationErr, ok := err.(*validator.ValidationErrors); ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":   "Validation failed",
+			"details": validationErr.Errors,
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, message)
+}
+
+func (api *api) CreateOrganization(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.CreateOrganizationRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	organization, err := api.OrganizationsSvc.CreateOrganization(c.Request().Context(), req.Name, req.Slug, user.ID)
+	if errors.Is(err, organizations.ErrSlugTaken) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Organization slug is already taken"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create organization")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"organization": organization,
+	})
+}
+
+func (api *api) GetOrganizations(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	orgs, err := api.OrganizationsSvc.GetUserOrganizations(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get organizations")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"organizations": orgs,
+	})
+}
+
+// GetOrganization returns the active organization and the caller's
+// permissions in it.
+func (api *api) GetOrganization(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"organization": c.Get("organization"),
+		"permissions":  permissions.EffectivePermissions(c),
+	})
+}
+
+func (api *api) GetOrganizationMembers(c echo.Context) error {
+	members, err := api.OrganizationsSvc.GetMembers(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get members")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"members": members,
+	})
+}
+
+// InviteOrganizationMember invites a user to the active organization. The
+// response is the same whether or not the user exists.
+func (api *api) InviteOrganizationMember(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.InviteMemberRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	err := api.OrganizationsSvc.InviteMember(c.Request().Context(), req.UserID, user.ID)
+	switch {
+	case errors.Is(err, organizations.ErrAlreadyMember):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "User is already a member"})
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to invite member")
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message": "Invitation sent",
+	})
+}
+
+func (api *api) GetInvitations(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	invitations, err := api.OrganizationsSvc.GetInvitations(c.Request().Context(), user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get invitations")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"invitations": invitations,
+	})
+}
+
+func (api *api) AcceptInvitation(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid invitation ID")
+	}
+
+	organization, err := api.OrganizationsSvc.AcceptInvitation(c.Request().Context(), params.ID, user.ID)
+	if errors.Is(err, organizations.ErrInvitationNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Invitation not found"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to accept invitation")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"organization": organization,
+	})
+}
+
+func (api *api) DeclineInvitation(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid invitation ID")
+	}
+
+	err := api.OrganizationsSvc.DeclineInvitation(c.Request().Context(), params.ID, user.ID)
+	if errors.Is(err, organizations.ErrInvitationNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Invitation not found"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decline invitation")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Invitation declined",
+	})
+}
+
+func (api *api) RemoveOrganizationMember(c echo.Context) error {
+	var params validator.UserIDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	err := api.OrganizationsSvc.RemoveMember(c.Request().Context(), params.UserID)
+	if errors.Is(err, organizations.ErrNotMember) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Member not found"})
+	}
+	if lastAdmin(err) {
+		return lastAdminConflict(c, err)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove member")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Member removed successfully",
+	})
+}
+
+// AssignOrganizationRole grants a role within the active organization. Callers
+// can only hand out permissions they hold there themselves.
+func (api *api) AssignOrganizationRole(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var req validator.AssignMemberRoleRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	granted, err := api.OrganizationsSvc.GetRolePermissions(req.RoleID)
+	if errors.Is(err, organizations.ErrRoleNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role")
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), granted) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	err = api.OrganizationsSvc.AssignRole(c.Request().Context(), req.UserID, req.RoleID, user.ID)
+	switch {
+	case errors.Is(err, organizations.ErrNotMember):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Member not found"})
+	case errors.Is(err, organizations.ErrRoleAlreadyHeld):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Member already has this role"})
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Role assigned successfully",
+	})
+}
+
+func (api *api) RemoveOrganizationRole(c echo.Context) error {
+	var params validator.UserRoleParams
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	err := api.OrganizationsSvc.RemoveRole(c.Request().Context(), params.UserID, params.RoleID)
+	if lastAdmin(err) {
+		return lastAdminConflict(c, err)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove role")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Role removed successfully",
+	})
+}
+
+func lastAdmin(err error) bool {
+	return errors.Is(err, permissions.ErrLastAdmin) || errors.Is(err, permissions.ErrLastOrganizationAdmin)
+}
+
+// lastAdminConflict answers a removal refused by permissions.GuardLastAdmin.
+func lastAdminConflict(c echo.Context, err error) error {
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "At least one active user must keep system:admin"})
+	}
+	return c.JSON(http.StatusConflict, map[string]string{"error": "At least one member must keep org:write in the organization"})
+}
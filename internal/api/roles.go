@@ -1,13 +1,45 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/labstack/echo/v4"
 )
 
 
+// roleError maps role management errors to responses, or returns nil.
+func roleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, permissions.ErrRoleNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	case errors.Is(err, permissions.ErrSystemRole):
+		return echo.NewHTTPError(http.StatusForbidden, "System roles cannot be renamed or deleted")
+	case errors.Is(err, permissions.ErrInvalidReassignment):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, permissions.ErrRoleInUse):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Role is still assigned to users; pass reassignTo to move them to another role"})
+	case errors.Is(err, permissions.ErrLastAdmin):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "At least one active user must keep system:admin"})
+	case errors.Is(err, permissions.ErrRoleExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A role with this name already exists"})
+	}
+	return nil
+}
+
+// grantedBy lists the permissions a role grants, directly or inherited.
+func grantedBy(role *models.Role) []string {
+	granted := append([]string{}, role.InheritedPermissions...)
+	for _, rolePermission := range role.Permissions {
+		granted = append(granted, rolePermission.Permission.Name)
+	}
+	return granted
+}
+
 func (api *api) GetRoles(c echo.Context) error {
 	roles, err := api.permissionsService.GetRoles()
 	if err != nil {
@@ -62,6 +94,13 @@ func (api *api) CreateRole(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create role")
 	}
 
+	if req.RequiresMFA != nil {
+		if err := api.permissionsService.SetRoleRequiresMFA(role.ID, *req.RequiresMFA); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create role")
+		}
+		role.RequiresMFA = *req.RequiresMFA
+	}
+
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"role": role,
 	})
@@ -69,7 +108,7 @@ func (api *api) CreateRole(c echo.Context) error {
 
 func (api *api) UpdateRole(c echo.Context) error {
 	var params validator.IDParam
-	if err := c.Bind(&params); err != nil {
+	if err := (&echo.DefaultBinder{}).BindPathParams(c, &params); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
 	}
 	
@@ -89,27 +128,39 @@ func (api *api) UpdateRole(c echo.Context) error {
 	}
 
 	role, err := api.permissionsService.UpdateRole(params.ID, req.Name, req.Description)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update role")
 	}
 
+	if req.RequiresMFA != nil {
+		if err := api.permissionsService.SetRoleRequiresMFA(role.ID, *req.RequiresMFA); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update role")
+		}
+		role.RequiresMFA = *req.RequiresMFA
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"role": role,
 	})
 }
 
+// DeleteRole deletes a custom role. Users still holding it must be moved to
+// another role with ?reassignTo=.
 func (api *api) DeleteRole(c echo.Context) error {
-	var params validator.IDParam
-	if err := c.Bind(&params); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
-	}
-	
-	if err := c.Validate(&params); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid role ID")
+	var req validator.DeleteRoleRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
 	}
 
-	if err := api.permissionsService.DeleteRole(params.ID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	err := api.permissionsService.DeleteRole(req.ID, req.ReassignTo)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete role")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -117,6 +168,87 @@ func (api *api) DeleteRole(c echo.Context) error {
 	})
 }
 
+// AddParentRole makes a role inherit another role's permissions. Callers can
+// only pass on permissions they hold themselves.
+func (api *api) AddParentRole(c echo.Context) error {
+	var req validator.AddParentRoleRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	parent, err := api.permissionsService.GetRoleByID(req.ParentRoleID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add parent role")
+	}
+	if parent == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), grantedBy(parent)) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	err = api.permissionsService.AddParentRole(req.ID, req.ParentRoleID)
+	switch {
+	case errors.Is(err, permissions.ErrRoleNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	case errors.Is(err, permissions.ErrInheritanceCycle):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Role inheritance would create a cycle"})
+	case errors.Is(err, permissions.ErrAlreadyInherited):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Role already inherits from this role"})
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add parent role")
+	}
+
+	role, err := api.permissionsService.GetRoleByID(req.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"role": role,
+	})
+}
+
+func (api *api) RemoveParentRole(c echo.Context) error {
+	var params validator.RoleParentParams
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	err := api.permissionsService.RemoveParentRole(params.ID, params.ParentID)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove parent role")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Parent role removed successfully",
+	})
+}
+
+// roleGrant builds the validity window of a role assignment; DurationSecs
+// counts from ValidFrom, or from now when it is unset.
+func roleGrant(assignedBy uint, validFrom, validUntil *time.Time, durationSecs int) permissions.RoleGrant {
+	grant := permissions.RoleGrant{
+		AssignedBy: assignedBy,
+		ValidFrom:  validFrom,
+		ValidUntil: validUntil,
+	}
+	if durationSecs > 0 {
+		start := time.Now()
+		if validFrom != nil {
+			start = *validFrom
+		}
+		until := start.Add(time.Duration(durationSecs) * time.Second)
+		grant.ValidUntil = &until
+	}
+	return grant
+}
+
+// AssignRoleToUser grants a role to a user. Callers must hold everything the
+// role grants.
 func (api *api) AssignRoleToUser(c echo.Context) error {
 	var req validator.AssignRoleRequest
 	if err := validator.BindAndValidate(c, &req); err != nil {
@@ -129,8 +261,27 @@ func (api *api) AssignRoleToUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
 	}
 
-	if err := api.permissionsService.AssignRoleToUser(req.UserID, req.RoleID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	role, err := api.permissionsService.GetRoleByID(req.RoleID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role")
+	}
+	if role == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), grantedBy(role)) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	caller := c.Get("user").(*models.User)
+	grant := roleGrant(caller.ID, req.ValidFrom, req.ValidUntil, req.DurationSecs)
+	err = api.permissionsService.GrantRole(req.UserID, req.RoleID, grant)
+	switch {
+	case errors.Is(err, permissions.ErrInvalidValidity):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role assignment must end after it starts"})
+	case errors.Is(err, permissions.ErrRoleAlreadyAssigned):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "User already has this role"})
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role")
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -154,7 +305,11 @@ func (api *api) RemoveRoleFromUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
 	}
 
-	if err := api.permissionsService.RemoveRoleFromUser(params.UserID, params.RoleID); err != nil {
+	err := api.permissionsService.RemoveRoleFromUser(params.UserID, params.RoleID)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove role")
 	}
 
@@ -186,6 +341,17 @@ func (api *api) AssignPermissionToRole(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
 	}
 
+	perm, err := api.permissionsService.GetPermissionByID(req.PermissionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign permission")
+	}
+	if perm == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Permission not found"})
+	}
+	if !permissions.HasPermission(permissions.EffectivePermissions(c), perm.Name) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
 	if err := api.permissionsService.AssignPermissionToRole(req.RoleID, req.PermissionID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign permission")
 	}
@@ -211,7 +377,11 @@ func (api *api) RemovePermissionFromRole(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
 	}
 
-	if err := api.permissionsService.RemovePermissionFromRole(params.RoleID, params.PermissionID); err != nil {
+	err := api.permissionsService.RemovePermissionFromRole(params.RoleID, params.PermissionID)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove permission")
 	}
 
@@ -258,4 +428,62 @@ func (api *api) GetUserPermissions(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"permissions": permissions,
 	})
-}
\ No newline at end of file
+}
+
+// ExplainUserPermission lists every role path that grants, or would grant,
+// the user a permission, so a 403 can be traced to its cause.
+func (api *api) ExplainUserPermission(c echo.Context) error {
+	var query validator.ExplainPermissionQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		return validationFailed(c, err, "Invalid query")
+	}
+
+	explanation, err := api.permissionsService.Explain(query.ID, query.Permission, query.OrganizationID)
+	if errors.Is(err, permissions.ErrUserNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to explain permission")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"explanation": explanation,
+	})
+}
+
+// GetPermissionHolders lists the users who currently have a permission.
+func (api *api) GetPermissionHolders(c echo.Context) error {
+	var query validator.WhoCanQuery
+	if err := validator.BindAndValidate(c, &query); err != nil {
+		return validationFailed(c, err, "Invalid query")
+	}
+
+	holders, err := api.permissionsService.WhoCan(query.Permission, query.OrganizationID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get permission holders")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"permission": query.Permission,
+		"users":      holders,
+	})
+}
+
+// GetRBACDrift lists how roles and permissions differ from the policy file,
+// typically because they were changed through the API.
+func (api *api) GetRBACDrift(c echo.Context) error {
+	file, err := permissions.LoadPolicyFile(api.RBACPolicyFile)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load policy file")
+	}
+
+	changes, err := api.permissionsService.Drift(file)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check drift")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"drifted": len(changes) > 0,
+		"changes": changes,
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+// bulkResponse reports the per-item results of a bulk operation. A rejected
+// operation changed nothing and is answered with 422.
+func bulkResponse(c echo.Context, results []permissions.BulkResult, err error, message string) error {
+	if errors.Is(err, permissions.ErrBulkRejected) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "Some items are invalid; nothing was changed",
+			"applied": false,
+			"results": results,
+		})
+	}
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, message)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"applied": true,
+		"results": results,
+	})
+}
+
+// SetRolePermissions replaces a role's permission list. Callers can only add
+// permissions they hold themselves.
+func (api *api) SetRolePermissions(c echo.Context) error {
+	var req validator.SetRolePermissionsRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	role, err := api.permissionsService.GetRoleByID(req.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set role permissions")
+	}
+	if role == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	catalog, err := api.permissionsService.GetPermissions()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set role permissions")
+	}
+	known := make(map[string]bool, len(catalog))
+	for _, permission := range catalog {
+		known[permission.Name] = true
+	}
+	held := make(map[string]bool, len(role.Permissions))
+	for _, rolePermission := range role.Permissions {
+		held[rolePermission.Permission.Name] = true
+	}
+	// Unknown names are left to the service, which reports them per item.
+	var added []string
+	for _, name := range req.Permissions {
+		if known[name] && !held[name] {
+			added = append(added, name)
+		}
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), added) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	results, err := api.permissionsService.SetRolePermissions(req.ID, req.Permissions)
+	return bulkResponse(c, results, err, "Failed to set role permissions")
+}
+
+// AssignRoleToUsers grants a role to many users with one validity window.
+// Callers must hold everything the role grants.
+func (api *api) AssignRoleToUsers(c echo.Context) error {
+	var req validator.BulkRoleUsersRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	role, err := api.permissionsService.GetRoleByID(req.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign role")
+	}
+	if role == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), grantedBy(role)) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	caller := c.Get("user").(*models.User)
+	grant := roleGrant(caller.ID, req.ValidFrom, req.ValidUntil, req.DurationSecs)
+	results, err := api.permissionsService.AssignRoleToUsers(req.ID, req.UserIDs, grant)
+	if errors.Is(err, permissions.ErrInvalidValidity) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role assignment must end after it starts"})
+	}
+	return bulkResponse(c, results, err, "Failed to assign role")
+}
+
+func (api *api) RemoveRoleFromUsers(c echo.Context) error {
+	var req validator.BulkRemoveRoleUsersRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	results, err := api.permissionsService.RemoveRoleFromUsers(req.ID, req.UserIDs)
+	return bulkResponse(c, results, err, "Failed to remove role")
+}
+
+// CloneRole copies a role's permissions and parents into a new role. Callers
+// must hold everything the source role grants.
+func (api *api) CloneRole(c echo.Context) error {
+	var req validator.CloneRoleRequest
+	if err := validator.BindAndValidate(c, &req); err != nil {
+		return validationFailed(c, err, "Invalid request")
+	}
+
+	source, err := api.permissionsService.GetRoleByID(req.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clone role")
+	}
+	if source == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if !permissions.HasAllPermissions(permissions.EffectivePermissions(c), grantedBy(source)) {
+		return echo.NewHTTPError(http.StatusForbidden, "You cannot grant permissions you do not hold")
+	}
+
+	role, results, err := api.permissionsService.CloneRole(req.ID, req.Name, req.Description)
+	if resp := roleError(c, err); resp != nil {
+		return resp
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clone role")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"role":    role,
+		"results": results,
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAssignPermissionToRole(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	if err := db.AutoMigrate(&models.Role{}, &models.Permission{}, &models.RolePermission{}); err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	roleWrite := models.Permission{Name: permissions.PermissionRoleWrite}
+	userDelete := models.Permission{Name: permissions.PermissionUserDelete}
+	db.Create(&roleWrite)
+	db.Create(&userDelete)
+	editor := models.Role{Name: "Editor"}
+	db.Create(&editor)
+
+	a := &api{permissionsService: permissions.NewService(db)}
+	e := echo.New()
+	e.Validator = validator.NewValidator()
+
+	// The caller only holds role:write.
+	caller := &models.User{ID: 1, UserRoles: []models.UserRole{{
+		Role: models.Role{Permissions: []models.RolePermission{{Permission: roleWrite}}},
+	}}}
+
+	assign := func(permissionID uint) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"roleId": %d, "permissionId": %d}`, editor.ID, permissionID)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/role-permissions/assign", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user", caller)
+		if err := a.AssignPermissionToRole(c); err != nil {
+			e.HTTPErrorHandler(err, c)
+		}
+		return rec
+	}
+
+	if rec := assign(userDelete.ID); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 granting a permission the caller lacks, got %d", rec.Code)
+	}
+	if rec := assign(999); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown permission, got %d", rec.Code)
+	}
+	if rec := assign(roleWrite.ID); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 granting a held permission, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int64
+	db.Model(&models.RolePermission{}).Where("role_id = ?", editor.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("Expected the role to have 1 permission, got %d", count)
+	}
+}
@@ -2,7 +2,9 @@ package api
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
 	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/labstack/echo/v4"
@@ -15,6 +17,7 @@ func (a *api) configureRoutes() http.Handler {
 	
 	e.Validator = validator.NewValidator()
 	
+	e.Use(logger.RequestLoggerMiddleware())
 	e.Use(validator.ErrorHandlerMiddleware(a.Logger))
 	e.Use(validator.RequestLoggingMiddleware(a.Logger))
 	e.Use(validator.SecurityValidationMiddleware())
@@ -28,30 +31,127 @@ func (a *api) configureRoutes() http.Handler {
 	v1Auth.POST("/logout", a.AuthenticationSvc.PostSignOut)
 	v1Auth.POST("/refresh-token", a.AuthenticationSvc.PostRefreshToken)
 	v1Auth.POST("/signup", a.AuthenticationSvc.PostSignUp)
+	v1Auth.POST("/forgot-password", a.AuthenticationSvc.PostForgotPassword)
+	v1Auth.POST("/reset-password", a.AuthenticationSvc.PostResetPassword)
+	v1Auth.POST("/verify-2fa", a.AuthenticationSvc.PostVerify2FA)
+	v1Auth.POST("/2fa/email/resend", a.AuthenticationSvc.PostResendEmailOTP)
+	v1Auth.GET("/sso/login", a.AuthenticationSvc.GetSSOLogin)
+	v1Auth.GET("/sso/callback", a.AuthenticationSvc.GetSSOCallback)
+	v1Auth.POST("/webauthn/login/begin", a.AuthenticationSvc.PostWebAuthnLoginBegin)
+	v1Auth.POST("/webauthn/login/finish", a.AuthenticationSvc.PostWebAuthnLoginFinish)
 
 	authMW := a.AuthenticationSvc.AuthenticationMiddleware()
+	recentAuth := permissions.RequireRecentAuth(time.Duration(a.StepUpMaxAgeSecs) * time.Second)
 
 	v1 := e.Group("/api/v1", authMW)
 
-	v1.GET("/user/profile", a.UsersSvc.GetUserProfile)
+	v1.POST("/auth/step-up", a.AuthenticationSvc.PostStepUp)
+	v1.POST("/auth/impersonation/stop", a.AuthenticationSvc.PostStopImpersonation)
+	// Account self-service is for interactive sessions only; API keys are
+	// scoped to permissions and none of these routes need one.
+	user := v1.Group("/user", permissions.RejectAPIKeys())
+	user.GET("/profile", a.UsersSvc.GetUserProfile)
+	user.POST("/password", a.AuthenticationSvc.PostChangePassword)
+	user.GET("/export", a.UsersSvc.GetDataExport, recentAuth)
+	user.POST("/erase", a.UsersSvc.PostEraseMe, recentAuth)
+	user.GET("/sessions", a.AuthenticationSvc.GetSessions)
+	user.DELETE("/sessions", a.AuthenticationSvc.DeleteSessions)
+	user.DELETE("/sessions/:id", a.AuthenticationSvc.DeleteSession)
+	user.GET("/api-keys", a.AuthenticationSvc.GetAPIKeys)
+	user.POST("/api-keys", a.AuthenticationSvc.PostCreateAPIKey)
+	user.DELETE("/api-keys/:id", a.AuthenticationSvc.DeleteAPIKey)
+	user.POST("/2fa/enable", a.AuthenticationSvc.PostEnable2FA)
+	user.POST("/2fa/confirm", a.AuthenticationSvc.PostConfirm2FA)
+	user.POST("/2fa/disable", a.AuthenticationSvc.PostDisable2FA)
+	user.POST("/2fa/backup-codes", a.AuthenticationSvc.PostRegenerateBackupCodes)
+	user.POST("/2fa/email/send", a.AuthenticationSvc.PostSendEmailOTP)
+	user.POST("/webauthn/register/begin", a.AuthenticationSvc.PostWebAuthnRegisterBegin, recentAuth)
+	user.POST("/webauthn/register/finish", a.AuthenticationSvc.PostWebAuthnRegisterFinish, recentAuth)
+	user.GET("/webauthn/credentials", a.AuthenticationSvc.GetWebAuthnCredentials)
+	user.PATCH("/webauthn/credentials/:id", a.AuthenticationSvc.PatchWebAuthnCredential)
+	user.DELETE("/webauthn/credentials/:id", a.AuthenticationSvc.DeleteWebAuthnCredential)
+	user.GET("/invitations", a.GetInvitations)
+	user.POST("/invitations/:id/accept", a.AcceptInvitation)
+	user.DELETE("/invitations/:id", a.DeclineInvitation)
+
+	users := v1.Group("/users")
+	users.DELETE("/:id", a.UsersSvc.DeleteUser, permissions.RequirePermission(permissions.PermissionUserDelete), recentAuth)
+	users.POST("/:id/restore", a.UsersSvc.PostRestoreUser, permissions.RequirePermission(permissions.PermissionUserDelete), recentAuth)
+	users.POST("/:id/deactivate", a.UsersSvc.PostDeactivateUser, permissions.RequirePermission(permissions.PermissionUserWrite), recentAuth)
+	users.POST("/:id/erase", a.UsersSvc.PostEraseUser, permissions.RequirePermission(permissions.PermissionUserDelete), recentAuth)
+	users.POST("/:id/activate", a.UsersSvc.PostActivateUser, permissions.RequirePermission(permissions.PermissionUserWrite), recentAuth)
+	users.POST("/:id/impersonate", a.AuthenticationSvc.PostImpersonate, permissions.RequirePermission(permissions.PermissionSystemAdmin), recentAuth)
+	users.GET("/:id/permissions/explain", a.ExplainUserPermission, permissions.RequireAuthorized(permissions.PermissionUserRead, permissions.UserParamResource))
+	users.GET("/:id/sessions", a.AuthenticationSvc.GetUserSessions, permissions.RequireAuthorized(permissions.PermissionUserRead, permissions.UserParamResource))
+	users.DELETE("/:id/sessions", a.AuthenticationSvc.DeleteUserSessions, permissions.RequireAdminOrOwner())
+	users.DELETE("/:id/sessions/:sessionId", a.AuthenticationSvc.DeleteUserSession, permissions.RequireAdminOrOwner())
 
 	roles := v1.Group("/roles", permissions.RequirePermission(permissions.PermissionRoleRead))
 	roles.GET("", a.GetRoles)
 	roles.GET("/:id", a.GetRole)
 	roles.POST("", a.CreateRole, permissions.RequirePermission(permissions.PermissionRoleWrite))
 	roles.PUT("/:id", a.UpdateRole, permissions.RequirePermission(permissions.PermissionRoleWrite))
-	roles.DELETE("/:id", a.DeleteRole, permissions.RequirePermission(permissions.PermissionRoleDelete))
+	roles.DELETE("/:id", a.DeleteRole, permissions.RequirePermission(permissions.PermissionRoleDelete), recentAuth)
+	roles.POST("/:id/parents", a.AddParentRole, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	roles.DELETE("/:id/parents/:parentId", a.RemoveParentRole, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	roles.PUT("/:id/permissions", a.SetRolePermissions, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	roles.POST("/:id/users", a.AssignRoleToUsers, permissions.RequirePermission(permissions.PermissionUserWrite), recentAuth)
+	roles.POST("/:id/users/remove", a.RemoveRoleFromUsers, permissions.RequirePermission(permissions.PermissionUserWrite), recentAuth)
+	roles.POST("/:id/clone", a.CloneRole, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	roles.GET("/:id/approvers", a.GetRoleApprovers)
+	roles.POST("/:id/approvers", a.AddRoleApprover, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	roles.DELETE("/:id/approvers/:userId", a.RemoveRoleApprover, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
 
 	perms := v1.Group("/permissions", permissions.RequirePermission(permissions.PermissionRoleRead))
 	perms.GET("", a.GetPermissions)
+	perms.GET("/drift", a.GetRBACDrift)
+	perms.GET("/who-can", a.GetPermissionHolders, permissions.RequirePermission(permissions.PermissionUserRead))
 
 	userRoles := v1.Group("/user-roles", permissions.RequirePermission(permissions.PermissionUserWrite))
-	userRoles.POST("/assign", a.AssignRoleToUser)
-	userRoles.DELETE("/user/:userId/role/:roleId", a.RemoveRoleFromUser)
+	userRoles.POST("/assign", a.AssignRoleToUser, recentAuth)
+	userRoles.DELETE("/user/:userId/role/:roleId", a.RemoveRoleFromUser, recentAuth)
 	userRoles.GET("/user/:userId", a.GetUserRoles, permissions.RequirePermission(permissions.PermissionUserRead))
 	userRoles.GET("/user/:userId/permissions", a.GetUserPermissions, permissions.RequirePermission(permissions.PermissionUserRead))
 
-	rolePerms := v1.Group("/role-permissions", permissions.RequirePermission(permissions.PermissionRoleWrite))
+	accessRequests := v1.Group("/access-requests", permissions.RejectAPIKeys())
+	accessRequests.POST("", a.CreateAccessRequest)
+	accessRequests.GET("", a.GetAccessRequests)
+	accessRequests.GET("/pending", a.GetPendingAccessRequests)
+	accessRequests.GET("/all", a.GetAllAccessRequests, permissions.RequirePermission(permissions.PermissionAuditRead))
+	accessRequests.POST("/:id/approve", a.ApproveAccessRequest, recentAuth)
+	accessRequests.POST("/:id/deny", a.DenyAccessRequest)
+	accessRequests.POST("/:id/cancel", a.CancelAccessRequest)
+
+	accessReviews := v1.Group("/access-reviews")
+	accessReviews.POST("", a.CreateAccessReview, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	accessReviews.GET("", a.GetAccessReviews, permissions.RequirePermission(permissions.PermissionAuditRead))
+	accessReviews.GET("/assigned", a.GetAssignedAccessReviews)
+	accessReviews.POST("/verify", a.PostVerifyAccessReviewReport, permissions.RequirePermission(permissions.PermissionAuditRead))
+	accessReviews.GET("/:id", a.GetAccessReview)
+	accessReviews.GET("/:id/items", a.GetAccessReviewItems)
+	accessReviews.POST("/:id/items/:itemId/decision", a.DecideAccessReviewItem, recentAuth)
+	accessReviews.POST("/:id/reviewers", a.AddAccessReviewer, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	accessReviews.POST("/:id/close", a.CloseAccessReview, permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
+	accessReviews.GET("/:id/report", a.GetAccessReviewReport, permissions.RequirePermission(permissions.PermissionAuditRead))
+
+	v1.GET("/audit-logs", a.GetAuditLogs, permissions.RequirePermission(permissions.PermissionAuditRead))
+
+	v1.GET("/organizations", a.GetOrganizations)
+	v1.POST("/organizations", a.CreateOrganization, permissions.RejectAPIKeys(), permissions.RequirePermission(permissions.PermissionOrgWrite))
+
+	// The same organization routes are served with the organization in the
+	// path, and for the X-Organization-ID header or subdomain.
+	tenant := a.OrganizationsSvc.ResolveOrganization(a.TenantBaseDomain)
+	for _, org := range []*echo.Group{v1.Group("/organizations/:orgId", tenant), v1.Group("/organization", tenant)} {
+		org.GET("", a.GetOrganization)
+		org.GET("/members", a.GetOrganizationMembers, permissions.RequirePermission(permissions.PermissionOrgRead))
+		org.POST("/members", a.InviteOrganizationMember, permissions.RequirePermission(permissions.PermissionOrgWrite))
+		org.DELETE("/members/:userId", a.RemoveOrganizationMember, permissions.RequirePermission(permissions.PermissionOrgWrite), recentAuth)
+		org.POST("/members/:userId/roles", a.AssignOrganizationRole, permissions.RequirePermission(permissions.PermissionOrgWrite), recentAuth)
+		org.DELETE("/members/:userId/roles/:roleId", a.RemoveOrganizationRole, permissions.RequirePermission(permissions.PermissionOrgWrite), recentAuth)
+	}
+
+	rolePerms := v1.Group("/role-permissions", permissions.RequirePermission(permissions.PermissionRoleWrite), recentAuth)
 	rolePerms.POST("/assign", a.AssignPermissionToRole)
 	rolePerms.DELETE("/role/:roleId/permission/:permissionId", a.RemovePermissionFromRole)
 
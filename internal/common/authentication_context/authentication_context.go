@@ -7,6 +7,7 @@ import (
 
 type UserContext struct {
 	UserID         float64 `json:"userId"`
+	ImpersonatorID float64 `json:"impersonatorId,omitempty"`
 }
 type userContextKeyType string
 
@@ -41,3 +42,10 @@ func SetUserContext(req *http.Request, userID float64) (*http.Request, error) {
 	req = req.Clone(context.WithValue(req.Context(), userContextKey, euc))
 	return req, nil
 }
+
+// SetImpersonatorID records the administrator acting as the request's user.
+func SetImpersonatorID(ctx context.Context, impersonatorID float64) {
+	if euc := ParseUserContext(ctx); euc != nil {
+		euc.ImpersonatorID = impersonatorID
+	}
+}
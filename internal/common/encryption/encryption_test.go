@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := New("test-key")
+
+	ciphertext, err := c.Encrypt("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if ciphertext == "JBSWY3DPEHPK3PXP" {
+		t.Fatal("Expected ciphertext to differ from plaintext")
+	}
+
+	other, _ := c.Encrypt("JBSWY3DPEHPK3PXP")
+	if other == ciphertext {
+		t.Fatal("Expected a fresh nonce for every encryption")
+	}
+
+	plaintext, err := c.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if plaintext != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Expected original plaintext, got %s", plaintext)
+	}
+}
+
+func TestDecryptRejectsInvalidInput(t *testing.T) {
+	c := New("test-key")
+	ciphertext, _ := c.Encrypt("secret")
+
+	tests := map[string]string{
+		"wrong key":  "",
+		"plaintext":  "JBSWY3DPEHPK3PXP",
+		"bad base64": "v1:!!!",
+		"too short":  "v1:AAAA",
+		"tampered":   ciphertext[:len(ciphertext)-2] + "AA",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			decrypter := c
+			if name == "wrong key" {
+				decrypter = New("other-key")
+				input = ciphertext
+			}
+
+			if _, err := decrypter.Decrypt(input); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Fatalf("Expected ErrInvalidCiphertext, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Every runs fn each interval until ctx is cancelled. Failures are logged and
+// do not stop the schedule.
+func Every(ctx context.Context, lgr *zap.Logger, name string, interval time.Duration, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fn(ctx); err != nil {
+				lgr.Error("background job failed", zap.String("job", name), zap.Error(err))
+			}
+		}
+	}
+}
@@ -27,8 +27,9 @@ type hTTPRequestLogContext struct {
 	ID           string
 	Method       string
 	URL          string
-	ProjectID    string
-	ClientIPAddr string
+	ProjectID      string
+	OrganizationID string
+	ClientIPAddr   string
 }
 
 func RequestLoggerMiddleware() echo.MiddlewareFunc {
@@ -64,6 +65,14 @@ func RequestLoggerMiddleware() echo.MiddlewareFunc {
 	}
 }
 
+// SetOrganizationID records the request's active organization so that
+// ContextLogger includes it.
+func SetOrganizationID(ctx context.Context, organizationID uint) {
+	if lCtx, ok := ctx.Value(requestLogContextKey).(*hTTPRequestLogContext); ok {
+		lCtx.OrganizationID = fmt.Sprint(organizationID)
+	}
+}
+
 func ContextLogger(ctx context.Context, lgr *zap.Logger) *zap.Logger {
 	if lgr == nil {
 		return lgr
@@ -90,6 +99,10 @@ func ContextLogger(ctx context.Context, lgr *zap.Logger) *zap.Logger {
 			fields = append(fields, zap.String("request_project_id", lCtx.ProjectID))
 		}
 
+		if lCtx.OrganizationID != "" {
+			fields = append(fields, zap.String("request_organization_id", lCtx.OrganizationID))
+		}
+
 		if lCtx.ClientIPAddr != "" {
 			fields = append(fields, zap.String("request_client_ip", lCtx.ClientIPAddr))
 		}
@@ -99,6 +112,9 @@ func ContextLogger(ctx context.Context, lgr *zap.Logger) *zap.Logger {
 	if usrCtx != nil {
 		usrID := fmt.Sprintf("%+v", usrCtx.UserID)
 		fields = append(fields, zap.String("request_user_id", usrID))
+		if usrCtx.ImpersonatorID != 0 {
+			fields = append(fields, zap.String("request_impersonator_id", fmt.Sprintf("%+v", usrCtx.ImpersonatorID)))
+		}
 	}
 
 	return lgr.With(fields...)
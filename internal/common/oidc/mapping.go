@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RoleMappings maps claim values such as IdP group names to role names. It
+// decodes from "value=Role;other value=Other Role" so values may contain
+// commas and equals signs (LDAP DNs); a value may be listed more than once to
+// grant several roles.
+type RoleMappings map[string][]string
+
+func (m *RoleMappings) Decode(value string) error {
+	mappings := RoleMappings{}
+
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		idx := strings.LastIndex(rule, "=")
+		if idx <= 0 || idx == len(rule)-1 {
+			return fmt.Errorf("invalid role mapping %q, expected claim value=role name", rule)
+		}
+
+		claimValue := strings.TrimSpace(rule[:idx])
+		roleName := strings.TrimSpace(rule[idx+1:])
+		if claimValue == "" || roleName == "" {
+			return fmt.Errorf("invalid role mapping %q, expected claim value=role name", rule)
+		}
+
+		mappings[claimValue] = append(mappings[claimValue], roleName)
+	}
+
+	*m = mappings
+	return nil
+}
+
+// Roles returns the sorted, de-duplicated role names granted by values.
+func (m RoleMappings) Roles(values []string) []string {
+	seen := map[string]bool{}
+	roles := []string{}
+
+	for _, value := range values {
+		for _, role := range m[value] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	sort.Strings(roles)
+	return roles
+}
+
+// ClaimValues returns a claim as a list of strings, accepting both a single
+// string and an array of strings as providers differ in how they send groups.
+func (c *IDTokenClaims) ClaimValues(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
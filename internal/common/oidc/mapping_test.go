@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoleMappingsDecode(t *testing.T) {
+	t.Run("parses rules", func(t *testing.T) {
+		var m RoleMappings
+		err := m.Decode("engineering=Team Account; cn=admins,ou=groups,dc=example=Admin;cn=admins,ou=groups,dc=example=Team Account")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := RoleMappings{
+			"engineering":                    {"Team Account"},
+			"cn=admins,ou=groups,dc=example": {"Admin", "Team Account"},
+		}
+		if !reflect.DeepEqual(m, expected) {
+			t.Fatalf("Expected %v, got %v", expected, m)
+		}
+	})
+
+	t.Run("empty value", func(t *testing.T) {
+		var m RoleMappings
+		if err := m.Decode(""); err != nil || len(m) != 0 {
+			t.Fatalf("Expected empty mappings, got %v, %v", m, err)
+		}
+	})
+
+	for _, invalid := range []string{"engineering", "=Admin", "engineering="} {
+		t.Run("invalid "+invalid, func(t *testing.T) {
+			var m RoleMappings
+			if err := m.Decode(invalid); err == nil {
+				t.Fatalf("Expected error for %q", invalid)
+			}
+		})
+	}
+}
+
+func TestRoleMappingsRoles(t *testing.T) {
+	m := RoleMappings{
+		"admins":      {"Admin"},
+		"engineering": {"Team Account", "Admin"},
+	}
+
+	roles := m.Roles([]string{"engineering", "admins", "unmapped"})
+	expected := []string{"Admin", "Team Account"}
+	if !reflect.DeepEqual(roles, expected) {
+		t.Fatalf("Expected %v, got %v", expected, roles)
+	}
+
+	if roles := m.Roles(nil); len(roles) != 0 {
+		t.Fatalf("Expected no roles, got %v", roles)
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	claims := &IDTokenClaims{Raw: map[string]interface{}{
+		"groups": []interface{}{"a", "b", 3},
+		"role":   "admin",
+	}}
+
+	if v := claims.ClaimValues("groups"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatalf("Expected [a b], got %v", v)
+	}
+	if v := claims.ClaimValues("role"); !reflect.DeepEqual(v, []string{"admin"}) {
+		t.Fatalf("Expected [admin], got %v", v)
+	}
+	if v := claims.ClaimValues("missing"); v != nil {
+		t.Fatalf("Expected nil, got %v", v)
+	}
+}
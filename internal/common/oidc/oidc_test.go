@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/oidc/oidctest"
+)
+
+func setupTestClient(t *testing.T) (*Client, *oidctest.Provider) {
+	provider := oidctest.NewProvider(t, "test-client")
+
+	client, err := Discover(context.Background(), &Config{
+		IssuerURL:   provider.Issuer(),
+		ClientID:    "test-client",
+		RedirectURL: "http://app.test/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	}, provider.Server.Client())
+	if err != nil {
+		t.Fatalf("Expected discovery to succeed, got %v", err)
+	}
+
+	return client, provider
+}
+
+// authorize follows the authorization request and returns the code the
+// provider redirected back with.
+func authorize(t *testing.T, client *Client, state, nonce, verifier string) string {
+	httpClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := httpClient.Get(client.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatalf("Authorization request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect, got status %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect location: %v", err)
+	}
+
+	if location.Query().Get("state") != state {
+		t.Fatalf("Expected state %s, got %s", state, location.Query().Get("state"))
+	}
+
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	client, provider := setupTestClient(t)
+	ctx := context.Background()
+
+	provider.SetUser(map[string]interface{}{
+		"sub":            "user-123",
+		"email":          "sso@example.com",
+		"email_verified": true,
+		"name":           "SSO User",
+		"groups":         []string{"engineering"},
+	})
+
+	t.Run("successful login", func(t *testing.T) {
+		verifier, _ := RandomString(32)
+		code := authorize(t, client, "state-1", "nonce-1", verifier)
+
+		tkns, err := client.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatalf("Expected exchange to succeed, got %v", err)
+		}
+
+		claims, err := client.VerifyIDToken(ctx, tkns.IDToken, "nonce-1")
+		if err != nil {
+			t.Fatalf("Expected id token to verify, got %v", err)
+		}
+
+		if claims.Subject != "user-123" || claims.Email != "sso@example.com" || !claims.EmailVerified {
+			t.Fatalf("Unexpected claims: %+v", claims)
+		}
+
+		if claims.Issuer != provider.Issuer() {
+			t.Fatalf("Expected issuer %s, got %s", provider.Issuer(), claims.Issuer)
+		}
+
+		if _, ok := claims.Raw["groups"]; !ok {
+			t.Fatal("Expected raw claims to include groups")
+		}
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		verifier, _ := RandomString(32)
+		code := authorize(t, client, "state-2", "nonce-2", verifier)
+
+		if _, err := client.Exchange(ctx, code, "not-the-verifier"); err == nil {
+			t.Fatal("Expected exchange with wrong verifier to fail")
+		}
+	})
+
+	t.Run("code is single use", func(t *testing.T) {
+		verifier, _ := RandomString(32)
+		code := authorize(t, client, "state-3", "nonce-3", verifier)
+
+		if _, err := client.Exchange(ctx, code, verifier); err != nil {
+			t.Fatalf("Expected first exchange to succeed, got %v", err)
+		}
+		if _, err := client.Exchange(ctx, code, verifier); err == nil {
+			t.Fatal("Expected second exchange to fail")
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		verifier, _ := RandomString(32)
+		code := authorize(t, client, "state-4", "nonce-4", verifier)
+
+		tkns, err := client.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatalf("Expected exchange to succeed, got %v", err)
+		}
+
+		if _, err := client.VerifyIDToken(ctx, tkns.IDToken, "other-nonce"); !errors.Is(err, ErrNonceMismatch) {
+			t.Fatalf("Expected nonce mismatch, got %v", err)
+		}
+	})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	client, provider := setupTestClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"wrong audience", map[string]interface{}{"sub": "u", "nonce": "n", "aud": "other-client"}},
+		{"wrong issuer", map[string]interface{}{"sub": "u", "nonce": "n", "iss": "https://evil.example.com"}},
+		{"expired", map[string]interface{}{"sub": "u", "nonce": "n", "exp": time.Now().Add(-time.Minute).Unix()}},
+		{"missing subject", map[string]interface{}{"nonce": "n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.VerifyIDToken(ctx, provider.SignIDToken(tt.claims), "n"); !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("Expected invalid id token, got %v", err)
+			}
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		if _, err := client.VerifyIDToken(ctx, provider.SignIDToken(map[string]interface{}{"sub": "u", "nonce": "n"}), "n"); err != nil {
+			t.Fatalf("Expected valid token, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// breachPrefixLength matches the range API of Have I Been Pwned, so a
+// BreachFile only ever looks up the first five hex characters of a hash.
+const breachPrefixLength = 5
+
+// BreachFile looks passwords up in a local copy of a breached password
+// corpus: upper case SHA-1 hashes sorted ascending, one "HASH:COUNT" per line,
+// as produced by the haveibeenpwned downloader. The file is searched on disk
+// rather than loaded, so multi-gigabyte corpora are fine.
+type BreachFile struct {
+	file *os.File
+	size int64
+}
+
+func OpenBreachFile(path string) (*BreachFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &BreachFile{file: f, size: info.Size()}, nil
+}
+
+func (b *BreachFile) Close() error {
+	return b.file.Close()
+}
+
+// Contains reports whether the password's hash appears in the file.
+func (b *BreachFile) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := b.Range(hash[:breachPrefixLength])
+	if err != nil {
+		return false, err
+	}
+
+	for _, suffix := range suffixes {
+		if suffix == hash[breachPrefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Range returns the hash suffixes of every entry starting with prefix.
+func (b *BreachFile) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+
+	// Binary search for the first line at or after prefix. Offsets are
+	// snapped forward to the next line start.
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := b.lineStart(mid)
+		if err != nil {
+			return nil, err
+		}
+		line, err := b.lineAt(start)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" || line >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	start, err := b.lineStart(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	var suffixes []string
+	scanner := bufio.NewScanner(io.NewSectionReader(b.file, start, b.size-start))
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+	}
+
+	return suffixes, scanner.Err()
+}
+
+// lineStart returns the offset of the first line beginning at or after off.
+func (b *BreachFile) lineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+
+	buf := make([]byte, 128)
+	for pos := off - 1; pos < b.size; pos += int64(len(buf)) {
+		n, err := b.file.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b.size, nil
+}
+
+// lineAt returns the hash on the line starting at off, or "" at the end of
+// the file.
+func (b *BreachFile) lineAt(off int64) (string, error) {
+	if off >= b.size {
+		return "", nil
+	}
+
+	buf := make([]byte, 128)
+	n, err := b.file.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	line, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	hash, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+	return string(hash), nil
+}
@@ -1,22 +1,88 @@
 package passwords
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"errors"
+	"fmt"
+	"strings"
 )
 
-func GenerateHashFromPassword(pw string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(pw), 1)
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+type Config struct {
+	Algorithm         string `envconfig:"PASSWORD_HASH_ALGORITHM" default:"argon2id"`
+	BcryptCost        int    `envconfig:"PASSWORD_BCRYPT_COST" default:"12"`
+	Argon2MemoryKiB   uint32 `envconfig:"PASSWORD_ARGON2_MEMORY_KIB" default:"65536"`
+	Argon2Iterations  uint32 `envconfig:"PASSWORD_ARGON2_ITERATIONS" default:"3"`
+	Argon2Parallelism uint8  `envconfig:"PASSWORD_ARGON2_PARALLELISM" default:"2"`
+}
+
+// Hasher produces self-describing encoded hashes, so hashes made with other
+// algorithms or parameters can still be verified and flagged for rehashing.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	NeedsRehash(encoded string) bool
 }
 
-func HashAndPasswordMatch(hashedPassword, password string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return false, nil
+// New returns a Hasher that hashes with the configured algorithm and verifies
+// hashes made by any supported one.
+func New(cfg Config) (Hasher, error) {
+	h := &hasher{
+		bcrypt: NewBcrypt(cfg.BcryptCost),
+		argon2id: NewArgon2id(Argon2idParams{
+			MemoryKiB:   cfg.Argon2MemoryKiB,
+			Iterations:  cfg.Argon2Iterations,
+			Parallelism: cfg.Argon2Parallelism,
+		}),
 	}
 
-	if err != nil {
-		return false, err
+	switch cfg.Algorithm {
+	case AlgorithmBcrypt:
+		h.preferred = h.bcrypt
+	case AlgorithmArgon2id:
+		h.preferred = h.argon2id
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", cfg.Algorithm)
 	}
 
-	return true, nil
+	return h, nil
+}
+
+type hasher struct {
+	preferred Hasher
+	bcrypt    *Bcrypt
+	argon2id  *Argon2id
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h *hasher) Verify(encoded, password string) (bool, error) {
+	impl := h.algorithmOf(encoded)
+	if impl == nil {
+		return false, ErrInvalidHash
+	}
+	return impl.Verify(encoded, password)
+}
+
+func (h *hasher) NeedsRehash(encoded string) bool {
+	impl := h.algorithmOf(encoded)
+	return impl != h.preferred || impl.NeedsRehash(encoded)
+}
+
+func (h *hasher) algorithmOf(encoded string) Hasher {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return h.argon2id
+	case isBcryptHash(encoded):
+		return h.bcrypt
+	default:
+		return nil
+	}
 }
@@ -0,0 +1,94 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+var testConfig = Config{
+	Algorithm:         AlgorithmArgon2id,
+	BcryptCost:        4,
+	Argon2MemoryKiB:   1024,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		cfg := testConfig
+		cfg.Algorithm = algorithm
+		h, err := New(cfg)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("Expected no error hashing with %s, got %v", algorithm, err)
+		}
+
+		if ok, err := h.Verify(encoded, "correct horse"); err != nil || !ok {
+			t.Fatalf("Expected %s hash to verify, got %v %v", algorithm, ok, err)
+		}
+
+		if ok, _ := h.Verify(encoded, "wrong horse"); ok {
+			t.Fatalf("Expected wrong password to fail for %s", algorithm)
+		}
+
+		if h.NeedsRehash(encoded) {
+			t.Fatalf("Expected fresh %s hash not to need rehash", algorithm)
+		}
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	h, _ := New(testConfig)
+	encoded, _ := h.Hash("pw")
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Expected PHC encoded hash, got %s", encoded)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptCfg := testConfig
+	bcryptCfg.Algorithm = AlgorithmBcrypt
+	old, _ := New(bcryptCfg)
+	bcryptHash, _ := old.Hash("pw")
+
+	h, _ := New(testConfig)
+	if ok, err := h.Verify(bcryptHash, "pw"); err != nil || !ok {
+		t.Fatalf("Expected bcrypt hash to verify under argon2id config, got %v %v", ok, err)
+	}
+	if !h.NeedsRehash(bcryptHash) {
+		t.Fatal("Expected bcrypt hash to need rehash when argon2id is preferred")
+	}
+
+	weaker := testConfig
+	weaker.Argon2Iterations = 2
+	stronger, _ := New(weaker)
+	argonHash, _ := h.Hash("pw")
+	if !stronger.NeedsRehash(argonHash) {
+		t.Fatal("Expected hash with old parameters to need rehash")
+	}
+
+	bcryptCfg.BcryptCost = 5
+	higherCost, _ := New(bcryptCfg)
+	if !higherCost.NeedsRehash(bcryptHash) {
+		t.Fatal("Expected bcrypt hash with lower cost to need rehash")
+	}
+}
+
+func TestInvalidHash(t *testing.T) {
+	h, _ := New(testConfig)
+
+	for _, encoded := range []string{"", "plaintext", "$argon2id$v=19$m=x$salt$key", "$argon2id$v=18$m=1,t=1,p=1$c2FsdA$a2V5"} {
+		if ok, err := h.Verify(encoded, "pw"); ok || err == nil {
+			t.Fatalf("Expected %q to be rejected, got %v %v", encoded, ok, err)
+		}
+	}
+
+	if _, err := New(Config{Algorithm: "md5"}); err == nil {
+		t.Fatal("Expected unsupported algorithm to be rejected")
+	}
+}
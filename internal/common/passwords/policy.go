@@ -0,0 +1,141 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PolicyConfig struct {
+	MinLength     int      `envconfig:"PASSWORD_MIN_LENGTH" default:"8"`
+	MaxLength     int      `envconfig:"PASSWORD_MAX_LENGTH" default:"128"`
+	RequireUpper  bool     `envconfig:"PASSWORD_REQUIRE_UPPER" default:"true"`
+	RequireLower  bool     `envconfig:"PASSWORD_REQUIRE_LOWER" default:"true"`
+	RequireDigit  bool     `envconfig:"PASSWORD_REQUIRE_DIGIT" default:"true"`
+	RequireSymbol bool     `envconfig:"PASSWORD_REQUIRE_SYMBOL" default:"true"`
+	BannedWords   []string `envconfig:"PASSWORD_BANNED_WORDS"`
+	HistorySize   int      `envconfig:"PASSWORD_HISTORY_SIZE" default:"5"` // includes the current password, 0 disables
+	BreachFile    string   `envconfig:"PASSWORD_BREACH_FILE"`              // sorted SHA-1 "HASH:COUNT" lines
+}
+
+// Violation is a single rule a password failed. Code is stable for clients,
+// Message is meant for people.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	PolicyConfig
+	breaches *BreachFile
+}
+
+// minPersonalWordLength keeps short name fragments like "al" from rejecting
+// otherwise fine passwords.
+const minPersonalWordLength = 3
+
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := &Policy{PolicyConfig: cfg}
+
+	if cfg.BreachFile != "" {
+		breaches, err := OpenBreachFile(cfg.BreachFile)
+		if err != nil {
+			return nil, err
+		}
+		p.breaches = breaches
+	}
+
+	return p, nil
+}
+
+// Check returns every rule the password breaks. personal holds values such as
+// the user's name and email that must not appear in the password.
+func (p *Policy) Check(password string, personal ...string) ([]Violation, error) {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{"min_length", fmt.Sprintf("Must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{"max_length", fmt.Sprintf("Must be no more than %d characters long", p.MaxLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{"uppercase", "Must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{"lowercase", "Must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{"digit", "Must contain a number"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{"symbol", "Must contain a special character"})
+	}
+
+	lowered := strings.ToLower(password)
+	for _, word := range p.BannedWords {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" && strings.Contains(lowered, word) {
+			violations = append(violations, Violation{"banned_word", "Must not contain common or banned words"})
+			break
+		}
+	}
+	for _, word := range personalWords(personal) {
+		if strings.Contains(lowered, word) {
+			violations = append(violations, Violation{"personal_info", "Must not contain your name or email address"})
+			break
+		}
+	}
+
+	if p.breaches != nil {
+		breached, err := p.breaches.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{"breached", "This password has appeared in a data breach, choose another"})
+		}
+	}
+
+	return violations, nil
+}
+
+// personalWords splits names and email addresses into the lower-cased words
+// worth checking for, so "Jane Doe" and "jane.doe@example.com" yield jane,
+// doe and example. Top level domains are skipped.
+func personalWords(values []string) []string {
+	var words []string
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if at := strings.LastIndex(value, "@"); at >= 0 {
+			if dot := strings.LastIndex(value, "."); dot > at {
+				value = value[:dot]
+			}
+		}
+
+		fields := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, field := range fields {
+			if utf8.RuneCountInString(field) >= minPersonalWordLength {
+				words = append(words, field)
+			}
+		}
+	}
+	return words
+}
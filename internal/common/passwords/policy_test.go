@@ -0,0 +1,101 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var defaultPolicy = PolicyConfig{
+	MinLength:     8,
+	MaxLength:     64,
+	RequireUpper:  true,
+	RequireLower:  true,
+	RequireDigit:  true,
+	RequireSymbol: true,
+	BannedWords:   []string{"acme"},
+}
+
+func violationCodes(violations []Violation) string {
+	codes := make([]string, len(violations))
+	for i, v := range violations {
+		codes[i] = v.Code
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := NewPolicy(defaultPolicy)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		password string
+		personal []string
+		expected string
+	}{
+		{"Tr0ub4dor&3x", nil, ""},
+		{"short1!", nil, "min_length,uppercase"},
+		{"alllowercase", nil, "uppercase,digit,symbol"},
+		{strings.Repeat("Aa1!", 17), nil, "max_length"},
+		{"Acme-2024!x", nil, "banned_word"},
+		{"Jane-2024!x", []string{"Jane Doe", "jdoe@example.com"}, "personal_info"},
+		{"Example#2024", []string{"Jane Doe", "jdoe@example.com"}, "personal_info"},
+		{"Comet#2024", []string{"Al", "al@x.com"}, ""},
+	}
+
+	for _, tt := range tests {
+		violations, err := p.Check(tt.password, tt.personal...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got := violationCodes(violations); got != tt.expected {
+			t.Fatalf("Expected %q for %q, got %q", tt.expected, tt.password, got)
+		}
+	}
+}
+
+func TestBreachFile(t *testing.T) {
+	var lines []string
+	for i, pw := range []string{"password", "123456", "Passw0rd!", "letmein", "qwerty"} {
+		sum := sha1.Sum([]byte(pw))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":"+strings.Repeat("9", i+1))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cfg := defaultPolicy
+	cfg.BreachFile = path
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, pw := range []string{"password", "123456", "Passw0rd!", "letmein", "qwerty"} {
+		if ok, err := p.breaches.Contains(pw); err != nil || !ok {
+			t.Fatalf("Expected %q to be found, got %v %v", pw, ok, err)
+		}
+	}
+
+	if ok, _ := p.breaches.Contains("Tr0ub4dor&3x"); ok {
+		t.Fatalf("Expected unlisted password not to be found")
+	}
+
+	violations, _ := p.Check("Passw0rd!")
+	if got := violationCodes(violations); got != "breached" {
+		t.Fatalf("Expected breached, got %q", got)
+	}
+
+	if _, err := NewPolicy(PolicyConfig{BreachFile: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Fatalf("Expected error for missing breach file")
+	}
+}
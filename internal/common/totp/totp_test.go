@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B secret for SHA1.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := GenerateCode(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if code != tt.expected {
+			t.Fatalf("Expected code %s at %d, got %s", tt.expected, tt.unix, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code, _ := GenerateCode(rfcSecret, step)
+
+	t.Run("current step", func(t *testing.T) {
+		matched, ok := Validate(rfcSecret, code, now, 0)
+		if !ok || matched != step {
+			t.Fatalf("Expected code to match step %d, got %d, %v", step, matched, ok)
+		}
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		if _, ok := Validate(rfcSecret, code, now.Add(Period*time.Second), 0); !ok {
+			t.Fatal("Expected code from the previous step to be accepted")
+		}
+		if _, ok := Validate(rfcSecret, code, now.Add(2*Period*time.Second), 0); ok {
+			t.Fatal("Expected code from two steps ago to be rejected")
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		if _, ok := Validate(rfcSecret, code, now, step); ok {
+			t.Fatal("Expected an already used step to be rejected")
+		}
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		for _, c := range []string{"000000", "12345", "abcdef", ""} {
+			if c == code {
+				continue
+			}
+			if _, ok := Validate(rfcSecret, c, now, 0); ok {
+				t.Fatalf("Expected %q to be rejected", c)
+			}
+		}
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(secret) != 32 || strings.Contains(secret, "=") {
+		t.Fatalf("Expected 32 unpadded base32 characters, got %q", secret)
+	}
+	if _, err := GenerateCode(secret, 1); err != nil {
+		t.Fatalf("Expected generated secret to be usable, got %v", err)
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	uri := KeyURI("Echo Boilerplate", "jane@example.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Echo%20Boilerplate:jane@example.com?") {
+		t.Fatalf("Unexpected label in %s", uri)
+	}
+	if !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=Echo+Boilerplate") {
+		t.Fatalf("Missing parameters in %s", uri)
+	}
+}
@@ -1,9 +1,14 @@
 package validator
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type SignUpRequest struct {
 	Name     string `json:"name" validate:"required,min=2,max=100"`
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,strong_password"`
+	Password string `json:"password" validate:"required"`
 }
 
 type SignInRequest struct {
@@ -22,38 +27,218 @@ type ForgotPasswordRequest struct {
 
 type ResetPasswordRequest struct {
 	Token       string `json:"token" validate:"required,min=1"`
-	NewPassword string `json:"newPassword" validate:"required,strong_password"`
+	NewPassword string `json:"newPassword" validate:"required"`
 }
 
 type Enable2FARequest struct {
 	Password string `json:"password" validate:"required,min=1"`
+	Method   string `json:"method,omitempty" validate:"omitempty,oneof=totp email"`
 }
 
+type Confirm2FARequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// Code accepts either a one-time code or a backup code.
 type Verify2FARequest struct {
-	Email string `json:"email" validate:"required,email"`
-	Code  string `json:"code" validate:"required,len=6,numeric"`
+	MFAToken string `json:"mfaToken" validate:"required,jwt"`
+	Code     string `json:"code" validate:"required,min=6,max=11"`
 }
 
 type Disable2FARequest struct {
+	Password string `json:"password" validate:"required,min=1"`
+	Code     string `json:"code" validate:"required,min=6,max=11"`
+}
+
+type RegenerateBackupCodesRequest struct {
 	Password string `json:"password" validate:"required,min=1"`
 	Code     string `json:"code" validate:"required,len=6,numeric"`
 }
 
+// Users with 2FA step up with a one-time or backup code, everyone else with
+// their password.
+type StepUpRequest struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty" validate:"omitempty,min=6,max=11"`
+}
+
+type ResendEmailOTPRequest struct {
+	MFAToken string `json:"mfaToken" validate:"required,jwt"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,min=2,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,permission_name"`
+	ExpiresInDays int      `json:"expiresInDays" validate:"omitempty,min=1,max=365"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+type WebAuthnRegisterFinishRequest struct {
+	SessionID  string          `json:"sessionId" validate:"required"`
+	Nickname   string          `json:"nickname" validate:"omitempty,max=100"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type WebAuthnLoginFinishRequest struct {
+	SessionID  string          `json:"sessionId" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type UpdateWebAuthnCredentialRequest struct {
+	ID       uint   `param:"id" validate:"required,min=1"`
+	Nickname string `json:"nickname" validate:"required,min=1,max=100"`
+}
+
 type CreateRoleRequest struct {
 	Name        string `json:"name" validate:"required,role_name"`
 	Description string `json:"description" validate:"max=255"`
 	IsActive    *bool  `json:"isActive,omitempty"`
+	RequiresMFA *bool  `json:"requiresMfa,omitempty"`
 }
 
 type UpdateRoleRequest struct {
 	Name        string `json:"name" validate:"required,role_name"`
 	Description string `json:"description" validate:"max=255"`
 	IsActive    *bool  `json:"isActive" validate:"required"`
+	RequiresMFA *bool  `json:"requiresMfa,omitempty"`
+}
+
+// DeleteRoleRequest moves the role's holders to ReassignTo before deleting it.
+type DeleteRoleRequest struct {
+	ID         uint  `param:"id" validate:"required,min=1"`
+	ReassignTo *uint `query:"reassignTo" validate:"omitempty,min=1"`
 }
 
 type AssignRoleRequest struct {
 	UserID uint `json:"userId" validate:"required,min=1"`
 	RoleID uint `json:"roleId" validate:"required,min=1"`
+	// ValidFrom schedules the assignment; DurationSecs or ValidUntil make it
+	// expire.
+	ValidFrom    *time.Time `json:"validFrom"`
+	ValidUntil   *time.Time `json:"validUntil" validate:"excluded_with=DurationSecs"`
+	DurationSecs int        `json:"durationSecs" validate:"omitempty,min=60"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" validate:"required,min=2,max=100"`
+	Slug string `json:"slug" validate:"required,org_slug"`
+}
+
+type InviteMemberRequest struct {
+	UserID uint `json:"userId" validate:"required,min=1"`
+}
+
+type AssignMemberRoleRequest struct {
+	UserID uint `param:"userId" validate:"required,min=1"`
+	RoleID uint `json:"roleId" validate:"required,min=1"`
+}
+
+type CreateAccessRequestRequest struct {
+	RoleID        uint   `json:"roleId" validate:"required,min=1"`
+	Justification string `json:"justification" validate:"required,min=10,max=1000"`
+	DurationSecs  int    `json:"durationSecs" validate:"omitempty,min=60"`
+}
+
+type AccessDecisionRequest struct {
+	ID   uint   `param:"id" validate:"required,min=1"`
+	Note string `json:"note" validate:"max=1000"`
+}
+
+type AccessRequestQuery struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending approved denied cancelled"`
+	UserID uint   `query:"userId"`
+	RoleID uint   `query:"roleId"`
+}
+
+type RoleApproverRequest struct {
+	ID     uint `param:"id" validate:"required,min=1"`
+	UserID uint `json:"userId" validate:"required,min=1"`
+}
+
+type RoleApproverParams struct {
+	ID     uint `param:"id" validate:"required,min=1"`
+	UserID uint `param:"userId" validate:"required,min=1"`
+}
+
+type CreateAccessReviewRequest struct {
+	Name        string     `json:"name" validate:"required,min=3,max=200"`
+	RoleID      *uint      `json:"roleId" validate:"omitempty,min=1"`
+	ReviewerIDs []uint     `json:"reviewerIds" validate:"required,min=1,dive,min=1"`
+	AutoRevoke  bool       `json:"autoRevoke"`
+	DueAt       *time.Time `json:"dueAt"`
+	RepeatDays  int        `json:"repeatDays" validate:"omitempty,min=1,max=366"`
+}
+
+type AccessReviewQuery struct {
+	Status string `query:"status" validate:"omitempty,oneof=open closed"`
+}
+
+type AccessReviewItemsQuery struct {
+	ID       uint   `param:"id" validate:"required,min=1"`
+	Decision string `query:"decision" validate:"omitempty,oneof=pending keep revoke"`
+}
+
+type AccessReviewDecisionRequest struct {
+	ID       uint   `param:"id" validate:"required,min=1"`
+	ItemID   uint   `param:"itemId" validate:"required,min=1"`
+	Decision string `json:"decision" validate:"required,oneof=keep revoke"`
+	Note     string `json:"note" validate:"max=1000"`
+}
+
+type AccessReviewerRequest struct {
+	ID     uint `param:"id" validate:"required,min=1"`
+	UserID uint `json:"userId" validate:"required,min=1"`
+}
+
+type AddParentRoleRequest struct {
+	ID           uint `param:"id" validate:"required,min=1"`
+	ParentRoleID uint `json:"parentRoleId" validate:"required,min=1"`
+}
+
+// SetRolePermissionsRequest replaces a role's permissions with Permissions.
+type SetRolePermissionsRequest struct {
+	ID          uint     `param:"id" validate:"required,min=1"`
+	Permissions []string `json:"permissions" validate:"required,max=200,dive,required,max=100"`
+}
+
+type BulkRoleUsersRequest struct {
+	ID           uint       `param:"id" validate:"required,min=1"`
+	UserIDs      []uint     `json:"userIds" validate:"required,min=1,max=500,dive,min=1"`
+	ValidFrom    *time.Time `json:"validFrom"`
+	ValidUntil   *time.Time `json:"validUntil" validate:"excluded_with=DurationSecs"`
+	DurationSecs int        `json:"durationSecs" validate:"omitempty,min=60"`
+}
+
+type BulkRemoveRoleUsersRequest struct {
+	ID      uint   `param:"id" validate:"required,min=1"`
+	UserIDs []uint `json:"userIds" validate:"required,min=1,max=500,dive,min=1"`
+}
+
+// CloneRoleRequest copies role ID under Name; an empty Description keeps the
+// source's.
+type CloneRoleRequest struct {
+	ID          uint   `param:"id" validate:"required,min=1"`
+	Name        string `json:"name" validate:"required,role_name"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+type RoleParentParams struct {
+	ID       uint `param:"id" validate:"required,min=1"`
+	ParentID uint `param:"parentId" validate:"required,min=1"`
+}
+
+type ExplainPermissionQuery struct {
+	ID             uint   `param:"id" validate:"required,min=1"`
+	Permission     string `query:"permission" validate:"required,max=100,contains=:"`
+	OrganizationID *uint  `query:"organizationId" validate:"omitempty,min=1"`
+}
+
+type WhoCanQuery struct {
+	Permission     string `query:"permission" validate:"required,max=100,contains=:"`
+	OrganizationID *uint  `query:"organizationId" validate:"omitempty,min=1"`
 }
 
 type AssignPermissionRequest struct {
@@ -76,7 +261,7 @@ type UpdateUserRequest struct {
 
 type UpdateUserPasswordRequest struct {
 	CurrentPassword string `json:"currentPassword" validate:"required,min=1"`
-	NewPassword     string `json:"newPassword" validate:"required,strong_password"`
+	NewPassword     string `json:"newPassword" validate:"required"`
 }
 
 type InviteUserRequest struct {
@@ -85,6 +270,11 @@ type InviteUserRequest struct {
 	RoleIDs []uint `json:"roleIds,omitempty" validate:"omitempty,dive,min=1"`
 }
 
+type ImpersonateRequest struct {
+	ID     uint   `param:"id" validate:"required,min=1"`
+	Reason string `json:"reason" validate:"required,min=5,max=500"`
+}
+
 type IDParam struct {
 	ID uint `param:"id" validate:"required,min=1"`
 }
@@ -106,6 +296,11 @@ type UserRoleParams struct {
 	RoleID uint `param:"roleId" validate:"required,min=1"`
 }
 
+type UserSessionParams struct {
+	UserID    uint `param:"id" validate:"required,min=1"`
+	SessionID uint `param:"sessionId" validate:"required,min=1"`
+}
+
 type RolePermissionParams struct {
 	RoleID       uint `param:"roleId" validate:"required,min=1"`
 	PermissionID uint `param:"permissionId" validate:"required,min=1"`
@@ -160,7 +355,7 @@ type UpdateSettingsRequest struct {
 type AuditLogQuery struct {
 	PaginationQuery
 	UserID     *uint  `query:"userId" validate:"omitempty,min=1"`
-	Action     string `query:"action" validate:"omitempty,alpha"`
+	Action     string `query:"action" validate:"omitempty,max=100"`
 	Resource   string `query:"resource" validate:"omitempty,alpha"`
 	StartDate  string `query:"startDate" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
 	EndDate    string `query:"endDate" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
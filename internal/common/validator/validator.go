@@ -97,6 +97,8 @@ func getErrorMessage(err validator.FieldError) string {
 		return "Role name must be 2-50 characters and contain only letters, numbers, spaces, and hyphens"
 	case "permission_name":
 		return "Permission name must be in format 'resource:action'"
+	case "org_slug":
+		return "Slug must be 2-63 lowercase letters, numbers and hyphens, and cannot be only numbers"
 	default:
 		return fmt.Sprintf("Invalid value for %s", err.Field())
 	}
@@ -123,6 +125,7 @@ func NewValidator() *CustomValidator {
 	validate.RegisterValidation("role_name", validateRoleName)
 	validate.RegisterValidation("permission_name", validatePermissionName)
 	validate.RegisterValidation("jwt", validateJWT)
+	validate.RegisterValidation("org_slug", validateOrganizationSlug)
 	
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
@@ -165,6 +168,19 @@ func validatePermissionName(fl validator.FieldLevel) bool {
 	return matched
 }
 
+// validateOrganizationSlug accepts slugs that are valid DNS labels so they can
+// be used as subdomains. All-digit slugs would be mistaken for IDs.
+func validateOrganizationSlug(fl validator.FieldLevel) bool {
+	slug := fl.Field().String()
+	if len(slug) < 2 || len(slug) > 63 {
+		return false
+	}
+
+	matched, _ := regexp.MatchString(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`, slug)
+	numeric, _ := regexp.MatchString(`^[0-9]+$`, slug)
+	return matched && !numeric
+}
+
 func validateJWT(fl validator.FieldLevel) bool {
 	token := fl.Field().String()
 	parts := strings.Split(token, ".")
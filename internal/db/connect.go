@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -20,6 +21,9 @@ func Connect(cfg *Config, deps *Dependencies) (*DB, error) {
 		return nil, fmt.Errorf("failed to connect to database at %s:%s/%s: %w", cfg.Host, cfg.Port, cfg.Database, err)
 	}
 
+	if err := tenancy.Register(conn); err != nil {
+		return nil, fmt.Errorf("failed to register tenancy callbacks: %w", err)
+	}
 	
 	sqlDB, err := conn.DB()
 	if err != nil {
@@ -5,11 +5,99 @@ import (
 )
 
 func (db *DB) MigrateAllFields() error {
-	return db.Conn.AutoMigrate(
+	err := db.Conn.AutoMigrate(
 		&models.User{}, 
 		&models.Role{}, 
 		&models.Permission{}, 
 		&models.UserRole{},
 		&models.RolePermission{},
+		&models.RoleInheritance{},
+		&models.Session{},
+		&models.RevokedToken{},
+		&models.APIKey{},
+		&models.UserIdentity{},
+		&models.SSOLoginState{},
+		&models.AuditLog{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.BackupCode{},
+		&models.EmailOTP{},
+		&models.TwoFactorAttempt{},
+		&models.PasswordHistory{},
+		&models.Organization{},
+		&models.Membership{},
+		&models.OrganizationInvitation{},
+		&models.RoleApprover{},
+		&models.AccessRequest{},
+		&models.AccessReview{},
+		&models.AccessReviewer{},
+		&models.AccessReviewItem{},
 	)
+	if err != nil {
+		return err
+	}
+
+	if err := db.createTenantUniqueIndexes(); err != nil {
+		return err
+	}
+
+	if err := db.dropLegacyTwoFactorData(); err != nil {
+		return err
+	}
+
+	return db.dropUserEmailUniqueConstraint()
+}
+
+// createTenantUniqueIndexes adds the per-organization unique indexes of
+// tenant-owned models. The organization_id column comes from
+// tenancy.OrganizationScoped, whose tag can't take part in a named composite
+// index.
+func (db *DB) createTenantUniqueIndexes() error {
+	indexes := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_memberships_org_user ON memberships (organization_id, user_id)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_org_user ON organization_invitations (organization_id, user_id)",
+	}
+	for _, index := range indexes {
+		if err := db.Conn.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropLegacyTwoFactorData removes the plaintext backup codes column. The old
+// enrollment never verified codes or encrypted secrets, so users who had 2FA
+// switched on have to enroll again.
+func (db *DB) dropLegacyTwoFactorData() error {
+	migrator := db.Conn.Migrator()
+	if !migrator.HasColumn(&models.User{}, "two_factor_backup_codes") {
+		return nil
+	}
+
+	err := db.Conn.Model(&models.User{}).Where("two_factor_enabled = ? OR two_factor_secret <> ?", true, "").
+		Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"two_factor_secret":  "",
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	return migrator.DropColumn(&models.User{}, "two_factor_backup_codes")
+}
+
+// dropUserEmailUniqueConstraint removes the table wide unique constraint on
+// users.email. Uniqueness is now enforced by idx_users_email_active, which
+// ignores soft-deleted users so their address can be signed up again.
+func (db *DB) dropUserEmailUniqueConstraint() error {
+	migrator := db.Conn.Migrator()
+	for _, name := range []string{"uni_users_email", "users_email_key"} {
+		if !migrator.HasConstraint(&models.User{}, name) {
+			continue
+		}
+		if err := migrator.DropConstraint(&models.User{}, name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
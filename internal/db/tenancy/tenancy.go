@@ -0,0 +1,278 @@
+// Package tenancy keeps organizations' data apart at the database layer.
+// Models that embed OrganizationScoped are tenant-owned: once Register has
+// installed its callbacks, every query, update and delete on them is limited
+// to the organization in the statement's context, and inserts are stamped
+// with it. Statements without an organization fail instead of touching every
+// tenant's rows. Raw SQL and joined tables are not rewritten.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const column = "organization_id"
+
+var (
+	ErrNoTenant    = errors.New("tenancy: no organization in context for a tenant-owned model")
+	ErrWrongTenant = errors.New("tenancy: record belongs to another organization")
+)
+
+// OrganizationScoped marks a model as tenant-owned when embedded.
+type OrganizationScoped struct {
+	OrganizationID uint `gorm:"not null;index" json:"organizationId"`
+}
+
+func (OrganizationScoped) tenantOwned() {}
+
+type owned interface {
+	tenantOwned()
+}
+
+type contextKey int
+
+const (
+	organizationKey contextKey = iota
+	crossTenantKey
+)
+
+// WithOrganization scopes tenant-owned queries run with ctx to the
+// organization.
+func WithOrganization(ctx context.Context, organizationID uint) context.Context {
+	return context.WithValue(ctx, organizationKey, organizationID)
+}
+
+func OrganizationID(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(organizationKey).(uint)
+	return id, ok && id != 0
+}
+
+// WithCrossTenant lifts tenant scoping for queries run with ctx. Inserts must
+// then set OrganizationID themselves. Only hand it to callers that have been
+// checked for system:admin, or to queries limited to one user's own rows.
+func WithCrossTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, crossTenantKey, true)
+}
+
+func IsCrossTenant(ctx context.Context) bool {
+	cross, _ := ctx.Value(crossTenantKey).(bool)
+	return cross
+}
+
+func Register(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenancy:create", stampCreate); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenancy:query", scopeQuery); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenancy:row", scopeQuery); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenancy:update", scopeUpdate); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register("tenancy:delete", scopeDelete)
+}
+
+// Scope limits a query on a table shared by global and organization rows,
+// such as user_roles, to the organization in the statement's context. Use it
+// as db.WithContext(ctx).Scopes(tenancy.Scope); like the callbacks it fails
+// with ErrNoTenant when there is no organization.
+func Scope(db *gorm.DB) *gorm.DB {
+	if id, ok := tenant(db); ok {
+		where(db, id)
+	}
+	return db
+}
+
+func isOwned(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return false
+	}
+	_, ok := reflect.New(stmt.Schema.ModelType).Interface().(owned)
+	return ok
+}
+
+// tenant returns the organization to scope to. ok is false when the
+// statement must not be scoped, either because it is cross-tenant or because
+// an error has been added.
+func tenant(db *gorm.DB) (uint, bool) {
+	ctx := db.Statement.Context
+	if IsCrossTenant(ctx) {
+		return 0, false
+	}
+
+	id, ok := OrganizationID(ctx)
+	if !ok {
+		db.AddError(ErrNoTenant)
+		return 0, false
+	}
+	return id, true
+}
+
+func where(db *gorm.DB, organizationID uint) {
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: organizationID},
+	}})
+}
+
+func scopeQuery(db *gorm.DB) {
+	if db.Error != nil || !isOwned(db.Statement) {
+		return
+	}
+	if id, ok := tenant(db); ok {
+		where(db, id)
+	}
+}
+
+// missingWhere repeats GORM's guard against conditionless updates and
+// deletes, which the tenant condition would otherwise satisfy.
+func missingWhere(db *gorm.DB) bool {
+	stmt := db.Statement
+	if db.AllowGlobalUpdate {
+		return false
+	}
+	if _, ok := stmt.Clauses["WHERE"]; ok {
+		return false
+	}
+	_, values := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	return len(values) == 0
+}
+
+func scopeDelete(db *gorm.DB) {
+	if db.Error != nil || !isOwned(db.Statement) {
+		return
+	}
+	if missingWhere(db) {
+		db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+	if id, ok := tenant(db); ok {
+		where(db, id)
+	}
+}
+
+func scopeUpdate(db *gorm.DB) {
+	if db.Error != nil || !isOwned(db.Statement) {
+		return
+	}
+	if missingWhere(db) {
+		db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+	id, ok := tenant(db)
+	if !ok {
+		return
+	}
+
+	// Rows may not be moved to another organization.
+	if value, set := assignedOrganization(db.Statement, db.Statement.Dest); set && value != id {
+		db.AddError(ErrWrongTenant)
+		return
+	}
+	where(db, id)
+}
+
+func stampCreate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || !isOwned(stmt) {
+		return
+	}
+
+	id, hasTenant := OrganizationID(stmt.Context)
+	if !hasTenant && !IsCrossTenant(stmt.Context) {
+		db.AddError(ErrNoTenant)
+		return
+	}
+
+	if dest, ok := stmt.Dest.(map[string]interface{}); ok {
+		stampMap(db, dest, id, hasTenant)
+		return
+	}
+
+	field := stmt.Schema.LookUpField(column)
+	rv := stmt.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			stampRecord(db, field, reflect.Indirect(rv.Index(i)), id, hasTenant)
+		}
+	case reflect.Struct:
+		stampRecord(db, field, rv, id, hasTenant)
+	}
+}
+
+func stampRecord(db *gorm.DB, field *schema.Field, rv reflect.Value, id uint, hasTenant bool) {
+	ctx := db.Statement.Context
+	value, zero := field.ValueOf(ctx, rv)
+	switch {
+	case !hasTenant && zero:
+		db.AddError(ErrNoTenant)
+	case !hasTenant:
+	case !zero && value != id:
+		db.AddError(ErrWrongTenant)
+	default:
+		if err := field.Set(ctx, rv, id); err != nil {
+			db.AddError(err)
+		}
+	}
+}
+
+func stampMap(db *gorm.DB, dest map[string]interface{}, id uint, hasTenant bool) {
+	value, set := assignedOrganization(db.Statement, dest)
+	switch {
+	case !hasTenant && !set:
+		db.AddError(ErrNoTenant)
+	case !hasTenant:
+	case set && value != id:
+		db.AddError(ErrWrongTenant)
+	default:
+		delete(dest, "OrganizationID")
+		dest[column] = id
+	}
+}
+
+// assignedOrganization returns the organization ID an insert or update
+// writes, if any.
+func assignedOrganization(stmt *gorm.Statement, dest interface{}) (uint, bool) {
+	if m, ok := dest.(map[string]interface{}); ok {
+		for _, key := range []string{column, "OrganizationID"} {
+			if v, ok := m[key]; ok {
+				id, ok := toUint(v)
+				return id, ok || v != nil
+			}
+		}
+		return 0, false
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(dest))
+	if rv.Kind() != reflect.Struct || !rv.Type().AssignableTo(stmt.Schema.ModelType) {
+		return 0, false
+	}
+	value, zero := stmt.Schema.LookUpField(column).ValueOf(stmt.Context, rv)
+	if zero {
+		return 0, false
+	}
+	id, _ := toUint(value)
+	return id, true
+}
+
+func toUint(v interface{}) (uint, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(rv.Uint()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() >= 0 {
+			return uint(rv.Int()), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,204 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type note struct {
+	ID uint `gorm:"primaryKey"`
+	OrganizationScoped
+	Body string
+}
+
+type globalNote struct {
+	ID   uint `gorm:"primaryKey"`
+	Body string
+}
+
+func setup(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	if err := db.AutoMigrate(&note{}, &globalNote{}); err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	if err := Register(db); err != nil {
+		t.Fatalf("Expected no error registering callbacks, got %v", err)
+	}
+
+	admin := db.WithContext(WithCrossTenant(context.Background()))
+	seed := []note{
+		{OrganizationScoped: OrganizationScoped{OrganizationID: 1}, Body: "one"},
+		{OrganizationScoped: OrganizationScoped{OrganizationID: 1}, Body: "two"},
+		{OrganizationScoped: OrganizationScoped{OrganizationID: 2}, Body: "other"},
+	}
+	if err := admin.Create(&seed).Error; err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+	return db
+}
+
+func as(db *gorm.DB, organizationID uint) *gorm.DB {
+	return db.WithContext(WithOrganization(context.Background(), organizationID))
+}
+
+func TestQueriesAreScoped(t *testing.T) {
+	db := setup(t)
+
+	var notes []note
+	if err := as(db, 1).Find(&notes).Error; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("Expected 2 notes, got %d", len(notes))
+	}
+
+	var n note
+	err := as(db, 1).First(&n, 3).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Expected another organization's note to be not found, got %v", err)
+	}
+
+	err = as(db, 1).Where("body = ?", "other").First(&n).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Expected filtered cross-tenant read to be not found, got %v", err)
+	}
+
+	var count int64
+	as(db, 2).Model(&note{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("Expected count 1, got %d", count)
+	}
+
+	var bodies []string
+	as(db, 2).Model(&note{}).Pluck("body", &bodies)
+	if len(bodies) != 1 || bodies[0] != "other" {
+		t.Fatalf("Expected [other], got %v", bodies)
+	}
+
+	rows, err := as(db, 2).Model(&note{}).Select("body").Rows()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var rowCount int
+	for rows.Next() {
+		rowCount++
+	}
+	rows.Close()
+	if rowCount != 1 {
+		t.Fatalf("Expected 1 row, got %d", rowCount)
+	}
+}
+
+func TestFailsClosedWithoutTenant(t *testing.T) {
+	db := setup(t)
+	ctx := db.WithContext(context.Background())
+
+	var notes []note
+	if err := ctx.Find(&notes).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("Expected ErrNoTenant on query, got %v", err)
+	}
+	if err := ctx.Create(&note{Body: "x"}).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("Expected ErrNoTenant on create, got %v", err)
+	}
+	if err := ctx.Model(&note{ID: 1}).Update("body", "x").Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("Expected ErrNoTenant on update, got %v", err)
+	}
+	if err := ctx.Delete(&note{}, 1).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("Expected ErrNoTenant on delete, got %v", err)
+	}
+
+	if err := ctx.Create(&globalNote{Body: "x"}).Error; err != nil {
+		t.Fatalf("Expected models that are not tenant-owned to be unaffected, got %v", err)
+	}
+}
+
+func TestCreateStampsTenant(t *testing.T) {
+	db := setup(t)
+
+	n := note{Body: "new"}
+	if err := as(db, 2).Create(&n).Error; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if n.OrganizationID != 2 {
+		t.Fatalf("Expected organization 2, got %d", n.OrganizationID)
+	}
+
+	foreign := note{OrganizationScoped: OrganizationScoped{OrganizationID: 1}, Body: "sneaky"}
+	if err := as(db, 2).Create(&foreign).Error; !errors.Is(err, ErrWrongTenant) {
+		t.Fatalf("Expected ErrWrongTenant, got %v", err)
+	}
+
+	err := as(db, 2).Model(&note{}).Create(map[string]interface{}{"body": "mapped"}).Error
+	if err != nil {
+		t.Fatalf("Expected no error creating from a map, got %v", err)
+	}
+	var mapped note
+	as(db, 2).Where("body = ?", "mapped").First(&mapped)
+	if mapped.OrganizationID != 2 {
+		t.Fatalf("Expected organization 2, got %d", mapped.OrganizationID)
+	}
+}
+
+func TestCrossTenantWritesAreImpossible(t *testing.T) {
+	db := setup(t)
+
+	res := as(db, 1).Model(&note{ID: 3}).Update("body", "hijacked")
+	if res.Error != nil || res.RowsAffected != 0 {
+		t.Fatalf("Expected no rows updated, got %d (%v)", res.RowsAffected, res.Error)
+	}
+
+	res = as(db, 1).Delete(&note{}, 3)
+	if res.Error != nil || res.RowsAffected != 0 {
+		t.Fatalf("Expected no rows deleted, got %d (%v)", res.RowsAffected, res.Error)
+	}
+
+	err := as(db, 1).Model(&note{ID: 1}).Update("organization_id", 2).Error
+	if !errors.Is(err, ErrWrongTenant) {
+		t.Fatalf("Expected ErrWrongTenant moving a row, got %v", err)
+	}
+
+	stolen := note{ID: 3, OrganizationScoped: OrganizationScoped{OrganizationID: 2}, Body: "hijacked"}
+	if err := as(db, 1).Save(&stolen).Error; !errors.Is(err, ErrWrongTenant) {
+		t.Fatalf("Expected ErrWrongTenant saving another organization's note, got %v", err)
+	}
+
+	if err := as(db, 1).Where("1 = 1").Delete(&note{}).Error; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var other note
+	if err := as(db, 2).First(&other, 3).Error; err != nil || other.Body != "other" {
+		t.Fatalf("Expected organization 2's note untouched, got %+v (%v)", other, err)
+	}
+
+	if err := as(db, 2).Delete(&note{}).Error; !errors.Is(err, gorm.ErrMissingWhereClause) {
+		t.Fatalf("Expected ErrMissingWhereClause, got %v", err)
+	}
+}
+
+func TestCrossTenantContext(t *testing.T) {
+	db := setup(t)
+	admin := db.WithContext(WithCrossTenant(context.Background()))
+
+	var notes []note
+	if err := admin.Find(&notes).Error; err != nil || len(notes) != 3 {
+		t.Fatalf("Expected 3 notes, got %d (%v)", len(notes), err)
+	}
+
+	if err := admin.Create(&note{Body: "unowned"}).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("Expected cross-tenant creates to need an organization, got %v", err)
+	}
+	n := note{OrganizationScoped: OrganizationScoped{OrganizationID: 7}, Body: "explicit"}
+	if err := admin.Create(&n).Error; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
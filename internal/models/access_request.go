@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Access request statuses.
+const (
+	AccessRequestPending   = "pending"
+	AccessRequestApproved  = "approved"
+	AccessRequestDenied    = "denied"
+	AccessRequestCancelled = "cancelled"
+)
+
+// RoleApprover lets a user decide access requests for a role.
+type RoleApprover struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RoleID    uint      `gorm:"not null;uniqueIndex:idx_role_approvers_role_user" json:"roleId"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_role_approvers_role_user;index" json:"userId"`
+	Role      Role      `gorm:"foreignKey:RoleID" json:"role,omitempty"`
+	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccessRequest is a user's request for a global role and, once decided,
+// the decision. Requests are never deleted so they form the history.
+type AccessRequest struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	UserID        uint   `gorm:"not null;index" json:"userId"`
+	RoleID        uint   `gorm:"not null;index" json:"roleId"`
+	Justification string `gorm:"size:1000;not null" json:"justification"`
+	// DurationSecs, when set, makes the granted role expire that long after
+	// approval.
+	DurationSecs int        `json:"durationSecs,omitempty"`
+	Status       string     `gorm:"size:20;not null;default:pending;index" json:"status"`
+	DecidedBy    *uint      `json:"decidedBy,omitempty"`
+	DecisionNote string     `gorm:"size:1000" json:"decisionNote,omitempty"`
+	DecidedAt    *time.Time `json:"decidedAt,omitempty"`
+	User         User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role         Role       `gorm:"foreignKey:RoleID" json:"role,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// Access review campaign statuses.
+const (
+	AccessReviewOpen   = "open"
+	AccessReviewClosed = "closed"
+)
+
+// Access review item decisions.
+const (
+	AccessReviewPending = "pending"
+	AccessReviewKeep    = "keep"
+	AccessReviewRevoke  = "revoke"
+)
+
+// AccessReview is a campaign certifying who holds which role. When it starts
+// every matching UserRole is copied into an AccessReviewItem.
+type AccessReview struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	Name   string `gorm:"size:200;not null" json:"name"`
+	RoleID *uint  `gorm:"index" json:"roleId,omitempty"` // nil reviews every role
+	Status string `gorm:"size:20;not null;default:open;index" json:"status"`
+	// AutoRevoke revokes assignments nobody reviewed when the campaign closes.
+	AutoRevoke bool `gorm:"default:false" json:"autoRevoke"`
+	// RepeatDays, when set, starts the next campaign with the same settings
+	// as this one closes, due that many days later.
+	RepeatDays int              `json:"repeatDays,omitempty"`
+	DueAt      *time.Time       `gorm:"index" json:"dueAt,omitempty"`
+	CreatedBy  uint             `json:"createdBy"`
+	ClosedBy   *uint            `json:"closedBy,omitempty"`
+	ClosedAt   *time.Time       `json:"closedAt,omitempty"`
+	Reviewers  []AccessReviewer `gorm:"foreignKey:ReviewID" json:"reviewers,omitempty"`
+	Role       *Role            `gorm:"foreignKey:RoleID" json:"role,omitempty"`
+	Counts     map[string]int64 `gorm:"-" json:"counts,omitempty"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	UpdatedAt  time.Time        `json:"updatedAt"`
+}
+
+// AccessReviewer can decide the items of a campaign.
+type AccessReviewer struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ReviewID  uint      `gorm:"not null;uniqueIndex:idx_access_reviewers_review_user" json:"reviewId"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_access_reviewers_review_user;index" json:"userId"`
+	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccessReviewItem is one role assignment as it was when the campaign
+// started, and what the reviewer decided about it. Items are kept after the
+// campaign closes as the record of the review.
+type AccessReviewItem struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ReviewID       uint       `gorm:"not null;index" json:"reviewId"`
+	UserRoleID     uint       `gorm:"not null" json:"userRoleId"`
+	UserID         uint       `gorm:"not null;index" json:"userId"`
+	RoleID         uint       `gorm:"not null" json:"roleId"`
+	OrganizationID *uint      `json:"organizationId,omitempty"`
+	AssignedBy     uint       `json:"assignedBy,omitempty"`
+	ValidUntil     *time.Time `json:"validUntil,omitempty"`
+	Decision       string     `gorm:"size:20;not null;default:pending;index" json:"decision"`
+	ReviewerID     *uint      `json:"reviewerId,omitempty"` // nil when revoked at close
+	Note           string     `gorm:"size:1000" json:"note,omitempty"`
+	DecidedAt      *time.Time `json:"decidedAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	User           User       `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role           Role       `gorm:"foreignKey:RoleID" json:"role,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
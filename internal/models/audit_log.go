@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records a security relevant change. ActorID is nil when the
+// change was made by the system, e.g. role reconciliation on SSO login.
+type AuditLog struct {
+	ID         uint                   `gorm:"primaryKey" json:"id"`
+	ActorID    *uint                  `gorm:"index" json:"actorId,omitempty"`
+	UserID     *uint                  `gorm:"index" json:"userId,omitempty"`
+	Action     string                 `gorm:"size:100;not null;index" json:"action"`
+	Resource   string                 `gorm:"size:50;not null;index" json:"resource"`
+	ResourceID string                 `gorm:"size:64" json:"resourceId,omitempty"`
+	Details    map[string]interface{} `gorm:"serializer:json;type:text" json:"details,omitempty"`
+	IPAddress  string                 `gorm:"size:64" json:"ipAddress,omitempty"`
+	CreatedAt  time.Time              `gorm:"index" json:"createdAt"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+type EmailOTP struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"userId"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+	CodeHash   string     `gorm:"size:64;not null" json:"-"`
+	Attempts   int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expiresAt"`
+	ConsumedAt *time.Time `json:"consumedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
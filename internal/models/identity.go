@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"userId"`
+	User        User      `gorm:"foreignKey:UserID" json:"-"`
+	Issuer      string    `gorm:"size:255;not null;uniqueIndex:idx_user_identities_issuer_subject" json:"issuer"`
+	Subject     string    `gorm:"size:255;not null;uniqueIndex:idx_user_identities_issuer_subject" json:"subject"`
+	Email       string    `gorm:"size:255" json:"email"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SSOLoginState holds the per-request secrets of an in-flight authorization
+// code flow until the provider redirects back.
+type SSOLoginState struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	State        string    `gorm:"size:128;not null;uniqueIndex" json:"-"`
+	Nonce        string    `gorm:"size:128;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:128;not null" json:"-"`
+	ExpiresAt    time.Time `gorm:"not null;index" json:"expiresAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
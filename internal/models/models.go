@@ -2,13 +2,25 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Role struct {
 	ID          uint               `gorm:"primaryKey" json:"id"`
 	Name        string             `gorm:"size:50;not null;unique" json:"name"`
 	Description string             `gorm:"size:255" json:"description"`
+	RequiresMFA bool               `gorm:"default:false" json:"requiresMfa"`
+	// IsSystem marks the built-in roles that code refers to by ID. They
+	// cannot be renamed or deleted.
+	IsSystem    bool               `gorm:"not null;default:false" json:"isSystem"`
 	Permissions []RolePermission   `gorm:"foreignKey:RoleID" json:"permissions,omitempty"`
+	// Parents are the roles this role inherits permissions from.
+	Parents     []RoleInheritance  `gorm:"foreignKey:RoleID" json:"parents,omitempty"`
+	// InheritedPermissions holds permissions granted through Parents, directly
+	// or transitively, that the role is not granted itself. It is filled in by
+	// permissions.LoadInheritedPermissions.
+	InheritedPermissions []string  `gorm:"-" json:"inheritedPermissions,omitempty"`
 	UserRoles   []UserRole         `gorm:"foreignKey:RoleID" json:"userRoles,omitempty"`
 	CreatedAt   time.Time          `json:"createdAt"`
 	UpdatedAt   time.Time          `json:"updatedAt"`
@@ -30,10 +42,27 @@ type UserRole struct {
 	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Role      Role      `gorm:"foreignKey:RoleID" json:"role,omitempty"`
 	AssignedBy uint     `json:"assignedBy,omitempty"`
+	// OrganizationID limits the role to one organization. Roles without one
+	// are global.
+	OrganizationID *uint `gorm:"index" json:"organizationId,omitempty"`
+	Source    string    `gorm:"size:20;not null;default:manual" json:"source"`
+	// ValidFrom and ValidUntil bound when the assignment is in effect; nil
+	// means immediately and never expiring.
+	ValidFrom        *time.Time `json:"validFrom,omitempty"`
+	ValidUntil       *time.Time `gorm:"index" json:"validUntil,omitempty"`
+	ExpiryNotifiedAt *time.Time `json:"-"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// ActiveAt reports whether the assignment is in effect at t.
+func (ur *UserRole) ActiveAt(t time.Time) bool {
+	if ur.ValidFrom != nil && t.Before(*ur.ValidFrom) {
+		return false
+	}
+	return ur.ValidUntil == nil || t.Before(*ur.ValidUntil)
+}
+
 type RolePermission struct {
 	ID           uint       `gorm:"primaryKey" json:"id"`
 	RoleID       uint       `gorm:"not null" json:"roleId"`
@@ -44,10 +73,19 @@ type RolePermission struct {
 	UpdatedAt    time.Time  `json:"updatedAt"`
 }
 
+// RoleInheritance makes RoleID inherit every permission of ParentRoleID.
+type RoleInheritance struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	RoleID       uint      `gorm:"not null;uniqueIndex:idx_role_inheritances_pair" json:"roleId"`
+	ParentRoleID uint      `gorm:"not null;uniqueIndex:idx_role_inheritances_pair;index" json:"parentRoleId"`
+	Parent       Role      `gorm:"foreignKey:ParentRoleID" json:"parent,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
 type User struct {
 	ID                        uint                     `gorm:"primaryKey" json:"id"`
 	Name                      string                   `gorm:"size:255;not null" json:"name"`
-	Email                     string                   `gorm:"size:255;not null;unique" json:"email"`
+	Email                     string                   `gorm:"size:255;not null;uniqueIndex:idx_users_email_active,where:deleted_at IS NULL" json:"email"`
 	Password                  string                   `gorm:"size:255;not null" json:"-"`
 	LastLogin                 time.Time                `json:"lastLogin,omitempty"`
 	EmailConfirmed            bool                     `gorm:"default:false" json:"emailConfirmed"`
@@ -57,10 +95,15 @@ type User struct {
 	IsActive                  bool                     `gorm:"default:true" json:"isActive"`
 	TwoFactorEnabled          bool                     `gorm:"default:false" json:"twoFactorEnabled"`
 	TwoFactorSecret           string                   `gorm:"size:255" json:"-"`
-	TwoFactorBackupCodes      string                   `gorm:"size:1023" json:"-"`
+	TwoFactorLastUsedStep     int64                    `gorm:"default:0" json:"-"`
+	TwoFactorMethod           string                   `gorm:"size:20;not null;default:totp" json:"twoFactorMethod"`
+	PendingTwoFactorMethod    string                   `gorm:"size:20" json:"-"`
+	PendingTwoFactorSecret    string                   `gorm:"size:255" json:"-"`
 	UserRoles                 []UserRole               `gorm:"foreignKey:UserID" json:"userRoles,omitempty"`
 	CreatedAt                 time.Time                `json:"createdAt"`
 	UpdatedAt                 time.Time                `json:"updatedAt"`
+	DeletedAt                 gorm.DeletedAt           `gorm:"index" json:"deletedAt,omitempty"`
+	ErasedAt                  *time.Time               `json:"erasedAt,omitempty"`
 }
 
 func (u *User) GetRoles() []Role {
@@ -71,12 +114,41 @@ func (u *User) GetRoles() []Role {
 	return roles
 }
 
+// GetPermissions returns the permissions granted by the user's global roles.
 func (u *User) GetPermissions() []string {
+	return u.collectPermissions(func(userRole UserRole) bool {
+		return userRole.OrganizationID == nil
+	})
+}
+
+// GetOrganizationPermissions returns the permissions granted by the user's
+// global roles and their roles in the organization.
+func (u *User) GetOrganizationPermissions(organizationID uint) []string {
+	return u.collectPermissions(func(userRole UserRole) bool {
+		return userRole.OrganizationID == nil || *userRole.OrganizationID == organizationID
+	})
+}
+
+// GetAllPermissions returns the permissions granted by every role the user
+// holds, in any organization.
+func (u *User) GetAllPermissions() []string {
+	return u.collectPermissions(func(UserRole) bool { return true })
+}
+
+// collectPermissions skips assignments that are expired or not yet valid.
+func (u *User) collectPermissions(include func(UserRole) bool) []string {
+	now := time.Now()
 	permissionsMap := make(map[string]bool)
 	for _, userRole := range u.UserRoles {
+		if !include(userRole) || !userRole.ActiveAt(now) {
+			continue
+		}
 		for _, rolePermission := range userRole.Role.Permissions {
 			permissionsMap[rolePermission.Permission.Name] = true
 		}
+		for _, permission := range userRole.Role.InheritedPermissions {
+			permissionsMap[permission] = true
+		}
 	}
 	
 	permissions := make([]string, 0, len(permissionsMap))
@@ -86,9 +158,23 @@ func (u *User) GetPermissions() []string {
 	return permissions
 }
 
+// RequiresMFA reports whether any of the user's roles demands two-factor
+// authentication. UserRoles.Role must be preloaded.
+func (u *User) RequiresMFA() bool {
+	now := time.Now()
+	for _, userRole := range u.UserRoles {
+		if userRole.Role.RequiresMFA && userRole.ActiveAt(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the user currently holds the role globally.
 func (u *User) HasRole(roleID uint) bool {
+	now := time.Now()
 	for _, userRole := range u.UserRoles {
-		if userRole.RoleID == roleID {
+		if userRole.RoleID == roleID && userRole.OrganizationID == nil && userRole.ActiveAt(now) {
 			return true
 		}
 	}
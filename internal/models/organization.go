@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+)
+
+// Organization is a tenant. Users join through a Membership and hold roles
+// per organization through UserRole.OrganizationID.
+type Organization struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Slug      string    `gorm:"size:63;not null;uniqueIndex" json:"slug"`
+	CreatedBy uint      `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Membership is tenant-owned. Its (organization_id, user_id) unique index is
+// created by the migration, since OrganizationScoped's tag can't name it.
+type Membership struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	tenancy.OrganizationScoped
+	UserID       uint         `gorm:"not null;index" json:"userId"`
+	Organization Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
+	User         User         `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt    time.Time    `json:"createdAt"`
+}
+
+// OrganizationInvitation offers a user membership. The membership is only
+// created when the invited user accepts. Like Membership it is tenant-owned
+// and its unique index is created by the migration.
+type OrganizationInvitation struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	tenancy.OrganizationScoped
+	UserID       uint         `gorm:"not null;index" json:"userId"`
+	Organization Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
+	InvitedBy    uint         `json:"invitedBy"`
+	ExpiresAt    time.Time    `gorm:"index" json:"expiresAt"`
+	CreatedAt    time.Time    `json:"createdAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordHistory keeps a user's previous password hashes so they cannot be
+// reused.
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;index" json:"userId"`
+	User         User      `gorm:"foreignKey:UserID" json:"-"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+type Session struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	UserID            uint       `gorm:"not null;index" json:"userId"`
+	User              User       `gorm:"foreignKey:UserID" json:"-"`
+	UserAgent         string     `gorm:"size:512" json:"userAgent"`
+	IPAddress         string     `gorm:"size:64" json:"ipAddress"`
+	TwoFactorVerified bool       `gorm:"default:false" json:"twoFactorVerified"`
+	AuthMethods       []string   `gorm:"serializer:json;size:255" json:"authMethods"`
+	AuthenticatedAt   time.Time  `json:"authenticatedAt"`
+	LastSeenAt        time.Time  `json:"lastSeenAt"`
+	RevokedAt         *time.Time `json:"revokedAt,omitempty"`
+	ImpersonatorID    *uint      `gorm:"index" json:"impersonatorId,omitempty"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+	Current           bool       `gorm:"-" json:"current"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
+}
+
+func (s *Session) IsRevoked() bool {
+	return s.RevokedAt != nil
+}
+
+// IsExpired reports whether a session with a fixed lifetime has ended.
+func (s *Session) IsExpired() bool {
+	return s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now())
+}
+
+// IsImpersonation reports whether an administrator is acting as the user.
+func (s *Session) IsImpersonation() bool {
+	return s.ImpersonatorID != nil
+}
+
+// AuthAge is how long ago the user last proved who they are in this session.
+func (s *Session) AuthAge() time.Duration {
+	return time.Since(s.AuthenticatedAt)
+}
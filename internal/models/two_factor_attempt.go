@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// TwoFactorAttempt counts wrong second-factor codes entered against one
+// challenge, such as an MFA token or a session stepping up.
+type TwoFactorAttempt struct {
+	Challenge string    `gorm:"primaryKey;size:100" json:"challenge"`
+	Failures  int       `gorm:"not null;default:0" json:"failures"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
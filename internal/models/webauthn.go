@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"userId"`
+	User            User       `gorm:"foreignKey:UserID" json:"-"`
+	CredentialID    []byte     `gorm:"not null;uniqueIndex" json:"-"`
+	PublicKey       []byte     `gorm:"not null" json:"-"`
+	AttestationType string     `gorm:"size:32" json:"attestationType"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"signCount"`
+	CloneWarning    bool       `gorm:"default:false" json:"cloneWarning"`
+	BackupEligible  bool       `gorm:"default:false" json:"backupEligible"`
+	BackupState     bool       `gorm:"default:false" json:"backupState"`
+	Transports      []string   `gorm:"serializer:json;type:text" json:"transports"`
+	Attachment      string     `gorm:"size:32" json:"attachment,omitempty"`
+	Nickname        string     `gorm:"size:100" json:"nickname"`
+	LastUsedAt      *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// WebAuthnChallenge holds the server side state of a registration or login
+// ceremony between its begin and finish requests.
+type WebAuthnChallenge struct {
+	ID          uint      `gorm:"primaryKey"`
+	Token       string    `gorm:"size:64;not null;uniqueIndex"`
+	UserID      *uint     `gorm:"index"`
+	Ceremony    string    `gorm:"size:20;not null"`
+	SessionData string    `gorm:"type:text;not null"`
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
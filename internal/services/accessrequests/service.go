@@ -0,0 +1,319 @@
+package accessrequests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/email"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound         = errors.New("access request not found")
+	ErrRoleNotFound     = errors.New("role not found")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrNoApprovers      = errors.New("role has no approvers")
+	ErrAlreadyHeld      = errors.New("user already has this role")
+	ErrAlreadyRequested = errors.New("a request for this role is already pending")
+	ErrAlreadyApprover  = errors.New("user already approves this role")
+	ErrNotPending       = errors.New("access request is no longer pending")
+	ErrNotApprover      = errors.New("user cannot decide requests for this role")
+	ErrSelfApproval     = errors.New("users cannot decide their own requests")
+)
+
+type Dependencies struct {
+	DB     *gorm.DB
+	Logger *zap.Logger
+	Email  email.Service
+	Audit  *audit.Service
+}
+
+type Service struct {
+	*Dependencies
+}
+
+func NewService(deps *Dependencies) *Service {
+	return &Service{Dependencies: deps}
+}
+
+func (s *Service) GetApprovers(roleID uint) ([]models.RoleApprover, error) {
+	var approvers []models.RoleApprover
+	if err := s.DB.Preload("User").Where("role_id = ?", roleID).Find(&approvers).Error; err != nil {
+		return nil, fmt.Errorf("failed to get approvers: %w", err)
+	}
+	return approvers, nil
+}
+
+func (s *Service) AddApprover(roleID, userID uint) error {
+	if err := s.DB.First(&models.Role{}, roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return fmt.Errorf("failed to find role: %w", err)
+	}
+	if err := s.DB.First(&models.User{}, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.RoleApprover{}).Where("role_id = ? AND user_id = ?", roleID, userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check approver: %w", err)
+	}
+	if count > 0 {
+		return ErrAlreadyApprover
+	}
+
+	approver := models.RoleApprover{RoleID: roleID, UserID: userID, CreatedAt: time.Now()}
+	if err := s.DB.Create(&approver).Error; err != nil {
+		return fmt.Errorf("failed to add approver: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) RemoveApprover(roleID, userID uint) error {
+	if err := s.DB.Where("role_id = ? AND user_id = ?", roleID, userID).Delete(&models.RoleApprover{}).Error; err != nil {
+		return fmt.Errorf("failed to remove approver: %w", err)
+	}
+	return nil
+}
+
+// CreateRequest records a request for a global role and emails the role's
+// approvers. A duration makes the role expire that long after approval.
+func (s *Service) CreateRequest(ctx context.Context, user *models.User, roleID uint, justification string, duration time.Duration) (*models.AccessRequest, error) {
+	db := s.DB.WithContext(ctx)
+
+	var role models.Role
+	if err := db.First(&role, roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to find role: %w", err)
+	}
+	if user.HasRole(roleID) {
+		return nil, ErrAlreadyHeld
+	}
+
+	approvers, err := s.GetApprovers(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(approvers) == 0 {
+		return nil, ErrNoApprovers
+	}
+
+	var pending int64
+	err = db.Model(&models.AccessRequest{}).
+		Where("user_id = ? AND role_id = ? AND status = ?", user.ID, roleID, models.AccessRequestPending).
+		Count(&pending).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pending requests: %w", err)
+	}
+	if pending > 0 {
+		return nil, ErrAlreadyRequested
+	}
+
+	request := &models.AccessRequest{
+		UserID:        user.ID,
+		RoleID:        roleID,
+		Justification: justification,
+		DurationSecs:  int(duration / time.Second),
+		Status:        models.AccessRequestPending,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if err := db.Create(request).Error; err != nil {
+		return nil, fmt.Errorf("failed to create access request: %w", err)
+	}
+	request.Role = role
+
+	s.record(ctx, audit.ActionAccessRequested, user.ID, user.ID, request)
+
+	for _, approver := range approvers {
+		if approver.UserID == user.ID || approver.User.ID == 0 {
+			continue
+		}
+		if err := s.Email.SendAccessRequestEmail(ctx, approver.User.Email, approver.User.Name, user.Name, role.Name, justification); err != nil {
+			s.Logger.Error("failed to notify approver", zap.Error(err), zap.Uint("approverId", approver.UserID), zap.Uint("requestId", request.ID))
+		}
+	}
+
+	return request, nil
+}
+
+// Filter selects access requests. ApproverID limits the result to requests
+// for roles that user approves.
+type Filter struct {
+	UserID     uint
+	RoleID     uint
+	ApproverID uint
+	Status     string
+}
+
+// GetRequests returns matching requests newest first.
+func (s *Service) GetRequests(filter Filter) ([]models.AccessRequest, error) {
+	query := s.DB.Preload("User").Preload("Role").Order("created_at desc")
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.RoleID != 0 {
+		query = query.Where("role_id = ?", filter.RoleID)
+	}
+	if filter.ApproverID != 0 {
+		query = query.Where("role_id IN (?)", s.DB.Model(&models.RoleApprover{}).Select("role_id").Where("user_id = ?", filter.ApproverID))
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var requests []models.AccessRequest
+	if err := query.Find(&requests).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access requests: %w", err)
+	}
+	return requests, nil
+}
+
+// Decide approves or denies a pending request. Approval grants the role,
+// assigned by the approver and expiring after the requested duration.
+func (s *Service) Decide(ctx context.Context, requestID uint, approver *models.User, approve bool, note string) (*models.AccessRequest, error) {
+	db := s.DB.WithContext(ctx)
+
+	var request models.AccessRequest
+	if err := db.Preload("User").Preload("Role").First(&request, requestID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find access request: %w", err)
+	}
+	if request.UserID == approver.ID {
+		return nil, ErrSelfApproval
+	}
+
+	var count int64
+	if err := db.Model(&models.RoleApprover{}).Where("role_id = ? AND user_id = ?", request.RoleID, approver.ID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check approver: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrNotApprover
+	}
+
+	status, action := models.AccessRequestDenied, audit.ActionAccessDenied
+	if approve {
+		status, action = models.AccessRequestApproved, audit.ActionAccessApproved
+	}
+	now := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// The status condition keeps two approvers from deciding the same
+		// request.
+		res := tx.Model(&models.AccessRequest{}).
+			Where("id = ? AND status = ?", request.ID, models.AccessRequestPending).
+			Updates(map[string]interface{}{
+				"status":        status,
+				"decided_by":    approver.ID,
+				"decision_note": note,
+				"decided_at":    now,
+				"updated_at":    now,
+			})
+		if res.Error != nil {
+			return fmt.Errorf("failed to update access request: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		if !approve {
+			return nil
+		}
+
+		grant := permissions.RoleGrant{AssignedBy: approver.ID}
+		if request.DurationSecs > 0 {
+			until := now.Add(time.Duration(request.DurationSecs) * time.Second)
+			grant.ValidUntil = &until
+		}
+		err := permissions.NewService(tx).GrantRole(request.UserID, request.RoleID, grant)
+		if err != nil && !errors.Is(err, permissions.ErrRoleAlreadyAssigned) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request.Status = status
+	request.DecidedBy = &approver.ID
+	request.DecisionNote = note
+	request.DecidedAt = &now
+
+	s.record(ctx, action, approver.ID, request.UserID, &request)
+	if approve {
+		s.record(ctx, audit.ActionRoleGranted, approver.ID, request.UserID, &request)
+	}
+
+	if request.User.ID != 0 {
+		if err := s.Email.SendAccessDecisionEmail(ctx, request.User.Email, request.User.Name, request.Role.Name, approve, note); err != nil {
+			s.Logger.Error("failed to notify requester", zap.Error(err), zap.Uint("requestId", request.ID))
+		}
+	}
+
+	return &request, nil
+}
+
+// Cancel withdraws the user's own pending request.
+func (s *Service) Cancel(ctx context.Context, requestID, userID uint) error {
+	db := s.DB.WithContext(ctx)
+
+	var request models.AccessRequest
+	if err := db.Preload("Role").Where("id = ? AND user_id = ?", requestID, userID).First(&request).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to find access request: %w", err)
+	}
+
+	now := time.Now()
+	res := db.Model(&models.AccessRequest{}).
+		Where("id = ? AND status = ?", request.ID, models.AccessRequestPending).
+		Updates(map[string]interface{}{"status": models.AccessRequestCancelled, "decided_at": now, "updated_at": now})
+	if res.Error != nil {
+		return fmt.Errorf("failed to cancel access request: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotPending
+	}
+
+	s.record(ctx, audit.ActionAccessCancelled, userID, userID, &request)
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, action string, actorID, userID uint, request *models.AccessRequest) {
+	entry := &models.AuditLog{
+		ActorID:    &actorID,
+		UserID:     &userID,
+		Action:     action,
+		Resource:   audit.ResourceAccessRequest,
+		ResourceID: strconv.FormatUint(uint64(request.ID), 10),
+		Details: map[string]interface{}{
+			"roleId": request.RoleID,
+			"role":   request.Role.Name,
+		},
+	}
+	if request.Justification != "" {
+		entry.Details["justification"] = request.Justification
+	}
+	if request.DecisionNote != "" {
+		entry.Details["note"] = request.DecisionNote
+	}
+	if err := s.Audit.Record(ctx, entry); err != nil {
+		s.Logger.Error("failed to record access request event", zap.Error(err), zap.String("action", action))
+	}
+}
@@ -0,0 +1,166 @@
+package accessrequests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type recordingEmail struct {
+	requests  []string
+	decisions []bool
+}
+
+func (e *recordingEmail) SendPasswordResetEmail(ctx context.Context, to, name, resetToken string) error {
+	return nil
+}
+func (e *recordingEmail) SendTwoFactorCode(ctx context.Context, to, name, code string) error {
+	return nil
+}
+func (e *recordingEmail) SendWelcomeEmail(ctx context.Context, to, name string) error { return nil }
+func (e *recordingEmail) SendEmailConfirmation(ctx context.Context, to, name, confirmToken string) error {
+	return nil
+}
+func (e *recordingEmail) SendRoleExpiringEmail(ctx context.Context, to, name, role string, expiresAt time.Time) error {
+	return nil
+}
+func (e *recordingEmail) SendAccessRequestEmail(ctx context.Context, to, name, requester, role, justification string) error {
+	e.requests = append(e.requests, to)
+	return nil
+}
+func (e *recordingEmail) SendAccessDecisionEmail(ctx context.Context, to, name, role string, approved bool, note string) error {
+	e.decisions = append(e.decisions, approved)
+	return nil
+}
+
+func newTestService(t *testing.T) (*Service, *gorm.DB, *recordingEmail) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.RolePermission{}, &models.UserRole{},
+		&models.RoleInheritance{}, &models.RoleApprover{}, &models.AccessRequest{}, &models.AuditLog{})
+	if err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	if err := permissions.NewService(db).SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	mail := &recordingEmail{}
+	svc := NewService(&Dependencies{DB: db, Logger: zap.NewNop(), Email: mail, Audit: audit.NewService(db)})
+	return svc, db, mail
+}
+
+func createUser(t *testing.T, db *gorm.DB, email string) *models.User {
+	t.Helper()
+	user := &models.User{Name: "Test User", Email: email, Password: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Expected no error creating user, got %v", err)
+	}
+	return user
+}
+
+func TestRequestAndApprove(t *testing.T) {
+	svc, db, mail := newTestService(t)
+	ctx := context.Background()
+	requester := createUser(t, db, "requester@example.com")
+	approver := createUser(t, db, "approver@example.com")
+
+	if _, err := svc.CreateRequest(ctx, requester, permissions.ROLE_ID_ADMIN, "Need it", 0); !errors.Is(err, ErrNoApprovers) {
+		t.Fatalf("Expected ErrNoApprovers, got %v", err)
+	}
+	if err := svc.AddApprover(permissions.ROLE_ID_ADMIN, approver.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	request, err := svc.CreateRequest(ctx, requester, permissions.ROLE_ID_ADMIN, "Need it", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mail.requests) != 1 || mail.requests[0] != approver.Email {
+		t.Fatalf("Expected the approver to be emailed, got %v", mail.requests)
+	}
+	if _, err := svc.CreateRequest(ctx, requester, permissions.ROLE_ID_ADMIN, "Need it", 0); !errors.Is(err, ErrAlreadyRequested) {
+		t.Fatalf("Expected ErrAlreadyRequested, got %v", err)
+	}
+
+	if _, err := svc.Decide(ctx, request.ID, requester, true, ""); !errors.Is(err, ErrSelfApproval) {
+		t.Fatalf("Expected ErrSelfApproval, got %v", err)
+	}
+	outsider := createUser(t, db, "outsider@example.com")
+	if _, err := svc.Decide(ctx, request.ID, outsider, true, ""); !errors.Is(err, ErrNotApprover) {
+		t.Fatalf("Expected ErrNotApprover, got %v", err)
+	}
+
+	decided, err := svc.Decide(ctx, request.ID, approver, true, "Approved for the audit")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decided.Status != models.AccessRequestApproved || len(mail.decisions) != 1 || !mail.decisions[0] {
+		t.Fatalf("Expected an approved request and a decision email, got %s %v", decided.Status, mail.decisions)
+	}
+
+	var userRole models.UserRole
+	if err := db.Where("user_id = ? AND role_id = ?", requester.ID, permissions.ROLE_ID_ADMIN).First(&userRole).Error; err != nil {
+		t.Fatalf("Expected the role to be granted, got %v", err)
+	}
+	if userRole.AssignedBy != approver.ID || userRole.ValidUntil == nil || userRole.ValidUntil.Before(time.Now().Add(50*time.Minute)) {
+		t.Fatalf("Expected a grant by the approver expiring in an hour, got %+v", userRole)
+	}
+
+	if _, err := svc.Decide(ctx, request.ID, approver, false, ""); !errors.Is(err, ErrNotPending) {
+		t.Fatalf("Expected ErrNotPending, got %v", err)
+	}
+
+	var count int64
+	db.Model(&models.AuditLog{}).Where("resource = ?", audit.ResourceAccessRequest).Count(&count)
+	if count != 3 {
+		t.Fatalf("Expected requested, approved and granted audit entries, got %d", count)
+	}
+}
+
+func TestDenyAndCancel(t *testing.T) {
+	svc, db, _ := newTestService(t)
+	ctx := context.Background()
+	requester := createUser(t, db, "requester@example.com")
+	approver := createUser(t, db, "approver@example.com")
+	svc.AddApprover(permissions.ROLE_ID_ADMIN, approver.ID)
+
+	request, _ := svc.CreateRequest(ctx, requester, permissions.ROLE_ID_ADMIN, "Need it", 0)
+	if _, err := svc.Decide(ctx, request.ID, approver, false, "No"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var count int64
+	db.Model(&models.UserRole{}).Where("user_id = ?", requester.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("Expected no role after denial, got %d", count)
+	}
+
+	request, _ = svc.CreateRequest(ctx, requester, permissions.ROLE_ID_ADMIN, "Need it again", 0)
+	if err := svc.Cancel(ctx, request.ID, approver.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound cancelling another user's request, got %v", err)
+	}
+	if err := svc.Cancel(ctx, request.ID, requester.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := svc.Cancel(ctx, request.ID, requester.ID); !errors.Is(err, ErrNotPending) {
+		t.Fatalf("Expected ErrNotPending, got %v", err)
+	}
+
+	history, err := svc.GetRequests(Filter{UserID: requester.ID})
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected both requests in the history, got %d (%v)", len(history), err)
+	}
+}
@@ -0,0 +1,551 @@
+package accessreviews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type Config struct {
+	SigningKey        string `envconfig:"ACCESS_REVIEW_SIGNING_KEY" required:"true"`
+	CloseIntervalSecs int    `envconfig:"ACCESS_REVIEW_CLOSE_INTERVAL_SEC" default:"3600"` // 1 hour default
+}
+
+var (
+	ErrNotFound        = errors.New("access review not found")
+	ErrItemNotFound    = errors.New("access review item not found")
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrNoReviewers     = errors.New("access review needs at least one reviewer")
+	ErrAlreadyReviewer = errors.New("user already reviews this campaign")
+	ErrClosed          = errors.New("access review is closed")
+	ErrNotReviewer     = errors.New("user does not review this campaign")
+	ErrSelfReview      = errors.New("users cannot review their own access")
+	ErrAlreadyDecided  = errors.New("access review item has already been decided")
+	ErrInvalidDecision = errors.New("decision must be keep or revoke")
+)
+
+const (
+	autoRevokeNote = "Not reviewed before the campaign closed"
+	// lastAdminNote explains an unreviewed item that was kept because
+	// revoking it would leave no administrator.
+	lastAdminNote = "Kept because it is the last administrator's access"
+)
+
+type Dependencies struct {
+	DB     *gorm.DB
+	Logger *zap.Logger
+	Audit  *audit.Service
+}
+
+type Service struct {
+	*Config
+	*Dependencies
+}
+
+func NewService(cfg *Config, deps *Dependencies) *Service {
+	return &Service{Config: cfg, Dependencies: deps}
+}
+
+// CampaignSpec describes a campaign to start. A nil RoleID reviews every
+// role assignment.
+type CampaignSpec struct {
+	Name        string
+	RoleID      *uint
+	ReviewerIDs []uint
+	AutoRevoke  bool
+	DueAt       *time.Time
+	RepeatDays  int
+}
+
+// CreateCampaign starts a campaign and snapshots the matching role
+// assignments of users that have not been deleted.
+func (s *Service) CreateCampaign(ctx context.Context, spec CampaignSpec, createdBy uint) (*models.AccessReview, error) {
+	db := s.DB.WithContext(ctx)
+
+	if len(spec.ReviewerIDs) == 0 {
+		return nil, ErrNoReviewers
+	}
+	if spec.RoleID != nil {
+		if err := db.First(&models.Role{}, *spec.RoleID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrRoleNotFound
+			}
+			return nil, fmt.Errorf("failed to find role: %w", err)
+		}
+	}
+
+	reviewerIDs := uniqueIDs(spec.ReviewerIDs)
+	var found int64
+	if err := db.Model(&models.User{}).Where("id IN ?", reviewerIDs).Count(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to find reviewers: %w", err)
+	}
+	if int(found) != len(reviewerIDs) {
+		return nil, ErrUserNotFound
+	}
+
+	now := time.Now()
+	review := &models.AccessReview{
+		Name:       spec.Name,
+		RoleID:     spec.RoleID,
+		Status:     models.AccessReviewOpen,
+		AutoRevoke: spec.AutoRevoke,
+		RepeatDays: spec.RepeatDays,
+		DueAt:      spec.DueAt,
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	var items []models.AccessReviewItem
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(review).Error; err != nil {
+			return fmt.Errorf("failed to create access review: %w", err)
+		}
+
+		for _, userID := range reviewerIDs {
+			reviewer := models.AccessReviewer{ReviewID: review.ID, UserID: userID, CreatedAt: now}
+			if err := tx.Create(&reviewer).Error; err != nil {
+				return fmt.Errorf("failed to add reviewer: %w", err)
+			}
+		}
+
+		query := tx.Model(&models.UserRole{}).Select("user_roles.*").
+			Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+			Order("user_roles.id")
+		if spec.RoleID != nil {
+			query = query.Where("user_roles.role_id = ?", *spec.RoleID)
+		}
+		var userRoles []models.UserRole
+		if err := query.Find(&userRoles).Error; err != nil {
+			return fmt.Errorf("failed to snapshot role assignments: %w", err)
+		}
+
+		items = make([]models.AccessReviewItem, len(userRoles))
+		for i, ur := range userRoles {
+			items[i] = models.AccessReviewItem{
+				ReviewID:       review.ID,
+				UserRoleID:     ur.ID,
+				UserID:         ur.UserID,
+				RoleID:         ur.RoleID,
+				OrganizationID: ur.OrganizationID,
+				AssignedBy:     ur.AssignedBy,
+				ValidUntil:     ur.ValidUntil,
+				Decision:       models.AccessReviewPending,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+		}
+		if len(items) > 0 {
+			if err := tx.CreateInBatches(items, 200).Error; err != nil {
+				return fmt.Errorf("failed to create access review items: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, audit.ActionReviewStarted, &createdBy, review, map[string]interface{}{
+		"name":  review.Name,
+		"items": len(items),
+	})
+
+	return s.GetCampaign(ctx, review.ID)
+}
+
+// GetCampaigns lists campaigns newest first. ReviewerID limits the result to
+// campaigns that user reviews.
+func (s *Service) GetCampaigns(ctx context.Context, status string, reviewerID uint) ([]models.AccessReview, error) {
+	query := s.DB.WithContext(ctx).Preload("Role").Order("created_at desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if reviewerID != 0 {
+		query = query.Where("id IN (?)", s.DB.Model(&models.AccessReviewer{}).Select("review_id").Where("user_id = ?", reviewerID))
+	}
+
+	var reviews []models.AccessReview
+	if err := query.Find(&reviews).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// GetCampaign returns a campaign with its reviewers and the number of items
+// per decision.
+func (s *Service) GetCampaign(ctx context.Context, reviewID uint) (*models.AccessReview, error) {
+	db := s.DB.WithContext(ctx)
+
+	var review models.AccessReview
+	if err := db.Preload("Role").Preload("Reviewers.User").First(&review, reviewID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find access review: %w", err)
+	}
+
+	var counts []struct {
+		Decision string
+		Count    int64
+	}
+	err := db.Model(&models.AccessReviewItem{}).
+		Select("decision, count(*) as count").
+		Where("review_id = ?", reviewID).
+		Group("decision").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count access review items: %w", err)
+	}
+	review.Counts = map[string]int64{
+		models.AccessReviewPending: 0,
+		models.AccessReviewKeep:    0,
+		models.AccessReviewRevoke:  0,
+	}
+	for _, c := range counts {
+		review.Counts[c.Decision] = c.Count
+	}
+
+	return &review, nil
+}
+
+func (s *Service) GetItems(ctx context.Context, reviewID uint, decision string) ([]models.AccessReviewItem, error) {
+	query := s.DB.WithContext(ctx).
+		Preload("User", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Role").
+		Where("review_id = ?", reviewID).
+		Order("id")
+	if decision != "" {
+		query = query.Where("decision = ?", decision)
+	}
+
+	var items []models.AccessReviewItem
+	if err := query.Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access review items: %w", err)
+	}
+	return items, nil
+}
+
+func (s *Service) IsReviewer(ctx context.Context, reviewID, userID uint) (bool, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&models.AccessReviewer{}).
+		Where("review_id = ? AND user_id = ?", reviewID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check reviewer: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *Service) AddReviewer(ctx context.Context, reviewID, userID uint) error {
+	db := s.DB.WithContext(ctx)
+
+	review, err := s.openCampaign(db, reviewID)
+	if err != nil {
+		return err
+	}
+	if err := db.First(&models.User{}, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+
+	isReviewer, err := s.IsReviewer(ctx, review.ID, userID)
+	if err != nil {
+		return err
+	}
+	if isReviewer {
+		return ErrAlreadyReviewer
+	}
+
+	reviewer := models.AccessReviewer{ReviewID: review.ID, UserID: userID, CreatedAt: time.Now()}
+	if err := db.Create(&reviewer).Error; err != nil {
+		return fmt.Errorf("failed to add reviewer: %w", err)
+	}
+	return nil
+}
+
+// Decide records a reviewer's decision on one item. Revoking removes the
+// role assignment straight away.
+func (s *Service) Decide(ctx context.Context, reviewID, itemID uint, reviewer *models.User, decision, note string) (*models.AccessReviewItem, error) {
+	if decision != models.AccessReviewKeep && decision != models.AccessReviewRevoke {
+		return nil, ErrInvalidDecision
+	}
+
+	db := s.DB.WithContext(ctx)
+
+	review, err := s.openCampaign(db, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	isReviewer, err := s.IsReviewer(ctx, review.ID, reviewer.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !isReviewer {
+		return nil, ErrNotReviewer
+	}
+
+	var item models.AccessReviewItem
+	if err := db.Preload("Role").Where("id = ? AND review_id = ?", itemID, review.ID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrItemNotFound
+		}
+		return nil, fmt.Errorf("failed to find access review item: %w", err)
+	}
+	if item.UserID == reviewer.ID {
+		return nil, ErrSelfReview
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.AccessReviewItem{}).
+			Where("id = ? AND decision = ?", item.ID, models.AccessReviewPending).
+			Updates(map[string]interface{}{
+				"decision":    decision,
+				"reviewer_id": reviewer.ID,
+				"note":        note,
+				"decided_at":  now,
+				"updated_at":  now,
+			})
+		if res.Error != nil {
+			return fmt.Errorf("failed to update access review item: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrAlreadyDecided
+		}
+		if decision == models.AccessReviewRevoke {
+			return revokeItem(tx, &item, now)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	item.Decision = decision
+	item.ReviewerID = &reviewer.ID
+	item.Note = note
+	item.DecidedAt = &now
+
+	action := audit.ActionReviewKept
+	if decision == models.AccessReviewRevoke {
+		action = audit.ActionReviewRevoked
+	}
+	s.recordItem(ctx, action, &reviewer.ID, review, &item)
+
+	return &item, nil
+}
+
+// Close ends a campaign. closedBy is nil when the campaign closed because it
+// was due. Pending items are revoked when the campaign auto-revokes, unless
+// that would remove the last administrator, and a repeating campaign starts
+// its next run.
+func (s *Service) Close(ctx context.Context, reviewID uint, closedBy *uint) (*models.AccessReview, error) {
+	db := s.DB.WithContext(ctx)
+
+	review, err := s.openCampaign(db, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var revoked []models.AccessReviewItem
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.AccessReview{}).
+			Where("id = ? AND status = ?", review.ID, models.AccessReviewOpen).
+			Updates(map[string]interface{}{
+				"status":     models.AccessReviewClosed,
+				"closed_by":  closedBy,
+				"closed_at":  now,
+				"updated_at": now,
+			})
+		if res.Error != nil {
+			return fmt.Errorf("failed to close access review: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrClosed
+		}
+		if !review.AutoRevoke {
+			return nil
+		}
+
+		var pending []models.AccessReviewItem
+		if err := tx.Preload("Role").Where("review_id = ? AND decision = ?", review.ID, models.AccessReviewPending).Find(&pending).Error; err != nil {
+			return fmt.Errorf("failed to find unreviewed items: %w", err)
+		}
+		for i := range pending {
+			item := &pending[i]
+			decision, note := models.AccessReviewRevoke, autoRevokeNote
+			err := revokeItem(tx, item, now)
+			if errors.Is(err, permissions.ErrLastAdmin) {
+				decision, note = models.AccessReviewKeep, lastAdminNote
+			} else if err != nil {
+				return err
+			}
+
+			err = tx.Model(&models.AccessReviewItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+				"decision":   decision,
+				"note":       note,
+				"decided_at": now,
+				"updated_at": now,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update access review item: %w", err)
+			}
+			item.Decision = decision
+			item.Note = note
+			if decision == models.AccessReviewRevoke {
+				revoked = append(revoked, *item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range revoked {
+		s.recordItem(ctx, audit.ActionReviewRevoked, nil, review, &revoked[i])
+	}
+	s.record(ctx, audit.ActionReviewClosed, closedBy, review, map[string]interface{}{
+		"name":        review.Name,
+		"autoRevoked": len(revoked),
+	})
+
+	if review.RepeatDays > 0 {
+		s.startNextRun(ctx, review, now)
+	}
+
+	return s.GetCampaign(ctx, review.ID)
+}
+
+func (s *Service) startNextRun(ctx context.Context, review *models.AccessReview, now time.Time) {
+	reviewerIDs := make([]uint, len(review.Reviewers))
+	for i, r := range review.Reviewers {
+		reviewerIDs[i] = r.UserID
+	}
+	dueAt := now.AddDate(0, 0, review.RepeatDays)
+
+	_, err := s.CreateCampaign(ctx, CampaignSpec{
+		Name:        review.Name,
+		RoleID:      review.RoleID,
+		ReviewerIDs: reviewerIDs,
+		AutoRevoke:  review.AutoRevoke,
+		DueAt:       &dueAt,
+		RepeatDays:  review.RepeatDays,
+	}, review.CreatedBy)
+	if err != nil {
+		s.Logger.Error("failed to start next access review", zap.Error(err), zap.Uint("reviewId", review.ID))
+	}
+}
+
+// CloseDueCampaigns closes every open campaign past its due date.
+func (s *Service) CloseDueCampaigns(ctx context.Context) error {
+	var due []models.AccessReview
+	err := s.DB.WithContext(ctx).
+		Where("status = ? AND due_at <= ?", models.AccessReviewOpen, time.Now()).
+		Find(&due).Error
+	if err != nil {
+		return fmt.Errorf("failed to find due access reviews: %w", err)
+	}
+
+	var errs []error
+	for _, review := range due {
+		if _, err := s.Close(ctx, review.ID, nil); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, fmt.Errorf("failed to close access review %d: %w", review.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) openCampaign(db *gorm.DB, reviewID uint) (*models.AccessReview, error) {
+	var review models.AccessReview
+	if err := db.Preload("Reviewers").First(&review, reviewID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find access review: %w", err)
+	}
+	if review.Status != models.AccessReviewOpen {
+		return nil, ErrClosed
+	}
+	return &review, nil
+}
+
+// revokeItem removes the assignment an item was snapshotted from. It may
+// already be gone, for example because it expired. It fails with
+// permissions.ErrLastAdmin rather than remove the last administrator.
+func revokeItem(tx *gorm.DB, item *models.AccessReviewItem, now time.Time) error {
+	err := permissions.GuardLastAdmin(tx, func(tx *gorm.DB) error {
+		return tx.Delete(&models.UserRole{}, item.UserRoleID).Error
+	})
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke role assignment: %w", err)
+	}
+	if err := tx.Model(&models.AccessReviewItem{}).Where("id = ?", item.ID).Update("revoked_at", now).Error; err != nil {
+		return fmt.Errorf("failed to update access review item: %w", err)
+	}
+	item.RevokedAt = &now
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, action string, actorID *uint, review *models.AccessReview, details map[string]interface{}) {
+	entry := &models.AuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		Resource:   audit.ResourceAccessReview,
+		ResourceID: strconv.FormatUint(uint64(review.ID), 10),
+		Details:    details,
+	}
+	if err := s.Audit.Record(ctx, entry); err != nil {
+		s.Logger.Error("failed to record access review event", zap.Error(err), zap.String("action", action))
+	}
+}
+
+func (s *Service) recordItem(ctx context.Context, action string, actorID *uint, review *models.AccessReview, item *models.AccessReviewItem) {
+	userID := item.UserID
+	entry := &models.AuditLog{
+		ActorID:    actorID,
+		UserID:     &userID,
+		Action:     action,
+		Resource:   audit.ResourceAccessReview,
+		ResourceID: strconv.FormatUint(uint64(review.ID), 10),
+		Details: map[string]interface{}{
+			"itemId":         item.ID,
+			"role":           item.Role.Name,
+			"organizationId": item.OrganizationID,
+		},
+	}
+	if item.Note != "" {
+		entry.Details["note"] = item.Note
+	}
+	if err := s.Audit.Record(ctx, entry); err != nil {
+		s.Logger.Error("failed to record access review event", zap.Error(err), zap.String("action", action))
+	}
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
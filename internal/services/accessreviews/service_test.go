@@ -0,0 +1,167 @@
+package accessreviews
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.RolePermission{}, &models.UserRole{},
+		&models.RoleInheritance{}, &models.AccessReview{}, &models.AccessReviewer{}, &models.AccessReviewItem{}, &models.AuditLog{})
+	if err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	if err := permissions.NewService(db).SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	svc := NewService(&Config{SigningKey: "test-key"}, &Dependencies{DB: db, Logger: zap.NewNop(), Audit: audit.NewService(db)})
+	return svc, db
+}
+
+func createUser(t *testing.T, db *gorm.DB, email string, roleIDs ...uint) *models.User {
+	t.Helper()
+	user := &models.User{Name: "Test User", Email: email, Password: "x"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Expected no error creating user, got %v", err)
+	}
+	for _, roleID := range roleIDs {
+		db.Create(&models.UserRole{UserID: user.ID, RoleID: roleID})
+	}
+	return user
+}
+
+func itemFor(t *testing.T, items []models.AccessReviewItem, userID uint) models.AccessReviewItem {
+	t.Helper()
+	for _, item := range items {
+		if item.UserID == userID {
+			return item
+		}
+	}
+	t.Fatalf("Expected an item for user %d", userID)
+	return models.AccessReviewItem{}
+}
+
+func TestReviewCampaign(t *testing.T) {
+	svc, db := newTestService(t)
+	ctx := context.Background()
+	reviewer := createUser(t, db, "reviewer@example.com", permissions.ROLE_ID_ADMIN)
+	kept := createUser(t, db, "kept@example.com", permissions.ROLE_ID_ADMIN)
+	revoked := createUser(t, db, "revoked@example.com", permissions.ROLE_ID_ADMIN, permissions.ROLE_ID_USER)
+	unreviewed := createUser(t, db, "unreviewed@example.com", permissions.ROLE_ID_ADMIN)
+
+	if _, err := svc.CreateCampaign(ctx, CampaignSpec{Name: "Q1"}, reviewer.ID); !errors.Is(err, ErrNoReviewers) {
+		t.Fatalf("Expected ErrNoReviewers, got %v", err)
+	}
+
+	roleID := uint(permissions.ROLE_ID_ADMIN)
+	review, err := svc.CreateCampaign(ctx, CampaignSpec{Name: "Q1", RoleID: &roleID, ReviewerIDs: []uint{reviewer.ID}, AutoRevoke: true, RepeatDays: 90}, reviewer.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if review.Counts[models.AccessReviewPending] != 4 {
+		t.Fatalf("Expected the four Admin assignments to be snapshotted, got %v", review.Counts)
+	}
+
+	items, _ := svc.GetItems(ctx, review.ID, "")
+	outsider := createUser(t, db, "outsider@example.com")
+	if _, err := svc.Decide(ctx, review.ID, itemFor(t, items, kept.ID).ID, outsider, models.AccessReviewKeep, ""); !errors.Is(err, ErrNotReviewer) {
+		t.Fatalf("Expected ErrNotReviewer, got %v", err)
+	}
+	if _, err := svc.Decide(ctx, review.ID, itemFor(t, items, reviewer.ID).ID, reviewer, models.AccessReviewKeep, ""); !errors.Is(err, ErrSelfReview) {
+		t.Fatalf("Expected ErrSelfReview, got %v", err)
+	}
+
+	if _, err := svc.Decide(ctx, review.ID, itemFor(t, items, kept.ID).ID, reviewer, models.AccessReviewKeep, "Still on the team"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := svc.Decide(ctx, review.ID, itemFor(t, items, kept.ID).ID, reviewer, models.AccessReviewRevoke, ""); !errors.Is(err, ErrAlreadyDecided) {
+		t.Fatalf("Expected ErrAlreadyDecided, got %v", err)
+	}
+	if _, err := svc.Decide(ctx, review.ID, itemFor(t, items, revoked.ID).ID, reviewer, models.AccessReviewRevoke, "Left the team"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var count int64
+	db.Model(&models.UserRole{}).Where("user_id = ? AND role_id = ?", revoked.ID, permissions.ROLE_ID_ADMIN).Count(&count)
+	if count != 0 {
+		t.Fatal("Expected the revoked assignment to be removed")
+	}
+	db.Model(&models.UserRole{}).Where("user_id = ?", revoked.ID).Count(&count)
+	if count != 1 {
+		t.Fatal("Expected assignments outside the campaign to be kept")
+	}
+
+	closed, err := svc.Close(ctx, review.ID, &reviewer.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if closed.Status != models.AccessReviewClosed || closed.Counts[models.AccessReviewRevoke] != 3 || closed.Counts[models.AccessReviewKeep] != 1 {
+		t.Fatalf("Expected unreviewed items to be revoked at close, got %s %v", closed.Status, closed.Counts)
+	}
+	db.Model(&models.UserRole{}).Where("user_id = ?", unreviewed.ID).Count(&count)
+	if count != 0 {
+		t.Fatal("Expected the unreviewed assignment to be removed")
+	}
+	if _, err := svc.Close(ctx, review.ID, nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+
+	open, _ := svc.GetCampaigns(ctx, models.AccessReviewOpen, reviewer.ID)
+	if len(open) != 1 || open[0].DueAt == nil || open[0].DueAt.Before(time.Now().AddDate(0, 0, 89)) {
+		t.Fatalf("Expected the next run to start, got %+v", open)
+	}
+}
+
+func TestReportSignature(t *testing.T) {
+	svc, db := newTestService(t)
+	ctx := context.Background()
+	reviewer := createUser(t, db, "reviewer@example.com")
+	createUser(t, db, "member@example.com", permissions.ROLE_ID_USER)
+
+	past := time.Now().Add(-time.Minute)
+	review, err := svc.CreateCampaign(ctx, CampaignSpec{Name: "All roles", ReviewerIDs: []uint{reviewer.ID}, DueAt: &past}, reviewer.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := svc.CloseDueCampaigns(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	report, signature, err := svc.Report(ctx, review.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(report)).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected a header and one row, got %d (%v)", len(rows), err)
+	}
+	if rows[1][2] != models.AccessReviewClosed || rows[1][5] != "member@example.com" || rows[1][10] != models.AccessReviewPending {
+		t.Fatalf("Unexpected row %v", rows[1])
+	}
+
+	if !svc.Verify(report, signature) {
+		t.Fatal("Expected the signature to verify")
+	}
+	if svc.Verify(bytes.Replace(report, []byte("pending"), []byte("keep"), 1), signature) {
+		t.Fatal("Expected a modified report to fail verification")
+	}
+}
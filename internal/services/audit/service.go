@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ActionRoleGranted  = "role.granted"
+	ActionRoleRevoked  = "role.revoked"
+	ActionRoleExpired  = "role.expired"
+	ActionUserExported = "user.exported"
+	ActionUserErased   = "user.erased"
+
+	ActionAccessRequested = "access.requested"
+	ActionAccessApproved  = "access.approved"
+	ActionAccessDenied    = "access.denied"
+	ActionAccessCancelled = "access.cancelled"
+
+	ActionImpersonationStarted = "impersonation.started"
+	ActionImpersonationEnded   = "impersonation.ended"
+	ActionImpersonatedRequest  = "impersonation.request"
+
+	ActionReviewStarted = "review.started"
+	ActionReviewKept    = "review.kept"
+	ActionReviewRevoked = "review.revoked"
+	ActionReviewClosed  = "review.closed"
+)
+
+const (
+	ResourceUser          = "user"
+	ResourceUserRole      = "userrole"
+	ResourceAccessRequest = "accessrequest"
+	ResourceAccessReview  = "accessreview"
+)
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+type Filter struct {
+	UserID    *uint
+	Action    string
+	Resource  string
+	StartDate *time.Time
+	EndDate   *time.Time
+	Page      int
+	Limit     int
+}
+
+func (s *Service) Record(ctx context.Context, entry *models.AuditLog) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if err := s.db.WithContext(ctx).Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to record audit log: %w", err)
+	}
+	return nil
+}
+
+// List returns matching entries newest first along with the total count.
+func (s *Service) List(ctx context.Context, filter Filter) ([]models.AuditLog, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.AuditLog{})
+
+	if filter.UserID != nil {
+		query = query.Where("user_id = ? OR actor_id = ?", *filter.UserID, *filter.UserID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Resource != "" {
+		query = query.Where("resource = ?", filter.Resource)
+	}
+	if filter.StartDate != nil {
+		query = query.Where("created_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("created_at <= ?", *filter.EndDate)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit logs: %w", err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	page := filter.Page
+	if page <= 0 {
+		page = 1
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("created_at desc, id desc").Limit(limit).Offset((page - 1) * limit).Find(&logs).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+
+	return logs, total, nil
+}
@@ -0,0 +1,276 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationcontext "github.com/feezyhendrix/echoboilerplate/internal/common/authentication_context"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyHeader        = "X-API-Key"
+	apiKeyTokenPrefix   = "ebk_"
+	apiKeyTouchInterval = time.Minute
+	apiKeyPrefixBytes   = 6
+	apiKeySecretBytes   = 32
+)
+
+type CreateAPIKeyResponse struct {
+	APIKey *models.APIKey `json:"apiKey"`
+	Key    string         `json:"key"`
+}
+
+func isAPIKey(tkn string) bool {
+	return strings.HasPrefix(tkn, apiKeyTokenPrefix)
+}
+
+// generateAPIKey returns the full key handed to the caller once, along with
+// the lookup prefix and the hash that is persisted in its place.
+func generateAPIKey() (key, prefix, secretHash string, err error) {
+	prefixBytes := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+
+	secretBytes := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	return apiKeyTokenPrefix + prefix + "_" + secret, prefix, hashAPIKeySecret(secret), nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseAPIKey(key string) (prefix, secret string, ok bool) {
+	if !isAPIKey(key) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyTokenPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// findAPIKey returns nil when the key is unknown, does not match its stored
+// hash, or has been revoked or expired.
+func (s *service) findAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	prefix, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	var apiKey models.APIKey
+	err := s.Database.Conn.WithContext(ctx).Where("prefix = ?", prefix).First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, nil
+	}
+
+	if !apiKey.IsUsable(time.Now()) {
+		return nil, nil
+	}
+
+	return &apiKey, nil
+}
+
+func (s *service) touchAPIKey(ctx context.Context, apiKey *models.APIKey) error {
+	now := time.Now()
+	if apiKey.LastUsedAt != nil && now.Sub(*apiKey.LastUsedAt) < apiKeyTouchInterval {
+		return nil
+	}
+
+	apiKey.LastUsedAt = &now
+	return s.Database.Conn.WithContext(ctx).Model(apiKey).Update("last_used_at", now).Error
+}
+
+func (s *service) authenticateAPIKey(c echo.Context, next echo.HandlerFunc, key string) error {
+	req := c.Request()
+	lgr := logger.ContextLogger(req.Context(), s.Logger)
+
+	apiKey, err := s.findAPIKey(req.Context(), key)
+	if err != nil {
+		lgr.Error("failed to look up api key", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if apiKey == nil {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	req, err = authenticationcontext.SetUserContext(req, float64(apiKey.UserID))
+	if err != nil {
+		return err
+	}
+
+	fullUser, err := s.loadAuthenticatedUser(req.Context(), apiKey.UserID)
+	if err != nil {
+		lgr.Error("failed to load api key owner", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if fullUser == nil {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	if err := s.touchAPIKey(req.Context(), apiKey); err != nil {
+		lgr.Warn("failed to update api key last used", zap.Error(err))
+	}
+
+	c.Set("user", fullUser)
+	c.Set("apiKey", apiKey)
+	c.SetRequest(req)
+	return next(c)
+}
+
+func (s *service) PostCreateAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	if _, usingKey := c.Get("apiKey").(*models.APIKey); usingKey {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot create other API keys")
+	}
+
+	var payload validator.CreateAPIKeyRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		if validationErr, ok := err.(*validator.ValidationErrors); ok {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error":   "Validation failed",
+				"details": validationErr.Errors,
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	// Keys may carry organization permissions; they are only usable inside
+	// that organization because requests are narrowed to the key's scopes.
+	ownerPermissions := user.GetAllPermissions()
+	for _, scope := range payload.Scopes {
+		if !permissions.HasPermission(ownerPermissions, scope) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Scope " + scope + " is not granted to the key owner",
+			})
+		}
+	}
+
+	key, prefix, secretHash, err := generateAPIKey()
+	if err != nil {
+		lgr.Error("failed to generate api key", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	now := time.Now()
+	apiKey := &models.APIKey{
+		UserID:     user.ID,
+		Name:       payload.Name,
+		Prefix:     prefix,
+		SecretHash: secretHash,
+		Scopes:     payload.Scopes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if payload.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, payload.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := s.Database.Conn.WithContext(ctx).Create(apiKey).Error; err != nil {
+		lgr.Error("failed to create api key", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	lgr.Info("api key created", zap.Uint("apiKeyId", apiKey.ID), zap.Strings("scopes", apiKey.Scopes))
+	return c.JSON(http.StatusCreated, &CreateAPIKeyResponse{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+func (s *service) GetAPIKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	var apiKeys []models.APIKey
+	err := s.Database.Conn.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Order("created_at desc").
+		Find(&apiKeys).Error
+	if err != nil {
+		lgr.Error("failed to list api keys", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"apiKeys": apiKeys,
+	})
+}
+
+func (s *service) DeleteAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid API key ID")
+	}
+
+	res := s.Database.Conn.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", params.ID, user.ID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		lgr.Error("failed to revoke api key", zap.Error(res.Error))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if res.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+}
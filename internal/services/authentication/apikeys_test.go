@@ -0,0 +1,92 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+)
+
+// createAPIKey creates a key for the signed-in user and returns it.
+func createAPIKey(t *testing.T, service *service, token string, scopes ...string) string {
+	t.Helper()
+	rec := doRequest(testRouter(service), http.MethodPost, "/api/v1/user/api-keys", token, map[string]interface{}{
+		"name":   "Test key",
+		"scopes": scopes,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created CreateAPIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal api key: %v", err)
+	}
+	return created.Key
+}
+
+func TestAPIKeys(t *testing.T) {
+	service, database := setupTestService(t)
+	router := testRouter(service)
+	owner := createTestUser(t, service, "keys@example.com", "password123")
+	service.Permissions.AssignRoleToUser(owner.ID, permissions.ROLE_ID_TEAM_ACCOUNT)
+	tokens := signIn(t, service, "keys@example.com", "password123")
+	ownSessions := "/api/v1/users/" + strconv.Itoa(int(owner.ID)) + "/sessions"
+
+	if rec := doRequest(router, http.MethodPost, "/api/v1/user/api-keys", tokens.AccessToken, map[string]interface{}{
+		"name":   "Too broad",
+		"scopes": []string{permissions.PermissionRoleWrite},
+	}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a scope the owner lacks to be refused, got %d", rec.Code)
+	}
+
+	readKey := createAPIKey(t, service, tokens.AccessToken, permissions.PermissionUserRead)
+	reportKey := createAPIKey(t, service, tokens.AccessToken, permissions.PermissionReportRead)
+
+	t.Run("self-service routes refuse keys", func(t *testing.T) {
+		for _, route := range []struct{ method, path string }{
+			{http.MethodGet, "/api/v1/user/profile"},
+			{http.MethodDelete, "/api/v1/user/sessions"},
+			{http.MethodGet, "/api/v1/user/api-keys"},
+			{http.MethodDelete, "/api/v1/user/api-keys/1"},
+			{http.MethodPost, "/api/v1/user/api-keys"},
+			{http.MethodPatch, "/api/v1/user/webauthn/credentials/1"},
+		} {
+			if rec := doRequest(router, route.method, route.path, readKey, nil); rec.Code != http.StatusForbidden {
+				t.Fatalf("Expected %s %s to refuse API keys, got %d", route.method, route.path, rec.Code)
+			}
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the session to stay signed in, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ownership does not widen a key's scopes", func(t *testing.T) {
+		if rec := doRequest(router, http.MethodGet, ownSessions, readKey, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected a user:read key to list the owner's sessions, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, ownSessions, reportKey, nil); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected a report:read key to be refused, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodDelete, ownSessions, readKey, nil); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected a key without user:write to be refused, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the owner to stay signed in, got %d", rec.Code)
+		}
+	})
+
+	t.Run("revoked keys are rejected", func(t *testing.T) {
+		var key models.APIKey
+		database.Conn.Where("user_id = ?", owner.ID).Order("id").First(&key)
+		rec := doRequest(router, http.MethodDelete, "/api/v1/user/api-keys/"+strconv.Itoa(int(key.ID)), tokens.AccessToken, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, ownSessions, readKey, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the revoked key to be rejected, got %d", rec.Code)
+		}
+	})
+}
@@ -1,21 +1,41 @@
 package authentication
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 	"strings"
 
 	authenticationcontext "github.com/feezyhendrix/echoboilerplate/internal/common/authentication_context"
 	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
+// mfaExemptRoutes stay reachable for sessions that still owe a second factor
+// required by the user's role, so they can enroll or step up.
+var mfaExemptRoutes = map[string]bool{
+	"/api/v1/user/profile":                  true,
+	"/api/v1/user/2fa/enable":               true,
+	"/api/v1/user/2fa/confirm":              true,
+	"/api/v1/user/2fa/email/send":           true,
+	"/api/v1/user/webauthn/register/begin":  true,
+	"/api/v1/user/webauthn/register/finish": true,
+	"/api/v1/auth/step-up":                  true,
+}
+
 func (s *service) AuthenticationMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			req := c.Request()
 			lgr := logger.ContextLogger(req.Context(), s.Logger)
+
+			if apiKey := req.Header.Get(apiKeyHeader); apiKey != "" {
+				return s.authenticateAPIKey(c, next, apiKey)
+			}
+
 			authHdr := req.Header.Get("Authorization")
 
 			if authHdr == "" {
@@ -35,6 +55,10 @@ func (s *service) AuthenticationMiddleware() echo.MiddlewareFunc {
 				return c.NoContent(http.StatusUnauthorized)
 			}
 
+			if isAPIKey(flds[1]) {
+				return s.authenticateAPIKey(c, next, flds[1])
+			}
+
 			valid, jwtUsr, err := s.validateToken(flds[1])
 
 			if err != nil {
@@ -45,34 +69,106 @@ func (s *service) AuthenticationMiddleware() echo.MiddlewareFunc {
 				return c.NoContent(http.StatusUnauthorized)
 			}
 
+			revoked, err := s.isTokenRevoked(req.Context(), jwtUsr.JTI)
+			if err != nil {
+				lgr.Error("failed to check token denylist", zap.Error(err))
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			if revoked {
+				return c.NoContent(http.StatusUnauthorized)
+			}
+
 			req, err = authenticationcontext.SetUserContext(req, jwtUsr.UserID)
 			if err != nil {
 				return err
 			}
 
-			// Load full user with roles
-			user, err := s.Users.GetUserByID(req.Context(), uint(jwtUsr.UserID))
+			fullUser, err := s.loadAuthenticatedUser(req.Context(), uint(jwtUsr.UserID))
 			if err != nil {
 				lgr.Error("failed to load user for authentication", zap.Error(err))
 				return c.NoContent(http.StatusInternalServerError)
 			}
 
-			if user == nil {
+			if fullUser == nil {
 				return c.NoContent(http.StatusUnauthorized)
 			}
 
-			// Load user roles
-			var fullUser models.User
-			err = s.Database.Conn.Preload("UserRoles.Role.Permissions").First(&fullUser, uint(user.ID)).Error
+			session, err := s.getActiveSession(req.Context(), fullUser.ID, uint(jwtUsr.SessionID))
 			if err != nil {
-				lgr.Error("failed to load user roles", zap.Error(err))
+				lgr.Error("failed to load session for authentication", zap.Error(err))
 				return c.NoContent(http.StatusInternalServerError)
 			}
 
+			if session == nil {
+				return c.NoContent(http.StatusUnauthorized)
+			}
+
+			if err := s.touchSession(req.Context(), session); err != nil {
+				lgr.Warn("failed to update session last seen", zap.Error(err))
+			}
+
+			var impersonator *models.User
+			if session.IsImpersonation() {
+				impersonator, err = s.loadImpersonator(req.Context(), session, jwtUsr)
+				if err != nil {
+					lgr.Error("failed to load impersonator for authentication", zap.Error(err))
+					return c.NoContent(http.StatusInternalServerError)
+				}
+				if impersonator == nil {
+					return c.NoContent(http.StatusUnauthorized)
+				}
+
+				authenticationcontext.SetImpersonatorID(req.Context(), float64(impersonator.ID))
+				c.Response().Header().Set(impersonatorHeader, strconv.FormatUint(uint64(impersonator.ID), 10))
+			}
+
+			if !session.TwoFactorVerified && fullUser.RequiresMFA() && !mfaExemptRoutes[c.Path()] {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":                 "Two-factor authentication is required for your role",
+					"mfaEnrollmentRequired": !fullUser.TwoFactorEnabled,
+					"stepUpRequired":        fullUser.TwoFactorEnabled,
+				})
+			}
+
 			// Set user in context for middleware to access
-			c.Set("user", &fullUser)
+			c.Set("user", fullUser)
+			c.Set("session", session)
 			c.SetRequest(req)
-			return next(c)
+
+			if impersonator == nil {
+				return next(c)
+			}
+
+			c.Set("impersonator", impersonator)
+			handler := next
+			if impersonationBlockedRoutes[req.Method+" "+c.Path()] {
+				handler = impersonationBlocked
+			}
+			err = handler(c)
+			s.logImpersonatedRequest(c, impersonator, session, err)
+			return err
 		}
 	}
 }
+
+// loadAuthenticatedUser returns the user with roles, permission names and
+// inherited permissions loaded, or nil when the user no longer exists or has
+// been deactivated.
+func (s *service) loadAuthenticatedUser(ctx context.Context, userID uint) (*models.User, error) {
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil || user == nil || !user.IsActive {
+		return nil, err
+	}
+
+	var fullUser models.User
+	conn := s.Database.Conn.WithContext(ctx)
+	err = conn.Preload("UserRoles.Role.Permissions.Permission").First(&fullUser, user.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := permissions.LoadUserInheritedPermissions(conn, &fullUser); err != nil {
+		return nil, err
+	}
+
+	return &fullUser, nil
+}
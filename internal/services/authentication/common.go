@@ -9,8 +9,10 @@ import (
 
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 
-	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
 )
 
 func (s *service) validateToken(tkn string) (bool, *TokenContext, error) {
@@ -28,25 +30,53 @@ func (s *service) validateToken(tkn string) (bool, *TokenContext, error) {
 	}
 
 	jwtUsr, err := s.parseTokenContext(claims)
-	return token.Valid, jwtUsr, err
+	if err != nil {
+		return false, nil, nil
+	}
+
+	return token.Valid, jwtUsr, nil
 }
 
 func (s *service) parseTokenContext(claims jwt.MapClaims) (*TokenContext, error) {
 	userID, usrOk := claims["userId"].(float64)
-	if !usrOk {
+	sessionID, sessOk := claims["sessionId"].(float64)
+	if !usrOk || !sessOk {
 		return nil, fmt.Errorf("failed to parse jwt claims")
 	}
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	impersonatorID, _ := claims["impersonatorId"].(float64)
 	usr := &TokenContext{
 		UserID:         userID,
+		SessionID:      sessionID,
+		JTI:            jti,
+		ExpiresAt:      exp,
+		ImpersonatorID: impersonatorID,
 	}
 
 	return usr, s.Validate.Struct(usr)
 }
 
 func (s *service) generateToken(usr *TokenContext, iatUnix, expUnix int64) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
+
 	ajwt := jwt.New(jwt.SigningMethodHS256)
 	claims := ajwt.Claims.(jwt.MapClaims)
+	claims["jti"] = jti
 	claims["userId"] = usr.UserID
+	claims["sessionId"] = usr.SessionID
+	if len(usr.AMR) > 0 {
+		claims["amr"] = usr.AMR
+	}
+	if usr.AuthTime > 0 {
+		claims["auth_time"] = int64(usr.AuthTime)
+	}
+	if usr.ImpersonatorID > 0 {
+		claims["impersonatorId"] = usr.ImpersonatorID
+	}
 	claims["iat"] = iatUnix
 	claims["exp"] = expUnix
 
@@ -71,13 +101,24 @@ func (s *service) generateTokens(usr *TokenContext) (*Tokens, error) {
 	}, nil
 }
 
-func (s *service) updateUserPassword(ctx context.Context, usrID float64, pw string) error {
-	hashByts, err := passwords.GenerateHashFromPassword(pw)
+// rehashPassword upgrades a stored hash made with an outdated algorithm or
+// parameters. Failures are logged and never block the sign-in.
+func (s *service) rehashPassword(ctx context.Context, usr *models.User, pw string) {
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	hash, err := s.Passwords.Hash(pw)
 	if err != nil {
-		return err
+		lgr.Warn("failed to rehash password", zap.Error(err))
+		return
+	}
+
+	if err := s.Users.UpdateUserPassword(ctx, usr.ID, hash); err != nil {
+		lgr.Warn("failed to store rehashed password", zap.Error(err))
+		return
 	}
 
-	return  s.Users.UpdateUserPassword(ctx, uint(usrID), string(hashByts))
+	usr.Password = hash
+	lgr.Info("password rehashed", zap.Uint("userId", usr.ID))
 }
 
 const (
@@ -0,0 +1,186 @@
+package authentication
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	twoFactorMethodTOTP  = "totp"
+	twoFactorMethodEmail = "email"
+)
+
+var errEmailOTPCooldown = errors.New("email code requested too recently")
+
+func generateEmailOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashEmailOTP keys the hash so six digit codes cannot be brute forced from
+// a copy of the table.
+func (s *service) hashEmailOTP(code string) string {
+	mac := hmac.New(sha256.New, []byte(s.TwoFactorEncryptionKey))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendEmailOTP replaces any outstanding code for the user with a new one and
+// emails it. It returns errEmailOTPCooldown when the previous code was sent
+// less than the resend cooldown ago.
+func (s *service) sendEmailOTP(ctx context.Context, user *models.User) error {
+	db := s.Database.Conn.WithContext(ctx)
+
+	var last models.EmailOTP
+	err := db.Where("user_id = ?", user.ID).Order("created_at desc").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && time.Since(last.CreatedAt) < time.Duration(s.EmailOTPResendCooldownSecs)*time.Second {
+		return errEmailOTPCooldown
+	}
+
+	code, err := generateEmailOTP()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	otp := &models.EmailOTP{
+		UserID:    user.ID,
+		CodeHash:  s.hashEmailOTP(code),
+		ExpiresAt: now.Add(time.Duration(s.EmailOTPTTLSecs) * time.Second),
+		CreatedAt: now,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(otp).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Email.SendTwoFactorCode(ctx, user.Email, user.Name, code)
+}
+
+// verifyEmailOTP consumes the user's outstanding code if it matches. Every
+// wrong guess counts against the code, which stops working after
+// EmailOTPMaxAttempts.
+func (s *service) verifyEmailOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	db := s.Database.Conn.WithContext(ctx)
+
+	var otp models.EmailOTP
+	err := db.Where("user_id = ? AND consumed_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("created_at desc").
+		First(&otp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if otp.Attempts >= s.EmailOTPMaxAttempts {
+		return false, nil
+	}
+
+	if !hmac.Equal([]byte(otp.CodeHash), []byte(s.hashEmailOTP(code))) {
+		err := db.Model(&models.EmailOTP{}).Where("id = ?", otp.ID).
+			UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+		return false, err
+	}
+
+	res := db.Model(&models.EmailOTP{}).
+		Where("id = ? AND consumed_at IS NULL AND attempts < ?", otp.ID, s.EmailOTPMaxAttempts).
+		Update("consumed_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+func (s *service) emailOTPSent(c echo.Context, err error) error {
+	if errors.Is(err, errEmailOTPCooldown) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Please wait before requesting another code"})
+	}
+	if err != nil {
+		logger.ContextLogger(c.Request().Context(), s.Logger).Error("failed to send email code", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Verification code sent"})
+}
+
+// PostResendEmailOTP sends a new sign-in code to a user holding an MFA token.
+func (s *service) PostResendEmailOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	var payload validator.ResendEmailOTPRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	challenge, err := s.parseMFAToken(ctx, payload.MFAToken)
+	if err != nil {
+		lgr.Error("failed to parse mfa token", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if challenge == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired MFA token"})
+	}
+
+	user, err := s.Users.GetUserByID(ctx, uint(challenge.UserID))
+	if err != nil {
+		lgr.Error("failed to get user", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil || !user.TwoFactorEnabled || user.TwoFactorMethod != twoFactorMethodEmail {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Email codes are not enabled for this account"})
+	}
+
+	return s.emailOTPSent(c, s.sendEmailOTP(ctx, user))
+}
+
+// PostSendEmailOTP sends a code to the signed-in user for confirming
+// enrollment, disabling 2FA or regenerating backup codes.
+func (s *service) PostSendEmailOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	user, err := twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+
+	method := user.TwoFactorMethod
+	if !user.TwoFactorEnabled {
+		method = user.PendingTwoFactorMethod
+	}
+	if method != twoFactorMethodEmail {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Email codes are not enabled for this account"})
+	}
+
+	return s.emailOTPSent(c, s.sendEmailOTP(ctx, user))
+}
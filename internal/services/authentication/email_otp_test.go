@@ -0,0 +1,122 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+// backdateEmailOTPs moves the user's outstanding codes past the resend
+// cooldown.
+func backdateEmailOTPs(service *service, userID uint) {
+	service.Database.Conn.Model(&models.EmailOTP{}).Where("user_id = ?", userID).
+		Update("created_at", time.Now().Add(-time.Duration(service.EmailOTPResendCooldownSecs+1)*time.Second))
+}
+
+func TestEmailOTPCooldown(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+	mailer := service.Email.(*mockEmailService)
+	user := createTestUser(t, service, "otp@example.com", "Password123!")
+	token := signIn(t, service, "otp@example.com", "Password123!").AccessToken
+
+	enable2FA(t, h, token, twoFactorMethodEmail)
+	if mailer.sentCodes != 1 {
+		t.Fatalf("Expected enrollment to send one code, got %d", mailer.sentCodes)
+	}
+	first := mailer.twoFactorCode
+
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/email/send", token, nil)
+	if rec.Code != http.StatusTooManyRequests || mailer.sentCodes != 1 {
+		t.Fatalf("Expected 429 within the cooldown, got %d after %d sends", rec.Code, mailer.sentCodes)
+	}
+
+	backdateEmailOTPs(service, user.ID)
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/email/send", token, nil)
+	if rec.Code != http.StatusOK || mailer.sentCodes != 2 {
+		t.Fatalf("Expected a new code after the cooldown, got %d after %d sends", rec.Code, mailer.sentCodes)
+	}
+
+	if first != mailer.twoFactorCode {
+		rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": first})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected the replaced code to stop working, got %d", rec.Code)
+		}
+	}
+
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": mailer.twoFactorCode})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the latest code to confirm enrollment, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	t.Run("sign-in resend honours the cooldown", func(t *testing.T) {
+		backdateEmailOTPs(service, user.ID)
+		rec := doRequest(h, http.MethodPost, "/api/v1/auth/login", "", map[string]string{
+			"email":    "otp@example.com",
+			"password": "Password123!",
+		})
+		var challenge TwoFactorChallengeResponse
+		json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if rec.Code != http.StatusForbidden || challenge.Method != twoFactorMethodEmail || challenge.MFAToken == "" {
+			t.Fatalf("Expected an email 2FA challenge, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		rec = doRequest(h, http.MethodPost, "/api/v1/auth/2fa/email/resend", "", map[string]string{"mfaToken": challenge.MFAToken})
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 for an immediate resend, got %d", rec.Code)
+		}
+
+		rec = doRequest(h, http.MethodPost, "/api/v1/auth/verify-2fa", "", map[string]string{
+			"mfaToken": challenge.MFAToken,
+			"code":     mailer.twoFactorCode,
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected the emailed code to complete sign-in, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestEmailOTPAttemptLimit(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+	mailer := service.Email.(*mockEmailService)
+	user := createTestUser(t, service, "guess@example.com", "Password123!")
+	token := signIn(t, service, "guess@example.com", "Password123!").AccessToken
+
+	enable2FA(t, h, token, twoFactorMethodEmail)
+	code := mailer.twoFactorCode
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < service.EmailOTPMaxAttempts; i++ {
+		rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": wrong})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected wrong guess %d to be rejected, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": code})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected the code to stop working after %d wrong guesses, got %d", service.EmailOTPMaxAttempts, rec.Code)
+	}
+
+	var otp models.EmailOTP
+	service.Database.Conn.Where("user_id = ?", user.ID).First(&otp)
+	if otp.Attempts != service.EmailOTPMaxAttempts || otp.ConsumedAt != nil {
+		t.Fatalf("Expected %d recorded attempts and no consumption, got %+v", service.EmailOTPMaxAttempts, otp)
+	}
+
+	backdateEmailOTPs(service, user.ID)
+	if rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/email/send", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a fresh code to be sendable, got %d", rec.Code)
+	}
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": mailer.twoFactorCode})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a fresh code to work, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,236 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// impersonatorHeader is set on every response to an impersonated request so
+// the SPA can show who is really signed in.
+const impersonatorHeader = "X-Impersonator-ID"
+
+// impersonationBlockedRoutes change how the user signs in, act on their
+// whole account or its data, or make decisions in their name, so
+// administrators acting as the user cannot reach them. Routes behind
+// RequireRecentAuth are refused as well, whether listed here or not.
+var impersonationBlockedRoutes = map[string]bool{
+	"POST /api/v1/auth/step-up":                              true,
+	"POST /api/v1/user/password":                             true,
+	"GET /api/v1/user/export":                                true,
+	"POST /api/v1/user/erase":                                true,
+	"DELETE /api/v1/user/sessions":                           true,
+	"DELETE /api/v1/user/sessions/:id":                       true,
+	"POST /api/v1/user/api-keys":                             true,
+	"DELETE /api/v1/user/api-keys/:id":                       true,
+	"POST /api/v1/user/2fa/enable":                           true,
+	"POST /api/v1/user/2fa/confirm":                          true,
+	"POST /api/v1/user/2fa/disable":                          true,
+	"POST /api/v1/user/2fa/backup-codes":                     true,
+	"POST /api/v1/user/2fa/email/send":                       true,
+	"POST /api/v1/user/webauthn/register/begin":              true,
+	"POST /api/v1/user/webauthn/register/finish":             true,
+	"PATCH /api/v1/user/webauthn/credentials/:id":            true,
+	"DELETE /api/v1/user/webauthn/credentials/:id":           true,
+	"POST /api/v1/user/invitations/:id/accept":               true,
+	"DELETE /api/v1/user/invitations/:id":                    true,
+	"DELETE /api/v1/users/:id/sessions":                      true,
+	"DELETE /api/v1/users/:id/sessions/:sessionId":           true,
+	"POST /api/v1/users/:id/impersonate":                     true,
+	"POST /api/v1/access-requests/:id/approve":               true,
+	"POST /api/v1/access-requests/:id/deny":                  true,
+	"POST /api/v1/access-reviews/:id/items/:itemId/decision": true,
+}
+
+func impersonationBlocked(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]interface{}{
+		"error":         "Not allowed while impersonating",
+		"impersonating": true,
+	})
+}
+
+// PostImpersonate starts a short-lived session as another user. The access
+// token carries both identities and cannot be refreshed.
+func (s *service) PostImpersonate(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	admin, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+	adminSession, ok := c.Get("session").(*models.Session)
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "Impersonation requires a signed-in session")
+	}
+
+	var payload validator.ImpersonateRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	if payload.ID == admin.ID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "You cannot impersonate yourself"})
+	}
+
+	target, err := s.loadAuthenticatedUser(ctx, payload.ID)
+	if err != nil {
+		lgr.Error("failed to load user to impersonate", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if target == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if target.HasPermission(permissions.PermissionSystemAdmin) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Administrators cannot be impersonated"})
+	}
+
+	// The session inherits how the administrator authenticated, so the MFA
+	// check reflects the person actually at the keyboard. It never counts as
+	// recent authentication: RequireRecentAuth refuses impersonation
+	// sessions outright.
+	now := time.Now()
+	expiresAt := now.Add(time.Second * time.Duration(s.ImpersonationTTLSecs))
+	session := &models.Session{
+		UserID:            target.ID,
+		UserAgent:         truncate(c.Request().UserAgent(), 512),
+		IPAddress:         truncate(c.RealIP(), 64),
+		TwoFactorVerified: adminSession.TwoFactorVerified,
+		AuthMethods:       adminSession.AuthMethods,
+		AuthenticatedAt:   adminSession.AuthenticatedAt,
+		LastSeenAt:        now,
+		ImpersonatorID:    &admin.ID,
+		ExpiresAt:         &expiresAt,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := s.Database.Conn.WithContext(ctx).Create(session).Error; err != nil {
+		lgr.Error("failed to create impersonation session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	accessToken, err := s.generateToken(sessionTokenContext(session), now.Unix(), expiresAt.Unix())
+	if err != nil {
+		lgr.Error("failed to generate impersonation token", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	s.recordImpersonation(c, audit.ActionImpersonationStarted, admin.ID, session, map[string]interface{}{
+		"reason":    payload.Reason,
+		"expiresAt": expiresAt,
+	})
+	lgr.Info("impersonation started", zap.Uint("impersonatorId", admin.ID), zap.Uint("userId", target.ID), zap.Uint("sessionId", session.ID))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"expiresAt":    expiresAt,
+		"impersonating": map[string]interface{}{
+			"id":    target.ID,
+			"name":  target.Name,
+			"email": target.Email,
+		},
+	})
+}
+
+// PostStopImpersonation ends the impersonation session the request was made
+// with. The administrator's own session is untouched.
+func (s *service) PostStopImpersonation(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	session, ok := c.Get("session").(*models.Session)
+	if !ok || !session.IsImpersonation() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Not impersonating"})
+	}
+
+	if _, err := s.revokeSession(ctx, session.UserID, session.ID); err != nil {
+		lgr.Error("failed to revoke impersonation session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	s.recordImpersonation(c, audit.ActionImpersonationEnded, *session.ImpersonatorID, session, nil)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Impersonation ended",
+	})
+}
+
+// loadImpersonator returns the administrator behind an impersonation
+// session, or nil when the token does not match the session or the
+// administrator has since lost access.
+func (s *service) loadImpersonator(ctx context.Context, session *models.Session, tkn *TokenContext) (*models.User, error) {
+	if uint(tkn.ImpersonatorID) != *session.ImpersonatorID {
+		return nil, nil
+	}
+
+	impersonator, err := s.loadAuthenticatedUser(ctx, *session.ImpersonatorID)
+	if err != nil || impersonator == nil {
+		return nil, err
+	}
+	if !impersonator.HasPermission(permissions.PermissionSystemAdmin) {
+		return nil, nil
+	}
+	return impersonator, nil
+}
+
+// logImpersonatedRequest records a request made while impersonating. Every
+// request is logged; requests that can change state are also audited.
+func (s *service) logImpersonatedRequest(c echo.Context, impersonator *models.User, session *models.Session, handlerErr error) {
+	req := c.Request()
+	lgr := logger.ContextLogger(req.Context(), s.Logger)
+
+	status := c.Response().Status
+	if handlerErr != nil && !c.Response().Committed {
+		status = http.StatusInternalServerError
+		if he, ok := handlerErr.(*echo.HTTPError); ok {
+			status = he.Code
+		}
+	}
+	lgr.Info("impersonated request",
+		zap.Uint("impersonatorId", impersonator.ID),
+		zap.Uint("userId", session.UserID),
+		zap.String("method", req.Method),
+		zap.String("path", req.URL.Path),
+		zap.Int("status", status))
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	s.recordImpersonation(c, audit.ActionImpersonatedRequest, impersonator.ID, session, map[string]interface{}{
+		"method": req.Method,
+		"path":   req.URL.Path,
+		"status": status,
+	})
+}
+
+func (s *service) recordImpersonation(c echo.Context, action string, impersonatorID uint, session *models.Session, details map[string]interface{}) {
+	ctx := c.Request().Context()
+
+	userID := session.UserID
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["sessionId"] = session.ID
+	entry := &models.AuditLog{
+		ActorID:    &impersonatorID,
+		UserID:     &userID,
+		Action:     action,
+		Resource:   audit.ResourceUser,
+		ResourceID: strconv.FormatUint(uint64(userID), 10),
+		Details:    details,
+		IPAddress:  c.RealIP(),
+	}
+	if err := s.Audit.Record(ctx, entry); err != nil {
+		logger.ContextLogger(ctx, s.Logger).Error("failed to record impersonation", zap.Error(err), zap.String("action", action))
+	}
+}
@@ -0,0 +1,274 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/totp"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	mfaTokenPurpose = "mfa"
+	mfaTokenTTL     = 5 * time.Minute
+	backupCodeCount = 10
+)
+
+var backupCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateMFAToken issues the short lived token a client exchanges for a
+// session once it has supplied a second factor. firstFactor is the amr value
+// of the sign-in that asked for it. It carries no session ID so the
+// middleware never accepts it as an access token.
+func (s *service) generateMFAToken(userID uint, firstFactor string) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	mjwt := jwt.New(jwt.SigningMethodHS256)
+	claims := mjwt.Claims.(jwt.MapClaims)
+	claims["jti"] = jti
+	claims["userId"] = float64(userID)
+	claims["purpose"] = mfaTokenPurpose
+	claims["factor"] = firstFactor
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(mfaTokenTTL).Unix()
+
+	return mjwt.SignedString([]byte(s.Config.JWTSecret))
+}
+
+// parseMFAToken returns nil when the token is invalid, expired, issued for
+// another purpose or already used.
+func (s *service) parseMFAToken(ctx context.Context, tkn string) (*TokenContext, error) {
+	token, err := jwt.Parse(tkn, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(s.Config.JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != mfaTokenPurpose {
+		return nil, nil
+	}
+
+	userID, usrOk := claims["userId"].(float64)
+	jti, jtiOk := claims["jti"].(string)
+	exp, expOk := claims["exp"].(float64)
+	if !usrOk || !jtiOk || !expOk || jti == "" {
+		return nil, nil
+	}
+
+	revoked, err := s.isTokenRevoked(ctx, jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, nil
+	}
+
+	firstFactor, _ := claims["factor"].(string)
+	if firstFactor == "" {
+		firstFactor = amrPassword
+	}
+
+	return &TokenContext{
+		UserID:    userID,
+		JTI:       jti,
+		ExpiresAt: exp,
+		AMR:       []string{firstFactor},
+	}, nil
+}
+
+// startMFAChallenge issues an MFA token for a user who has passed
+// firstFactor and, for the email method, sends them a code.
+func (s *service) startMFAChallenge(ctx context.Context, usr *models.User, firstFactor string) (*TwoFactorChallengeResponse, error) {
+	mfaToken, err := s.generateMFAToken(usr.ID, firstFactor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+
+	if usr.TwoFactorMethod == twoFactorMethodEmail {
+		if err := s.sendEmailOTP(ctx, usr); err != nil && !errors.Is(err, errEmailOTPCooldown) {
+			return nil, fmt.Errorf("failed to send email code: %w", err)
+		}
+	}
+
+	return &TwoFactorChallengeResponse{
+		Error:             "2FA code required",
+		TwoFactorRequired: true,
+		Method:            usr.TwoFactorMethod,
+		MFAToken:          mfaToken,
+	}, nil
+}
+
+func generateBackupCode() (string, error) {
+	bytes := make([]byte, 7)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(backupCodeEncoding.EncodeToString(bytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashBackupCode ignores case, whitespace and the separator so codes can be
+// typed the way they read.
+func hashBackupCode(code string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(code, "-", "")), ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceBackupCodes discards every existing backup code for the user and
+// returns a fresh set. Only the hashes are stored.
+func replaceBackupCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.BackupCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	codes := make([]string, backupCodeCount)
+	rows := make([]models.BackupCode, backupCodeCount)
+	for i := range codes {
+		code, err := generateBackupCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.BackupCode{
+			UserID:    userID,
+			CodeHash:  hashBackupCode(code),
+			CreatedAt: now,
+		}
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// verifyTOTP checks code against the user's stored secret and records the
+// accepted time step so the same code cannot be used twice.
+func (s *service) verifyTOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	if user.TwoFactorSecret == "" {
+		return false, nil
+	}
+
+	secret, err := s.secrets.Decrypt(user.TwoFactorSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), user.TwoFactorLastUsedStep)
+	if !ok {
+		return false, nil
+	}
+
+	res := s.Database.Conn.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND two_factor_last_used_step < ?", user.ID, step).
+		Update("two_factor_last_used_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	user.TwoFactorLastUsedStep = step
+	return res.RowsAffected == 1, nil
+}
+
+// useBackupCode marks a matching unused backup code as used.
+func (s *service) useBackupCode(ctx context.Context, userID uint, code string) (bool, error) {
+	res := s.Database.Conn.WithContext(ctx).Model(&models.BackupCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashBackupCode(code)).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected == 1, nil
+}
+
+// verifyOneTimeCode checks a code from the user's chosen factor.
+func (s *service) verifyOneTimeCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	if user.TwoFactorMethod == twoFactorMethodEmail {
+		return s.verifyEmailOTP(ctx, user, code)
+	}
+
+	return s.verifyTOTP(ctx, user, code)
+}
+
+// verifySecondFactor accepts either a one-time code or an unused backup code.
+func (s *service) verifySecondFactor(ctx context.Context, user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return s.verifyOneTimeCode(ctx, user, code)
+	}
+
+	return s.useBackupCode(ctx, user.ID, code)
+}
+
+// recordSecondFactorFailure counts a wrong code against challenge and
+// reports whether it has used up TwoFactorMaxAttempts. The count is
+// forgotten at expiresAt.
+func (s *service) recordSecondFactorFailure(ctx context.Context, challenge string, expiresAt time.Time) (bool, error) {
+	db := s.Database.Conn.WithContext(ctx)
+	if err := db.Where("challenge = ? AND expires_at <= ?", challenge, time.Now()).Delete(&models.TwoFactorAttempt{}).Error; err != nil {
+		return false, err
+	}
+
+	attempt := models.TwoFactorAttempt{Challenge: challenge, Failures: 1, ExpiresAt: expiresAt, CreatedAt: time.Now()}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "challenge"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"failures": gorm.Expr("two_factor_attempts.failures + 1")}),
+	}).Create(&attempt).Error
+	if err != nil {
+		return false, err
+	}
+
+	var failures int
+	if err := db.Model(&models.TwoFactorAttempt{}).Where("challenge = ?", challenge).Select("failures").Scan(&failures).Error; err != nil {
+		return false, err
+	}
+	return failures >= s.TwoFactorMaxAttempts, nil
+}
+
+// secondFactorLocked reports whether challenge has used up its attempts.
+func (s *service) secondFactorLocked(ctx context.Context, challenge string) (bool, error) {
+	var count int64
+	err := s.Database.Conn.WithContext(ctx).Model(&models.TwoFactorAttempt{}).
+		Where("challenge = ? AND expires_at > ? AND failures >= ?", challenge, time.Now(), s.TwoFactorMaxAttempts).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// clearSecondFactorFailures forgets the wrong codes counted against challenge.
+func (s *service) clearSecondFactorFailures(ctx context.Context, challenge string) error {
+	return s.Database.Conn.WithContext(ctx).Where("challenge = ?", challenge).Delete(&models.TwoFactorAttempt{}).Error
+}
+
+// userRequiresMFA loads the user's roles and reports whether one in effect
+// demands 2FA, as the authentication middleware does.
+func (s *service) userRequiresMFA(ctx context.Context, usr *models.User) (bool, error) {
+	err := s.Database.Conn.WithContext(ctx).Preload("Role").Where("user_id = ?", usr.ID).Find(&usr.UserRoles).Error
+	if err != nil {
+		return false, err
+	}
+	return usr.RequiresMFA(), nil
+}
@@ -0,0 +1,172 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// checkPassword applies the password policy to a new password for user. For
+// existing users it also rejects the current and recently used passwords.
+func (s *service) checkPassword(ctx context.Context, user *models.User, password string) ([]passwords.Violation, error) {
+	violations, err := s.PasswordPolicy.Check(password, user.Name, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID == 0 || s.PasswordPolicy.HistorySize <= 0 {
+		return violations, nil
+	}
+
+	hashes := []string{user.Password}
+	if s.PasswordPolicy.HistorySize > 1 {
+		var previous []string
+		err := s.Database.Conn.WithContext(ctx).Model(&models.PasswordHistory{}).
+			Where("user_id = ?", user.ID).
+			Order("created_at desc, id desc").
+			Limit(s.PasswordPolicy.HistorySize-1).
+			Pluck("password_hash", &previous).Error
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, previous...)
+	}
+
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		match, err := s.Passwords.Verify(hash, password)
+		if err != nil && err != passwords.ErrInvalidHash {
+			return nil, err
+		}
+		if match {
+			return append(violations, passwords.Violation{Code: "reused", Message: "Must not match one of your recent passwords"}), nil
+		}
+	}
+
+	return violations, nil
+}
+
+// setPassword hashes and stores a new password, moving the old hash into the
+// user's password history and trimming it to the policy's size.
+func (s *service) setPassword(ctx context.Context, user *models.User, password string) error {
+	hash, err := s.Passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	keep := s.PasswordPolicy.HistorySize - 1
+	err = s.Database.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if user.Password != "" && keep > 0 {
+			entry := &models.PasswordHistory{UserID: user.ID, PasswordHash: user.Password, CreatedAt: time.Now()}
+			if err := tx.Create(entry).Error; err != nil {
+				return err
+			}
+		}
+
+		stale := tx.Model(&models.PasswordHistory{}).Select("id").
+			Where("user_id = ?", user.ID).
+			Order("created_at desc, id desc").
+			Offset(max(keep, 0)).Limit(-1)
+		if err := tx.Where("id IN (?)", stale).Delete(&models.PasswordHistory{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"password":                  hash,
+			"password_reset_token":      "",
+			"password_reset_expires_at": time.Time{},
+			"updated_at":                time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Password = hash
+	user.PasswordResetToken = ""
+	user.PasswordResetExpiresAt = time.Time{}
+	return nil
+}
+
+// passwordRejected reports policy violations in the same shape as validator
+// errors so clients can show them next to the field.
+func passwordRejected(c echo.Context, field string, violations []passwords.Violation) error {
+	details := make([]validator.ValidationError, len(violations))
+	for i, v := range violations {
+		details[i] = validator.ValidationError{
+			Field:   field,
+			Tag:     v.Code,
+			Message: v.Message,
+		}
+	}
+
+	return c.JSON(http.StatusBadRequest, map[string]interface{}{
+		"error":   "Validation failed",
+		"details": details,
+	})
+}
+
+// PostChangePassword replaces the signed-in user's password and signs out
+// their other sessions.
+func (s *service) PostChangePassword(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	session, ok := c.Get("session").(*models.Session)
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot change passwords")
+	}
+
+	var payload validator.UpdateUserPasswordRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	match, err := s.Passwords.Verify(user.Password, payload.CurrentPassword)
+	if err != nil {
+		lgr.Error("failed to compare password", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !match {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid password"})
+	}
+
+	violations, err := s.checkPassword(ctx, user, payload.NewPassword)
+	if err != nil {
+		lgr.Error("failed to check password policy", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if len(violations) > 0 {
+		return passwordRejected(c, "newPassword", violations)
+	}
+
+	if err := s.setPassword(ctx, user, payload.NewPassword); err != nil {
+		lgr.Error("failed to update password", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	err = s.Database.Conn.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, session.ID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		lgr.Error("failed to revoke other sessions", zap.Error(err))
+	}
+
+	lgr.Info("password changed", zap.Uint("userId", user.ID))
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password changed"})
+}
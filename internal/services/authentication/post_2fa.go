@@ -1,220 +1,404 @@
 package authentication
 
 import (
-	"crypto/rand"
-	"encoding/base32"
-	"fmt"
-	"math/big"
+	"context"
+	"errors"
 	"net/http"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/totp"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
-type Enable2FARequest struct {
-	Password string `json:"password" validate:"required"`
+type Enable2FAResponse struct {
+	Method    string `json:"method"`
+	Secret    string `json:"secret,omitempty"`
+	QRCodeURL string `json:"qrCodeUrl,omitempty"`
 }
 
-type Disable2FARequest struct {
-	Password string `json:"password" validate:"required"`
-	Code     string `json:"code" validate:"required"`
+type BackupCodesResponse struct {
+	BackupCodes []string `json:"backupCodes"`
 }
 
-type Verify2FARequest struct {
-	Email string `json:"email" validate:"required,email"`
-	Code  string `json:"code" validate:"required"`
+type TwoFactorChallengeResponse struct {
+	Error             string `json:"error"`
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	Method            string `json:"method"`
+	MFAToken          string `json:"mfaToken"`
 }
 
-type Enable2FAResponse struct {
-	Secret      string   `json:"secret"`
-	QRCodeURL   string   `json:"qrCodeUrl"`
-	BackupCodes []string `json:"backupCodes"`
-}
+// twoFactorUser returns the authenticated user for the 2FA management
+// endpoints, which are only available to interactive sessions.
+func twoFactorUser(c echo.Context) (*models.User, error) {
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
 
-func (s *service) PostEnable2FA(c echo.Context) error {
-	req := c.Request()
-	payload := &Enable2FARequest{}
+	if _, usingKey := c.Get("apiKey").(*models.APIKey); usingKey {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage two-factor authentication")
+	}
 
-	ctx := req.Context()
-	lgr := logger.ContextLogger(ctx, s.Logger)
+	return user, nil
+}
 
-	if err := c.Bind(payload); err != nil {
-		lgr.Error("failed to bind request", zap.Error(err))
-		return c.NoContent(http.StatusBadRequest)
+func invalidRequest(c echo.Context, err error) error {
+	if validationErr, ok := err.(*validator.ValidationErrors); ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":   "Validation failed",
+			"details": validationErr.Errors,
+		})
 	}
+	return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+}
+
+// PostEnable2FA starts enrollment. For TOTP the secret is stored encrypted,
+// for email a code is sent, and either way 2FA stays off until
+// PostConfirm2FA sees a valid code.
+func (s *service) PostEnable2FA(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
 
-	// Get user from context (assume middleware sets this)
-	userID, err := getUserIDFromContext(c)
+	user, err := twoFactorUser(c)
 	if err != nil {
-		return c.NoContent(http.StatusUnauthorized)
+		return err
 	}
 
-	user, err := s.Users.GetUserByID(ctx, userID)
-	if err != nil || user == nil {
-		lgr.Error("failed to get user", zap.Error(err))
-		return c.NoContent(http.StatusNotFound)
+	var payload validator.Enable2FARequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
 	}
 
+	match, err := s.Passwords.Verify(user.Password, payload.Password)
+	if err != nil {
+		lgr.Error("failed to compare password", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !match {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid password"})
+	}
 
 	if user.TwoFactorEnabled {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA is already enabled"})
 	}
 
-	secret := generateTOTPSecret()
-	
-	backupCodes := generateBackupCodes(10)
-	backupCodesStr := strings.Join(backupCodes, ",")
+	if payload.Method == twoFactorMethodEmail {
+		if err := s.setPending2FA(ctx, user, twoFactorMethodEmail, ""); err != nil {
+			lgr.Error("failed to store pending 2FA method", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		if err := s.sendEmailOTP(ctx, user); err != nil && !errors.Is(err, errEmailOTPCooldown) {
+			lgr.Error("failed to send email code", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
 
-	if err := s.Users.UpdateUser2FA(ctx, userID, true, secret); err != nil {
-		lgr.Error("failed to enable 2FA for user", zap.Error(err))
+		return c.JSON(http.StatusOK, &Enable2FAResponse{Method: twoFactorMethodEmail})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		lgr.Error("failed to generate totp secret", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	encrypted, err := s.secrets.Encrypt(secret)
+	if err != nil {
+		lgr.Error("failed to encrypt totp secret", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	user.TwoFactorBackupCodes = backupCodesStr
-	if err := s.Users.UpdateUser(ctx, user); err != nil {
-		lgr.Error("failed to update backup codes", zap.Error(err))
+	if err := s.setPending2FA(ctx, user, twoFactorMethodTOTP, encrypted); err != nil {
+		lgr.Error("failed to store pending 2FA secret", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	qrCodeURL := generateQRCodeURL(user.Email, secret)
+	return c.JSON(http.StatusOK, &Enable2FAResponse{
+		Method:    twoFactorMethodTOTP,
+		Secret:    secret,
+		QRCodeURL: totp.KeyURI(s.TwoFactorIssuer, user.Email, secret),
+	})
+}
 
-	response := &Enable2FAResponse{
-		Secret:      secret,
-		QRCodeURL:   qrCodeURL,
-		BackupCodes: backupCodes,
+// setPending2FA records the method being enrolled apart from the active one,
+// so an abandoned enrollment never changes how the user signs in.
+func (s *service) setPending2FA(ctx context.Context, user *models.User, method, secret string) error {
+	err := s.Database.Conn.WithContext(ctx).Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"pending_two_factor_method": method,
+		"pending_two_factor_secret": secret,
+	}).Error
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, response)
+	user.PendingTwoFactorMethod = method
+	user.PendingTwoFactorSecret = secret
+	return nil
 }
 
-func (s *service) PostDisable2FA(c echo.Context) error {
-	req := c.Request()
-	payload := &Disable2FARequest{}
+// pending2FAUser returns a copy of user whose factor is the one being
+// enrolled, for checking codes against it before it becomes active.
+func pending2FAUser(user *models.User) *models.User {
+	pending := *user
+	pending.TwoFactorMethod = user.PendingTwoFactorMethod
+	pending.TwoFactorSecret = user.PendingTwoFactorSecret
+	return &pending
+}
 
-	ctx := req.Context()
+// PostConfirm2FA turns 2FA on once the user proves they receive codes for the
+// pending method, and hands out the backup codes.
+func (s *service) PostConfirm2FA(c echo.Context) error {
+	ctx := c.Request().Context()
 	lgr := logger.ContextLogger(ctx, s.Logger)
 
-	if err := c.Bind(payload); err != nil {
-		lgr.Error("failed to bind request", zap.Error(err))
-		return c.NoContent(http.StatusBadRequest)
+	user, err := twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+
+	var payload validator.Confirm2FARequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	if user.TwoFactorEnabled {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA is already enabled"})
+	}
+	if user.PendingTwoFactorMethod == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA enrollment has not been started"})
 	}
 
-	userID, err := getUserIDFromContext(c)
+	valid, err := s.verifyOneTimeCode(ctx, pending2FAUser(user), payload.Code)
 	if err != nil {
-		return c.NoContent(http.StatusUnauthorized)
+		lgr.Error("failed to verify 2FA code", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
 	}
 
-	user, err := s.Users.GetUserByID(ctx, userID)
-	if err != nil || user == nil {
-		lgr.Error("failed to get user", zap.Error(err))
-		return c.NoContent(http.StatusNotFound)
+	var codes []string
+	err = s.Database.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"two_factor_enabled":        true,
+			"two_factor_method":         user.PendingTwoFactorMethod,
+			"two_factor_secret":         user.PendingTwoFactorSecret,
+			"pending_two_factor_method": "",
+			"pending_two_factor_secret": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceBackupCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		lgr.Error("failed to enable 2FA for user", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// The code just proved possession, so the enrolling session counts as
+	// verified rather than being locked out by a role that requires 2FA.
+	if session, ok := c.Get("session").(*models.Session); ok {
+		authMethods := append(append([]string{}, session.AuthMethods...), amrOTP, amrMFA)
+		if err := s.reauthenticateSession(ctx, session, authMethods); err != nil {
+			lgr.Warn("failed to mark session as 2FA verified", zap.Error(err))
+		}
+	}
+
+	lgr.Info("2FA enabled", zap.Uint("userId", user.ID))
+	return c.JSON(http.StatusOK, &BackupCodesResponse{BackupCodes: codes})
+}
+
+// PostDisable2FA turns 2FA off. Unlike PostRegenerateBackupCodes it accepts
+// a backup code as well as a one-time code, so a user who lost their
+// authenticator can still turn it off and enroll a new one.
+func (s *service) PostDisable2FA(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, err := twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+
+	var payload validator.Disable2FARequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
 	}
 
 	if !user.TwoFactorEnabled {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA is not enabled"})
 	}
 
-	if !verifyTOTPCode(user.TwoFactorSecret, payload.Code) {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
+	match, err := s.Passwords.Verify(user.Password, payload.Password)
+	if err != nil {
+		lgr.Error("failed to compare password", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !match {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid password"})
 	}
 
-	if err := s.Users.UpdateUser2FA(ctx, userID, false, ""); err != nil {
-		lgr.Error("failed to disable 2FA for user", zap.Error(err))
+	valid, err := s.verifySecondFactor(ctx, user, payload.Code)
+	if err != nil {
+		lgr.Error("failed to verify 2FA code", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
+	}
+
+	err = s.Database.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"two_factor_enabled":        false,
+			"two_factor_method":         twoFactorMethodTOTP,
+			"two_factor_secret":         "",
+			"pending_two_factor_method": "",
+			"pending_two_factor_secret": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailOTP{}).Error; err != nil {
+			return err
+		}
 
-	user.TwoFactorBackupCodes = ""
-	if err := s.Users.UpdateUser(ctx, user); err != nil {
-		lgr.Error("failed to clear backup codes", zap.Error(err))
+		return tx.Where("user_id = ?", user.ID).Delete(&models.BackupCode{}).Error
+	})
+	if err != nil {
+		lgr.Error("failed to disable 2FA for user", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	lgr.Info("2FA disabled", zap.Uint("userId", user.ID))
 	return c.JSON(http.StatusOK, map[string]string{"message": "2FA disabled successfully"})
 }
 
-func (s *service) PostVerify2FA(c echo.Context) error {
-	req := c.Request()
-	payload := &Verify2FARequest{}
-
-	ctx := req.Context()
+// PostRegenerateBackupCodes invalidates every outstanding backup code. It
+// requires a one-time code so a leaked backup code cannot be used to mint more.
+func (s *service) PostRegenerateBackupCodes(c echo.Context) error {
+	ctx := c.Request().Context()
 	lgr := logger.ContextLogger(ctx, s.Logger)
 
-	if err := c.Bind(payload); err != nil {
-		lgr.Error("failed to bind request", zap.Error(err))
-		return c.NoContent(http.StatusBadRequest)
+	user, err := twoFactorUser(c)
+	if err != nil {
+		return err
 	}
 
-	user, err := s.Users.GetUserByEmail(ctx, payload.Email)
-	if err != nil || user == nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid credentials"})
+	var payload validator.RegenerateBackupCodesRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
 	}
 
 	if !user.TwoFactorEnabled {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA is not enabled"})
 	}
 
-	valid := verifyTOTPCode(user.TwoFactorSecret, payload.Code)
-	
-	if !valid {
-		backupCodes := strings.Split(user.TwoFactorBackupCodes, ",")
-		for i, code := range backupCodes {
-			if code == payload.Code {
-					backupCodes = append(backupCodes[:i], backupCodes[i+1:]...)
-				user.TwoFactorBackupCodes = strings.Join(backupCodes, ",")
-				s.Users.UpdateUser(ctx, user)
-				valid = true
-				break
-			}
-		}
+	match, err := s.Passwords.Verify(user.Password, payload.Password)
+	if err != nil {
+		lgr.Error("failed to compare password", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !match {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid password"})
 	}
 
+	valid, err := s.verifyOneTimeCode(ctx, user, payload.Code)
+	if err != nil {
+		lgr.Error("failed to verify 2FA code", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
 	if !valid {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
 	}
 
-	jwt, err := s.generateTokens(&TokenContext{UserID: float64(user.ID)})
+	var codes []string
+	err = s.Database.Conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceBackupCodes(tx, user.ID)
+		return err
+	})
 	if err != nil {
+		lgr.Error("failed to regenerate backup codes", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	return c.JSON(http.StatusOK, jwt)
+	return c.JSON(http.StatusOK, &BackupCodesResponse{BackupCodes: codes})
 }
 
-func generateTOTPSecret() string {
-	bytes := make([]byte, 20)
-	rand.Read(bytes)
-	return base32.StdEncoding.EncodeToString(bytes)
-}
+// PostVerify2FA completes a sign-in that PostSignIn or GetSSOCallback
+// answered with an MFA token. The token is single use and stops working after
+// TwoFactorMaxAttempts wrong codes.
+func (s *service) PostVerify2FA(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
 
-func generateBackupCodes(count int) []string {
-	codes := make([]string, count)
-	for i := 0; i < count; i++ {
-		code, _ := rand.Int(rand.Reader, big.NewInt(100000000))
-		codes[i] = fmt.Sprintf("%08d", code.Int64())
+	var payload validator.Verify2FARequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
 	}
-	return codes
-}
 
-func generateQRCodeURL(email, secret string) string {
-	return fmt.Sprintf("otpauth://totp/Echo%%20Boilerplate:%s?secret=%s&issuer=Echo%%20Boilerplate", 
-		email, secret)
-}
+	challenge, err := s.parseMFAToken(ctx, payload.MFAToken)
+	if err != nil {
+		lgr.Error("failed to parse mfa token", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if challenge == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired MFA token"})
+	}
 
-func verifyTOTPCode(secret, code string) bool {
-	if len(code) != 6 {
-		return false
+	user, err := s.Users.GetUserByID(ctx, uint(challenge.UserID))
+	if err != nil {
+		lgr.Error("failed to get user", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil || !user.TwoFactorEnabled {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired MFA token"})
+	}
+	if !user.IsActive {
+		return accountDeactivated(c)
+	}
+
+	valid, err := s.verifySecondFactor(ctx, user, payload.Code)
+	if err != nil {
+		lgr.Error("failed to verify 2FA code", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !valid {
+		// Too many wrong codes use up the token and the user signs in again.
+		locked, err := s.recordSecondFactorFailure(ctx, "mfa:"+challenge.JTI, time.Unix(int64(challenge.ExpiresAt), 0))
+		if err == nil && locked {
+			err = s.revokeToken(ctx, challenge)
+		}
+		if err != nil {
+			lgr.Error("failed to record 2FA failure", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if locked {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Too many invalid codes, please sign in again"})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
+	}
+
+	if err := s.revokeToken(ctx, challenge); err != nil {
+		lgr.Error("failed to revoke mfa token", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
 	}
-	
-	_, err := strconv.Atoi(code)
-	return err == nil
-}
 
-func getUserIDFromContext(c echo.Context) (uint, error) {
-	claims := c.Get("user")
-	if claims == nil {
-		return 0, fmt.Errorf("no user in context")
+	jwt, err := s.startSession(c, user, append(challenge.AMR, amrOTP, amrMFA))
+	if err != nil {
+		lgr.Error("failed to start session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
 	}
-	
-	return 1, nil
-}
\ No newline at end of file
+
+	c.SetCookie(s.createAuthCookie(jwt.AccessToken))
+
+	return c.JSON(http.StatusOK, jwt)
+}
@@ -53,13 +53,25 @@ if err != nil {
 		return false, nil, err
 	}
 
-	if usr == nil {
+	if usr == nil || !usr.IsActive {
 		return false, nil, nil
 	}
 
-	tkns, err := s.generateTokens(&TokenContext{
-		UserID: float64(usr.ID),
-	})
+	session, err := s.getActiveSession(ctx, usr.ID, uint(jwtUsr.SessionID))
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Impersonation sessions end with their access token.
+	if session == nil || session.IsImpersonation() {
+		return false, nil, nil
+	}
+
+	if err := s.touchSession(ctx, session); err != nil {
+		return false, nil, err
+	}
+
+	tkns, err := s.generateTokens(sessionTokenContext(session))
 
 	return true, tkns, err
 }
@@ -5,28 +5,23 @@ import (
 	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
-	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-type ResetPasswordRequest struct {
-	Token       string `json:"token" validate:"required"`
-	NewPassword string `json:"newPassword" validate:"required,min=8"`
-}
-
 func (s *service) PostResetPassword(c echo.Context) error {
 	req := c.Request()
-	payload := &ResetPasswordRequest{}
+	var payload validator.ResetPasswordRequest
 
 	ctx := req.Context()
 	lgr := logger.ContextLogger(ctx, s.Logger)
 
-	if err := c.Bind(payload); err != nil {
+	if err := validator.BindAndValidate(c, &payload); err != nil {
 		lgr.Error("failed to bind request", zap.Error(err))
-		return c.NoContent(http.StatusBadRequest)
+		return invalidRequest(c, err)
 	}
 
 	var user models.User
@@ -41,21 +36,20 @@ func (s *service) PostResetPassword(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	hashedPassword, err := passwords.GenerateHashFromPassword(payload.NewPassword)
+	violations, err := s.checkPassword(ctx, &user, payload.NewPassword)
 	if err != nil {
-		lgr.Error("failed to hash new password", zap.Error(err))
+		lgr.Error("failed to check password policy", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	if len(violations) > 0 {
+		return passwordRejected(c, "newPassword", violations)
+	}
 
-	user.Password = string(hashedPassword)
-	user.PasswordResetToken = ""
-	user.PasswordResetExpiresAt = time.Time{}
-
-	if err := s.Users.UpdateUser(ctx, &user); err != nil {
+	if err := s.setPassword(ctx, &user, payload.NewPassword); err != nil {
 		lgr.Error("failed to update user password", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
 	lgr.Info("password reset successful", zap.Uint("userId", user.ID))
 	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successful"})
-}
\ No newline at end of file
+}
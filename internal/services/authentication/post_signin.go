@@ -1,10 +1,11 @@
 package authentication
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
-	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
 	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -12,12 +13,21 @@ import (
 
 
 type Tokens struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	MFAEnrollmentRequired bool   `json:"mfaEnrollmentRequired,omitempty"`
 }
 
 type TokenContext struct {
-	UserID float64 `json:"userId" validate:"required"`
+	UserID    float64  `json:"userId" validate:"required"`
+	SessionID float64  `json:"sessionId" validate:"required"`
+	JTI       string   `json:"jti"`
+	ExpiresAt float64  `json:"exp"`
+	AMR       []string `json:"amr,omitempty"`
+	AuthTime  float64  `json:"auth_time,omitempty"`
+
+	// ImpersonatorID is set when an administrator is acting as UserID.
+	ImpersonatorID float64 `json:"impersonatorId,omitempty"`
 }
 
 func (s *service) PostSignIn(c echo.Context) error {
@@ -52,7 +62,7 @@ func (s *service) PostSignIn(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	match, err := passwords.HashAndPasswordMatch(usr.Password, payload.Password)
+	match, err := s.Passwords.Verify(usr.Password, payload.Password)
 	if err != nil {
 		return c.NoContent(http.StatusInternalServerError)
 	}
@@ -61,11 +71,76 @@ func (s *service) PostSignIn(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	jwt, err := s.generateTokens(&TokenContext{UserID: float64(usr.ID)})
+	if !usr.IsActive {
+		return accountDeactivated(c)
+	}
+
+	if s.Passwords.NeedsRehash(usr.Password) {
+		s.rehashPassword(ctx, usr, payload.Password)
+	}
+
+	if usr.TwoFactorEnabled {
+		if payload.Code == "" {
+			challenge, err := s.startMFAChallenge(ctx, usr, amrPassword)
+			if err != nil {
+				lgr.Error("failed to start mfa challenge", zap.Error(err))
+				return c.NoContent(http.StatusInternalServerError)
+			}
+
+			return c.JSON(http.StatusForbidden, challenge)
+		}
+
+		// Codes sent along with the password have no MFA token to use up, so
+		// wrong ones are counted per user for as long as a token would last.
+		challenge := fmt.Sprintf("sign-in:%d", usr.ID)
+		locked, err := s.secondFactorLocked(ctx, challenge)
+		if err != nil {
+			lgr.Error("failed to check 2FA attempts", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if locked {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many invalid codes, please try again later"})
+		}
+
+		valid, err := s.verifyOneTimeCode(ctx, usr, payload.Code)
+		if err != nil {
+			lgr.Error("failed to verify 2FA code", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if !valid {
+			if _, err := s.recordSecondFactorFailure(ctx, challenge, time.Now().Add(mfaTokenTTL)); err != nil {
+				lgr.Error("failed to record 2FA failure", zap.Error(err))
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
+		}
+		if err := s.clearSecondFactorFailures(ctx, challenge); err != nil {
+			lgr.Error("failed to clear 2FA attempts", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	authMethods := []string{amrPassword}
+	if usr.TwoFactorEnabled {
+		authMethods = append(authMethods, amrOTP, amrMFA)
+	}
+
+	jwt, err := s.startSession(c, usr, authMethods)
 	if err != nil {
+		lgr.Error("failed to start session", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	// Users whose role demands 2FA but who have not enrolled get a session
+	// that only reaches the enrollment endpoints until they do.
+	if !usr.TwoFactorEnabled {
+		jwt.MFAEnrollmentRequired, err = s.userRequiresMFA(ctx, usr)
+		if err != nil {
+			lgr.Error("failed to check role mfa requirement", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
 	c.SetCookie(s.createAuthCookie(jwt.AccessToken))
 
 	return c.JSON(http.StatusOK, jwt)
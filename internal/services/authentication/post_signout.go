@@ -2,11 +2,44 @@ package authentication
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 )
 
 func (s *service) PostSignOut(c echo.Context) error {
+	req := c.Request()
+	ctx := req.Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
 	s.clearAuthCookie(c)
+
+	authHdr := req.Header.Get("Authorization")
+	if authHdr == "" {
+		authHdr, _ = s.getAuthCookieValue(req)
+	}
+
+	flds := strings.Fields(authHdr)
+	if len(flds) != 2 || strings.ToLower(flds[0]) != "bearer" {
+		return c.NoContent(http.StatusOK)
+	}
+
+	valid, jwtUsr, err := s.validateToken(flds[1])
+	if err != nil || !valid {
+		return c.NoContent(http.StatusOK)
+	}
+
+	if _, err := s.revokeSession(ctx, uint(jwtUsr.UserID), uint(jwtUsr.SessionID)); err != nil {
+		lgr.Error("failed to revoke session on sign out", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := s.revokeToken(ctx, jwtUsr); err != nil {
+		lgr.Error("failed to revoke access token on sign out", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
 	return c.NoContent(http.StatusOK)
 }
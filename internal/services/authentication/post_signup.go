@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
-	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
 	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
 	"github.com/labstack/echo/v4"
@@ -37,6 +36,15 @@ func (s *service) PostSignUp(c echo.Context) error {
 		})
 	}
 
+	violations, err := s.checkPassword(ctx, &models.User{Name: payload.Name, Email: payload.Email}, payload.Password)
+	if err != nil {
+		lgr.Error("failed to check password policy", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if len(violations) > 0 {
+		return passwordRejected(c, "password", violations)
+	}
+
 	usr, err := s.Users.GetUserByEmail(ctx, payload.Email)
 	if err != nil {
 		lgr.Error("failed to get user by email", zap.Error(err))
@@ -47,7 +55,7 @@ func (s *service) PostSignUp(c echo.Context) error {
 		return c.NoContent(http.StatusConflict)
 	}
 
-	pw, err := passwords.GenerateHashFromPassword(payload.Password)
+	pw, err := s.Passwords.Hash(payload.Password)
 	if err != nil {
 		lgr.Error("failed to hash password", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
@@ -63,7 +71,7 @@ func (s *service) PostSignUp(c echo.Context) error {
 	newUser := &models.User{
 		Name:              payload.Name,
 		Email:             payload.Email,
-		Password:          string(pw),
+		Password:          pw,
 		EmailConfirmed:    false,
 		IsActive:          true,
 		EmailConfirmToken: emailConfirmToken,
@@ -85,8 +93,9 @@ func (s *service) PostSignUp(c echo.Context) error {
 		lgr.Error("failed to send email confirmation", zap.Error(err))
 	}
 
-	jwt, err := s.generateTokens(&TokenContext{UserID: float64(newUser.ID)})
+	jwt, err := s.startSession(c, newUser, []string{amrPassword})
 	if err != nil {
+		lgr.Error("failed to start session", zap.Error(err))
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
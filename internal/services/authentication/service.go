@@ -1,36 +1,79 @@
 package authentication
 
 import (
+	"context"
 	"encoding/hex"
+	"sync"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/common/encryption"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/oidc"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
 	"github.com/feezyhendrix/echoboilerplate/internal/db"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/email"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/users"
 	"github.com/go-playground/validator/v10"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
 type Config struct {
-	JWTSecret                  string `envconfig:"AUTHENTICATION_JWT_SECRET" required:"true"`
-	AccessTokenTTLSecs         int    `envconfig:"AUTHENTICATION_ACCESS_TOKEN_TTL_SEC" default:"900"`            // 15 minutes default
-	RefreshTokenTTLSecs        int    `envconfig:"AUTHENTICATION_REFRESH_TOKEN_TTL_SEC" default:"86400"`         // 24 hours default
-	PasswordResetTokenTTLSecs  int64  `envconfig:"AUTHENTICATION__PASSWORD_RESET_TOKEN_TTL_SECS" default:"3600"` // 1 hour default
-	PasswordResetEncryptionKey string `envconfig:"AUTHENTICATION__PASSWORD_RESET_TOKEN_ENCRYPTION_KEY" required:"true"`
-	PasswordResetURL           string `envconfig:"AUTHENTICATION__PASSWORD_RESET_URL" required:"true"`
+	JWTSecret                  string            `envconfig:"AUTHENTICATION_JWT_SECRET" required:"true"`
+	AccessTokenTTLSecs         int               `envconfig:"AUTHENTICATION_ACCESS_TOKEN_TTL_SEC" default:"900"`            // 15 minutes default
+	RefreshTokenTTLSecs        int               `envconfig:"AUTHENTICATION_REFRESH_TOKEN_TTL_SEC" default:"86400"`         // 24 hours default
+	PasswordResetTokenTTLSecs  int64             `envconfig:"AUTHENTICATION__PASSWORD_RESET_TOKEN_TTL_SECS" default:"3600"` // 1 hour default
+	PasswordResetEncryptionKey string            `envconfig:"AUTHENTICATION__PASSWORD_RESET_TOKEN_ENCRYPTION_KEY" required:"true"`
+	PasswordResetURL           string            `envconfig:"AUTHENTICATION__PASSWORD_RESET_URL" required:"true"`
+	DenylistPruneIntervalSecs  int               `envconfig:"AUTHENTICATION_DENYLIST_PRUNE_INTERVAL_SEC" default:"600"` // 10 minutes default
+	SSOEnabled                 bool              `envconfig:"AUTHENTICATION_SSO_ENABLED" default:"false"`
+	SSOIssuerURL               string            `envconfig:"AUTHENTICATION_SSO_ISSUER_URL"`
+	SSOClientID                string            `envconfig:"AUTHENTICATION_SSO_CLIENT_ID"`
+	SSOClientSecret            string            `envconfig:"AUTHENTICATION_SSO_CLIENT_SECRET"`
+	SSORedirectURL             string            `envconfig:"AUTHENTICATION_SSO_REDIRECT_URL"`
+	SSOScopes                  string            `envconfig:"AUTHENTICATION_SSO_SCOPES" default:"openid email profile"`
+	SSOJITProvisioning         bool              `envconfig:"AUTHENTICATION_SSO_JIT_PROVISIONING" default:"false"`
+	SSODefaultRole             string            `envconfig:"AUTHENTICATION_SSO_DEFAULT_ROLE" default:"User"`
+	SSOPostLoginRedirectURL    string            `envconfig:"AUTHENTICATION_SSO_POST_LOGIN_REDIRECT_URL" default:"/"`
+	SSOGroupsClaim             string            `envconfig:"AUTHENTICATION_SSO_GROUPS_CLAIM" default:"groups"`
+	SSORoleMappings            oidc.RoleMappings `envconfig:"AUTHENTICATION_SSO_ROLE_MAPPINGS"` // "group=Role;other group=Other Role"
+	SSOTrustIdPMFA             bool              `envconfig:"AUTHENTICATION_SSO_TRUST_IDP_MFA" default:"false"`
+	WebAuthnRPID               string            `envconfig:"AUTHENTICATION_WEBAUTHN_RP_ID" default:"localhost"`
+	WebAuthnRPDisplayName      string            `envconfig:"AUTHENTICATION_WEBAUTHN_RP_DISPLAY_NAME" default:"Echo Boilerplate"`
+	WebAuthnRPOrigins          []string          `envconfig:"AUTHENTICATION_WEBAUTHN_RP_ORIGINS" default:"http://localhost:8080"`
+	TwoFactorEncryptionKey     string            `envconfig:"AUTHENTICATION_2FA_ENCRYPTION_KEY" required:"true"`
+	TwoFactorIssuer            string            `envconfig:"AUTHENTICATION_2FA_ISSUER" default:"Echo Boilerplate"`
+	EmailOTPTTLSecs            int               `envconfig:"AUTHENTICATION_2FA_EMAIL_CODE_TTL_SEC" default:"600"` // 10 minutes default
+	EmailOTPResendCooldownSecs int               `envconfig:"AUTHENTICATION_2FA_EMAIL_CODE_RESEND_COOLDOWN_SEC" default:"60"`
+	EmailOTPMaxAttempts        int               `envconfig:"AUTHENTICATION_2FA_EMAIL_CODE_MAX_ATTEMPTS" default:"5"`
+	TwoFactorMaxAttempts       int               `envconfig:"AUTHENTICATION_2FA_MAX_ATTEMPTS" default:"5"`
+	ImpersonationTTLSecs       int               `envconfig:"AUTHENTICATION_IMPERSONATION_TTL_SEC" default:"900"` // 15 minutes default
 }
 
 type Dependencies struct {
-	Validate *validator.Validate
-	Logger   *zap.Logger
-	Database db.DB
-	Users    users.Service
-	Email    email.Service
+	Validate       *validator.Validate
+	Logger         *zap.Logger
+	Database       db.DB
+	Users          users.Service
+	Email          email.Service
+	Permissions    *permissions.Service
+	Audit          *audit.Service
+	Passwords      passwords.Hasher
+	PasswordPolicy *passwords.Policy
 }
 
 type service struct {
 	*Config
 	*Dependencies
+
+	secrets *encryption.Cipher
+
+	ssoMu     sync.Mutex
+	ssoClient *oidc.Client
+
+	webAuthnMu sync.Mutex
+	webAuthnRP *webauthn.WebAuthn
 }
 
 type Service interface {
@@ -41,9 +84,36 @@ type Service interface {
 	PostRefreshToken(c echo.Context) error
 	PostForgotPassword(c echo.Context) error
 	PostResetPassword(c echo.Context) error
+	PostChangePassword(c echo.Context) error
 	PostEnable2FA(c echo.Context) error
+	PostConfirm2FA(c echo.Context) error
 	PostDisable2FA(c echo.Context) error
+	PostRegenerateBackupCodes(c echo.Context) error
 	PostVerify2FA(c echo.Context) error
+	PostResendEmailOTP(c echo.Context) error
+	PostSendEmailOTP(c echo.Context) error
+	PostStepUp(c echo.Context) error
+	GetSessions(c echo.Context) error
+	DeleteSession(c echo.Context) error
+	DeleteSessions(c echo.Context) error
+	GetUserSessions(c echo.Context) error
+	DeleteUserSession(c echo.Context) error
+	DeleteUserSessions(c echo.Context) error
+	PostCreateAPIKey(c echo.Context) error
+	GetAPIKeys(c echo.Context) error
+	DeleteAPIKey(c echo.Context) error
+	PruneRevokedTokens(ctx context.Context) error
+	GetSSOLogin(c echo.Context) error
+	GetSSOCallback(c echo.Context) error
+	PostWebAuthnRegisterBegin(c echo.Context) error
+	PostWebAuthnRegisterFinish(c echo.Context) error
+	PostWebAuthnLoginBegin(c echo.Context) error
+	PostWebAuthnLoginFinish(c echo.Context) error
+	GetWebAuthnCredentials(c echo.Context) error
+	PatchWebAuthnCredential(c echo.Context) error
+	DeleteWebAuthnCredential(c echo.Context) error
+	PostImpersonate(c echo.Context) error
+	PostStopImpersonation(c echo.Context) error
 }
 
 func New(cfg *Config, deps *Dependencies) Service {
@@ -51,7 +121,8 @@ func New(cfg *Config, deps *Dependencies) Service {
 	cfg.PasswordResetEncryptionKey = hex.EncodeToString(key)
 
 	return &service{
-		cfg,
-		deps,
+		Config:       cfg,
+		Dependencies: deps,
+		secrets:      encryption.New(cfg.TwoFactorEncryptionKey),
 	}
 }
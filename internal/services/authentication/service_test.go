@@ -9,24 +9,33 @@ import (
 	"testing"
 	"time"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
 	"github.com/feezyhendrix/echoboilerplate/internal/db"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
-	"github.com/feezyhendrix/echoboilerplate/internal/services/email"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/users"
-	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
-type mockEmailService struct{}
+// mockEmailService records the last 2FA code it was asked to send.
+type mockEmailService struct {
+	twoFactorCode string
+	sentCodes     int
+}
 
 func (m *mockEmailService) SendPasswordResetEmail(ctx context.Context, to, name, resetToken string) error {
 	return nil
 }
 
 func (m *mockEmailService) SendTwoFactorCode(ctx context.Context, to, name, code string) error {
+	m.twoFactorCode = code
+	m.sentCodes++
 	return nil
 }
 
@@ -38,32 +47,43 @@ func (m *mockEmailService) SendEmailConfirmation(ctx context.Context, to, name,
 	return nil
 }
 
-type mockDB struct {
-	db *gorm.DB
+func (m *mockEmailService) SendRoleExpiringEmail(ctx context.Context, to, name, role string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *mockEmailService) SendAccessRequestEmail(ctx context.Context, to, name, requester, role, justification string) error {
+	return nil
 }
 
-func (m *mockDB) Connection() *gorm.DB {
-	return m.db
+func (m *mockEmailService) SendAccessDecisionEmail(ctx context.Context, to, name, role string, approved bool, note string) error {
+	return nil
 }
 
-func setupTestDB(t *testing.T) *mockDB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+// setupTestDB opens a migrated in-memory database. Each test gets its own.
+func setupTestDB(t *testing.T) db.DB {
+	conn, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
 	if err != nil {
 		t.Fatal("Failed to connect to test database:", err)
 	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
 
-	err = db.AutoMigrate(&models.User{})
-	if err != nil {
+	database := db.DB{Conn: conn}
+	if err := database.MigrateAllFields(); err != nil {
 		t.Fatal("Failed to migrate test database:", err)
 	}
 
-	return &mockDB{db: db}
+	return database
 }
 
-func setupTestService(t *testing.T) (*service, *mockDB) {
+func setupTestService(t *testing.T) (*service, db.DB) {
 	logger := zap.NewNop()
-	validator := validator.New()
-	mockDB := setupTestDB(t)
+	database := setupTestDB(t)
 
 	cfg := &Config{
 		JWTSecret:                  "test-secret",
@@ -72,32 +92,71 @@ func setupTestService(t *testing.T) (*service, *mockDB) {
 		PasswordResetTokenTTLSecs:  3600,
 		PasswordResetEncryptionKey: "test-encryption-key-32-bytes-long",
 		PasswordResetURL:           "http://localhost:3000/reset-password",
+		TwoFactorEncryptionKey:     "test-2fa-encryption-key",
+		TwoFactorIssuer:            "Test",
+		EmailOTPTTLSecs:            600,
+		EmailOTPResendCooldownSecs: 60,
+		EmailOTPMaxAttempts:        3,
+		TwoFactorMaxAttempts:       3,
+		ImpersonationTTLSecs:       900,
 	}
 
+	auditSvc := audit.NewService(database.Conn)
 	userSvc := users.New(&users.Config{}, &users.Dependencies{
-		Database: mockDB,
+		Database: database,
 		Logger:   logger,
+		Audit:    auditSvc,
+	})
+
+	permissionsSvc := permissions.NewService(database.Conn)
+	if err := permissionsSvc.SeedDefaultData(); err != nil {
+		t.Fatal("Failed to seed roles:", err)
+	}
+
+	hasher, err := passwords.New(passwords.Config{
+		Algorithm:         passwords.AlgorithmArgon2id,
+		BcryptCost:        4,
+		Argon2MemoryKiB:   1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
 	})
+	if err != nil {
+		t.Fatal("Failed to create password hasher:", err)
+	}
+	policy, err := passwords.NewPolicy(passwords.PolicyConfig{MinLength: 8, MaxLength: 128})
+	if err != nil {
+		t.Fatal("Failed to create password policy:", err)
+	}
 
 	emailSvc := &mockEmailService{}
 
 	deps := &Dependencies{
-		Validate: validator,
-		Logger:   logger,
-		Database: mockDB,
-		Users:    userSvc,
-		Email:    emailSvc,
+		Validate:       validator.NewValidator().Validator,
+		Logger:         logger,
+		Database:       database,
+		Users:          userSvc,
+		Email:          emailSvc,
+		Permissions:    permissionsSvc,
+		Audit:          auditSvc,
+		Passwords:      hasher,
+		PasswordPolicy: policy,
 	}
 
-	return New(cfg, deps).(*service), mockDB
+	return New(cfg, deps).(*service), database
+}
+
+func newTestEcho() *echo.Echo {
+	e := echo.New()
+	e.Validator = validator.NewValidator()
+	return e
 }
 
 func TestPostSignUp(t *testing.T) {
 	service, _ := setupTestService(t)
-	e := echo.New()
+	e := newTestEcho()
 
 	t.Run("successful signup", func(t *testing.T) {
-		payload := PostSignUpRequest{
+		payload := validator.SignUpRequest{
 			Email:    "test@example.com",
 			Password: "password123",
 			Name:     "Test User",
@@ -119,7 +178,7 @@ func TestPostSignUp(t *testing.T) {
 		}
 
 		var user models.User
-		err = service.Database.Connection().Where("email = ?", payload.Email).First(&user).Error
+		err = service.Database.Conn.Where("email = ?", payload.Email).First(&user).Error
 		if err != nil {
 			t.Fatalf("User was not created: %v", err)
 		}
@@ -135,9 +194,9 @@ func TestPostSignUp(t *testing.T) {
 			Name:     "Existing User",
 			Password: "hashedpassword",
 		}
-		service.Database.Connection().Create(user)
+		service.Database.Conn.Create(user)
 
-		payload := PostSignUpRequest{
+		payload := validator.SignUpRequest{
 			Email:    "duplicate@example.com",
 			Password: "password123",
 			Name:     "New User",
@@ -178,7 +237,7 @@ func TestPostSignUp(t *testing.T) {
 
 func TestPostSignIn(t *testing.T) {
 	service, _ := setupTestService(t)
-	e := echo.New()
+	e := newTestEcho()
 
 	user := &models.User{
 		Email:    "signin@example.com",
@@ -186,10 +245,10 @@ func TestPostSignIn(t *testing.T) {
 		Password: "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi", // "password"
 		IsActive: true,
 	}
-	service.Database.Connection().Create(user)
+	service.Database.Conn.Create(user)
 
 	t.Run("successful signin", func(t *testing.T) {
-		payload := SigninPayload{
+		payload := validator.SignInRequest{
 			Email:    "signin@example.com",
 			Password: "password",
 		}
@@ -221,7 +280,7 @@ func TestPostSignIn(t *testing.T) {
 	})
 
 	t.Run("invalid credentials", func(t *testing.T) {
-		payload := SigninPayload{
+		payload := validator.SignInRequest{
 			Email:    "signin@example.com",
 			Password: "wrongpassword",
 		}
@@ -243,7 +302,7 @@ func TestPostSignIn(t *testing.T) {
 	})
 
 	t.Run("user not found", func(t *testing.T) {
-		payload := SigninPayload{
+		payload := validator.SignInRequest{
 			Email:    "notfound@example.com",
 			Password: "password",
 		}
@@ -267,7 +326,7 @@ func TestPostSignIn(t *testing.T) {
 
 func TestPostForgotPassword(t *testing.T) {
 	service, _ := setupTestService(t)
-	e := echo.New()
+	e := newTestEcho()
 
 	user := &models.User{
 		Email:    "forgot@example.com",
@@ -275,10 +334,10 @@ func TestPostForgotPassword(t *testing.T) {
 		Password: "hashedpassword",
 		IsActive: true,
 	}
-	service.Database.Connection().Create(user)
+	service.Database.Conn.Create(user)
 
 	t.Run("successful forgot password", func(t *testing.T) {
-		payload := ForgotPasswordRequest{
+		payload := validator.ForgotPasswordRequest{
 			Email: "forgot@example.com",
 		}
 
@@ -298,7 +357,7 @@ func TestPostForgotPassword(t *testing.T) {
 		}
 
 		var updatedUser models.User
-		service.Database.Connection().Where("email = ?", payload.Email).First(&updatedUser)
+		service.Database.Conn.Where("email = ?", payload.Email).First(&updatedUser)
 		if updatedUser.PasswordResetToken == "" {
 			t.Fatal("Password reset token should be set")
 		}
@@ -309,7 +368,7 @@ func TestPostForgotPassword(t *testing.T) {
 	})
 
 	t.Run("user not found - still returns success", func(t *testing.T) {
-		payload := ForgotPasswordRequest{
+		payload := validator.ForgotPasswordRequest{
 			Email: "notfound@example.com",
 		}
 
@@ -332,7 +391,7 @@ func TestPostForgotPassword(t *testing.T) {
 
 func TestPostResetPassword(t *testing.T) {
 	service, _ := setupTestService(t)
-	e := echo.New()
+	e := newTestEcho()
 
 	resetToken := "valid-reset-token"
 	user := &models.User{
@@ -343,10 +402,10 @@ func TestPostResetPassword(t *testing.T) {
 		PasswordResetExpiresAt: time.Now().Add(time.Hour),
 		IsActive:               true,
 	}
-	service.Database.Connection().Create(user)
+	service.Database.Conn.Create(user)
 
 	t.Run("successful password reset", func(t *testing.T) {
-		payload := ResetPasswordRequest{
+		payload := validator.ResetPasswordRequest{
 			Token:       resetToken,
 			NewPassword: "newpassword123",
 		}
@@ -367,7 +426,7 @@ func TestPostResetPassword(t *testing.T) {
 		}
 
 		var updatedUser models.User
-		service.Database.Connection().Where("email = ?", user.Email).First(&updatedUser)
+		service.Database.Conn.Where("email = ?", user.Email).First(&updatedUser)
 		if updatedUser.Password == "oldpassword" {
 			t.Fatal("Password should have been changed")
 		}
@@ -377,7 +436,7 @@ func TestPostResetPassword(t *testing.T) {
 	})
 
 	t.Run("invalid token", func(t *testing.T) {
-		payload := ResetPasswordRequest{
+		payload := validator.ResetPasswordRequest{
 			Token:       "invalid-token",
 			NewPassword: "newpassword123",
 		}
@@ -408,9 +467,9 @@ func TestPostResetPassword(t *testing.T) {
 			PasswordResetExpiresAt: time.Now().Add(-time.Hour), // Expired
 			IsActive:               true,
 		}
-		service.Database.Connection().Create(expiredUser)
+		service.Database.Conn.Create(expiredUser)
 
-		payload := ResetPasswordRequest{
+		payload := validator.ResetPasswordRequest{
 			Token:       expiredToken,
 			NewPassword: "newpassword123",
 		}
@@ -430,4 +489,99 @@ func TestPostResetPassword(t *testing.T) {
 			t.Fatalf("Expected status 400, got %d", rec.Code)
 		}
 	})
-}
\ No newline at end of file
+}
+
+// createTestUser stores an active user who signs in with password.
+func createTestUser(t *testing.T, service *service, email, password string) *models.User {
+	t.Helper()
+	hash, err := service.Passwords.Hash(password)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := &models.User{Email: email, Name: "Test User", Password: hash, IsActive: true}
+	if err := service.Database.Conn.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return user
+}
+
+// signIn signs in through PostSignIn and returns the issued tokens.
+func signIn(t *testing.T, service *service, email, password string) *Tokens {
+	t.Helper()
+	rec := doRequest(testRouter(service), http.MethodPost, "/api/v1/auth/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected sign in to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokens Tokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("Failed to unmarshal tokens: %v", err)
+	}
+	return &tokens
+}
+
+// testRouter serves the service's handlers on the same paths as the API.
+func testRouter(service *service) *echo.Echo {
+	e := newTestEcho()
+	recentAuth := permissions.RequireRecentAuth(5 * time.Minute)
+
+	e.POST("/api/v1/auth/login", service.PostSignIn)
+	e.POST("/api/v1/auth/logout", service.PostSignOut)
+	e.POST("/api/v1/auth/refresh-token", service.PostRefreshToken)
+	e.POST("/api/v1/auth/verify-2fa", service.PostVerify2FA)
+	e.POST("/api/v1/auth/2fa/email/resend", service.PostResendEmailOTP)
+	e.POST("/api/v1/auth/webauthn/login/begin", service.PostWebAuthnLoginBegin)
+	e.POST("/api/v1/auth/webauthn/login/finish", service.PostWebAuthnLoginFinish)
+	e.GET("/api/v1/auth/sso/login", service.GetSSOLogin)
+	e.GET("/api/v1/auth/sso/callback", service.GetSSOCallback)
+
+	v1 := e.Group("/api/v1", service.AuthenticationMiddleware())
+	v1.POST("/auth/step-up", service.PostStepUp)
+	v1.POST("/auth/impersonation/stop", service.PostStopImpersonation)
+	user := v1.Group("/user", permissions.RejectAPIKeys())
+	user.GET("/profile", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, c.Get("user"))
+	})
+	user.GET("/sessions", service.GetSessions)
+	user.DELETE("/sessions", service.DeleteSessions)
+	user.DELETE("/sessions/:id", service.DeleteSession)
+	user.GET("/api-keys", service.GetAPIKeys)
+	user.POST("/api-keys", service.PostCreateAPIKey)
+	user.DELETE("/api-keys/:id", service.DeleteAPIKey)
+	user.POST("/2fa/enable", service.PostEnable2FA)
+	user.POST("/2fa/confirm", service.PostConfirm2FA)
+	user.POST("/2fa/disable", service.PostDisable2FA)
+	user.POST("/2fa/backup-codes", service.PostRegenerateBackupCodes)
+	user.POST("/2fa/email/send", service.PostSendEmailOTP)
+	user.POST("/webauthn/register/begin", service.PostWebAuthnRegisterBegin, recentAuth)
+	user.POST("/webauthn/register/finish", service.PostWebAuthnRegisterFinish, recentAuth)
+	user.GET("/webauthn/credentials", service.GetWebAuthnCredentials)
+	user.PATCH("/webauthn/credentials/:id", service.PatchWebAuthnCredential)
+	user.DELETE("/webauthn/credentials/:id", service.DeleteWebAuthnCredential)
+	v1.GET("/users/:id/sessions", service.GetUserSessions, permissions.RequireAuthorized(permissions.PermissionUserRead, permissions.UserParamResource))
+	v1.DELETE("/users/:id/sessions", service.DeleteUserSessions, permissions.RequireAdminOrOwner())
+	v1.POST("/users/:id/impersonate", service.PostImpersonate, permissions.RequirePermission(permissions.PermissionSystemAdmin), recentAuth)
+	v1.DELETE("/roles/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, permissions.RequirePermission(permissions.PermissionRoleDelete), recentAuth)
+
+	return e
+}
+
+// doRequest sends a JSON request to h, authenticated with token if set.
+func doRequest(h http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
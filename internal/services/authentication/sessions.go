@@ -0,0 +1,289 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionTouchInterval = time.Minute
+)
+
+// Authentication method references (RFC 8176) recorded on sessions and in
+// the amr claim.
+const (
+	amrPassword    = "pwd"
+	amrOTP         = "otp"
+	amrHardwareKey = "hwk"
+	amrFederated   = "fed"
+	amrMFA         = "mfa"
+)
+
+func accountDeactivated(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": "Account is deactivated"})
+}
+
+func hasAuthMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *service) startSession(c echo.Context, usr *models.User, authMethods []string) (*Tokens, error) {
+	req := c.Request()
+	now := time.Now()
+
+	session := &models.Session{
+		UserID:            usr.ID,
+		UserAgent:         truncate(req.UserAgent(), 512),
+		IPAddress:         truncate(c.RealIP(), 64),
+		TwoFactorVerified: hasAuthMethod(authMethods, amrMFA),
+		AuthMethods:       authMethods,
+		AuthenticatedAt:   now,
+		LastSeenAt:        now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	if err := s.Database.Conn.WithContext(req.Context()).Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	return s.generateTokens(sessionTokenContext(session))
+}
+
+func sessionTokenContext(session *models.Session) *TokenContext {
+	tkn := &TokenContext{
+		UserID:    float64(session.UserID),
+		SessionID: float64(session.ID),
+		AMR:       session.AuthMethods,
+		AuthTime:  float64(session.AuthenticatedAt.Unix()),
+	}
+	if session.ImpersonatorID != nil {
+		tkn.ImpersonatorID = float64(*session.ImpersonatorID)
+	}
+	return tkn
+}
+
+// getActiveSession returns nil when the session does not exist, belongs to
+// another user, was revoked, has expired or has been idle for longer than a
+// refresh token lives.
+func (s *service) getActiveSession(ctx context.Context, userID, sessionID uint) (*models.Session, error) {
+	var session models.Session
+	err := s.Database.Conn.WithContext(ctx).Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if session.IsRevoked() || session.IsExpired() || session.LastSeenAt.Before(s.sessionIdleCutoff()) {
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+func (s *service) touchSession(ctx context.Context, session *models.Session) error {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+
+	session.LastSeenAt = time.Now()
+	return s.Database.Conn.WithContext(ctx).Model(session).Update("last_seen_at", session.LastSeenAt).Error
+}
+
+func (s *service) revokeSession(ctx context.Context, userID, sessionID uint) (bool, error) {
+	res := s.Database.Conn.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *service) revokeAllSessions(ctx context.Context, userID uint) (int64, error) {
+	res := s.Database.Conn.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+
+	return res.RowsAffected, res.Error
+}
+
+func (s *service) listActiveSessions(ctx context.Context, userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.Database.Conn.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, s.sessionIdleCutoff()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+func (s *service) sessionIdleCutoff() time.Time {
+	return time.Now().Add(-time.Second * time.Duration(s.RefreshTokenTTLSecs))
+}
+
+func (s *service) GetSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	sessions, err := s.listActiveSessions(ctx, user.ID)
+	if err != nil {
+		lgr.Error("failed to list sessions", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if current, ok := c.Get("session").(*models.Session); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+func (s *service) DeleteSession(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid session ID")
+	}
+
+	revoked, err := s.revokeSession(ctx, user.ID, params.ID)
+	if err != nil {
+		lgr.Error("failed to revoke session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if !revoked {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	if current, ok := c.Get("session").(*models.Session); ok && current.ID == params.ID {
+		s.clearAuthCookie(c)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session revoked successfully"})
+}
+
+func (s *service) DeleteSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	count, err := s.revokeAllSessions(ctx, user.ID)
+	if err != nil {
+		lgr.Error("failed to revoke sessions", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	s.clearAuthCookie(c)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Signed out of all sessions",
+		"revoked": count,
+	})
+}
+
+func (s *service) GetUserSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	sessions, err := s.listActiveSessions(ctx, params.ID)
+	if err != nil {
+		lgr.Error("failed to list user sessions", zap.Error(err), zap.Uint("userId", params.ID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+func (s *service) DeleteUserSession(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	var params validator.UserSessionParams
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	revoked, err := s.revokeSession(ctx, params.UserID, params.SessionID)
+	if err != nil {
+		lgr.Error("failed to revoke user session", zap.Error(err), zap.Uint("userId", params.UserID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if !revoked {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+
+	lgr.Info("user session revoked by admin", zap.Uint("userId", params.UserID), zap.Uint("sessionId", params.SessionID))
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session revoked successfully"})
+}
+
+func (s *service) DeleteUserSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	count, err := s.revokeAllSessions(ctx, params.ID)
+	if err != nil {
+		lgr.Error("failed to revoke user sessions", zap.Error(err), zap.Uint("userId", params.ID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	lgr.Info("all user sessions revoked by admin", zap.Uint("userId", params.ID), zap.Int64("revoked", count))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "All sessions revoked",
+		"revoked": count,
+	})
+}
+
+func truncate(v string, n int) string {
+	if len(v) <= n {
+		return v
+	}
+	return v[:n]
+}
@@ -0,0 +1,170 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+)
+
+func listSessions(t *testing.T, service *service, token string) []models.Session {
+	t.Helper()
+	rec := doRequest(testRouter(service), http.MethodGet, "/api/v1/user/sessions", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var body struct {
+		Sessions []models.Session `json:"sessions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to unmarshal sessions: %v", err)
+	}
+	return body.Sessions
+}
+
+func TestSessions(t *testing.T) {
+	service, database := setupTestService(t)
+	router := testRouter(service)
+	createTestUser(t, service, "sessions@example.com", "password123")
+	other := createTestUser(t, service, "other@example.com", "password123")
+
+	laptop := signIn(t, service, "sessions@example.com", "password123")
+	phone := signIn(t, service, "sessions@example.com", "password123")
+	otherTokens := signIn(t, service, "other@example.com", "password123")
+
+	sessions := listSessions(t, service, laptop.AccessToken)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected two sessions, got %+v", sessions)
+	}
+	current := 0
+	for _, session := range sessions {
+		if session.Current {
+			current++
+		}
+	}
+	if current != 1 {
+		t.Fatalf("Expected exactly one current session, got %+v", sessions)
+	}
+
+	t.Run("another user's session is not found", func(t *testing.T) {
+		var otherSession models.Session
+		database.Conn.Where("user_id = ?", other.ID).First(&otherSession)
+		rec := doRequest(router, http.MethodDelete, "/api/v1/user/sessions/"+strconv.Itoa(int(otherSession.ID)), laptop.AccessToken, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected status 404, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", otherTokens.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the other user's session to keep working, got %d", rec.Code)
+		}
+	})
+
+	t.Run("revoking one session signs out that device only", func(t *testing.T) {
+		var phoneID uint
+		for _, session := range sessions {
+			if !session.Current {
+				phoneID = session.ID
+			}
+		}
+		rec := doRequest(router, http.MethodDelete, "/api/v1/user/sessions/"+strconv.Itoa(int(phoneID)), laptop.AccessToken, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", phone.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the revoked session to be rejected, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodPost, "/api/v1/auth/refresh-token", "", map[string]string{"refresh_token": phone.RefreshToken}); rec.Code == http.StatusOK {
+			t.Fatal("Expected the revoked session's refresh token to be rejected")
+		}
+		if sessions := listSessions(t, service, laptop.AccessToken); len(sessions) != 1 {
+			t.Fatalf("Expected one remaining session, got %+v", sessions)
+		}
+	})
+
+	t.Run("idle sessions are not listed or accepted", func(t *testing.T) {
+		idle := signIn(t, service, "sessions@example.com", "password123")
+		database.Conn.Model(&models.Session{}).Where("id = (SELECT MAX(id) FROM sessions)").
+			Update("last_seen_at", time.Now().Add(-48*time.Hour))
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", idle.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the idle session to be rejected, got %d", rec.Code)
+		}
+		if sessions := listSessions(t, service, laptop.AccessToken); len(sessions) != 1 {
+			t.Fatalf("Expected the idle session to be hidden, got %+v", sessions)
+		}
+	})
+
+	t.Run("signing out everywhere revokes every session", func(t *testing.T) {
+		signIn(t, service, "sessions@example.com", "password123")
+		rec := doRequest(router, http.MethodDelete, "/api/v1/user/sessions", laptop.AccessToken, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", laptop.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the current session to be revoked too, got %d", rec.Code)
+		}
+		var active int64
+		database.Conn.Model(&models.Session{}).Where("user_id <> ? AND revoked_at IS NULL", other.ID).Count(&active)
+		if active != 0 {
+			t.Fatalf("Expected no active sessions, got %d", active)
+		}
+	})
+}
+
+func TestAdminSessions(t *testing.T) {
+	service, _ := setupTestService(t)
+	router := testRouter(service)
+	admin := createTestUser(t, service, "admin@example.com", "password123")
+	user := createTestUser(t, service, "user@example.com", "password123")
+	createTestUser(t, service, "peer@example.com", "password123")
+	service.Permissions.AssignRoleToUser(admin.ID, permissions.ROLE_ID_SUPER_ADMIN)
+
+	adminTokens := signIn(t, service, "admin@example.com", "password123")
+	userTokens := signIn(t, service, "user@example.com", "password123")
+	peerTokens := signIn(t, service, "peer@example.com", "password123")
+	path := "/api/v1/users/" + strconv.Itoa(int(user.ID)) + "/sessions"
+
+	if rec := doRequest(router, http.MethodGet, path, peerTokens.AccessToken, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected another user to be refused, got %d", rec.Code)
+	}
+	if rec := doRequest(router, http.MethodGet, path, userTokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the user to list their own sessions, got %d", rec.Code)
+	}
+	if rec := doRequest(router, http.MethodGet, path, adminTokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the admin to list sessions, got %d", rec.Code)
+	}
+	if rec := doRequest(router, http.MethodDelete, path, adminTokens.AccessToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the admin to revoke sessions, got %d", rec.Code)
+	}
+	if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", userTokens.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the user's session to be revoked, got %d", rec.Code)
+	}
+}
+
+func TestDeactivatedUser(t *testing.T) {
+	service, database := setupTestService(t)
+	router := testRouter(service)
+	user := createTestUser(t, service, "inactive@example.com", "password123")
+	tokens := signIn(t, service, "inactive@example.com", "password123")
+
+	database.Conn.Model(&models.User{}).Where("id = ?", user.ID).Update("is_active", false)
+
+	if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", tokens.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the access token of a deactivated user to be rejected, got %d", rec.Code)
+	}
+	if rec := doRequest(router, http.MethodPost, "/api/v1/auth/refresh-token", "", map[string]string{"refresh_token": tokens.RefreshToken}); rec.Code == http.StatusOK {
+		t.Fatal("Expected the refresh token of a deactivated user to be rejected")
+	}
+	rec := doRequest(router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{
+		"email":    "inactive@example.com",
+		"password": "password123",
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected sign in to be refused with 403, got %d", rec.Code)
+	}
+
+	database.Conn.Model(&models.User{}).Where("id = ?", user.ID).Update("is_active", true)
+	signIn(t, service, "inactive@example.com", "password123")
+}
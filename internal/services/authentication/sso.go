@@ -0,0 +1,478 @@
+package authentication
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/oidc"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	ssoLoginStateTTL = 10 * time.Minute
+
+	// ssoStateCookieName binds a login to the browser that started it, so a
+	// callback URL from someone else's login cannot sign the victim in.
+	ssoStateCookieName = "SSOState"
+	ssoStateCookiePath = "/api/v1/auth/sso"
+)
+
+var (
+	errSSOEmailNotVerified = errors.New("identity provider did not return a verified email")
+	errSSOAccountNotFound  = errors.New("no account matches the identity provider login")
+)
+
+// ssoProvider discovers the provider on first use so the server can start
+// while the identity provider is unreachable.
+func (s *service) ssoProvider(ctx context.Context) (*oidc.Client, error) {
+	s.ssoMu.Lock()
+	defer s.ssoMu.Unlock()
+
+	if s.ssoClient != nil {
+		return s.ssoClient, nil
+	}
+
+	client, err := oidc.Discover(ctx, &oidc.Config{
+		IssuerURL:    s.SSOIssuerURL,
+		ClientID:     s.SSOClientID,
+		ClientSecret: s.SSOClientSecret,
+		RedirectURL:  s.SSORedirectURL,
+		Scopes:       strings.Fields(s.SSOScopes),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ssoClient = client
+	return client, nil
+}
+
+func (s *service) GetSSOLogin(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	if !s.SSOEnabled {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "SSO is not enabled"})
+	}
+
+	client, err := s.ssoProvider(ctx)
+	if err != nil {
+		lgr.Error("failed to discover sso provider", zap.Error(err))
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+
+	loginState, err := newSSOLoginState()
+	if err != nil {
+		lgr.Error("failed to generate sso login state", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	db := s.Database.Conn.WithContext(ctx)
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.SSOLoginState{}).Error; err != nil {
+		lgr.Warn("failed to prune expired sso login states", zap.Error(err))
+	}
+
+	if err := db.Create(loginState).Error; err != nil {
+		lgr.Error("failed to store sso login state", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    hashSSOState(loginState.State),
+		Path:     ssoStateCookiePath,
+		MaxAge:   int(ssoLoginStateTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		// Lax so the cookie is sent on the provider's top-level redirect back.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, client.AuthCodeURL(loginState.State, loginState.Nonce, loginState.CodeVerifier))
+}
+
+func (s *service) GetSSOCallback(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	if !s.SSOEnabled {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "SSO is not enabled"})
+	}
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":   "SSO login failed",
+			"details": providerErr,
+		})
+	}
+
+	code := c.QueryParam("code")
+	if code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing authorization code"})
+	}
+
+	state := c.QueryParam("state")
+	if !ssoStateMatchesCookie(c.Request(), state) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired SSO state"})
+	}
+	clearSSOStateCookie(c)
+
+	loginState, err := s.consumeSSOLoginState(ctx, state)
+	if err != nil {
+		lgr.Error("failed to load sso login state", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if loginState == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired SSO state"})
+	}
+
+	client, err := s.ssoProvider(ctx)
+	if err != nil {
+		lgr.Error("failed to discover sso provider", zap.Error(err))
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+
+	tkns, err := client.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		lgr.Warn("failed to exchange sso authorization code", zap.Error(err))
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "SSO login failed"})
+	}
+
+	claims, err := client.VerifyIDToken(ctx, tkns.IDToken, loginState.Nonce)
+	if err != nil {
+		lgr.Warn("failed to verify sso id token", zap.Error(err))
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "SSO login failed"})
+	}
+
+	usr, err := s.resolveSSOUser(ctx, claims)
+	if err != nil {
+		if errors.Is(err, errSSOEmailNotVerified) || errors.Is(err, errSSOAccountNotFound) {
+			lgr.Info("sso login rejected", zap.Error(err), zap.String("subject", claims.Subject))
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+		lgr.Error("failed to resolve sso user", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if !usr.IsActive {
+		return accountDeactivated(c)
+	}
+
+	if err := s.syncSSORoles(c, usr, claims); err != nil {
+		lgr.Error("failed to reconcile sso roles", zap.Error(err), zap.Uint("userId", usr.ID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// Users with their own second factor finish signing in through
+	// PostVerify2FA, unless the IdP is trusted to have asked for one.
+	authMethods := []string{amrFederated}
+	if usr.TwoFactorEnabled {
+		if !s.SSOTrustIdPMFA {
+			challenge, err := s.startMFAChallenge(ctx, usr, amrFederated)
+			if err != nil {
+				lgr.Error("failed to start mfa challenge", zap.Error(err))
+				return c.NoContent(http.StatusInternalServerError)
+			}
+
+			fragment := url.Values{"mfaToken": {challenge.MFAToken}, "method": {challenge.Method}}
+			return c.Redirect(http.StatusFound, s.SSOPostLoginRedirectURL+"#"+fragment.Encode())
+		}
+		authMethods = append(authMethods, amrMFA)
+	}
+
+	jwt, err := s.startSession(c, usr, authMethods)
+	if err != nil {
+		lgr.Error("failed to start session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	c.SetCookie(s.createAuthCookie(jwt.AccessToken))
+
+	return c.Redirect(http.StatusFound, s.SSOPostLoginRedirectURL)
+}
+
+func newSSOLoginState() (*models.SSOLoginState, error) {
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	codeVerifier, err := oidc.RandomString(48)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &models.SSOLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    now.Add(ssoLoginStateTTL),
+		CreatedAt:    now,
+	}, nil
+}
+
+func hashSSOState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// ssoStateMatchesCookie reports whether the callback's state belongs to the
+// login this browser started.
+func ssoStateMatchesCookie(req *http.Request, state string) bool {
+	if state == "" {
+		return false
+	}
+
+	ck, err := req.Cookie(ssoStateCookieName)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(ck.Value), []byte(hashSSOState(state))) == 1
+}
+
+func clearSSOStateCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     ssoStateCookiePath,
+		Secure:   true,
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// consumeSSOLoginState deletes the state so a callback can only be replayed
+// once, returning nil when it is unknown or expired.
+func (s *service) consumeSSOLoginState(ctx context.Context, state string) (*models.SSOLoginState, error) {
+	if state == "" {
+		return nil, nil
+	}
+
+	db := s.Database.Conn.WithContext(ctx)
+
+	var loginState models.SSOLoginState
+	err := db.Where("state = ? AND expires_at > ?", state, time.Now()).First(&loginState).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := db.Delete(&models.SSOLoginState{}, loginState.ID)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &loginState, nil
+}
+
+// resolveSSOUser finds the account for an identity provider login: an
+// existing link first, then an account with the same verified email, then a
+// newly provisioned account when just-in-time provisioning is enabled.
+func (s *service) resolveSSOUser(ctx context.Context, claims *oidc.IDTokenClaims) (*models.User, error) {
+	db := s.Database.Conn.WithContext(ctx)
+	now := time.Now()
+
+	var identity models.UserIdentity
+	err := db.Where("issuer = ? AND subject = ?", claims.Issuer, claims.Subject).First(&identity).Error
+	if err == nil {
+		usr, err := s.Users.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if usr == nil {
+			return nil, errSSOAccountNotFound
+		}
+
+		identity.Email = claims.Email
+		identity.LastLoginAt = now
+		if err := db.Save(&identity).Error; err != nil {
+			return nil, err
+		}
+
+		return usr, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errSSOEmailNotVerified
+	}
+
+	usr, err := s.Users.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		if !s.SSOJITProvisioning {
+			return nil, errSSOAccountNotFound
+		}
+
+		usr, err = s.provisionSSOUser(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity = models.UserIdentity{
+		UserID:      usr.ID,
+		Issuer:      claims.Issuer,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := db.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("linked sso identity to user", zap.Uint("userId", usr.ID), zap.String("issuer", claims.Issuer))
+	return usr, nil
+}
+
+func (s *service) provisionSSOUser(ctx context.Context, claims *oidc.IDTokenClaims) (*models.User, error) {
+	// SSO-only accounts get an unguessable password so password sign-in stays
+	// closed until the user explicitly resets it.
+	randomPassword, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := s.Passwords.Hash(randomPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	newUser := &models.User{
+		Name:           name,
+		Email:          claims.Email,
+		Password:       pw,
+		EmailConfirmed: true,
+		IsActive:       true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if err := s.Users.CreateUser(ctx, newUser); err != nil {
+		return nil, err
+	}
+
+	if s.SSODefaultRole == "" {
+		return newUser, nil
+	}
+
+	role, err := s.Permissions.GetRoleByName(s.SSODefaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		s.Logger.Warn("sso default role not found", zap.String("role", s.SSODefaultRole))
+		return newUser, nil
+	}
+
+	if err := s.Permissions.AssignRoleToUser(newUser.ID, role.ID); err != nil {
+		return nil, err
+	}
+
+	s.Logger.Info("provisioned user from sso login", zap.Uint("userId", newUser.ID), zap.String("role", role.Name))
+	return newUser, nil
+}
+
+// syncSSORoles grants and revokes the roles mapped from the user's IdP groups.
+// It is a no-op until role mappings are configured.
+func (s *service) syncSSORoles(c echo.Context, usr *models.User, claims *oidc.IDTokenClaims) error {
+	if len(s.SSORoleMappings) == 0 {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	groups := claims.ClaimValues(s.SSOGroupsClaim)
+	changes, err := s.Permissions.ReconcileExternalRoles(usr.ID, permissions.RoleSourceSSO, s.SSORoleMappings.Roles(groups))
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		// The user keeps their roles until another administrator exists.
+		lgr.Warn("sso role mappings would remove the last administrator", zap.Uint("userId", usr.ID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(changes.Unknown) > 0 {
+		lgr.Warn("sso role mappings reference unknown roles", zap.Strings("roles", changes.Unknown))
+	}
+
+	record := func(action, role string) {
+		userID := usr.ID
+		entry := &models.AuditLog{
+			UserID:     &userID,
+			Action:     action,
+			Resource:   audit.ResourceUserRole,
+			ResourceID: role,
+			Details: map[string]interface{}{
+				"role":    role,
+				"source":  permissions.RoleSourceSSO,
+				"issuer":  claims.Issuer,
+				"subject": claims.Subject,
+				"groups":  groups,
+			},
+			IPAddress: c.RealIP(),
+		}
+		if err := s.Audit.Record(ctx, entry); err != nil {
+			lgr.Error("failed to record sso role change", zap.Error(err))
+		}
+	}
+
+	for _, role := range changes.Added {
+		record(audit.ActionRoleGranted, role)
+	}
+	for _, role := range changes.Removed {
+		record(audit.ActionRoleRevoked, role)
+	}
+
+	if !changes.Empty() {
+		lgr.Info("reconciled sso roles", zap.Uint("userId", usr.ID), zap.Strings("added", changes.Added), zap.Strings("removed", changes.Removed))
+	}
+
+	return nil
+}
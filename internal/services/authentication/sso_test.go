@@ -0,0 +1,188 @@
+package authentication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/oidc/oidctest"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func setupSSOService(t *testing.T) (*service, *oidctest.Provider) {
+	service, _ := setupTestService(t)
+
+	provider := oidctest.NewProvider(t, "test-client")
+	provider.SetUser(map[string]interface{}{
+		"sub":            "sso-subject",
+		"email":          "sso@example.com",
+		"email_verified": true,
+		"name":           "SSO User",
+	})
+
+	service.SSOEnabled = true
+	service.SSOIssuerURL = provider.Issuer()
+	service.SSOClientID = "test-client"
+	service.SSOClientSecret = "test-secret"
+	service.SSORedirectURL = "http://localhost/api/v1/auth/sso/callback"
+	service.SSOScopes = "openid email profile"
+	service.SSOJITProvisioning = true
+	service.SSODefaultRole = "User"
+	service.SSOPostLoginRedirectURL = "/dashboard"
+
+	return service, provider
+}
+
+// startSSOLogin begins a login and follows the provider's redirect, returning
+// the state cookie set for this browser and the callback query.
+func startSSOLogin(t *testing.T, h http.Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/sso/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var stateCookie *http.Cookie
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == ssoStateCookieName {
+			stateCookie = ck
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected an HttpOnly SameSite=Lax state cookie, got %+v", stateCookie)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatal("Failed to call the provider:", err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatal("Failed to parse the callback URL:", err)
+	}
+	if callback.Query().Get("state") == stateCookie.Value {
+		t.Fatal("Expected the cookie to hold a hash of the state, not the state itself")
+	}
+
+	return stateCookie, callback.RawQuery
+}
+
+func ssoCallback(h http.Handler, query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/sso/callback?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSSOCallbackRequiresStateCookie(t *testing.T) {
+	service, _ := setupSSOService(t)
+	h := testRouter(service)
+
+	t.Run("callback without the browser's cookie is rejected", func(t *testing.T) {
+		_, query := startSSOLogin(t, h)
+
+		if rec := ssoCallback(h, query, nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 without a state cookie, got %d", rec.Code)
+		}
+	})
+
+	t.Run("cookie from another login is rejected", func(t *testing.T) {
+		victimCookie, _ := startSSOLogin(t, h)
+		_, attackerQuery := startSSOLogin(t, h)
+
+		if rec := ssoCallback(h, attackerQuery, victimCookie); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for a state from another login, got %d", rec.Code)
+		}
+	})
+
+	t.Run("callback with the matching cookie signs in once", func(t *testing.T) {
+		cookie, query := startSSOLogin(t, h)
+
+		rec := ssoCallback(h, query, cookie)
+		if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "/dashboard" {
+			t.Fatalf("Expected a redirect after sign-in, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var cleared, authCookie bool
+		for _, ck := range rec.Result().Cookies() {
+			switch ck.Name {
+			case ssoStateCookieName:
+				cleared = ck.MaxAge < 0
+			case authCookieName:
+				authCookie = ck.Value != ""
+			}
+		}
+		if !cleared || !authCookie {
+			t.Fatalf("Expected the state cookie cleared and the auth cookie set, got %v", rec.Result().Cookies())
+		}
+
+		if rec := ssoCallback(h, query, cookie); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected a replayed callback to be rejected, got %d", rec.Code)
+		}
+	})
+}
+
+func TestSSOCallbackTwoFactor(t *testing.T) {
+	service, _ := setupSSOService(t)
+	h := testRouter(service)
+	mailer := service.Email.(*mockEmailService)
+	user := createTestUser(t, service, "sso@example.com", "Password123!")
+	token := signIn(t, service, "sso@example.com", "Password123!").AccessToken
+	enable2FA(t, h, token, twoFactorMethodEmail)
+	if rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": mailer.twoFactorCode}); rec.Code != http.StatusOK {
+		t.Fatalf("Expected enrollment to be confirmed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	backdateEmailOTPs(service, user.ID)
+
+	cookie, query := startSSOLogin(t, h)
+	rec := ssoCallback(h, query, cookie)
+	location, _ := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if rec.Code != http.StatusFound || location.Path != "/dashboard" || fragment.Get("mfaToken") == "" || fragment.Get("method") != twoFactorMethodEmail {
+		t.Fatalf("Expected a redirect with an MFA challenge, got %d: %s", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == authCookieName && ck.Value != "" {
+			t.Fatal("Expected no session before the second factor")
+		}
+	}
+
+	rec = doRequest(h, http.MethodPost, "/api/v1/auth/verify-2fa", "", map[string]string{
+		"mfaToken": fragment.Get("mfaToken"),
+		"code":     mailer.twoFactorCode,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the second factor to finish signing in, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session models.Session
+	service.Database.Conn.Where("user_id = ?", user.ID).Order("id DESC").First(&session)
+	if !session.TwoFactorVerified || !hasAuthMethod(session.AuthMethods, amrFederated) || hasAuthMethod(session.AuthMethods, amrPassword) {
+		t.Fatalf("Expected a federated, 2FA-verified session, got %+v", session)
+	}
+
+	t.Run("trusted identity provider", func(t *testing.T) {
+		service.SSOTrustIdPMFA = true
+		cookie, query := startSSOLogin(t, h)
+		rec := ssoCallback(h, query, cookie)
+		if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "/dashboard" {
+			t.Fatalf("Expected a plain redirect after sign-in, got %d: %s", rec.Code, rec.Header().Get(echo.HeaderLocation))
+		}
+		var session models.Session
+		service.Database.Conn.Where("user_id = ?", user.ID).Order("id DESC").First(&session)
+		if !session.TwoFactorVerified {
+			t.Fatalf("Expected the IdP's second factor to count, got %+v", session)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package authentication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// reauthenticateSession restarts the session's authentication clock and
+// records the methods the user just used.
+func (s *service) reauthenticateSession(ctx context.Context, session *models.Session, authMethods []string) error {
+	now := time.Now()
+	twoFactorVerified := session.TwoFactorVerified || hasAuthMethod(authMethods, amrMFA)
+
+	err := s.Database.Conn.WithContext(ctx).Model(&models.Session{ID: session.ID}).
+		Select("auth_methods", "authenticated_at", "two_factor_verified", "updated_at").
+		Updates(&models.Session{
+			AuthMethods:       authMethods,
+			AuthenticatedAt:   now,
+			TwoFactorVerified: twoFactorVerified,
+			UpdatedAt:         now,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	session.AuthMethods = authMethods
+	session.AuthenticatedAt = now
+	session.TwoFactorVerified = twoFactorVerified
+	return nil
+}
+
+// PostStepUp re-verifies the signed-in user so routes guarded by
+// permissions.RequireRecentAuth accept the session again.
+func (s *service) PostStepUp(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, err := twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+
+	session, ok := c.Get("session").(*models.Session)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	var payload validator.StepUpRequest
+	if err := validator.BindAndValidate(c, &payload); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	var authMethods []string
+	if payload.Password != "" {
+		match, err := s.Passwords.Verify(user.Password, payload.Password)
+		if err != nil {
+			lgr.Error("failed to compare password", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if !match {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid password"})
+		}
+		authMethods = append(authMethods, amrPassword)
+	}
+
+	if user.TwoFactorEnabled {
+		if payload.Code == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "2FA code required"})
+		}
+
+		challenge := fmt.Sprintf("step-up:%d", session.ID)
+		valid, err := s.verifySecondFactor(ctx, user, payload.Code)
+		if err != nil {
+			lgr.Error("failed to verify 2FA code", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if !valid {
+			// Too many wrong codes end the session.
+			locked, err := s.recordSecondFactorFailure(ctx, challenge, time.Now().Add(mfaTokenTTL))
+			if err == nil && locked {
+				_, err = s.revokeSession(ctx, user.ID, session.ID)
+			}
+			if err != nil {
+				lgr.Error("failed to record 2FA failure", zap.Error(err))
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			if locked {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Too many invalid codes, please sign in again"})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid 2FA code"})
+		}
+		if err := s.clearSecondFactorFailures(ctx, challenge); err != nil {
+			lgr.Error("failed to clear 2FA attempts", zap.Error(err))
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		authMethods = append(authMethods, amrOTP, amrMFA)
+	} else if len(authMethods) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Password required"})
+	}
+
+	if err := s.reauthenticateSession(ctx, session, authMethods); err != nil {
+		lgr.Error("failed to update session", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	jwt, err := s.generateTokens(sessionTokenContext(session))
+	if err != nil {
+		lgr.Error("failed to generate tokens", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	c.SetCookie(s.createAuthCookie(jwt.AccessToken))
+
+	return c.JSON(http.StatusOK, jwt)
+}
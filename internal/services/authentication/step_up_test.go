@@ -0,0 +1,134 @@
+package authentication
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/totp"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+)
+
+func stepUpReason(t *testing.T, h http.Handler, token string) string {
+	t.Helper()
+	rec := doRequest(h, http.MethodDelete, "/api/v1/roles/99", token, nil)
+	if rec.Code == http.StatusOK {
+		return ""
+	}
+	var challenge permissions.StepUpChallenge
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || !challenge.StepUpRequired {
+		t.Fatalf("Expected a step-up challenge, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return challenge.Reason
+}
+
+func TestStepUp(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+
+	t.Run("stale session steps up with a password", func(t *testing.T) {
+		admin := createTestUser(t, service, "admin@example.com", "Password123!")
+		service.Permissions.AssignRoleToUser(admin.ID, permissions.ROLE_ID_SUPER_ADMIN)
+		token := signIn(t, service, "admin@example.com", "Password123!").AccessToken
+
+		if reason := stepUpReason(t, h, token); reason != "" {
+			t.Fatalf("Expected a fresh session to pass, got %s", reason)
+		}
+
+		service.Database.Conn.Model(&models.Session{}).Where("user_id = ?", admin.ID).
+			Update("authenticated_at", time.Now().Add(-time.Hour))
+		if reason := stepUpReason(t, h, token); reason != permissions.StepUpReasonStale {
+			t.Fatalf("Expected %s, got %q", permissions.StepUpReasonStale, reason)
+		}
+
+		rec := doRequest(h, http.MethodPost, "/api/v1/auth/step-up", token, map[string]string{"password": "wrong-password"})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected a wrong password to be rejected, got %d", rec.Code)
+		}
+		rec = doRequest(h, http.MethodPost, "/api/v1/auth/step-up", token, map[string]string{"password": "Password123!"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected step-up to succeed, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if reason := stepUpReason(t, h, token); reason != "" {
+			t.Fatalf("Expected the session to pass after step-up, got %s", reason)
+		}
+	})
+
+	t.Run("session without a second factor must present one", func(t *testing.T) {
+		admin := createTestUser(t, service, "mfa-admin@example.com", "Password123!")
+		service.Permissions.AssignRoleToUser(admin.ID, permissions.ROLE_ID_SUPER_ADMIN)
+		token := signIn(t, service, "mfa-admin@example.com", "Password123!").AccessToken
+
+		secret := enable2FA(t, h, token, twoFactorMethodTOTP).Secret
+		now := time.Now()
+		// Confirming verifies the session, so clear that to model a session
+		// that signed in some other way.
+		if rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": totpCode(t, secret, now)}); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 2FA to be confirmed, got %d", rec.Code)
+		}
+		service.Database.Conn.Model(&models.Session{}).Where("user_id = ?", admin.ID).Update("two_factor_verified", false)
+
+		if reason := stepUpReason(t, h, token); reason != permissions.StepUpReasonMFARequired {
+			t.Fatalf("Expected %s, got %q", permissions.StepUpReasonMFARequired, reason)
+		}
+
+		rec := doRequest(h, http.MethodPost, "/api/v1/auth/step-up", token, map[string]string{"password": "Password123!"})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected step-up without a code to be rejected, got %d", rec.Code)
+		}
+		rec = doRequest(h, http.MethodPost, "/api/v1/auth/step-up", token, map[string]string{
+			"code": totpCode(t, secret, now.Add(totp.Period*time.Second)),
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected step-up with a TOTP code to succeed, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if reason := stepUpReason(t, h, token); reason != "" {
+			t.Fatalf("Expected the session to pass after step-up, got %s", reason)
+		}
+	})
+
+	t.Run("API keys have no session to step up", func(t *testing.T) {
+		admin := createTestUser(t, service, "key-admin@example.com", "Password123!")
+		service.Permissions.AssignRoleToUser(admin.ID, permissions.ROLE_ID_SUPER_ADMIN)
+		token := signIn(t, service, "key-admin@example.com", "Password123!").AccessToken
+		key := createAPIKey(t, service, token, permissions.PermissionRoleDelete)
+
+		rec := doRequest(h, http.MethodDelete, "/api/v1/roles/99", key, nil)
+		var challenge permissions.StepUpChallenge
+		json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if rec.Code != http.StatusForbidden || challenge.Reason != permissions.StepUpReasonSessionRequired {
+			t.Fatalf("Expected %s for an API key, got %d: %s", permissions.StepUpReasonSessionRequired, rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestMFAEnrollmentRequired(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.Permissions.SetRoleRequiresMFA(permissions.ROLE_ID_ADMIN, true)
+	past, soon := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		grant    models.UserRole
+		required bool
+	}{
+		{"role in effect", models.UserRole{ValidUntil: &soon}, true},
+		{"expired role", models.UserRole{ValidUntil: &past}, false},
+		{"scheduled role", models.UserRole{ValidFrom: &soon}, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := fmt.Sprintf("mfa%d@example.com", i)
+			user := createTestUser(t, service, email, "Password123!")
+			tt.grant.UserID, tt.grant.RoleID = user.ID, permissions.ROLE_ID_ADMIN
+			service.Database.Conn.Create(&tt.grant)
+
+			if tokens := signIn(t, service, email, "Password123!"); tokens.MFAEnrollmentRequired != tt.required {
+				t.Fatalf("Expected mfaEnrollmentRequired %v, got %v", tt.required, tokens.MFAEnrollmentRequired)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+func generateTokenID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// revokeToken keeps the token's jti on the denylist until the token would
+// have expired on its own.
+func (s *service) revokeToken(ctx context.Context, tkn *TokenContext) error {
+	revoked := &models.RevokedToken{
+		JTI:       tkn.JTI,
+		ExpiresAt: time.Unix(int64(tkn.ExpiresAt), 0),
+		CreatedAt: time.Now(),
+	}
+
+	return s.Database.Conn.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(revoked).Error
+}
+
+func (s *service) isTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := s.Database.Conn.WithContext(ctx).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PruneRevokedTokens deletes expired denylist entries and second-factor
+// attempt counts.
+func (s *service) PruneRevokedTokens(ctx context.Context) error {
+	db := s.Database.Conn.WithContext(ctx)
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", time.Now()).Delete(&models.TwoFactorAttempt{}).Error
+}
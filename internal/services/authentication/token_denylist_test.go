@@ -0,0 +1,72 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+func TestTokenDenylist(t *testing.T) {
+	service, database := setupTestService(t)
+	router := testRouter(service)
+	ctx := context.Background()
+	createTestUser(t, service, "denylist@example.com", "password123")
+
+	t.Run("a revoked jti is rejected while its session is active", func(t *testing.T) {
+		first := signIn(t, service, "denylist@example.com", "password123")
+		_, tkn, _ := service.validateToken(first.AccessToken)
+		if err := service.revokeToken(ctx, tkn); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := service.revokeToken(ctx, tkn); err != nil {
+			t.Fatalf("Expected revoking twice to be a no-op, got %v", err)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", first.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the denied token to be rejected, got %d", rec.Code)
+		}
+
+		var session models.Session
+		database.Conn.First(&session, uint(tkn.SessionID))
+		if session.IsRevoked() {
+			t.Fatal("Expected the session to stay active")
+		}
+	})
+
+	t.Run("signing out denies the access token", func(t *testing.T) {
+		tokens := signIn(t, service, "denylist@example.com", "password123")
+		if rec := doRequest(router, http.MethodPost, "/api/v1/auth/logout", tokens.AccessToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		_, tkn, _ := service.validateToken(tokens.AccessToken)
+		if revoked, err := service.isTokenRevoked(ctx, tkn.JTI); err != nil || !revoked {
+			t.Fatalf("Expected the jti to be denied, got %v (%v)", revoked, err)
+		}
+
+		var denied models.RevokedToken
+		database.Conn.First(&denied, "jti = ?", tkn.JTI)
+		if !denied.ExpiresAt.Equal(time.Unix(int64(tkn.ExpiresAt), 0)) {
+			t.Fatalf("Expected the entry to expire with the token, got %v", denied.ExpiresAt)
+		}
+		if rec := doRequest(router, http.MethodGet, "/api/v1/user/profile", tokens.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the signed out token to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("pruning drops only expired entries", func(t *testing.T) {
+		database.Conn.Create(&models.RevokedToken{JTI: "expired", ExpiresAt: time.Now().Add(-time.Minute)})
+		if err := service.PruneRevokedTokens(ctx); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var count int64
+		database.Conn.Model(&models.RevokedToken{}).Count(&count)
+		if count != 2 {
+			t.Fatalf("Expected the two live entries to remain, got %d", count)
+		}
+		if revoked, _ := service.isTokenRevoked(ctx, "expired"); revoked {
+			t.Fatal("Expected the expired entry to be pruned")
+		}
+	})
+}
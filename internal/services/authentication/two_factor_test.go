@@ -0,0 +1,203 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/totp"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, totp.Step(at))
+	if err != nil {
+		t.Fatalf("Failed to generate TOTP code: %v", err)
+	}
+	return code
+}
+
+func reloadUser(t *testing.T, service *service, id uint) *models.User {
+	t.Helper()
+	var user models.User
+	if err := service.Database.Conn.First(&user, id).Error; err != nil {
+		t.Fatalf("Failed to reload user: %v", err)
+	}
+	return &user
+}
+
+func enable2FA(t *testing.T, h http.Handler, token, method string) *Enable2FAResponse {
+	t.Helper()
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/enable", token, map[string]string{
+		"password": "Password123!",
+		"method":   method,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected enrollment to start, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp Enable2FAResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return &resp
+}
+
+func TestPending2FAEnrollment(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+	user := createTestUser(t, service, "enroll@example.com", "Password123!")
+	token := signIn(t, service, "enroll@example.com", "Password123!").AccessToken
+
+	pendingTOTP := enable2FA(t, h, token, twoFactorMethodTOTP)
+	stored := reloadUser(t, service, user.ID)
+	if stored.TwoFactorSecret != "" || stored.PendingTwoFactorMethod != twoFactorMethodTOTP || stored.PendingTwoFactorSecret == "" {
+		t.Fatalf("Expected the TOTP secret to be kept as pending only, got %+v", stored)
+	}
+
+	// Switching to email abandons the TOTP enrollment without touching the
+	// active method.
+	enable2FA(t, h, token, twoFactorMethodEmail)
+	stored = reloadUser(t, service, user.ID)
+	if stored.TwoFactorMethod != twoFactorMethodTOTP || stored.TwoFactorSecret != "" || stored.PendingTwoFactorMethod != twoFactorMethodEmail {
+		t.Fatalf("Expected only the pending method to change, got %+v", stored)
+	}
+	signIn(t, service, "enroll@example.com", "Password123!")
+
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{
+		"code": totpCode(t, pendingTOTP.Secret, time.Now()),
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a code for the abandoned TOTP enrollment to be rejected, got %d", rec.Code)
+	}
+
+	mailer := service.Email.(*mockEmailService)
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": mailer.twoFactorCode})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the emailed code to confirm enrollment, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	stored = reloadUser(t, service, user.ID)
+	if !stored.TwoFactorEnabled || stored.TwoFactorMethod != twoFactorMethodEmail || stored.PendingTwoFactorMethod != "" || stored.PendingTwoFactorSecret != "" {
+		t.Fatalf("Expected email 2FA to be active with nothing pending, got %+v", stored)
+	}
+}
+
+func TestBackupCodeAcceptance(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+	createTestUser(t, service, "backup@example.com", "Password123!")
+	token := signIn(t, service, "backup@example.com", "Password123!").AccessToken
+
+	secret := enable2FA(t, h, token, twoFactorMethodTOTP).Secret
+	now := time.Now()
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": totpCode(t, secret, now)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected enrollment to be confirmed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var backup BackupCodesResponse
+	json.Unmarshal(rec.Body.Bytes(), &backup)
+
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/backup-codes", token, map[string]string{
+		"password": "Password123!",
+		"code":     backup.BackupCodes[0],
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a backup code to be refused for regenerating codes, got %d", rec.Code)
+	}
+
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/backup-codes", token, map[string]string{
+		"password": "Password123!",
+		"code":     totpCode(t, secret, now.Add(totp.Period*time.Second)),
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a TOTP code to regenerate backup codes, got %d: %s", rec.Code, rec.Body.String())
+	}
+	json.Unmarshal(rec.Body.Bytes(), &backup)
+
+	rec = doRequest(h, http.MethodPost, "/api/v1/user/2fa/disable", token, map[string]string{
+		"password": "Password123!",
+		"code":     backup.BackupCodes[0],
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a backup code to disable 2FA, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSecondFactorAttemptLimits(t *testing.T) {
+	service, _ := setupTestService(t)
+	h := testRouter(service)
+	createTestUser(t, service, "limits@example.com", "Password123!")
+	token := signIn(t, service, "limits@example.com", "Password123!").AccessToken
+
+	secret := enable2FA(t, h, token, twoFactorMethodTOTP).Secret
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/2fa/confirm", token, map[string]string{"code": totpCode(t, secret, time.Now())})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected enrollment to be confirmed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var backup BackupCodesResponse
+	json.Unmarshal(rec.Body.Bytes(), &backup)
+
+	login := func(code string) *httptest.ResponseRecorder {
+		return doRequest(h, http.MethodPost, "/api/v1/auth/login", "", map[string]string{
+			"email":    "limits@example.com",
+			"password": "Password123!",
+			"code":     code,
+		})
+	}
+	mfaToken := func() string {
+		var challenge TwoFactorChallengeResponse
+		json.Unmarshal(login("").Body.Bytes(), &challenge)
+		return challenge.MFAToken
+	}
+	verify := func(mfaToken, code string) *httptest.ResponseRecorder {
+		return doRequest(h, http.MethodPost, "/api/v1/auth/verify-2fa", "", map[string]string{"mfaToken": mfaToken, "code": code})
+	}
+
+	t.Run("codes sent with the password", func(t *testing.T) {
+		for i := 0; i < service.TwoFactorMaxAttempts; i++ {
+			if rec := login("000000"); rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400 for a wrong code, got %d", rec.Code)
+			}
+		}
+		if rec := login(totpCode(t, secret, time.Now())); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 once the attempts are used up, got %d", rec.Code)
+		}
+	})
+
+	t.Run("MFA token", func(t *testing.T) {
+		tkn := mfaToken()
+		for i := 1; i < service.TwoFactorMaxAttempts; i++ {
+			if rec := verify(tkn, "zzzzz-zzzzz"); rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400 for a wrong backup code, got %d", rec.Code)
+			}
+		}
+		if rec := verify(tkn, "000000"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the last wrong code to use up the token, got %d", rec.Code)
+		}
+		if rec := verify(tkn, backup.BackupCodes[0]); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the used-up token to be refused, got %d", rec.Code)
+		}
+	})
+
+	t.Run("step-up", func(t *testing.T) {
+		rec := verify(mfaToken(), backup.BackupCodes[0])
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected a backup code to sign in, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var tokens Tokens
+		json.Unmarshal(rec.Body.Bytes(), &tokens)
+
+		for i := 1; i < service.TwoFactorMaxAttempts; i++ {
+			if rec := doRequest(h, http.MethodPost, "/api/v1/auth/step-up", tokens.AccessToken, map[string]string{"code": "000000"}); rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400 for a wrong code, got %d", rec.Code)
+			}
+		}
+		if rec := doRequest(h, http.MethodPost, "/api/v1/auth/step-up", tokens.AccessToken, map[string]string{"code": "000000"}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the last wrong code to end the session, got %d", rec.Code)
+		}
+		if rec := doRequest(h, http.MethodGet, "/api/v1/user/profile", tokens.AccessToken, nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected the session to be revoked, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+)
+
+const testWebAuthnOrigin = "http://localhost:8080"
+
+var b64url = base64.RawURLEncoding
+
+// softAuthenticator is a minimal platform authenticator: an ES256 key pair
+// that answers registration with "none" attestation and signs assertions
+// with user presence and verification set.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+type beginResponse struct {
+	SessionID string `json:"sessionId"`
+	Options   struct {
+		PublicKey struct {
+			Challenge        string `json:"challenge"`
+			AllowCredentials []struct {
+				ID string `json:"id"`
+			} `json:"allowCredentials"`
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	} `json:"options"`
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		head := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(head[1:], uint16(n))
+		return head
+	}
+}
+
+func cborInt(i int) []byte {
+	if i >= 0 {
+		return cborHead(0, uint64(i))
+	}
+	return cborHead(1, uint64(-1-i))
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, uint64(len(b))), b...) }
+func cborText(s string) []byte  { return append(cborHead(3, uint64(len(s))), s...) }
+
+func rpIDHash() []byte {
+	sum := sha256.Sum256([]byte("localhost"))
+	return sum[:]
+}
+
+func clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testWebAuthnOrigin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) register(t *testing.T, begin *beginResponse) json.RawMessage {
+	t.Helper()
+
+	var err error
+	if a.userHandle, err = b64url.DecodeString(begin.Options.PublicKey.User.ID); err != nil {
+		t.Fatal("Failed to decode user handle:", err)
+	}
+	if a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+	a.credentialID = make([]byte, 16)
+	rand.Read(a.credentialID)
+
+	// COSE EC2 key: kty=2, alg=-7 (ES256), crv=1 (P-256), x, y.
+	publicKey := []byte{0xa5}
+	for _, field := range [][]byte{
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(-7),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(a.key.PublicKey.X.FillBytes(make([]byte, 32))),
+		cborInt(-3), cborBytes(a.key.PublicKey.Y.FillBytes(make([]byte, 32))),
+	} {
+		publicKey = append(publicKey, field...)
+	}
+
+	authData := append([]byte{}, rpIDHash()...)
+	authData = append(authData, 0x45, 0, 0, 0, 0) // UP, UV and attested credential data
+	authData = append(authData, make([]byte, 16)...)
+	authData = append(authData, byte(len(a.credentialID)>>8), byte(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation := []byte{0xa3}
+	attestation = append(attestation, cborText("fmt")...)
+	attestation = append(attestation, cborText("none")...)
+	attestation = append(attestation, cborText("attStmt")...)
+	attestation = append(attestation, 0xa0)
+	attestation = append(attestation, cborText("authData")...)
+	attestation = append(attestation, cborBytes(authData)...)
+
+	out, _ := json.Marshal(map[string]interface{}{
+		"id":    b64url.EncodeToString(a.credentialID),
+		"rawId": b64url.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url.EncodeToString(clientData("webauthn.create", begin.Options.PublicKey.Challenge)),
+			"attestationObject": b64url.EncodeToString(attestation),
+		},
+	})
+	return out
+}
+
+func (a *softAuthenticator) assert(t *testing.T, begin *beginResponse) json.RawMessage {
+	t.Helper()
+
+	a.signCount++
+	authData := append([]byte{}, rpIDHash()...)
+	authData = append(authData, 0x05) // UP and UV
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+
+	data := clientData("webauthn.get", begin.Options.PublicKey.Challenge)
+	dataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), dataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal("Failed to sign assertion:", err)
+	}
+
+	out, _ := json.Marshal(map[string]interface{}{
+		"id":    b64url.EncodeToString(a.credentialID),
+		"rawId": b64url.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url.EncodeToString(data),
+			"authenticatorData": b64url.EncodeToString(authData),
+			"signature":         b64url.EncodeToString(signature),
+			"userHandle":        b64url.EncodeToString(a.userHandle),
+		},
+	})
+	return out
+}
+
+func setupWebAuthnService(t *testing.T) *service {
+	service, _ := setupTestService(t)
+	service.WebAuthnRPID = "localhost"
+	service.WebAuthnRPDisplayName = "Test"
+	service.WebAuthnRPOrigins = []string{testWebAuthnOrigin}
+	return service
+}
+
+func webAuthnBegin(t *testing.T, h http.Handler, path, token string, body interface{}) *beginResponse {
+	t.Helper()
+	rec := doRequest(h, http.MethodPost, path, token, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %s to succeed, got %d: %s", path, rec.Code, rec.Body.String())
+	}
+	var begin beginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &begin); err != nil {
+		t.Fatalf("Failed to unmarshal begin response: %v", err)
+	}
+	return &begin
+}
+
+// registerPasskey registers a new soft authenticator for the token's user.
+func registerPasskey(t *testing.T, h http.Handler, token string) *softAuthenticator {
+	t.Helper()
+	begin := webAuthnBegin(t, h, "/api/v1/user/webauthn/register/begin", token, nil)
+
+	authenticator := &softAuthenticator{}
+	rec := doRequest(h, http.MethodPost, "/api/v1/user/webauthn/register/finish", token, map[string]interface{}{
+		"sessionId":  begin.SessionID,
+		"nickname":   "Laptop",
+		"credential": authenticator.register(t, begin),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected registration to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return authenticator
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	service := setupWebAuthnService(t)
+	h := testRouter(service)
+	user := createTestUser(t, service, "passkey@example.com", "Password123!")
+
+	t.Run("stale session must step up first", func(t *testing.T) {
+		tokens := signIn(t, service, "passkey@example.com", "Password123!")
+		service.Database.Conn.Model(&models.Session{}).Where("user_id = ?", user.ID).
+			Update("authenticated_at", time.Now().Add(-time.Hour))
+
+		rec := doRequest(h, http.MethodPost, "/api/v1/user/webauthn/register/begin", tokens.AccessToken, nil)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for a stale session, got %d", rec.Code)
+		}
+		var challenge permissions.StepUpChallenge
+		json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if challenge.Reason != permissions.StepUpReasonStale {
+			t.Fatalf("Expected a stale step-up challenge, got %+v", challenge)
+		}
+	})
+
+	t.Run("user whose role requires MFA can enroll a passkey", func(t *testing.T) {
+		service.Database.Conn.Model(&models.Role{}).Where("id = ?", permissions.ROLE_ID_USER).Update("requires_mfa", true)
+		t.Cleanup(func() {
+			service.Database.Conn.Model(&models.Role{}).Where("id = ?", permissions.ROLE_ID_USER).Update("requires_mfa", false)
+		})
+		if err := service.Permissions.AssignRoleToUser(user.ID, permissions.ROLE_ID_USER); err != nil {
+			t.Fatalf("Failed to assign role: %v", err)
+		}
+
+		tokens := signIn(t, service, "passkey@example.com", "Password123!")
+		if rec := doRequest(h, http.MethodGet, "/api/v1/user/sessions", tokens.AccessToken, nil); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected other routes to stay blocked until MFA, got %d", rec.Code)
+		}
+		registerPasskey(t, h, tokens.AccessToken)
+	})
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	service := setupWebAuthnService(t)
+	h := testRouter(service)
+	user := createTestUser(t, service, "passkey@example.com", "Password123!")
+	createTestUser(t, service, "nopasskey@example.com", "Password123!")
+	authenticator := registerPasskey(t, h, signIn(t, service, "passkey@example.com", "Password123!").AccessToken)
+
+	finish := func(begin *beginResponse, credential json.RawMessage) int {
+		return doRequest(h, http.MethodPost, "/api/v1/auth/webauthn/login/finish", "", map[string]interface{}{
+			"sessionId":  begin.SessionID,
+			"credential": credential,
+		}).Code
+	}
+
+	t.Run("discoverable and email-scoped logins succeed", func(t *testing.T) {
+		begin := webAuthnBegin(t, h, "/api/v1/auth/webauthn/login/begin", "", map[string]string{})
+		if code := finish(begin, authenticator.assert(t, begin)); code != http.StatusOK {
+			t.Fatalf("Expected discoverable login to succeed, got %d", code)
+		}
+
+		begin = webAuthnBegin(t, h, "/api/v1/auth/webauthn/login/begin", "", map[string]string{"email": "passkey@example.com"})
+		if code := finish(begin, authenticator.assert(t, begin)); code != http.StatusOK {
+			t.Fatalf("Expected email-scoped login to succeed, got %d", code)
+		}
+	})
+
+	t.Run("unknown and passkey-less accounts get a decoy challenge", func(t *testing.T) {
+		for _, email := range []string{"nobody@example.com", "nopasskey@example.com"} {
+			first := webAuthnBegin(t, h, "/api/v1/auth/webauthn/login/begin", "", map[string]string{"email": email})
+			second := webAuthnBegin(t, h, "/api/v1/auth/webauthn/login/begin", "", map[string]string{"email": email})
+
+			allowed := first.Options.PublicKey.AllowCredentials
+			if len(allowed) != 1 || len(second.Options.PublicKey.AllowCredentials) != 1 || allowed[0].ID != second.Options.PublicKey.AllowCredentials[0].ID {
+				t.Fatalf("Expected a stable decoy credential for %s, got %+v", email, first.Options)
+			}
+			if code := finish(first, authenticator.assert(t, first)); code != http.StatusUnauthorized {
+				t.Fatalf("Expected a decoy login for %s to fail, got %d", email, code)
+			}
+		}
+	})
+
+	t.Run("deactivated account is refused before the credential is updated", func(t *testing.T) {
+		var before models.WebAuthnCredential
+		service.Database.Conn.Where("user_id = ?", user.ID).First(&before)
+		service.Database.Conn.Model(&models.User{}).Where("id = ?", user.ID).Update("is_active", false)
+
+		begin := webAuthnBegin(t, h, "/api/v1/auth/webauthn/login/begin", "", map[string]string{})
+		if code := finish(begin, authenticator.assert(t, begin)); code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for a deactivated account, got %d", code)
+		}
+
+		var after models.WebAuthnCredential
+		service.Database.Conn.Where("user_id = ?", user.ID).First(&after)
+		if after.SignCount != before.SignCount {
+			t.Fatalf("Expected the sign count to stay %d, got %d", before.SignCount, after.SignCount)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package email
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/resend/resend-go/v2"
 	"go.uber.org/zap"
@@ -30,6 +31,9 @@ type Service interface {
 	SendTwoFactorCode(ctx context.Context, to, name, code string) error
 	SendWelcomeEmail(ctx context.Context, to, name string) error
 	SendEmailConfirmation(ctx context.Context, to, name, confirmToken string) error
+	SendRoleExpiringEmail(ctx context.Context, to, name, role string, expiresAt time.Time) error
+	SendAccessRequestEmail(ctx context.Context, to, name, requester, role, justification string) error
+	SendAccessDecisionEmail(ctx context.Context, to, name, role string, approved bool, note string) error
 }
 
 func New(cfg *Config, deps *Dependencies) Service {
@@ -132,4 +136,77 @@ func (s *service) SendEmailConfirmation(ctx context.Context, to, name, confirmTo
 
 	s.Logger.Info("email confirmation sent successfully", zap.String("to", to))
 	return nil
-}
\ No newline at end of file
+}
+
+func (s *service) SendRoleExpiringEmail(ctx context.Context, to, name, role string, expiresAt time.Time) error {
+	params := &resend.SendEmailRequest{
+		From:    s.FromEmail,
+		To:      []string{to},
+		Subject: fmt.Sprintf("Your %s role in %s is expiring", role, s.AppName),
+		Html:    s.generateRoleExpiringHTML(name, role, expiresAt),
+		Text:    s.generateRoleExpiringText(name, role, expiresAt),
+	}
+
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		s.Logger.Error("failed to send role expiry email",
+			zap.Error(err),
+			zap.String("to", to),
+		)
+		return err
+	}
+
+	s.Logger.Info("role expiry email sent successfully", zap.String("to", to))
+	return nil
+}
+
+func (s *service) SendAccessRequestEmail(ctx context.Context, to, name, requester, role, justification string) error {
+	reviewURL := fmt.Sprintf("%s/access-requests", s.AppURL)
+
+	params := &resend.SendEmailRequest{
+		From:    s.FromEmail,
+		To:      []string{to},
+		Subject: fmt.Sprintf("%s requested the %s role", requester, role),
+		Html:    s.generateAccessRequestHTML(name, requester, role, justification, reviewURL),
+		Text:    s.generateAccessRequestText(name, requester, role, justification, reviewURL),
+	}
+
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		s.Logger.Error("failed to send access request email",
+			zap.Error(err),
+			zap.String("to", to),
+		)
+		return err
+	}
+
+	s.Logger.Info("access request email sent successfully", zap.String("to", to))
+	return nil
+}
+
+func (s *service) SendAccessDecisionEmail(ctx context.Context, to, name, role string, approved bool, note string) error {
+	decision := "denied"
+	if approved {
+		decision = "approved"
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    s.FromEmail,
+		To:      []string{to},
+		Subject: fmt.Sprintf("Your request for the %s role was %s", role, decision),
+		Html:    s.generateAccessDecisionHTML(name, role, decision, note),
+		Text:    s.generateAccessDecisionText(name, role, decision, note),
+	}
+
+	_, err := s.client.Emails.Send(params)
+	if err != nil {
+		s.Logger.Error("failed to send access decision email",
+			zap.Error(err),
+			zap.String("to", to),
+		)
+		return err
+	}
+
+	s.Logger.Info("access decision email sent successfully", zap.String("to", to))
+	return nil
+}
@@ -1,6 +1,10 @@
 package email
 
-import "fmt"
+import (
+	"fmt"
+	"html"
+	"time"
+)
 
 func (s *service) generatePasswordResetHTML(name, resetURL string) string {
 	return fmt.Sprintf(`
@@ -232,4 +236,161 @@ If you didn't create an account, you can safely ignore this email.
 Best regards,
 The %s Team
 `, name, confirmURL, s.AppName)
-}
\ No newline at end of file
+}
+
+func (s *service) generateRoleExpiringHTML(name, role string, expiresAt time.Time) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Your Access Is Expiring</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #f8f9fa; padding: 20px; text-align: center; border-radius: 8px 8px 0 0; }
+        .content { background: white; padding: 30px; border: 1px solid #e9ecef; }
+        .footer { background: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d; border-radius: 0 0 8px 8px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+        </div>
+        <div class="content">
+            <h2>Your Access Is Expiring</h2>
+            <p>Hi %s,</p>
+            <p>Your <strong>%s</strong> role expires on %s.</p>
+            <p>If you still need this access, ask an administrator to extend it before then.</p>
+        </div>
+        <div class="footer">
+            <p>This email was sent by %s.</p>
+        </div>
+    </div>
+</body>
+</html>`, s.AppName, name, role, expiresAt.UTC().Format(time.RFC1123), s.AppName)
+}
+
+func (s *service) generateRoleExpiringText(name, role string, expiresAt time.Time) string {
+	return fmt.Sprintf(`
+Your Access Is Expiring
+
+Hi %s,
+
+Your %s role expires on %s.
+
+If you still need this access, ask an administrator to extend it before then.
+
+Best regards,
+The %s Team
+`, name, role, expiresAt.UTC().Format(time.RFC1123), s.AppName)
+}
+
+func (s *service) generateAccessRequestHTML(name, requester, role, justification, reviewURL string) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Access Request</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #f8f9fa; padding: 20px; text-align: center; border-radius: 8px 8px 0 0; }
+        .content { background: white; padding: 30px; border: 1px solid #e9ecef; }
+        .button { display: inline-block; padding: 12px 24px; background: #007bff; color: white; text-decoration: none; border-radius: 6px; margin: 20px 0; }
+        .footer { background: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d; border-radius: 0 0 8px 8px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+        </div>
+        <div class="content">
+            <h2>Access Request</h2>
+            <p>Hi %s,</p>
+            <p>%s requested the <strong>%s</strong> role:</p>
+            <blockquote>%s</blockquote>
+            <p style="text-align: center;">
+                <a href="%s" class="button">Review Request</a>
+            </p>
+        </div>
+        <div class="footer">
+            <p>You received this email because you approve requests for this role in %s.</p>
+        </div>
+    </div>
+</body>
+</html>`, s.AppName, html.EscapeString(name), html.EscapeString(requester), html.EscapeString(role), html.EscapeString(justification), reviewURL, s.AppName)
+}
+
+func (s *service) generateAccessRequestText(name, requester, role, justification, reviewURL string) string {
+	return fmt.Sprintf(`
+Access Request
+
+Hi %s,
+
+%s requested the %s role:
+
+%s
+
+Review the request at:
+%s
+
+Best regards,
+The %s Team
+`, name, requester, role, justification, reviewURL, s.AppName)
+}
+
+func (s *service) generateAccessDecisionHTML(name, role, decision, note string) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Access Request %s</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #f8f9fa; padding: 20px; text-align: center; border-radius: 8px 8px 0 0; }
+        .content { background: white; padding: 30px; border: 1px solid #e9ecef; }
+        .footer { background: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d; border-radius: 0 0 8px 8px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>%s</h1>
+        </div>
+        <div class="content">
+            <h2>Access Request %s</h2>
+            <p>Hi %s,</p>
+            <p>Your request for the <strong>%s</strong> role was %s.</p>
+            <p>%s</p>
+        </div>
+        <div class="footer">
+            <p>This email was sent by %s.</p>
+        </div>
+    </div>
+</body>
+</html>`, decision, s.AppName, decision, html.EscapeString(name), html.EscapeString(role), decision, html.EscapeString(note), s.AppName)
+}
+
+func (s *service) generateAccessDecisionText(name, role, decision, note string) string {
+	return fmt.Sprintf(`
+Access Request %s
+
+Hi %s,
+
+Your request for the %s role was %s.
+
+%s
+
+Best regards,
+The %s Team
+`, decision, name, role, decision, note, s.AppName)
+}
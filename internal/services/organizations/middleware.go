@@ -0,0 +1,102 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+const HeaderOrganizationID = "X-Organization-ID"
+
+// organizationRef finds which organization the request targets: the :orgId
+// path parameter, then the X-Organization-ID header, then the subdomain of
+// baseDomain. Each may hold an ID or a slug.
+func organizationRef(c echo.Context, baseDomain string) string {
+	if ref := c.Param("orgId"); ref != "" {
+		return ref
+	}
+	if ref := strings.TrimSpace(c.Request().Header.Get(HeaderOrganizationID)); ref != "" {
+		return ref
+	}
+	if baseDomain == "" {
+		return ""
+	}
+
+	host := c.Request().Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(baseDomain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// ResolveOrganization sets the request's active organization, which
+// permissions.RequirePermission then checks the caller's roles against. The
+// caller must be a member unless they are a global system admin. It must run
+// after authentication.
+func (s *Service) ResolveOrganization(baseDomain string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*models.User)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+			}
+
+			ref := organizationRef(c, baseDomain)
+			if ref == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "No organization selected")
+			}
+
+			organization, err := s.GetOrganization(ref)
+			if errors.Is(err, ErrNotFound) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Organization not found"})
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get organization")
+			}
+
+			ctx := tenancy.WithOrganization(c.Request().Context(), organization.ID)
+			if !permissions.HasPermission(user.GetPermissions(), permissions.PermissionSystemAdmin) {
+				member, err := s.IsMember(ctx, user.ID)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check membership")
+				}
+				if !member {
+					return echo.NewHTTPError(http.StatusForbidden, "You are not a member of this organization")
+				}
+			}
+
+			logger.SetOrganizationID(ctx, organization.ID)
+			c.SetRequest(c.Request().WithContext(ctx))
+			c.Set("organization", organization)
+			return next(c)
+		}
+	}
+}
+
+// CrossTenantContext returns a context whose queries are not limited to one
+// organization. Only global system admins get one.
+func CrossTenantContext(c echo.Context) (context.Context, error) {
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+	// EffectivePermissions applies API key scopes; the global check keeps an
+	// organization-level system:admin role from counting.
+	if !permissions.HasPermission(user.GetPermissions(), permissions.PermissionSystemAdmin) ||
+		!permissions.HasPermission(permissions.EffectivePermissions(c), permissions.PermissionSystemAdmin) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+	}
+	return tenancy.WithCrossTenant(c.Request().Context()), nil
+}
@@ -0,0 +1,46 @@
+package organizations
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestOrganizationRef(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		header string
+		param  string
+		want   string
+	}{
+		{name: "path parameter wins", host: "acme.example.com", header: "other", param: "7", want: "7"},
+		{name: "header", host: "acme.example.com", header: "globex", want: "globex"},
+		{name: "subdomain", host: "acme.example.com", want: "acme"},
+		{name: "subdomain with port", host: "Acme.Example.com:8080", want: "acme"},
+		{name: "nested subdomain ignored", host: "a.acme.example.com", want: ""},
+		{name: "bare domain", host: "example.com", want: ""},
+		{name: "other domain", host: "acme.example.org", want: ""},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = tt.host
+			if tt.header != "" {
+				req.Header.Set(HeaderOrganizationID, tt.header)
+			}
+			c := e.NewContext(req, httptest.NewRecorder())
+			if tt.param != "" {
+				c.SetParamNames("orgId")
+				c.SetParamValues(tt.param)
+			}
+
+			if got := organizationRef(c, "example.com"); got != tt.want {
+				t.Fatalf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
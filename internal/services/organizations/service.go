@@ -0,0 +1,380 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotFound           = errors.New("organization not found")
+	ErrSlugTaken          = errors.New("organization slug is already taken")
+	ErrAlreadyMember      = errors.New("user is already a member")
+	ErrNotMember          = errors.New("user is not a member")
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleAlreadyHeld    = errors.New("member already has this role")
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+// invitationTTL is how long an invitation can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// Member is what other members see of a member: the name and the roles held
+// in the organization, nothing from the rest of the account.
+type Member struct {
+	ID       uint         `json:"id"`
+	Name     string       `json:"name"`
+	Roles    []MemberRole `json:"roles"`
+	JoinedAt time.Time    `json:"joinedAt"`
+}
+
+type MemberRole struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// CreateOrganization creates the organization and makes the owner a member
+// with the Admin role in it.
+func (s *Service) CreateOrganization(ctx context.Context, name, slug string, ownerID uint) (*models.Organization, error) {
+	organization := &models.Organization{
+		Name:      name,
+		Slug:      slug,
+		CreatedBy: ownerID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Organization{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check organization slug: %w", err)
+		}
+		if count > 0 {
+			return ErrSlugTaken
+		}
+
+		if err := tx.Create(organization).Error; err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+
+		tx = tx.WithContext(tenancy.WithOrganization(ctx, organization.ID))
+		membership := models.Membership{UserID: ownerID, CreatedAt: time.Now()}
+		if err := tx.Create(&membership).Error; err != nil {
+			return fmt.Errorf("failed to add organization owner: %w", err)
+		}
+
+		var admin models.Role
+		if err := tx.Where("name = ?", permissions.AdminRoleName).First(&admin).Error; err != nil {
+			return fmt.Errorf("failed to find admin role: %w", err)
+		}
+
+		return createMemberRole(tx, ownerID, admin.ID, ownerID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// GetOrganization looks an organization up by ID or slug.
+func (s *Service) GetOrganization(ref string) (*models.Organization, error) {
+	query := s.db.Where("slug = ?", ref)
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		query = s.db.Where("id = ?", id)
+	}
+
+	var organization models.Organization
+	err := query.First(&organization).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return &organization, nil
+}
+
+func (s *Service) GetUserOrganizations(userID uint) ([]models.Organization, error) {
+	var organizations []models.Organization
+	err := s.db.Joins("JOIN memberships ON memberships.organization_id = organizations.id").
+		Where("memberships.user_id = ?", userID).
+		Order("organizations.name").
+		Find(&organizations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organizations: %w", err)
+	}
+	return organizations, nil
+}
+
+// IsMember reports whether the user is a member of the organization in ctx.
+func (s *Service) IsMember(ctx context.Context, userID uint) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.Membership{}).Where("user_id = ?", userID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check membership: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetMembers lists the members of the organization in ctx.
+func (s *Service) GetMembers(ctx context.Context) ([]Member, error) {
+	db := s.db.WithContext(ctx)
+
+	var memberships []models.Membership
+	err := db.Preload("User", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name") }).
+		Order("created_at").Find(&memberships).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+
+	var userRoles []models.UserRole
+	if err := db.Scopes(tenancy.Scope).Preload("Role").Find(&userRoles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get member roles: %w", err)
+	}
+
+	roles := make(map[uint][]MemberRole)
+	for _, userRole := range userRoles {
+		roles[userRole.UserID] = append(roles[userRole.UserID], MemberRole{ID: userRole.Role.ID, Name: userRole.Role.Name})
+	}
+
+	members := make([]Member, len(memberships))
+	for i, membership := range memberships {
+		members[i] = Member{
+			ID:       membership.UserID,
+			Name:     membership.User.Name,
+			Roles:    roles[membership.UserID],
+			JoinedAt: membership.CreatedAt,
+		}
+		if members[i].Roles == nil {
+			members[i].Roles = []MemberRole{}
+		}
+	}
+	return members, nil
+}
+
+// InviteMember invites a user to the organization in ctx. Inviting an
+// unknown user succeeds without doing anything so the endpoint can't be used
+// to probe which user IDs exist; inviting someone again renews the
+// invitation.
+func (s *Service) InviteMember(ctx context.Context, userID, invitedBy uint) error {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check user: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	member, err := s.IsMember(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if member {
+		return ErrAlreadyMember
+	}
+
+	invitation := models.OrganizationInvitation{
+		UserID:    userID,
+		InvitedBy: invitedBy,
+		ExpiresAt: time.Now().Add(invitationTTL),
+		CreatedAt: time.Now(),
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.OrganizationInvitation{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"invited_by": invitation.InvitedBy,
+			"expires_at": invitation.ExpiresAt,
+			"created_at": invitation.CreatedAt,
+		})
+		if res.Error != nil || res.RowsAffected > 0 {
+			return res.Error
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invite member: %w", err)
+	}
+	return nil
+}
+
+// GetInvitations returns the user's invitations that can still be accepted,
+// from every organization.
+func (s *Service) GetInvitations(ctx context.Context, userID uint) ([]models.OrganizationInvitation, error) {
+	var invitations []models.OrganizationInvitation
+	err := s.db.WithContext(tenancy.WithCrossTenant(ctx)).Preload("Organization").
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation turns the user's invitation into a membership.
+func (s *Service) AcceptInvitation(ctx context.Context, invitationID, userID uint) (*models.Organization, error) {
+	var invitation models.OrganizationInvitation
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The invitation is looked up by its invitee; the organization is
+		// only known once it is found.
+		err := tx.WithContext(tenancy.WithCrossTenant(ctx)).Preload("Organization").
+			Where("id = ? AND user_id = ? AND expires_at > ?", invitationID, userID, time.Now()).
+			First(&invitation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get invitation: %w", err)
+		}
+
+		tx = tx.WithContext(tenancy.WithOrganization(ctx, invitation.OrganizationID))
+		if err := tx.Delete(&invitation).Error; err != nil {
+			return fmt.Errorf("failed to delete invitation: %w", err)
+		}
+
+		membership := models.Membership{UserID: userID, CreatedAt: time.Now()}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&membership).Error; err != nil {
+			return fmt.Errorf("failed to add member: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invitation.Organization, nil
+}
+
+func (s *Service) DeclineInvitation(ctx context.Context, invitationID, userID uint) error {
+	res := s.db.WithContext(tenancy.WithCrossTenant(ctx)).
+		Where("id = ? AND user_id = ?", invitationID, userID).
+		Delete(&models.OrganizationInvitation{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to decline invitation: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// RemoveMember removes the membership and every role the user held in the
+// organization in ctx. It returns permissions.ErrLastOrganizationAdmin when
+// that would leave no member able to manage the organization.
+func (s *Service) RemoveMember(ctx context.Context, userID uint) error {
+	return permissions.GuardLastAdmin(s.db.WithContext(ctx), func(tx *gorm.DB) error {
+		res := tx.Where("user_id = ?", userID).Delete(&models.Membership{})
+		if res.Error != nil {
+			return fmt.Errorf("failed to remove member: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotMember
+		}
+
+		err := tx.Scopes(tenancy.Scope).Where("user_id = ?", userID).Delete(&models.UserRole{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove member roles: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetRolePermissions returns the names of the permissions the role grants,
+// including inherited ones.
+func (s *Service) GetRolePermissions(roleID uint) ([]string, error) {
+	var role models.Role
+	err := s.db.Preload("Permissions.Permission").First(&role, roleID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	if err := permissions.LoadInheritedPermissions(s.db, &role); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(role.Permissions)+len(role.InheritedPermissions))
+	for _, rolePermission := range role.Permissions {
+		names = append(names, rolePermission.Permission.Name)
+	}
+	return append(names, role.InheritedPermissions...), nil
+}
+
+// AssignRole grants a role to a member within the organization in ctx only.
+func (s *Service) AssignRole(ctx context.Context, userID, roleID, assignedBy uint) error {
+	member, err := s.IsMember(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotMember
+	}
+
+	db := s.db.WithContext(ctx)
+	var count int64
+	err = db.Model(&models.UserRole{}).Scopes(tenancy.Scope).
+		Where("user_id = ? AND role_id = ?", userID, roleID).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check existing member role: %w", err)
+	}
+	if count > 0 {
+		return ErrRoleAlreadyHeld
+	}
+
+	return createMemberRole(db, userID, roleID, assignedBy)
+}
+
+// RemoveRole revokes the role the user holds in the organization in ctx,
+// under the same guard as RemoveMember.
+func (s *Service) RemoveRole(ctx context.Context, userID, roleID uint) error {
+	return permissions.GuardLastAdmin(s.db.WithContext(ctx), func(tx *gorm.DB) error {
+		err := tx.Scopes(tenancy.Scope).
+			Where("user_id = ? AND role_id = ?", userID, roleID).
+			Delete(&models.UserRole{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove member role: %w", err)
+		}
+		return nil
+	})
+}
+
+// createMemberRole grants the role in the organization in tx's context.
+func createMemberRole(tx *gorm.DB, userID, roleID, assignedBy uint) error {
+	organizationID, ok := tenancy.OrganizationID(tx.Statement.Context)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+
+	userRole := models.UserRole{
+		UserID:         userID,
+		RoleID:         roleID,
+		OrganizationID: &organizationID,
+		AssignedBy:     assignedBy,
+		Source:         permissions.RoleSourceManual,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := tx.Create(&userRole).Error; err != nil {
+		return fmt.Errorf("failed to assign member role: %w", err)
+	}
+	return nil
+}
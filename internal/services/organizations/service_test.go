@@ -0,0 +1,239 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.RolePermission{}, &models.UserRole{},
+		&models.RoleInheritance{}, &models.Organization{}, &models.Membership{}, &models.OrganizationInvitation{})
+	if err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	if err := tenancy.Register(db); err != nil {
+		t.Fatalf("Expected no error registering callbacks, got %v", err)
+	}
+	if err := permissions.NewService(db).SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+	return NewService(db), db
+}
+
+func in(organization *models.Organization) context.Context {
+	return tenancy.WithOrganization(context.Background(), organization.ID)
+}
+
+// unscoped returns db for checks that read across organizations.
+func unscoped(db *gorm.DB) *gorm.DB {
+	return db.WithContext(tenancy.WithCrossTenant(context.Background()))
+}
+
+func createUser(t *testing.T, db *gorm.DB, email string) *models.User {
+	t.Helper()
+	user := &models.User{Name: "Test User", Email: email, Password: "x", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Expected no error creating user, got %v", err)
+	}
+	return user
+}
+
+// join invites the user into the organization in ctx and accepts for them.
+func join(t *testing.T, svc *Service, ctx context.Context, userID, invitedBy uint) {
+	t.Helper()
+	if err := svc.InviteMember(ctx, userID, invitedBy); err != nil {
+		t.Fatalf("Expected no error inviting, got %v", err)
+	}
+	invitations, _ := svc.GetInvitations(ctx, userID)
+	for _, invitation := range invitations {
+		if _, err := svc.AcceptInvitation(ctx, invitation.ID, userID); err != nil {
+			t.Fatalf("Expected no error accepting, got %v", err)
+		}
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	svc, db := newTestService(t)
+	owner := createUser(t, db, "owner@example.com")
+	invitee := createUser(t, db, "invitee@example.com")
+	organization, err := svc.CreateOrganization(context.Background(), "Acme", "acme", owner.ID)
+	if err != nil {
+		t.Fatalf("Expected no error creating organization, got %v", err)
+	}
+	ctx := in(organization)
+
+	if err := svc.InviteMember(ctx, 999, owner.ID); err != nil {
+		t.Fatalf("Expected inviting an unknown user to look like success, got %v", err)
+	}
+	var count int64
+	unscoped(db).Model(&models.OrganizationInvitation{}).Count(&count)
+	if count != 0 {
+		t.Fatal("Expected no invitation for an unknown user")
+	}
+	if err := svc.InviteMember(ctx, owner.ID, owner.ID); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("Expected ErrAlreadyMember, got %v", err)
+	}
+
+	if err := svc.InviteMember(ctx, invitee.ID, owner.ID); err != nil {
+		t.Fatalf("Expected no error inviting, got %v", err)
+	}
+	if err := svc.InviteMember(ctx, invitee.ID, owner.ID); err != nil {
+		t.Fatalf("Expected inviting again to renew the invitation, got %v", err)
+	}
+	if member, _ := svc.IsMember(ctx, invitee.ID); member {
+		t.Fatal("Expected an invitation not to make the user a member")
+	}
+
+	invitations, err := svc.GetInvitations(ctx, invitee.ID)
+	if err != nil || len(invitations) != 1 || invitations[0].Organization.Name != "Acme" {
+		t.Fatalf("Expected one invitation to Acme, got %+v (%v)", invitations, err)
+	}
+
+	if _, err := svc.AcceptInvitation(ctx, invitations[0].ID, owner.ID); !errors.Is(err, ErrInvitationNotFound) {
+		t.Fatalf("Expected another user's invitation to be hidden, got %v", err)
+	}
+	joined, err := svc.AcceptInvitation(ctx, invitations[0].ID, invitee.ID)
+	if err != nil || joined.ID != organization.ID {
+		t.Fatalf("Expected to join Acme, got %+v (%v)", joined, err)
+	}
+	if member, _ := svc.IsMember(ctx, invitee.ID); !member {
+		t.Fatal("Expected accepting to make the user a member")
+	}
+	if _, err := svc.AcceptInvitation(ctx, invitations[0].ID, invitee.ID); !errors.Is(err, ErrInvitationNotFound) {
+		t.Fatalf("Expected an accepted invitation to be gone, got %v", err)
+	}
+
+	t.Run("expired and declined invitations", func(t *testing.T) {
+		late := createUser(t, db, "late@example.com")
+		svc.InviteMember(ctx, late.ID, owner.ID)
+		unscoped(db).Model(&models.OrganizationInvitation{}).Where("user_id = ?", late.ID).Update("expires_at", time.Now().Add(-time.Minute))
+
+		if invitations, _ := svc.GetInvitations(ctx, late.ID); len(invitations) != 0 {
+			t.Fatalf("Expected expired invitations to be hidden, got %+v", invitations)
+		}
+		var expired models.OrganizationInvitation
+		unscoped(db).Where("user_id = ?", late.ID).First(&expired)
+		if _, err := svc.AcceptInvitation(ctx, expired.ID, late.ID); !errors.Is(err, ErrInvitationNotFound) {
+			t.Fatalf("Expected an expired invitation to be refused, got %v", err)
+		}
+
+		if err := svc.DeclineInvitation(ctx, expired.ID, late.ID); err != nil {
+			t.Fatalf("Expected no error declining, got %v", err)
+		}
+		if err := svc.DeclineInvitation(ctx, expired.ID, late.ID); !errors.Is(err, ErrInvitationNotFound) {
+			t.Fatalf("Expected declining twice to return ErrInvitationNotFound, got %v", err)
+		}
+	})
+}
+
+func TestGetMembers(t *testing.T) {
+	svc, db := newTestService(t)
+	owner := createUser(t, db, "owner@example.com")
+	organization, _ := svc.CreateOrganization(context.Background(), "Acme", "acme", owner.ID)
+
+	members, err := svc.GetMembers(in(organization))
+	if err != nil || len(members) != 1 {
+		t.Fatalf("Expected one member, got %+v (%v)", members, err)
+	}
+	if members[0].ID != owner.ID || members[0].Name != owner.Name || len(members[0].Roles) != 1 || members[0].Roles[0].Name != permissions.AdminRoleName {
+		t.Fatalf("Expected the owner as Admin, got %+v", members[0])
+	}
+
+	body, _ := json.Marshal(members)
+	if strings.Contains(string(body), owner.Email) || strings.Contains(string(body), "permissions") {
+		t.Fatalf("Expected only the member's id, name and roles, got %s", body)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	svc, db := newTestService(t)
+	alice := createUser(t, db, "alice@example.com")
+	bob := createUser(t, db, "bob@example.com")
+	acme, _ := svc.CreateOrganization(context.Background(), "Acme", "acme", alice.ID)
+	globex, _ := svc.CreateOrganization(context.Background(), "Globex", "globex", bob.ID)
+	permissions.NewService(db).AssignRoleToUser(alice.ID, permissions.ROLE_ID_USER)
+
+	if members, _ := svc.GetMembers(in(globex)); len(members) != 1 || members[0].ID != bob.ID {
+		t.Fatalf("Expected only Globex's member, got %+v", members)
+	}
+	if member, _ := svc.IsMember(in(globex), alice.ID); member {
+		t.Fatal("Expected Acme's member not to count as a Globex member")
+	}
+
+	if err := svc.RemoveRole(in(globex), alice.ID, permissions.ROLE_ID_ADMIN); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := svc.RemoveMember(in(globex), alice.ID); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("Expected ErrNotMember from another organization, got %v", err)
+	}
+	if members, _ := svc.GetMembers(in(acme)); len(members) != 1 || len(members[0].Roles) != 1 {
+		t.Fatalf("Expected Acme's member and role to be untouched, got %+v", members)
+	}
+
+	t.Run("queries without an organization fail", func(t *testing.T) {
+		if _, err := svc.GetMembers(context.Background()); !errors.Is(err, tenancy.ErrNoTenant) {
+			t.Fatalf("Expected ErrNoTenant, got %v", err)
+		}
+		if err := svc.RemoveMember(context.Background(), alice.ID); !errors.Is(err, tenancy.ErrNoTenant) {
+			t.Fatalf("Expected ErrNoTenant, got %v", err)
+		}
+	})
+
+	t.Run("removing a member keeps their global roles", func(t *testing.T) {
+		join(t, svc, in(acme), bob.ID, alice.ID)
+		svc.AssignRole(in(acme), bob.ID, permissions.ROLE_ID_ADMIN, alice.ID)
+		if err := svc.RemoveMember(in(acme), alice.ID); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var roles []models.UserRole
+		db.Where("user_id = ?", alice.ID).Find(&roles)
+		if len(roles) != 1 || roles[0].OrganizationID != nil {
+			t.Fatalf("Expected only the global role to be left, got %+v", roles)
+		}
+	})
+}
+
+func TestLastOrganizationAdmin(t *testing.T) {
+	svc, db := newTestService(t)
+	owner := createUser(t, db, "owner@example.com")
+	member := createUser(t, db, "member@example.com")
+	organization, _ := svc.CreateOrganization(context.Background(), "Acme", "acme", owner.ID)
+	ctx := in(organization)
+	join(t, svc, ctx, member.ID, owner.ID)
+
+	if err := svc.RemoveRole(ctx, owner.ID, permissions.ROLE_ID_ADMIN); !errors.Is(err, permissions.ErrLastOrganizationAdmin) {
+		t.Fatalf("Expected ErrLastOrganizationAdmin, got %v", err)
+	}
+	if err := svc.RemoveMember(ctx, owner.ID); !errors.Is(err, permissions.ErrLastOrganizationAdmin) {
+		t.Fatalf("Expected ErrLastOrganizationAdmin, got %v", err)
+	}
+	if member, _ := svc.IsMember(ctx, owner.ID); !member {
+		t.Fatal("Expected the refused removal to be rolled back")
+	}
+
+	if err := svc.RemoveMember(ctx, member.ID); err != nil {
+		t.Fatalf("Expected removing a member without org:write to succeed, got %v", err)
+	}
+	join(t, svc, ctx, member.ID, owner.ID)
+	svc.AssignRole(ctx, member.ID, permissions.ROLE_ID_ADMIN, owner.ID)
+	if err := svc.RemoveRole(ctx, owner.ID, permissions.ROLE_ID_ADMIN); err != nil {
+		t.Fatalf("Expected no error once another member holds org:write, got %v", err)
+	}
+}
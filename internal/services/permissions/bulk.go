@@ -0,0 +1,318 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBulkRejected = errors.New("bulk operation rejected; nothing was changed")
+	ErrRoleExists   = errors.New("a role with this name already exists")
+)
+
+// Statuses of the items in a bulk operation.
+const (
+	BulkAdded       = "added"
+	BulkRemoved     = "removed"
+	BulkUnchanged   = "unchanged"
+	BulkAssigned    = "assigned"
+	BulkUpdated     = "updated"
+	BulkNotAssigned = "not_assigned"
+	BulkCopied      = "copied"
+	BulkNotFound    = "not_found"
+)
+
+// BulkResult is the outcome for one item of a bulk operation. When any item
+// is invalid the operation returns ErrBulkRejected and changes nothing; the
+// other results then describe what would have happened.
+type BulkResult struct {
+	Permission string `json:"permission,omitempty"`
+	UserID     uint   `json:"userId,omitempty"`
+	ParentRole string `json:"parentRole,omitempty"`
+	Status     string `json:"status"`
+}
+
+func loadRole(db *gorm.DB, roleID uint, preload ...string) (*models.Role, error) {
+	query := db
+	for _, association := range preload {
+		query = query.Preload(association)
+	}
+	var role models.Role
+	if err := query.First(&role, roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("failed to find role: %w", err)
+	}
+	return &role, nil
+}
+
+// SetRolePermissions replaces a role's permissions with names and returns
+// the difference: each permission added, removed or left unchanged.
+func (s *Service) SetRolePermissions(roleID uint, names []string) ([]BulkResult, error) {
+	var results []BulkResult
+	err := GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		role, err := loadRole(tx, roleID, "Permissions.Permission")
+		if err != nil {
+			return err
+		}
+
+		var found []models.Permission
+		if len(names) > 0 {
+			if err := tx.Where("name IN ?", names).Find(&found).Error; err != nil {
+				return fmt.Errorf("failed to get permissions: %w", err)
+			}
+		}
+		permissionIDs := make(map[string]uint, len(found))
+		for _, permission := range found {
+			permissionIDs[permission.Name] = permission.ID
+		}
+		current := make(map[string]uint, len(role.Permissions))
+		for _, rp := range role.Permissions {
+			current[rp.Permission.Name] = rp.ID
+		}
+
+		rejected := false
+		wanted := make(map[string]bool, len(names))
+		var added []uint
+		for _, name := range names {
+			if wanted[name] {
+				continue
+			}
+			wanted[name] = true
+
+			switch _, held := current[name]; {
+			case permissionIDs[name] == 0:
+				results = append(results, BulkResult{Permission: name, Status: BulkNotFound})
+				rejected = true
+			case held:
+				results = append(results, BulkResult{Permission: name, Status: BulkUnchanged})
+			default:
+				results = append(results, BulkResult{Permission: name, Status: BulkAdded})
+				added = append(added, permissionIDs[name])
+			}
+		}
+
+		var removed []uint
+		var removedNames []string
+		for name, id := range current {
+			if !wanted[name] {
+				removed = append(removed, id)
+				removedNames = append(removedNames, name)
+			}
+		}
+		sort.Strings(removedNames)
+		for _, name := range removedNames {
+			results = append(results, BulkResult{Permission: name, Status: BulkRemoved})
+		}
+
+		if rejected {
+			return ErrBulkRejected
+		}
+
+		now := time.Now()
+		for _, permissionID := range added {
+			rp := models.RolePermission{RoleID: role.ID, PermissionID: permissionID, CreatedAt: now, UpdatedAt: now}
+			if err := tx.Create(&rp).Error; err != nil {
+				return fmt.Errorf("failed to assign permission to role: %w", err)
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Delete(&models.RolePermission{}, removed).Error; err != nil {
+				return fmt.Errorf("failed to remove permission from role: %w", err)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// roleHolders returns which of userIDs exist and, for those that do, their
+// global assignment of the role if any.
+func roleHolders(tx *gorm.DB, roleID uint, userIDs []uint) (map[uint]bool, map[uint]models.UserRole, error) {
+	var existing []uint
+	if err := tx.Model(&models.User{}).Where("id IN ?", userIDs).Pluck("id", &existing).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	exists := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		exists[id] = true
+	}
+
+	var userRoles []models.UserRole
+	err := tx.Where("role_id = ? AND organization_id IS NULL AND user_id IN ?", roleID, userIDs).Find(&userRoles).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	held := make(map[uint]models.UserRole, len(userRoles))
+	for _, userRole := range userRoles {
+		held[userRole.UserID] = userRole
+	}
+	return exists, held, nil
+}
+
+func uniqueUserIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// AssignRoleToUsers grants a global role to every user with the same
+// validity window, following GrantRole for users who already hold it.
+func (s *Service) AssignRoleToUsers(roleID uint, userIDs []uint, grant RoleGrant) ([]BulkResult, error) {
+	if err := grant.validate(); err != nil {
+		return nil, err
+	}
+	userIDs = uniqueUserIDs(userIDs)
+
+	var results []BulkResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := loadRole(tx, roleID); err != nil {
+			return err
+		}
+		exists, held, err := roleHolders(tx, roleID, userIDs)
+		if err != nil {
+			return err
+		}
+
+		rejected := false
+		for _, userID := range userIDs {
+			userRole, ok := held[userID]
+			switch {
+			case !exists[userID]:
+				results = append(results, BulkResult{UserID: userID, Status: BulkNotFound})
+				rejected = true
+			case ok && !supersedes(userRole, grant):
+				results = append(results, BulkResult{UserID: userID, Status: BulkUnchanged})
+			case ok:
+				results = append(results, BulkResult{UserID: userID, Status: BulkUpdated})
+			default:
+				results = append(results, BulkResult{UserID: userID, Status: BulkAssigned})
+			}
+		}
+		if rejected {
+			return ErrBulkRejected
+		}
+
+		for _, result := range results {
+			if result.Status == BulkUnchanged {
+				continue
+			}
+			if _, err := grantRole(tx, result.UserID, roleID, grant); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// RemoveRoleFromUsers removes a global role from every user.
+func (s *Service) RemoveRoleFromUsers(roleID uint, userIDs []uint) ([]BulkResult, error) {
+	userIDs = uniqueUserIDs(userIDs)
+
+	var results []BulkResult
+	err := GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		if _, err := loadRole(tx, roleID); err != nil {
+			return err
+		}
+		exists, held, err := roleHolders(tx, roleID, userIDs)
+		if err != nil {
+			return err
+		}
+
+		rejected := false
+		var removed []uint
+		for _, userID := range userIDs {
+			userRole, ok := held[userID]
+			switch {
+			case !exists[userID]:
+				results = append(results, BulkResult{UserID: userID, Status: BulkNotFound})
+				rejected = true
+			case ok:
+				results = append(results, BulkResult{UserID: userID, Status: BulkRemoved})
+				removed = append(removed, userRole.ID)
+			default:
+				results = append(results, BulkResult{UserID: userID, Status: BulkNotAssigned})
+			}
+		}
+		if rejected {
+			return ErrBulkRejected
+		}
+
+		if len(removed) > 0 {
+			if err := tx.Delete(&models.UserRole{}, removed).Error; err != nil {
+				return fmt.Errorf("failed to remove role from users: %w", err)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// CloneRole creates a role with the permissions, parent roles and MFA
+// requirement of another. Users are not copied and the clone is never a
+// system role. An empty description copies the source's.
+func (s *Service) CloneRole(sourceID uint, name, description string) (*models.Role, []BulkResult, error) {
+	var clone models.Role
+	var results []BulkResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		source, err := loadRole(tx, sourceID, "Permissions.Permission", "Parents.Parent")
+		if err != nil {
+			return err
+		}
+
+		var taken int64
+		if err := tx.Model(&models.Role{}).Where("name = ?", name).Count(&taken).Error; err != nil {
+			return fmt.Errorf("failed to check role name: %w", err)
+		}
+		if taken > 0 {
+			return ErrRoleExists
+		}
+
+		if description == "" {
+			description = source.Description
+		}
+		now := time.Now()
+		clone = models.Role{Name: name, Description: description, RequiresMFA: source.RequiresMFA, CreatedAt: now, UpdatedAt: now}
+		if err := tx.Create(&clone).Error; err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+
+		for _, rp := range source.Permissions {
+			copied := models.RolePermission{RoleID: clone.ID, PermissionID: rp.PermissionID, CreatedAt: now, UpdatedAt: now}
+			if err := tx.Create(&copied).Error; err != nil {
+				return fmt.Errorf("failed to copy permission: %w", err)
+			}
+			results = append(results, BulkResult{Permission: rp.Permission.Name, Status: BulkCopied})
+		}
+		for _, parent := range source.Parents {
+			edge := models.RoleInheritance{RoleID: clone.ID, ParentRoleID: parent.ParentRoleID, CreatedAt: now}
+			if err := tx.Create(&edge).Error; err != nil {
+				return fmt.Errorf("failed to copy parent role: %w", err)
+			}
+			results = append(results, BulkResult{ParentRole: parent.Parent.Name, Status: BulkCopied})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	role, err := s.GetRoleByID(clone.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return role, results, nil
+}
@@ -0,0 +1,167 @@
+package permissions
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+func statuses(results []BulkResult) map[string]string {
+	got := make(map[string]string, len(results))
+	for _, result := range results {
+		key := result.Permission + result.ParentRole
+		if result.UserID != 0 {
+			key = strconv.FormatUint(uint64(result.UserID), 10)
+		}
+		got[key] = result.Status
+	}
+	return got
+}
+
+func rolePermissionNames(t *testing.T, svc *Service, roleID uint) []string {
+	t.Helper()
+	role, err := svc.GetRoleByID(roleID)
+	if err != nil || role == nil {
+		t.Fatalf("Expected role %d, got %v", roleID, err)
+	}
+	var names []string
+	for _, rp := range role.Permissions {
+		names = append(names, rp.Permission.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestSetRolePermissions(t *testing.T) {
+	svc, _ := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	results, err := svc.SetRolePermissions(ROLE_ID_TEAM_ACCOUNT, []string{PermissionUserRead, PermissionAuditRead, PermissionAuditRead, "nope:read"})
+	if !errors.Is(err, ErrBulkRejected) {
+		t.Fatalf("Expected ErrBulkRejected, got %v", err)
+	}
+	if got := statuses(results); got["nope:read"] != BulkNotFound || got[PermissionAuditRead] != BulkAdded || len(results) != 5 {
+		t.Fatalf("Expected the unknown permission to be reported, got %+v", results)
+	}
+	if names := rolePermissionNames(t, svc, ROLE_ID_TEAM_ACCOUNT); !slices.Equal(names, []string{PermissionOrgRead, PermissionReportWrite, PermissionUserRead}) {
+		t.Fatalf("Expected a rejected update to change nothing, got %v", names)
+	}
+
+	results, err = svc.SetRolePermissions(ROLE_ID_TEAM_ACCOUNT, []string{PermissionUserRead, PermissionAuditRead})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := map[string]string{
+		PermissionUserRead:    BulkUnchanged,
+		PermissionAuditRead:   BulkAdded,
+		PermissionOrgRead:     BulkRemoved,
+		PermissionReportWrite: BulkRemoved,
+	}
+	if got := statuses(results); len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	} else {
+		for name, status := range want {
+			if got[name] != status {
+				t.Fatalf("Expected %s to be %s, got %v", name, status, got)
+			}
+		}
+	}
+	if names := rolePermissionNames(t, svc, ROLE_ID_TEAM_ACCOUNT); !slices.Equal(names, []string{PermissionAuditRead, PermissionUserRead}) {
+		t.Fatalf("Expected the role's permissions to be replaced, got %v", names)
+	}
+
+	if _, err := svc.SetRolePermissions(999, nil); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound, got %v", err)
+	}
+}
+
+func TestBulkRoleUsers(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+	for _, name := range []string{"one", "two", "three"} {
+		db.Create(&models.User{Name: name, Email: name + "@example.com", Password: "x", IsActive: true})
+	}
+	svc.AssignRoleToUser(1, ROLE_ID_USER)
+	soon := time.Now().Add(time.Hour)
+	svc.GrantRole(2, ROLE_ID_USER, RoleGrant{ValidUntil: &soon})
+
+	results, err := svc.AssignRoleToUsers(ROLE_ID_USER, []uint{1, 2, 3, 9}, RoleGrant{AssignedBy: 1})
+	if !errors.Is(err, ErrBulkRejected) || statuses(results)["9"] != BulkNotFound {
+		t.Fatalf("Expected the unknown user to reject the batch, got %+v (%v)", results, err)
+	}
+	var count int64
+	db.Model(&models.UserRole{}).Where("user_id = ?", 3).Count(&count)
+	if count != 0 {
+		t.Fatal("Expected a rejected batch to assign nothing")
+	}
+
+	results, err = svc.AssignRoleToUsers(ROLE_ID_USER, []uint{1, 2, 3, 3}, RoleGrant{AssignedBy: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := statuses(results)
+	if len(results) != 3 || got["1"] != BulkUnchanged || got["2"] != BulkUpdated || got["3"] != BulkAssigned {
+		t.Fatalf("Expected unchanged, updated and assigned, got %+v", results)
+	}
+	var userRole models.UserRole
+	db.Where("user_id = ? AND role_id = ?", 2, ROLE_ID_USER).First(&userRole)
+	if userRole.ValidUntil != nil {
+		t.Fatalf("Expected the time-bound assignment to become permanent, got %+v", userRole)
+	}
+
+	if _, err := svc.AssignRoleToUsers(ROLE_ID_USER, []uint{1}, RoleGrant{ValidUntil: &time.Time{}}); !errors.Is(err, ErrInvalidValidity) {
+		t.Fatalf("Expected ErrInvalidValidity, got %v", err)
+	}
+
+	results, err = svc.RemoveRoleFromUsers(ROLE_ID_ADMIN, []uint{1, 2})
+	if err != nil || statuses(results)["1"] != BulkNotAssigned {
+		t.Fatalf("Expected not_assigned, got %+v (%v)", results, err)
+	}
+	results, err = svc.RemoveRoleFromUsers(ROLE_ID_USER, []uint{1, 3})
+	if err != nil || statuses(results)["3"] != BulkRemoved {
+		t.Fatalf("Expected removed, got %+v (%v)", results, err)
+	}
+	db.Model(&models.UserRole{}).Where("role_id = ?", ROLE_ID_USER).Count(&count)
+	if count != 1 {
+		t.Fatalf("Expected only user 2 to keep the role, got %d", count)
+	}
+}
+
+func TestCloneRole(t *testing.T) {
+	svc, _ := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	if _, _, err := svc.CloneRole(ROLE_ID_ADMIN, TeamAccountRoleName, ""); !errors.Is(err, ErrRoleExists) {
+		t.Fatalf("Expected ErrRoleExists, got %v", err)
+	}
+	if _, _, err := svc.CloneRole(999, "Copy", ""); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound, got %v", err)
+	}
+
+	clone, results, err := svc.CloneRole(ROLE_ID_ADMIN, "Deputy Admin", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if clone.IsSystem || clone.Description == "" {
+		t.Fatalf("Expected a non-system role with the source's description, got %+v", clone)
+	}
+	if names := rolePermissionNames(t, svc, clone.ID); !slices.Equal(names, rolePermissionNames(t, svc, ROLE_ID_ADMIN)) {
+		t.Fatalf("Expected the clone to have Admin's permissions, got %v", names)
+	}
+	if statuses(results)[TeamAccountRoleName] != BulkCopied {
+		t.Fatalf("Expected the parent role to be copied, got %+v", results)
+	}
+	if !slices.Contains(clone.InheritedPermissions, PermissionReportRead) {
+		t.Fatalf("Expected inherited permissions on the clone, got %v", clone.InheritedPermissions)
+	}
+}
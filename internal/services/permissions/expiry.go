@@ -0,0 +1,186 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"gorm.io/gorm"
+)
+
+type Config struct {
+	ExpiryIntervalSecs int `envconfig:"ROLE_EXPIRY_INTERVAL_SEC" default:"300"` // 5 minutes default
+	ExpiryNoticeHours  int `envconfig:"ROLE_EXPIRY_NOTICE_HOURS" default:"72"`
+}
+
+var (
+	ErrRoleAlreadyAssigned = errors.New("user already has this role")
+	ErrInvalidValidity     = errors.New("role assignment must end after it starts")
+)
+
+// RoleGrant describes a role assignment: who made it and when it applies. A
+// nil ValidFrom takes effect immediately and a nil ValidUntil never expires.
+type RoleGrant struct {
+	AssignedBy uint
+	ValidFrom  *time.Time
+	ValidUntil *time.Time
+}
+
+func (g RoleGrant) permanent() bool {
+	return g.ValidFrom == nil && g.ValidUntil == nil
+}
+
+// GrantRole assigns a global role. Granting a role the user already holds
+// replaces its validity window, except that a permanent grant is never
+// narrowed: see supersedes.
+func (s *Service) GrantRole(userID, roleID uint, grant RoleGrant) error {
+	if err := grant.validate(); err != nil {
+		return err
+	}
+	_, err := grantRole(s.db, userID, roleID, grant)
+	return err
+}
+
+func (g RoleGrant) validate() error {
+	if g.ValidUntil != nil {
+		start := time.Now()
+		if g.ValidFrom != nil && g.ValidFrom.After(start) {
+			start = *g.ValidFrom
+		}
+		if !g.ValidUntil.After(start) {
+			return ErrInvalidValidity
+		}
+	}
+	return nil
+}
+
+// supersedes reports whether grant replaces the existing assignment. A
+// time-bound grant never narrows a permanent one, so approving a temporary
+// access request or a bulk assignment with an end date leaves it alone.
+// Assigning a role the identity provider granted permanently makes it manual,
+// so it is kept when the user later leaves the mapped group.
+func supersedes(existing models.UserRole, grant RoleGrant) bool {
+	current := RoleGrant{ValidFrom: existing.ValidFrom, ValidUntil: existing.ValidUntil}
+	if !current.permanent() {
+		return true
+	}
+	return grant.permanent() && existing.Source != RoleSourceManual
+}
+
+// grantRole assigns or updates a global role and reports whether the user
+// already held it.
+func grantRole(db *gorm.DB, userID, roleID uint, grant RoleGrant) (bool, error) {
+	var existing models.UserRole
+	err := db.Where("user_id = ? AND role_id = ? AND organization_id IS NULL", userID, roleID).First(&existing).Error
+	if err == nil {
+		if !supersedes(existing, grant) {
+			return true, ErrRoleAlreadyAssigned
+		}
+		err := db.Model(&existing).Updates(map[string]interface{}{
+			"source":             RoleSourceManual,
+			"assigned_by":        grant.AssignedBy,
+			"valid_from":         grant.ValidFrom,
+			"valid_until":        grant.ValidUntil,
+			"expiry_notified_at": nil,
+			"updated_at":         time.Now(),
+		}).Error
+		if err != nil {
+			return true, fmt.Errorf("failed to assign role to user: %w", err)
+		}
+		return true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, fmt.Errorf("failed to check existing user role: %w", err)
+	}
+
+	userRole := models.UserRole{
+		UserID:     userID,
+		RoleID:     roleID,
+		AssignedBy: grant.AssignedBy,
+		Source:     RoleSourceManual,
+		ValidFrom:  grant.ValidFrom,
+		ValidUntil: grant.ValidUntil,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if err := db.Create(&userRole).Error; err != nil {
+		return false, fmt.Errorf("failed to assign role to user: %w", err)
+	}
+	return false, nil
+}
+
+// ExpiryNotifier warns a user that their role assignment is about to end.
+// UserRole.User and UserRole.Role are preloaded.
+type ExpiryNotifier func(ctx context.Context, userRole models.UserRole) error
+
+// ExpireRoleAssignments returns a job that notifies users about assignments
+// ending within notice, once each, and deletes assignments that have ended.
+// Deletions go through GuardLastAdmin, in the assignment's organization for
+// organization roles, and are recorded in the audit log.
+func (s *Service) ExpireRoleAssignments(notice time.Duration, notify ExpiryNotifier, auditSvc *audit.Service) func(context.Context) error {
+	return func(ctx context.Context) error {
+		db := s.db.WithContext(ctx)
+		now := time.Now()
+		var errs []error
+
+		var expiring []models.UserRole
+		err := db.Preload("User").Preload("Role").
+			Where("valid_until > ? AND valid_until <= ? AND expiry_notified_at IS NULL", now, now.Add(notice)).
+			Find(&expiring).Error
+		if err != nil {
+			return fmt.Errorf("failed to find expiring roles: %w", err)
+		}
+		for _, userRole := range expiring {
+			// Deleted users are not preloaded and have no one to notify.
+			if userRole.User.ID != 0 {
+				if err := notify(ctx, userRole); err != nil {
+					errs = append(errs, fmt.Errorf("failed to notify user %d: %w", userRole.UserID, err))
+					continue
+				}
+			}
+			if err := db.Model(&models.UserRole{}).Where("id = ?", userRole.ID).Update("expiry_notified_at", now).Error; err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		var expired []models.UserRole
+		if err := db.Preload("Role").Where("valid_until <= ?", now).Find(&expired).Error; err != nil {
+			return errors.Join(append(errs, fmt.Errorf("failed to find expired roles: %w", err))...)
+		}
+		for _, userRole := range expired {
+			guarded := db
+			if userRole.OrganizationID != nil {
+				guarded = db.WithContext(tenancy.WithOrganization(ctx, *userRole.OrganizationID))
+			}
+			err := GuardLastAdmin(guarded, func(tx *gorm.DB) error {
+				return tx.Delete(&models.UserRole{}, userRole.ID).Error
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove expired role: %w", err))
+				continue
+			}
+
+			userID := userRole.UserID
+			entry := &models.AuditLog{
+				UserID:     &userID,
+				Action:     audit.ActionRoleExpired,
+				Resource:   audit.ResourceUserRole,
+				ResourceID: userRole.Role.Name,
+				Details: map[string]interface{}{
+					"role":           userRole.Role.Name,
+					"validUntil":     userRole.ValidUntil,
+					"organizationId": userRole.OrganizationID,
+				},
+			}
+			if err := auditSvc.Record(ctx, entry); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,157 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+)
+
+func TestGrantRoleValidity(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	past, soon, later := time.Now().Add(-time.Hour), time.Now().Add(time.Hour), time.Now().Add(2*time.Hour)
+	if err := svc.GrantRole(1, ROLE_ID_USER, RoleGrant{ValidUntil: &past}); !errors.Is(err, ErrInvalidValidity) {
+		t.Fatalf("Expected ErrInvalidValidity for a past end, got %v", err)
+	}
+	if err := svc.GrantRole(1, ROLE_ID_USER, RoleGrant{ValidFrom: &later, ValidUntil: &soon}); !errors.Is(err, ErrInvalidValidity) {
+		t.Fatalf("Expected ErrInvalidValidity for an end before the start, got %v", err)
+	}
+
+	if err := svc.GrantRole(1, ROLE_ID_TEAM_ACCOUNT, RoleGrant{AssignedBy: 9, ValidFrom: &soon}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if perms, _ := svc.GetUserPermissions(1); len(perms) != 0 {
+		t.Fatalf("Expected a scheduled role to grant nothing yet, got %v", perms)
+	}
+
+	// Granting again replaces the window.
+	if err := svc.GrantRole(1, ROLE_ID_TEAM_ACCOUNT, RoleGrant{AssignedBy: 9, ValidUntil: &soon}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if perms, _ := svc.GetUserPermissions(1); !slices.Contains(perms, PermissionReportWrite) {
+		t.Fatalf("Expected the role to be active, got %v", perms)
+	}
+	var userRole models.UserRole
+	db.Where("user_id = ? AND role_id = ?", 1, ROLE_ID_TEAM_ACCOUNT).First(&userRole)
+	if userRole.AssignedBy != 9 || userRole.ValidFrom != nil || userRole.ValidUntil == nil {
+		t.Fatalf("Expected the assignment to be updated, got %+v", userRole)
+	}
+
+	if err := svc.AssignRoleToUser(1, ROLE_ID_USER); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := svc.AssignRoleToUser(1, ROLE_ID_USER); !errors.Is(err, ErrRoleAlreadyAssigned) {
+		t.Fatalf("Expected ErrRoleAlreadyAssigned, got %v", err)
+	}
+
+	// A time-bound grant leaves a permanent one permanent.
+	if err := svc.GrantRole(1, ROLE_ID_USER, RoleGrant{AssignedBy: 9, ValidUntil: &soon}); !errors.Is(err, ErrRoleAlreadyAssigned) {
+		t.Fatalf("Expected ErrRoleAlreadyAssigned, got %v", err)
+	}
+	db.Create(&models.User{ID: 1, Name: "Test User", Email: "user@example.com", Password: "x"})
+	results, err := svc.AssignRoleToUsers(ROLE_ID_USER, []uint{1}, RoleGrant{AssignedBy: 9, ValidUntil: &soon})
+	if err != nil || results[0].Status != BulkUnchanged {
+		t.Fatalf("Expected the bulk assignment to leave the role unchanged, got %+v (%v)", results, err)
+	}
+	var permanent models.UserRole
+	db.Where("user_id = ? AND role_id = ?", 1, ROLE_ID_USER).First(&permanent)
+	if permanent.ValidUntil != nil || permanent.AssignedBy == 9 {
+		t.Fatalf("Expected the permanent assignment to be kept, got %+v", permanent)
+	}
+
+	// Roles outside their window are not listed.
+	if err := svc.GrantRole(1, ROLE_ID_ADMIN, RoleGrant{ValidFrom: &soon}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	roles, _ := svc.GetUserRoles(1)
+	for _, role := range roles {
+		if role.ID == ROLE_ID_ADMIN {
+			t.Fatal("Expected a scheduled role not to be listed yet")
+		}
+	}
+	if len(roles) != 2 {
+		t.Fatalf("Expected the two active roles, got %+v", roles)
+	}
+}
+
+func TestReconcileExternalRolesValidity(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	past, soon := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_TEAM_ACCOUNT, Source: RoleSourceSSO, ValidUntil: &past})
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_USER, ValidFrom: &soon})
+
+	changes, err := svc.ReconcileExternalRoles(1, RoleSourceSSO, []string{TeamAccountRoleName, UserRoleName})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(changes.Added) != 2 || len(changes.Removed) != 0 {
+		t.Fatalf("Expected both roles to be granted, got %+v", changes)
+	}
+	if perms, _ := svc.GetUserPermissions(1); !slices.Contains(perms, PermissionReportWrite) || !slices.Contains(perms, PermissionReportRead) {
+		t.Fatalf("Expected the mapped roles to be in effect, got %v", perms)
+	}
+
+	var expired, scheduled int64
+	db.Model(&models.UserRole{}).Where("user_id = ? AND valid_until IS NOT NULL", 1).Count(&expired)
+	db.Model(&models.UserRole{}).Where("user_id = ? AND valid_from IS NOT NULL", 1).Count(&scheduled)
+	if expired != 0 || scheduled != 1 {
+		t.Fatalf("Expected the ended grant to be replaced and the scheduled one kept, got %d ended and %d scheduled", expired, scheduled)
+	}
+
+	if changes, _ := svc.ReconcileExternalRoles(1, RoleSourceSSO, []string{TeamAccountRoleName, UserRoleName}); !changes.Empty() {
+		t.Fatalf("Expected a second sync to change nothing, got %+v", changes)
+	}
+}
+
+func TestExpireRoleAssignments(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := db.AutoMigrate(&models.User{}, &models.AuditLog{}); err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	svc.SeedDefaultData()
+	db.Create(&models.User{ID: 1, Name: "Ann", Email: "ann@example.com", Password: "x"})
+
+	expired, expiring, distant := time.Now().Add(-time.Minute), time.Now().Add(time.Hour), time.Now().Add(30*24*time.Hour)
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_ADMIN, ValidUntil: &expired})
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_TEAM_ACCOUNT, ValidUntil: &expiring})
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_USER, ValidUntil: &distant})
+
+	var notified []string
+	notify := func(ctx context.Context, userRole models.UserRole) error {
+		notified = append(notified, userRole.User.Email+" "+userRole.Role.Name)
+		return nil
+	}
+	job := svc.ExpireRoleAssignments(24*time.Hour, notify, audit.NewService(db))
+
+	for i := 0; i < 2; i++ {
+		if err := job(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if len(notified) != 1 || notified[0] != "ann@example.com "+TeamAccountRoleName {
+		t.Fatalf("Expected one notice for the expiring role, got %v", notified)
+	}
+
+	var remaining []models.UserRole
+	db.Order("role_id").Find(&remaining)
+	if len(remaining) != 2 || remaining[0].RoleID != ROLE_ID_TEAM_ACCOUNT {
+		t.Fatalf("Expected the expired role to be removed, got %+v", remaining)
+	}
+
+	var entry models.AuditLog
+	if err := db.Where("action = ?", audit.ActionRoleExpired).First(&entry).Error; err != nil || entry.ResourceID != AdminRoleName {
+		t.Fatalf("Expected an audit entry for the expired role, got %+v (%v)", entry, err)
+	}
+}
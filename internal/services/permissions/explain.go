@@ -0,0 +1,248 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// How a grant matched the permission being explained.
+const (
+	MatchExact       = "exact"
+	MatchWildcard    = "wildcard"
+	MatchSystemAdmin = "system_admin"
+)
+
+// GrantPath is one way a role leads to a permission.
+type GrantPath struct {
+	RoleID uint   `json:"roleId"`
+	Role   string `json:"role"`
+	// Via is the inheritance chain from Role to the role holding the grant,
+	// nearest first. It is empty when Role holds the grant itself.
+	Via []string `json:"via,omitempty"`
+	// Permission is the grant that matched, e.g. "role:*" for "role:write".
+	Permission string `json:"permission"`
+	Match      string `json:"match"`
+
+	// The fields below describe the user's assignment of Role.
+	OrganizationID *uint      `json:"organizationId,omitempty"`
+	Active         bool       `json:"active"`
+	ValidFrom      *time.Time `json:"validFrom,omitempty"`
+	ValidUntil     *time.Time `json:"validUntil,omitempty"`
+}
+
+// Explanation lists why a user does or does not have a permission.
+type Explanation struct {
+	UserID         uint   `json:"userId"`
+	Permission     string `json:"permission"`
+	OrganizationID *uint  `json:"organizationId,omitempty"`
+	Allowed        bool   `json:"allowed"`
+	// Paths come from the user's own role assignments, including ones that
+	// are expired or not yet valid.
+	Paths []GrantPath `json:"paths"`
+	// Candidates are roles the user does not hold that would grant it.
+	Candidates []GrantPath `json:"candidates"`
+	// Conditions can allow the action without the permission, such as
+	// users reading their own account.
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+// Holder is a user who has a permission and the roles that grant it.
+type Holder struct {
+	User  models.User `json:"user"`
+	Roles []string    `json:"roles"`
+}
+
+func matchKind(granted, required string) string {
+	switch {
+	case granted == required:
+		return MatchExact
+	case MatchPermission(granted, required):
+		return MatchWildcard
+	case granted == PermissionSystemAdmin:
+		return MatchSystemAdmin
+	}
+	return ""
+}
+
+// grantPaths returns, for every role, each way it leads to permission
+// directly or through inheritance.
+func grantPaths(db *gorm.DB, permission string) (map[uint][]GrantPath, error) {
+	var roles []models.Role
+	if err := db.Preload("Permissions.Permission").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	graph, err := loadInheritanceGraph(db)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Role, len(roles))
+	for i := range roles {
+		byID[roles[i].ID] = &roles[i]
+	}
+
+	paths := make(map[uint][]GrantPath)
+	for _, role := range roles {
+		// Walk the inheritance graph breadth first, remembering the chain
+		// that reached each ancestor.
+		via := map[uint][]string{role.ID: nil}
+		queue := []uint{role.ID}
+		for len(queue) > 0 {
+			current := byID[queue[0]]
+			queue = queue[1:]
+			if current == nil {
+				continue
+			}
+			for _, rp := range current.Permissions {
+				if match := matchKind(rp.Permission.Name, permission); match != "" {
+					paths[role.ID] = append(paths[role.ID], GrantPath{
+						RoleID:     role.ID,
+						Role:       role.Name,
+						Via:        via[current.ID],
+						Permission: rp.Permission.Name,
+						Match:      match,
+					})
+				}
+			}
+			for _, parentID := range graph[current.ID] {
+				if _, seen := via[parentID]; seen || byID[parentID] == nil {
+					continue
+				}
+				chain := append(append([]string{}, via[current.ID]...), byID[parentID].Name)
+				via[parentID] = chain
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Explain lists every path by which the user's roles grant permission, in
+// the organization when one is given, and the roles that would grant it.
+func (s *Service) Explain(userID uint, permission string, organizationID *uint) (*Explanation, error) {
+	var user models.User
+	if err := s.db.Select("id").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	paths, err := grantPaths(s.db, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("user_id = ?", userID)
+	if organizationID != nil {
+		query = query.Where("organization_id IS NULL OR organization_id = ?", *organizationID)
+	} else {
+		query = query.Where("organization_id IS NULL")
+	}
+	var userRoles []models.UserRole
+	if err := query.Order("id").Find(&userRoles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	explanation := &Explanation{
+		UserID:         userID,
+		Permission:     permission,
+		OrganizationID: organizationID,
+		Paths:          []GrantPath{},
+		Candidates:     []GrantPath{},
+	}
+
+	now := time.Now()
+	held := make(map[uint]bool)
+	for _, userRole := range userRoles {
+		held[userRole.RoleID] = true
+		active := userRole.ActiveAt(now)
+		for _, path := range paths[userRole.RoleID] {
+			path.OrganizationID = userRole.OrganizationID
+			path.Active = active
+			path.ValidFrom = userRole.ValidFrom
+			path.ValidUntil = userRole.ValidUntil
+			explanation.Paths = append(explanation.Paths, path)
+			explanation.Allowed = explanation.Allowed || active
+		}
+	}
+
+	roleIDs := make([]uint, 0, len(paths))
+	for roleID := range paths {
+		if !held[roleID] {
+			roleIDs = append(roleIDs, roleID)
+		}
+	}
+	sort.Slice(roleIDs, func(i, j int) bool { return roleIDs[i] < roleIDs[j] })
+	for _, roleID := range roleIDs {
+		explanation.Candidates = append(explanation.Candidates, paths[roleID]...)
+	}
+
+	for _, condition := range DefaultAuthorizer.Policy(permission).Conditions {
+		explanation.Conditions = append(explanation.Conditions, condition.Name)
+	}
+	return explanation, nil
+}
+
+// WhoCan lists the users whose active roles grant permission globally, or in
+// the organization when one is given.
+func (s *Service) WhoCan(permission string, organizationID *uint) ([]Holder, error) {
+	paths, err := grantPaths(s.db, permission)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return []Holder{}, nil
+	}
+
+	roleIDs := make([]uint, 0, len(paths))
+	for roleID := range paths {
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	query := activeUserRoles(s.db.Select("user_roles.*").Preload("User").Preload("Role"), time.Now()).
+		Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+		Where("user_roles.role_id IN ?", roleIDs)
+	if organizationID != nil {
+		query = query.Where("user_roles.organization_id IS NULL OR user_roles.organization_id = ?", *organizationID)
+	} else {
+		query = query.Where("user_roles.organization_id IS NULL")
+	}
+	var userRoles []models.UserRole
+	if err := query.Order("user_roles.user_id").Find(&userRoles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get role holders: %w", err)
+	}
+
+	holders := []Holder{}
+	index := make(map[uint]int)
+	for _, userRole := range userRoles {
+		i, ok := index[userRole.UserID]
+		if !ok {
+			i = len(holders)
+			index[userRole.UserID] = i
+			holders = append(holders, Holder{User: userRole.User})
+		}
+		holders[i].Roles = append(holders[i].Roles, userRole.Role.Name)
+	}
+	for i := range holders {
+		// A role held globally and in the organization is listed once.
+		sort.Strings(holders[i].Roles)
+		holders[i].Roles = slices.Compact(holders[i].Roles)
+	}
+	return holders, nil
+}
+
+// activeUserRoles limits a user_roles query to assignments in effect at now.
+func activeUserRoles(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.
+		Where("user_roles.valid_from IS NULL OR user_roles.valid_from <= ?", now).
+		Where("user_roles.valid_until IS NULL OR user_roles.valid_until > ?", now)
+}
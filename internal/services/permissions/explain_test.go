@@ -0,0 +1,124 @@
+package permissions
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted string
+		want    bool
+	}{
+		{"role:write", true},
+		{"role:*", true},
+		{"*:write", true},
+		{"*:*", true},
+		{"role:read", false},
+		{"user:*", false},
+		{"*", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPermission(tt.granted, PermissionRoleWrite); got != tt.want {
+			t.Errorf("MatchPermission(%q, role:write) = %v, want %v", tt.granted, got, tt.want)
+		}
+	}
+	if !HasPermission([]string{"report:*"}, PermissionReportWrite) {
+		t.Fatal("Expected a wildcard grant to satisfy HasPermission")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	alice := models.User{Name: "Alice", Email: "alice@example.com", Password: "x"}
+	bob := models.User{Name: "Bob", Email: "bob@example.com", Password: "x"}
+	gone := models.User{Name: "Gone", Email: "gone@example.com", Password: "x"}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&gone)
+
+	// A role granted report:* reaches report:read by wildcard.
+	auditor, _ := svc.CreateRole("Auditor", "")
+	wildcard := models.Permission{Name: "report:*"}
+	db.Create(&wildcard)
+	if err := svc.AssignPermissionToRole(auditor.ID, wildcard.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	earlier := past.Add(-time.Hour)
+	svc.AssignRoleToUser(alice.ID, ROLE_ID_ADMIN)
+	svc.AssignRoleToUser(bob.ID, auditor.ID)
+	db.Create(&models.UserRole{UserID: bob.ID, RoleID: ROLE_ID_USER, ValidFrom: &earlier, ValidUntil: &past})
+	svc.AssignRoleToUser(gone.ID, ROLE_ID_USER)
+	db.Delete(&gone)
+
+	explanation, err := svc.Explain(alice.ID, PermissionReportRead, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !explanation.Allowed || len(explanation.Paths) != 1 {
+		t.Fatalf("Expected one path for Alice, got %+v", explanation)
+	}
+	if path := explanation.Paths[0]; !slices.Equal(path.Via, []string{TeamAccountRoleName, UserRoleName}) || path.Match != MatchExact {
+		t.Fatalf("Expected report:read through Team Account and User, got %+v", path)
+	}
+
+	explanation, _ = svc.Explain(bob.ID, PermissionReportRead, nil)
+	if !explanation.Allowed || len(explanation.Paths) != 2 {
+		t.Fatalf("Expected the wildcard and the expired role for Bob, got %+v", explanation.Paths)
+	}
+	for _, path := range explanation.Paths {
+		if path.RoleID == auditor.ID && (!path.Active || path.Match != MatchWildcard) {
+			t.Fatalf("Expected an active wildcard path, got %+v", path)
+		}
+		if path.RoleID == ROLE_ID_USER && path.Active {
+			t.Fatalf("Expected the expired assignment to be inactive, got %+v", path)
+		}
+	}
+
+	explanation, _ = svc.Explain(bob.ID, PermissionRoleWrite, nil)
+	if explanation.Allowed || len(explanation.Paths) != 0 {
+		t.Fatalf("Expected Bob not to have role:write, got %+v", explanation)
+	}
+	var bypass bool
+	for _, path := range explanation.Candidates {
+		if path.RoleID == ROLE_ID_SUPER_ADMIN && path.Match == MatchExact {
+			bypass = true
+		}
+	}
+	if !bypass {
+		t.Fatalf("Expected Super Admin as a candidate, got %+v", explanation.Candidates)
+	}
+
+	explanation, _ = svc.Explain(alice.ID, PermissionUserRead, nil)
+	if !slices.Contains(explanation.Conditions, ConditionOwner.Name) {
+		t.Fatalf("Expected the owner condition, got %v", explanation.Conditions)
+	}
+
+	if _, err := svc.Explain(999, PermissionReportRead, nil); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	holders, err := svc.WhoCan(PermissionReportRead, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(holders) != 2 || holders[0].User.ID != alice.ID || holders[1].User.ID != bob.ID {
+		t.Fatalf("Expected Alice and Bob, got %+v", holders)
+	}
+	if !slices.Equal(holders[1].Roles, []string{"Auditor"}) {
+		t.Fatalf("Expected Bob's expired role to be left out, got %v", holders[1].Roles)
+	}
+}
@@ -0,0 +1,160 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInheritanceCycle = errors.New("role inheritance would create a cycle")
+	ErrAlreadyInherited = errors.New("role already inherits from this role")
+)
+
+// inheritanceGraph maps each role to the roles it inherits from directly.
+type inheritanceGraph map[uint][]uint
+
+func loadInheritanceGraph(db *gorm.DB) (inheritanceGraph, error) {
+	var edges []models.RoleInheritance
+	if err := db.Find(&edges).Error; err != nil {
+		return nil, fmt.Errorf("failed to get role inheritance: %w", err)
+	}
+
+	graph := make(inheritanceGraph, len(edges))
+	for _, edge := range edges {
+		graph[edge.RoleID] = append(graph[edge.RoleID], edge.ParentRoleID)
+	}
+	return graph, nil
+}
+
+// ancestors returns every role id inherits from, nearest first. Each role is
+// visited once, so a cycle already in the database cannot loop forever.
+func (g inheritanceGraph) ancestors(id uint) []uint {
+	seen := map[uint]bool{id: true}
+	queue := slices.Clone(g[id])
+	var result []uint
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		result = append(result, next)
+		queue = append(queue, g[next]...)
+	}
+	return result
+}
+
+// AddParentRole makes roleID inherit the permissions of parentID. It fails
+// with ErrInheritanceCycle when parentID already inherits from roleID.
+func (s *Service) AddParentRole(roleID, parentID uint) error {
+	if roleID == parentID {
+		return ErrInheritanceCycle
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Role{}).Where("id IN ?", []uint{roleID, parentID}).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to find roles: %w", err)
+		}
+		if count != 2 {
+			return ErrRoleNotFound
+		}
+
+		graph, err := loadInheritanceGraph(tx)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(graph[roleID], parentID) {
+			return ErrAlreadyInherited
+		}
+		if slices.Contains(graph.ancestors(parentID), roleID) {
+			return ErrInheritanceCycle
+		}
+
+		edge := models.RoleInheritance{RoleID: roleID, ParentRoleID: parentID, CreatedAt: time.Now()}
+		if err := tx.Create(&edge).Error; err != nil {
+			return fmt.Errorf("failed to add parent role: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Service) RemoveParentRole(roleID, parentID uint) error {
+	return GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ? AND parent_role_id = ?", roleID, parentID).Delete(&models.RoleInheritance{}).Error; err != nil {
+			return fmt.Errorf("failed to remove parent role: %w", err)
+		}
+		return nil
+	})
+}
+
+// LoadInheritedPermissions fills in InheritedPermissions for each role.
+// Permissions should be preloaded so that direct grants are left out.
+func LoadInheritedPermissions(db *gorm.DB, roles ...*models.Role) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	graph, err := loadInheritanceGraph(db)
+	if err != nil {
+		return err
+	}
+
+	ancestors := make(map[uint][]uint, len(roles))
+	var all []uint
+	for _, role := range roles {
+		ancestors[role.ID] = graph.ancestors(role.ID)
+		all = append(all, ancestors[role.ID]...)
+	}
+
+	granted := make(map[uint][]string)
+	if len(all) > 0 {
+		var rolePermissions []models.RolePermission
+		if err := db.Preload("Permission").Where("role_id IN ?", all).Find(&rolePermissions).Error; err != nil {
+			return fmt.Errorf("failed to get inherited permissions: %w", err)
+		}
+		for _, rp := range rolePermissions {
+			granted[rp.RoleID] = append(granted[rp.RoleID], rp.Permission.Name)
+		}
+	}
+
+	for _, role := range roles {
+		direct := make(map[string]bool, len(role.Permissions))
+		for _, rp := range role.Permissions {
+			direct[rp.Permission.Name] = true
+		}
+
+		inherited := make(map[string]bool)
+		for _, ancestor := range ancestors[role.ID] {
+			for _, name := range granted[ancestor] {
+				if !direct[name] {
+					inherited[name] = true
+				}
+			}
+		}
+
+		role.InheritedPermissions = make([]string, 0, len(inherited))
+		for name := range inherited {
+			role.InheritedPermissions = append(role.InheritedPermissions, name)
+		}
+		sort.Strings(role.InheritedPermissions)
+	}
+	return nil
+}
+
+// LoadUserInheritedPermissions resolves inheritance for every role the user
+// holds, so that the user's permission helpers include inherited grants.
+func LoadUserInheritedPermissions(db *gorm.DB, user *models.User) error {
+	roles := make([]*models.Role, len(user.UserRoles))
+	for i := range user.UserRoles {
+		roles[i] = &user.UserRoles[i].Role
+	}
+	return LoadInheritedPermissions(db, roles...)
+}
@@ -0,0 +1,92 @@
+package permissions
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+)
+
+func TestAddParentRole(t *testing.T) {
+	svc, _ := newRBACService(t)
+	a, _ := svc.CreateRole("A", "")
+	b, _ := svc.CreateRole("B", "")
+	c, _ := svc.CreateRole("C", "")
+
+	if err := svc.AddParentRole(a.ID, b.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := svc.AddParentRole(b.ID, c.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		role   uint
+		parent uint
+		want   error
+	}{
+		{"self", a.ID, a.ID, ErrInheritanceCycle},
+		{"direct cycle", b.ID, a.ID, ErrInheritanceCycle},
+		{"transitive cycle", c.ID, a.ID, ErrInheritanceCycle},
+		{"duplicate", a.ID, b.ID, ErrAlreadyInherited},
+		{"unknown role", a.ID, 999, ErrRoleNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.AddParentRole(tt.role, tt.parent); !errors.Is(err, tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// A shortcut to an ancestor is not a cycle.
+	if err := svc.AddParentRole(a.ID, c.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestInheritedPermissions(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	admin, _ := svc.GetRoleByID(ROLE_ID_ADMIN)
+	for _, want := range []string{PermissionReportRead, PermissionReportWrite, PermissionUserRead} {
+		if !slices.Contains(admin.InheritedPermissions, want) {
+			t.Fatalf("Expected Admin to inherit %s, got %v", want, admin.InheritedPermissions)
+		}
+	}
+	if slices.Contains(admin.InheritedPermissions, PermissionUserWrite) {
+		t.Fatal("Expected direct grants to be left out of inherited permissions")
+	}
+	if len(admin.Parents) != 1 || admin.Parents[0].Parent.Name != TeamAccountRoleName {
+		t.Fatalf("Expected Admin's parent to be loaded, got %+v", admin.Parents)
+	}
+
+	// Effective permissions match the flat grants the roles had before.
+	db.Create(&models.UserRole{UserID: 1, RoleID: ROLE_ID_SUPER_ADMIN})
+	got, err := svc.GetUserPermissions(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var want []string
+	for _, p := range GetDefaultPermissions() {
+		want = append(want, p.Name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected Super Admin to hold every permission, got %v", got)
+	}
+
+	// A cycle written directly to the database still terminates.
+	db.Create(&models.RoleInheritance{RoleID: ROLE_ID_USER, ParentRoleID: ROLE_ID_SUPER_ADMIN})
+	user, _ := svc.GetRoleByID(ROLE_ID_USER)
+	if !slices.Contains(user.InheritedPermissions, PermissionSystemAdmin) {
+		t.Fatalf("Expected the cycle to be followed once, got %v", user.InheritedPermissions)
+	}
+}
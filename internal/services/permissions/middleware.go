@@ -3,20 +3,49 @@ package permissions
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
 	"github.com/labstack/echo/v4"
 )
 
+// EffectivePermissions returns the permissions the current request may use:
+// the user's global role grants plus their roles in the active organization,
+// narrowed to the key's scopes when the request was authenticated with an API
+// key.
+func EffectivePermissions(c echo.Context) []string {
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return []string{}
+	}
+
+	userPermissions := user.GetPermissions()
+	if organization, ok := c.Get("organization").(*models.Organization); ok {
+		userPermissions = user.GetOrganizationPermissions(organization.ID)
+	}
+
+	apiKey, ok := c.Get("apiKey").(*models.APIKey)
+	if !ok {
+		return userPermissions
+	}
+
+	scoped := make([]string, 0, len(apiKey.Scopes))
+	for _, scope := range apiKey.Scopes {
+		if HasPermission(userPermissions, scope) {
+			scoped = append(scoped, scope)
+		}
+	}
+	return scoped
+}
+
 func RequirePermission(permission string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			user, ok := c.Get("user").(*models.User)
-			if !ok {
+			if _, ok := c.Get("user").(*models.User); !ok {
 				return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
 			}
 
-			if !user.HasPermission(permission) {
+			if !HasPermission(EffectivePermissions(c), permission) {
 				return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
 			}
 
@@ -28,12 +57,11 @@ func RequirePermission(permission string) echo.MiddlewareFunc {
 func RequireAnyPermission(permissions ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			user, ok := c.Get("user").(*models.User)
-			if !ok {
+			if _, ok := c.Get("user").(*models.User); !ok {
 				return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
 			}
 
-			if !user.HasAnyPermission(permissions) {
+			if !HasAnyPermission(EffectivePermissions(c), permissions) {
 				return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
 			}
 
@@ -45,12 +73,11 @@ func RequireAnyPermission(permissions ...string) echo.MiddlewareFunc {
 func RequireAllPermissions(permissions ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			user, ok := c.Get("user").(*models.User)
-			if !ok {
+			if _, ok := c.Get("user").(*models.User); !ok {
 				return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
 			}
 
-			if !user.HasAllPermissions(permissions) {
+			if !HasAllPermissions(EffectivePermissions(c), permissions) {
 				return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
 			}
 
@@ -101,7 +128,27 @@ func RequireAnyRole(roleIDs ...uint) echo.MiddlewareFunc {
 	}
 }
 
-func RequireAdminOrOwner() echo.MiddlewareFunc {
+const (
+	StepUpReasonSessionRequired = "session_required"
+	StepUpReasonMFARequired     = "mfa_required"
+	StepUpReasonStale           = "stale"
+)
+
+// StepUpChallenge tells the client to re-verify at /api/v1/auth/step-up and
+// retry the request.
+type StepUpChallenge struct {
+	Error          string `json:"error"`
+	StepUpRequired bool   `json:"stepUpRequired"`
+	Reason         string `json:"reason"`
+	MaxAgeSecs     int    `json:"maxAgeSecs"`
+}
+
+// RequireRecentAuth guards dangerous actions. The session must have been
+// authenticated within maxAge and, when the user has 2FA, with a second
+// factor. Users who must enroll 2FA but have not yet cannot present one, so
+// only freshness is checked for them. Requests made with API keys have no
+// session and are refused, and so are impersonation sessions.
+func RequireRecentAuth(maxAge time.Duration) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			user, ok := c.Get("user").(*models.User)
@@ -109,12 +156,35 @@ func RequireAdminOrOwner() echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
 			}
 
-			userID := c.Param("id")
-			isOwner := userID != "" && userID == string(rune(user.ID))
-			isAdmin := user.HasRole(ROLE_ID_ADMIN) || user.HasRole(ROLE_ID_SUPER_ADMIN)
+			challenge := func(reason string) error {
+				return c.JSON(http.StatusForbidden, &StepUpChallenge{
+					Error:          "Recent authentication required",
+					StepUpRequired: true,
+					Reason:         reason,
+					MaxAgeSecs:     int(maxAge.Seconds()),
+				})
+			}
+
+			session, ok := c.Get("session").(*models.Session)
+			if !ok {
+				return challenge(StepUpReasonSessionRequired)
+			}
 
-			if !isOwner && !isAdmin {
-				return echo.NewHTTPError(http.StatusForbidden, "Access denied: must be owner or admin")
+			// Stepping up is not possible while impersonating, so there is
+			// nothing to challenge for.
+			if session.IsImpersonation() {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":         "Not allowed while impersonating",
+					"impersonating": true,
+				})
+			}
+
+			if user.TwoFactorEnabled && !session.TwoFactorVerified {
+				return challenge(StepUpReasonMFARequired)
+			}
+
+			if session.AuthAge() > maxAge {
+				return challenge(StepUpReasonStale)
 			}
 
 			return next(c)
@@ -122,6 +192,27 @@ func RequireAdminOrOwner() echo.MiddlewareFunc {
 	}
 }
 
+// RejectAPIKeys keeps routes that manage the account itself, or that are
+// not covered by any permission an API key could be scoped to, to
+// interactive sessions.
+func RejectAPIKeys() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, usingKey := c.Get("apiKey").(*models.APIKey); usingKey {
+				return echo.NewHTTPError(http.StatusForbidden, "API keys cannot be used for this action")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// RequireAdminOrOwner lets users act on the account named by :id when it is
+// their own, and callers with user:write act on any account.
+func RequireAdminOrOwner() echo.MiddlewareFunc {
+	return RequireAuthorized(PermissionUserWrite, UserParamResource)
+}
+
 func PermissionBasedFilter(c echo.Context, data interface{}, userPermissions []string) interface{} {
 	switch v := data.(type) {
 	case []models.User:
@@ -25,6 +25,9 @@ const (
 	PermissionSettingsRead  = "settings:read"
 	PermissionSettingsWrite = "settings:write"
 	PermissionSystemAdmin   = "system:admin"
+	PermissionAuditRead     = "audit:read"
+	PermissionOrgRead       = "org:read"
+	PermissionOrgWrite      = "org:write"
 )
 
 const (
@@ -34,78 +37,64 @@ const (
 	UserRoleName         = "User"
 )
 
+// UserRole sources. Roles from an external identity provider are reconciled
+// on every login; manually assigned roles are never touched by reconciliation.
+const (
+	RoleSourceManual = "manual"
+	RoleSourceSSO    = "sso"
+)
+
 var ErrInsufficientPermissions = errors.New("insufficient permissions")
 var ErrInvalidRole = errors.New("invalid role")
+var ErrRoleNotFound = errors.New("role not found")
 
+// GetDefaultPermissions, GetDefaultRoles, GetDefaultRolePermissions and
+// GetDefaultRoleParents read the embedded rbac.yaml.
 func GetDefaultPermissions() []models.Permission {
-	return []models.Permission{
-		{Name: PermissionUserRead, Description: "Read user information"},
-		{Name: PermissionUserWrite, Description: "Create and update users"},
-		{Name: PermissionUserDelete, Description: "Delete users"},
-		{Name: PermissionRoleRead, Description: "Read role information"},
-		{Name: PermissionRoleWrite, Description: "Create and update roles"},
-		{Name: PermissionRoleDelete, Description: "Delete roles"},
-		{Name: PermissionReportRead, Description: "View reports"},
-		{Name: PermissionReportWrite, Description: "Create and modify reports"},
-		{Name: PermissionSettingsRead, Description: "View system settings"},
-		{Name: PermissionSettingsWrite, Description: "Modify system settings"},
-		{Name: PermissionSystemAdmin, Description: "Full system administration"},
+	specs := defaults().Permissions
+	permissions := make([]models.Permission, len(specs))
+	for i, spec := range specs {
+		permissions[i] = models.Permission{Name: spec.Name, Description: spec.Description}
 	}
+	return permissions
 }
 
 func GetDefaultRoles() []models.Role {
-	return []models.Role{
-		{
-			ID:          ROLE_ID_SUPER_ADMIN,
-			Name:        SuperAdminRoleName,
-			Description: "Full system access with all permissions",
-		},
-		{
-			ID:          ROLE_ID_ADMIN,
-			Name:        AdminRoleName,
-			Description: "Administrative access with user and role management",
-		},
-		{
-			ID:          ROLE_ID_TEAM_ACCOUNT,
-			Name:        TeamAccountRoleName,
-			Description: "Team member with report and user management permissions",
-		},
-		{
-			ID:          ROLE_ID_USER,
-			Name:        UserRoleName,
-			Description: "Basic user with read-only access",
-		},
+	specs := defaults().Roles
+	roles := make([]models.Role, len(specs))
+	for i, spec := range specs {
+		roles[i] = models.Role{ID: spec.ID, Name: spec.Name, Description: spec.Description, RequiresMFA: spec.RequiresMFA, IsSystem: spec.System}
 	}
+	return roles
 }
 
 func GetDefaultRolePermissions() map[uint][]string {
-	return map[uint][]string{
-		ROLE_ID_SUPER_ADMIN: {
-			PermissionUserRead, PermissionUserWrite, PermissionUserDelete,
-			PermissionRoleRead, PermissionRoleWrite, PermissionRoleDelete,
-			PermissionReportRead, PermissionReportWrite,
-			PermissionSettingsRead, PermissionSettingsWrite,
-			PermissionSystemAdmin,
-		},
-		ROLE_ID_ADMIN: {
-			PermissionUserRead, PermissionUserWrite,
-			PermissionRoleRead,
-			PermissionReportRead, PermissionReportWrite,
-			PermissionSettingsRead,
-		},
-		ROLE_ID_TEAM_ACCOUNT: {
-			PermissionUserRead,
-			PermissionReportRead, PermissionReportWrite,
-		},
-		ROLE_ID_USER: {
-			PermissionReportRead,
-		},
+	grants := make(map[uint][]string)
+	for _, spec := range defaults().Roles {
+		grants[spec.ID] = spec.Permissions
+	}
+	return grants
+}
+
+// GetDefaultRoleParents maps each default role to the roles it inherits from.
+func GetDefaultRoleParents() map[uint][]uint {
+	ids := make(map[string]uint)
+	for _, spec := range defaults().Roles {
+		ids[spec.Name] = spec.ID
+	}
+
+	parents := make(map[uint][]uint)
+	for _, spec := range defaults().Roles {
+		for _, name := range spec.Inherits {
+			parents[spec.ID] = append(parents[spec.ID], ids[name])
+		}
 	}
+	return parents
 }
 
 func HasPermission(userPermissions []string, requiredPermission string) bool {
 	for _, permission := range userPermissions {
-		if permission == requiredPermission {
+		if MatchPermission(permission, requiredPermission) {
 			return true
 		}
 		if permission == PermissionSystemAdmin {
@@ -115,6 +104,24 @@ func HasPermission(userPermissions []string, requiredPermission string) bool {
 	return false
 }
 
+// MatchPermission reports whether a granted permission covers the required
+// one. Either half of a grant may be "*", so "role:*" covers every role
+// permission and "*:read" every read permission.
+func MatchPermission(granted, required string) bool {
+	if granted == required {
+		return true
+	}
+	grantedResource, grantedAction, err := ParsePermission(granted)
+	if err != nil {
+		return false
+	}
+	resource, action, err := ParsePermission(required)
+	if err != nil {
+		return false
+	}
+	return (grantedResource == "*" || grantedResource == resource) && (grantedAction == "*" || grantedAction == action)
+}
+
 func HasAnyPermission(userPermissions []string, requiredPermissions []string) bool {
 	for _, required := range requiredPermissions {
 		if HasPermission(userPermissions, required) {
@@ -0,0 +1,266 @@
+package permissions
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	VisibilityPrivate      = "private"
+	VisibilityOrganization = "organization"
+	VisibilityPublic       = "public"
+)
+
+// Resource describes what an action is performed on. Zero values mean the
+// attribute does not apply.
+type Resource struct {
+	Type           string                 `json:"type"`
+	ID             string                 `json:"id,omitempty"`
+	OwnerID        uint                   `json:"ownerId,omitempty"`
+	OrganizationID uint                   `json:"organizationId,omitempty"`
+	Visibility     string                 `json:"visibility,omitempty"`
+	Attributes     map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Subject is the caller an action is authorized for.
+type Subject struct {
+	User           *models.User
+	Permissions    []string
+	OrganizationID uint
+	// Scopes limits what a request made with an API key may do, including
+	// through conditions. It is nil for sessions.
+	Scopes []string
+}
+
+// SubjectFromContext builds the subject for the request: the user, their
+// effective permissions and the active organization.
+func SubjectFromContext(c echo.Context) Subject {
+	subject := Subject{Permissions: EffectivePermissions(c)}
+	if user, ok := c.Get("user").(*models.User); ok {
+		subject.User = user
+	}
+	if organization, ok := c.Get("organization").(*models.Organization); ok {
+		subject.OrganizationID = organization.ID
+	}
+	if apiKey, ok := c.Get("apiKey").(*models.APIKey); ok {
+		subject.Scopes = append([]string{}, apiKey.Scopes...)
+	}
+	return subject
+}
+
+// inScope reports whether the subject's API key, if any, covers permission.
+func (s Subject) inScope(permission string) bool {
+	return s.Scopes == nil || HasPermission(s.Scopes, permission)
+}
+
+func (s Subject) userID() uint {
+	if s.User == nil {
+		return 0
+	}
+	return s.User.ID
+}
+
+// Condition grants an action based on attributes of the subject and
+// resource rather than a permission.
+type Condition struct {
+	Name  string
+	Check func(Subject, Resource) bool
+}
+
+var (
+	// ConditionOwner holds when the caller owns the resource.
+	ConditionOwner = Condition{Name: "owner", Check: func(s Subject, r Resource) bool {
+		return r.OwnerID != 0 && r.OwnerID == s.userID()
+	}}
+	// ConditionSameOrganization holds when the resource belongs to the active
+	// organization.
+	ConditionSameOrganization = Condition{Name: "same_organization", Check: func(s Subject, r Resource) bool {
+		return r.OrganizationID != 0 && r.OrganizationID == s.OrganizationID
+	}}
+	ConditionPublic = Condition{Name: "public", Check: func(_ Subject, r Resource) bool {
+		return r.Visibility == VisibilityPublic
+	}}
+	// ConditionOrganizationVisible holds for resources shared with the
+	// active organization.
+	ConditionOrganizationVisible = Condition{Name: "organization_visible", Check: func(s Subject, r Resource) bool {
+		return r.Visibility == VisibilityOrganization && ConditionSameOrganization.Check(s, r)
+	}}
+)
+
+// AllOf holds when every condition does.
+func AllOf(conditions ...Condition) Condition {
+	names := make([]string, len(conditions))
+	for i, condition := range conditions {
+		names[i] = condition.Name
+	}
+	return Condition{Name: strings.Join(names, "+"), Check: func(s Subject, r Resource) bool {
+		for _, condition := range conditions {
+			if !condition.Check(s, r) {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+// Granted holds when the caller has the permission, for combining conditions
+// with RBAC, e.g. owners who can also read reports.
+func Granted(permission string) Condition {
+	return Condition{Name: "granted:" + permission, Check: func(s Subject, _ Resource) bool {
+		return HasPermission(s.Permissions, permission)
+	}}
+}
+
+// Policy allows Action when the caller holds Permission, or when any of the
+// Conditions holds. Permission grants only reach resources in the active
+// organization, or outside any organization, unless the caller is a global
+// system admin.
+type Policy struct {
+	Action     string
+	Permission string
+	Conditions []Condition
+}
+
+const (
+	DecisionPermission     = "permission"
+	DecisionCondition      = "condition"
+	DecisionTenantMismatch = "tenant_mismatch"
+	DecisionNoMatch        = "no_match"
+)
+
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	// Rule is the permission or condition that decided.
+	Rule string `json:"rule,omitempty"`
+}
+
+type Authorizer struct {
+	mu       sync.RWMutex
+	policies map[string]Policy
+}
+
+func NewAuthorizer(policies ...Policy) *Authorizer {
+	a := &Authorizer{policies: make(map[string]Policy)}
+	a.SetPolicies(policies...)
+	return a
+}
+
+// SetPolicies adds or replaces policies by action.
+func (a *Authorizer) SetPolicies(policies ...Policy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, policy := range policies {
+		if policy.Permission == "" {
+			policy.Permission = policy.Action
+		}
+		a.policies[policy.Action] = policy
+	}
+}
+
+// Policy returns the policy for action. Actions without one are granted by
+// the permission of the same name.
+func (a *Authorizer) Policy(action string) Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if policy, ok := a.policies[action]; ok {
+		return policy
+	}
+	return Policy{Action: action, Permission: action}
+}
+
+func (a *Authorizer) Evaluate(subject Subject, action string, resource Resource) Decision {
+	policy := a.Policy(action)
+
+	if HasPermission(subject.Permissions, policy.Permission) {
+		if inTenant(subject, resource) {
+			return Decision{Allowed: true, Reason: DecisionPermission, Rule: policy.Permission}
+		}
+	}
+
+	// An API key only reaches what its scopes cover, so conditions such as
+	// ownership cannot widen it.
+	if subject.inScope(policy.Permission) {
+		for _, condition := range policy.Conditions {
+			if condition.Check(subject, resource) {
+				return Decision{Allowed: true, Reason: DecisionCondition, Rule: condition.Name}
+			}
+		}
+	}
+
+	if HasPermission(subject.Permissions, policy.Permission) {
+		return Decision{Reason: DecisionTenantMismatch, Rule: policy.Permission}
+	}
+	return Decision{Reason: DecisionNoMatch}
+}
+
+func inTenant(subject Subject, resource Resource) bool {
+	if resource.OrganizationID == 0 || resource.OrganizationID == subject.OrganizationID {
+		return true
+	}
+	return subject.User != nil && subject.inScope(PermissionSystemAdmin) &&
+		HasPermission(subject.User.GetPermissions(), PermissionSystemAdmin)
+}
+
+// DefaultPolicies lets users read and edit their own account and reports
+// without the matching permission, and read reports shared with them.
+func DefaultPolicies() []Policy {
+	return []Policy{
+		{Action: PermissionUserRead, Conditions: []Condition{ConditionOwner}},
+		{Action: PermissionUserWrite, Conditions: []Condition{ConditionOwner}},
+		{Action: PermissionReportRead, Conditions: []Condition{ConditionOwner, ConditionPublic, ConditionOrganizationVisible}},
+		{Action: PermissionReportWrite, Conditions: []Condition{AllOf(ConditionOwner, Granted(PermissionReportRead))}},
+	}
+}
+
+var DefaultAuthorizer = NewAuthorizer(DefaultPolicies()...)
+
+// Authorize checks the action against DefaultAuthorizer for the request's
+// caller and returns a 403 error when it is not allowed.
+func Authorize(c echo.Context, action string, resource Resource) error {
+	if _, ok := c.Get("user").(*models.User); !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	if !DefaultAuthorizer.Evaluate(SubjectFromContext(c), action, resource).Allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+	}
+	return nil
+}
+
+// ResourceLoader describes the resource a request targets, typically by
+// loading it from the path parameters.
+type ResourceLoader func(c echo.Context) (Resource, error)
+
+// RequireAuthorized runs Authorize with the resource load returns.
+func RequireAuthorized(action string, load ResourceLoader) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			resource, err := load(c)
+			if err != nil {
+				return err
+			}
+
+			if err := Authorize(c, action, resource); err != nil {
+				return err
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// UserParamResource treats the user named by the :id path parameter as a
+// resource they own.
+func UserParamResource(c echo.Context) (Resource, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return Resource{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+	return Resource{Type: "user", ID: c.Param("id"), OwnerID: uint(id)}, nil
+}
@@ -0,0 +1,226 @@
+package permissions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func userWith(id uint, global []string, orgID uint, org []string) *models.User {
+	role := func(names []string, organizationID *uint) models.UserRole {
+		r := models.UserRole{OrganizationID: organizationID}
+		for _, name := range names {
+			r.Role.Permissions = append(r.Role.Permissions, models.RolePermission{Permission: models.Permission{Name: name}})
+		}
+		return r
+	}
+	user := &models.User{ID: id, UserRoles: []models.UserRole{role(global, nil)}}
+	if orgID != 0 {
+		user.UserRoles = append(user.UserRoles, role(org, &orgID))
+	}
+	return user
+}
+
+func subject(user *models.User, orgID uint) Subject {
+	perms := user.GetPermissions()
+	if orgID != 0 {
+		perms = user.GetOrganizationPermissions(orgID)
+	}
+	return Subject{User: user, Permissions: perms, OrganizationID: orgID}
+}
+
+// scoped narrows a subject to an API key with scopes, as
+// EffectivePermissions does.
+func scoped(s Subject, scopes ...string) Subject {
+	var granted []string
+	for _, scope := range scopes {
+		if HasPermission(s.Permissions, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	s.Permissions = granted
+	s.Scopes = scopes
+	return s
+}
+
+func TestEvaluate(t *testing.T) {
+	a := NewAuthorizer(DefaultPolicies()...)
+
+	reader := userWith(1, []string{PermissionReportRead}, 0, nil)
+	nobody := userWith(2, nil, 0, nil)
+	orgWriter := userWith(3, nil, 10, []string{PermissionReportWrite})
+	root := userWith(4, []string{PermissionSystemAdmin}, 0, nil)
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		action   string
+		resource Resource
+		allowed  bool
+		reason   string
+	}{
+		{"owner edits own report", subject(reader, 0), PermissionReportWrite, Resource{Type: "report", OwnerID: 1}, true, DecisionCondition},
+		{"owner without read cannot edit", subject(nobody, 0), PermissionReportWrite, Resource{Type: "report", OwnerID: 2}, false, DecisionNoMatch},
+		{"reader cannot edit others' reports", subject(reader, 0), PermissionReportWrite, Resource{Type: "report", OwnerID: 9}, false, DecisionNoMatch},
+		{"public report", subject(nobody, 0), PermissionReportRead, Resource{Type: "report", OwnerID: 9, Visibility: VisibilityPublic}, true, DecisionCondition},
+		{"private report", subject(nobody, 0), PermissionReportRead, Resource{Type: "report", OwnerID: 9, Visibility: VisibilityPrivate}, false, DecisionNoMatch},
+		{"organization report in org", subject(nobody, 10), PermissionReportRead, Resource{Type: "report", OrganizationID: 10, Visibility: VisibilityOrganization}, true, DecisionCondition},
+		{"organization report elsewhere", subject(nobody, 11), PermissionReportRead, Resource{Type: "report", OrganizationID: 10, Visibility: VisibilityOrganization}, false, DecisionNoMatch},
+		{"org permission in org", subject(orgWriter, 10), PermissionReportWrite, Resource{Type: "report", OrganizationID: 10}, true, DecisionPermission},
+		{"org permission outside org", subject(orgWriter, 0), PermissionReportWrite, Resource{Type: "report", OrganizationID: 10}, false, DecisionNoMatch},
+		{"permission does not cross tenants", subject(reader, 0), PermissionReportRead, Resource{Type: "report", OrganizationID: 10}, false, DecisionTenantMismatch},
+		{"system admin crosses tenants", subject(root, 0), PermissionReportWrite, Resource{Type: "report", OrganizationID: 10}, true, DecisionPermission},
+		{"action without policy", subject(reader, 0), PermissionRoleWrite, Resource{Type: "role"}, false, DecisionNoMatch},
+		{"key scoped to the action keeps ownership", scoped(subject(reader, 0), PermissionReportRead, PermissionReportWrite), PermissionReportWrite, Resource{Type: "report", OwnerID: 1}, true, DecisionCondition},
+		{"key outside its scope loses ownership", scoped(subject(reader, 0), PermissionReportRead), PermissionReportWrite, Resource{Type: "report", OwnerID: 1}, false, DecisionNoMatch},
+		{"key outside its scope loses public access", scoped(subject(nobody, 0), PermissionUserRead), PermissionReportRead, Resource{Type: "report", Visibility: VisibilityPublic}, false, DecisionNoMatch},
+		{"key without system:admin stays in tenant", scoped(subject(root, 0), PermissionReportWrite), PermissionReportWrite, Resource{Type: "report", OrganizationID: 10}, false, DecisionTenantMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := a.Evaluate(tt.subject, tt.action, tt.resource)
+			if d.Allowed != tt.allowed || d.Reason != tt.reason {
+				t.Fatalf("Expected allowed=%v reason=%s, got %+v", tt.allowed, tt.reason, d)
+			}
+		})
+	}
+}
+
+func TestRequireAdminOrOwner(t *testing.T) {
+	e := echo.New()
+	handler := RequireAdminOrOwner()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		apiKey *models.APIKey
+		id     string
+		code   int
+	}{
+		{"owner", userWith(42, nil, 0, nil), nil, "42", http.StatusOK},
+		{"other user", userWith(42, nil, 0, nil), nil, "43", http.StatusForbidden},
+		{"admin", userWith(1, []string{PermissionUserWrite}, 0, nil), nil, "43", http.StatusOK},
+		{"bad id", userWith(42, nil, 0, nil), nil, "abc", http.StatusBadRequest},
+		{"owner's key with user:write", userWith(42, []string{PermissionUserWrite}, 0, nil), &models.APIKey{Scopes: []string{PermissionUserWrite}}, "42", http.StatusOK},
+		{"owner's key with another scope", userWith(42, []string{PermissionReportRead}, 0, nil), &models.APIKey{Scopes: []string{PermissionReportRead}}, "42", http.StatusForbidden},
+		{"admin's key with another scope", userWith(1, []string{PermissionUserWrite, PermissionReportRead}, 0, nil), &models.APIKey{Scopes: []string{PermissionReportRead}}, "43", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+			c.Set("user", tt.user)
+			if tt.apiKey != nil {
+				c.Set("apiKey", tt.apiKey)
+			}
+
+			err := handler(c)
+			code := rec.Code
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			if code != tt.code {
+				t.Fatalf("Expected %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestRejectAPIKeys(t *testing.T) {
+	e := echo.New()
+	handler := RejectAPIKeys()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.Set("user", userWith(1, nil, 0, nil))
+	if err := handler(c); err != nil {
+		t.Fatalf("Expected sessions to pass, got %v", err)
+	}
+
+	c.Set("apiKey", &models.APIKey{Scopes: []string{PermissionSystemAdmin}})
+	if he, ok := handler(c).(*echo.HTTPError); !ok || he.Code != http.StatusForbidden {
+		t.Fatalf("Expected API keys to be refused, got %v", he)
+	}
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	e := echo.New()
+	handler := RequireRecentAuth(5 * time.Minute)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	fresh := &models.Session{AuthenticatedAt: time.Now()}
+	stale := &models.Session{AuthenticatedAt: time.Now().Add(-time.Hour), TwoFactorVerified: true}
+	verified := &models.Session{AuthenticatedAt: time.Now(), TwoFactorVerified: true}
+	twoFactorUser := userWith(1, nil, 0, nil)
+	twoFactorUser.TwoFactorEnabled = true
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		session *models.Session
+		apiKey  *models.APIKey
+		reason  string
+	}{
+		{"no session", userWith(1, nil, 0, nil), nil, nil, StepUpReasonSessionRequired},
+		{"api key", userWith(1, nil, 0, nil), nil, &models.APIKey{Scopes: []string{PermissionSystemAdmin}}, StepUpReasonSessionRequired},
+		{"2FA user without a second factor", twoFactorUser, fresh, nil, StepUpReasonMFARequired},
+		{"stale session", twoFactorUser, stale, nil, StepUpReasonStale},
+		{"fresh verified session", twoFactorUser, verified, nil, ""},
+		{"fresh session without 2FA", userWith(1, nil, 0, nil), fresh, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec)
+			c.Set("user", tt.user)
+			if tt.session != nil {
+				c.Set("session", tt.session)
+			}
+			if tt.apiKey != nil {
+				c.Set("apiKey", tt.apiKey)
+			}
+
+			if err := handler(c); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tt.reason == "" {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected the request to pass, got %d", rec.Code)
+				}
+				return
+			}
+
+			var challenge StepUpChallenge
+			json.Unmarshal(rec.Body.Bytes(), &challenge)
+			if rec.Code != http.StatusForbidden || !challenge.StepUpRequired || challenge.Reason != tt.reason || challenge.MaxAgeSecs != 300 {
+				t.Fatalf("Expected a %s challenge, got %d %+v", tt.reason, rec.Code, challenge)
+			}
+		})
+	}
+
+	t.Run("impersonation session", func(t *testing.T) {
+		adminID := uint(2)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec)
+		c.Set("user", twoFactorUser)
+		c.Set("session", &models.Session{AuthenticatedAt: time.Now(), TwoFactorVerified: true, ImpersonatorID: &adminID})
+
+		if err := handler(c); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var challenge StepUpChallenge
+		json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if rec.Code != http.StatusForbidden || challenge.StepUpRequired || !strings.Contains(rec.Body.String(), `"impersonating":true`) {
+			t.Fatalf("Expected impersonation to be refused without a challenge, got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,536 @@
+package permissions
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// PolicyFileVersion is the only format version LoadPolicyFile accepts.
+const PolicyFileVersion = 1
+
+//go:embed rbac.yaml
+var defaultPolicyFile []byte
+
+// PolicyFile declares every role, permission and grant. JSON files are read
+// too, since YAML is a superset of JSON.
+type PolicyFile struct {
+	Version     int              `yaml:"version" json:"version"`
+	Permissions []PermissionSpec `yaml:"permissions" json:"permissions"`
+	Roles       []RoleSpec       `yaml:"roles" json:"roles"`
+}
+
+type PermissionSpec struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+}
+
+type RoleSpec struct {
+	// ID pins the role's primary key. Roles without one are matched by name.
+	ID          uint     `yaml:"id" json:"id,omitempty"`
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description" json:"description"`
+	RequiresMFA bool     `yaml:"requiresMfa" json:"requiresMfa"`
+	Permissions []string `yaml:"permissions" json:"permissions"`
+	// Inherits names roles in the same file whose permissions this role
+	// inherits.
+	Inherits []string `yaml:"inherits" json:"inherits,omitempty"`
+	// System roles cannot be renamed or deleted through the API.
+	System bool `yaml:"system" json:"system,omitempty"`
+}
+
+// LoadPolicyFile reads a policy file, or the embedded default when path is
+// empty.
+func LoadPolicyFile(path string) (*PolicyFile, error) {
+	data := defaultPolicyFile
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return ParsePolicyFile(data)
+}
+
+func ParsePolicyFile(data []byte) (*PolicyFile, error) {
+	var file PolicyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid policy file: %w", err)
+	}
+	if err := file.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file: %w", err)
+	}
+	return &file, nil
+}
+
+func (f *PolicyFile) validate() error {
+	if f.Version != PolicyFileVersion {
+		return fmt.Errorf("unsupported version %d", f.Version)
+	}
+
+	permissions := make(map[string]bool, len(f.Permissions))
+	for _, p := range f.Permissions {
+		if _, _, err := ParsePermission(p.Name); err != nil {
+			return fmt.Errorf("permission %q: %w", p.Name, err)
+		}
+		if permissions[p.Name] {
+			return fmt.Errorf("permission %q is declared twice", p.Name)
+		}
+		permissions[p.Name] = true
+	}
+
+	names := make(map[string]bool, len(f.Roles))
+	ids := make(map[uint]bool, len(f.Roles))
+	for _, r := range f.Roles {
+		if r.Name == "" {
+			return errors.New("role without a name")
+		}
+		if names[r.Name] || (r.ID != 0 && ids[r.ID]) {
+			return fmt.Errorf("role %q is declared twice", r.Name)
+		}
+		names[r.Name], ids[r.ID] = true, true
+
+		for _, p := range r.Permissions {
+			if !permissions[p] {
+				return fmt.Errorf("role %q grants undeclared permission %q", r.Name, p)
+			}
+		}
+	}
+
+	parents := make(map[string][]string, len(f.Roles))
+	for _, r := range f.Roles {
+		for _, parent := range r.Inherits {
+			if !names[parent] {
+				return fmt.Errorf("role %q inherits undeclared role %q", r.Name, parent)
+			}
+		}
+		parents[r.Name] = r.Inherits
+	}
+	for _, r := range f.Roles {
+		if inheritsFrom(parents, r.Name, r.Name, map[string]bool{}) {
+			return fmt.Errorf("role %q: %w", r.Name, ErrInheritanceCycle)
+		}
+	}
+	return nil
+}
+
+// inheritsFrom reports whether role reaches target through parents.
+func inheritsFrom(parents map[string][]string, role, target string, seen map[string]bool) bool {
+	for _, parent := range parents[role] {
+		if parent == target {
+			return true
+		}
+		if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		if inheritsFrom(parents, parent, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+var defaults = sync.OnceValue(func() *PolicyFile {
+	file, err := ParsePolicyFile(defaultPolicyFile)
+	if err != nil {
+		panic(err)
+	}
+	return file
+})
+
+// Change is one difference between a policy file and the database.
+type Change struct {
+	Action string `json:"action"` // create, update, delete, grant, revoke, inherit or uninherit
+	Kind   string `json:"kind"`   // permission or role
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s %q", c.Action, c.Kind, c.Name)
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+	ChangeGrant  = "grant"
+	ChangeRevoke = "revoke"
+	// ChangeInherit and ChangeUninherit add and remove a parent role; the
+	// change's Detail names the parent.
+	ChangeInherit   = "inherit"
+	ChangeUninherit = "uninherit"
+)
+
+type ReconcileOptions struct {
+	// DryRun computes the changes without applying them.
+	DryRun bool
+	// Prune deletes roles and permissions missing from the file. Without it
+	// they are left alone and not reported.
+	Prune bool
+}
+
+// Reconcile makes the database match the policy file and returns what it
+// changed, or would change with DryRun. Grants on roles in the file are
+// authoritative and extra ones are revoked, unless that would leave no one
+// holding system:admin.
+func (s *Service) Reconcile(file *PolicyFile, opts ReconcileOptions) ([]Change, error) {
+	var changes []Change
+	err := GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		r := &reconciler{tx: tx, opts: opts, roleIDs: make(map[string]uint)}
+		if err := r.run(file); err != nil {
+			return err
+		}
+		changes = r.changes
+		return nil
+	})
+	return changes, err
+}
+
+// Drift reports every change made to roles and permissions since the file
+// was last applied, including roles and permissions added through the API.
+func (s *Service) Drift(file *PolicyFile) ([]Change, error) {
+	return s.Reconcile(file, ReconcileOptions{DryRun: true, Prune: true})
+}
+
+type reconciler struct {
+	tx      *gorm.DB
+	opts    ReconcileOptions
+	changes []Change
+	// roleIDs maps the file's role names to their IDs, zero for roles a dry
+	// run would create.
+	roleIDs map[string]uint
+}
+
+func (r *reconciler) record(change Change, apply func() error) error {
+	r.changes = append(r.changes, change)
+	if r.opts.DryRun {
+		return nil
+	}
+	return apply()
+}
+
+func (r *reconciler) run(file *PolicyFile) error {
+	permissionIDs, err := r.permissions(file)
+	if err != nil {
+		return err
+	}
+
+	var roles []models.Role
+	if err := r.tx.Preload("Permissions.Permission").Find(&roles).Error; err != nil {
+		return fmt.Errorf("failed to get roles: %w", err)
+	}
+
+	matched := make(map[uint]bool)
+	for _, spec := range file.Roles {
+		role := findRole(roles, spec)
+		if role != nil {
+			matched[role.ID] = true
+		}
+		if err := r.role(spec, role, permissionIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := r.inheritance(file, roles); err != nil {
+		return err
+	}
+
+	if !r.opts.Prune {
+		return nil
+	}
+	for _, role := range roles {
+		if matched[role.ID] {
+			continue
+		}
+		id, name, system := role.ID, role.Name, role.IsSystem
+		err := r.record(Change{Action: ChangeDelete, Kind: "role", Name: role.Name}, func() error {
+			if system {
+				return fmt.Errorf("role %q: %w", name, ErrSystemRole)
+			}
+			return deleteRole(r.tx, id)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return r.prunePermissions(file)
+}
+
+// permissions creates and updates declared permissions and returns every
+// permission's ID by name.
+func (r *reconciler) permissions(file *PolicyFile) (map[string]uint, error) {
+	var existing []models.Permission
+	if err := r.tx.Find(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %w", err)
+	}
+
+	ids := make(map[string]uint, len(existing))
+	byName := make(map[string]models.Permission, len(existing))
+	for _, p := range existing {
+		ids[p.Name] = p.ID
+		byName[p.Name] = p
+	}
+
+	for _, spec := range file.Permissions {
+		current, ok := byName[spec.Name]
+		switch {
+		case !ok:
+			permission := models.Permission{Name: spec.Name, Description: spec.Description, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+			err := r.record(Change{Action: ChangeCreate, Kind: "permission", Name: spec.Name}, func() error {
+				if err := r.tx.Create(&permission).Error; err != nil {
+					return fmt.Errorf("failed to create permission %s: %w", spec.Name, err)
+				}
+				ids[spec.Name] = permission.ID
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		case current.Description != spec.Description:
+			detail := fmt.Sprintf("description %q -> %q", current.Description, spec.Description)
+			err := r.record(Change{Action: ChangeUpdate, Kind: "permission", Name: spec.Name, Detail: detail}, func() error {
+				return r.tx.Model(&models.Permission{}).Where("id = ?", current.ID).Updates(map[string]interface{}{
+					"description": spec.Description,
+					"updated_at":  time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func (r *reconciler) prunePermissions(file *PolicyFile) error {
+	declared := make(map[string]bool, len(file.Permissions))
+	for _, spec := range file.Permissions {
+		declared[spec.Name] = true
+	}
+
+	var existing []models.Permission
+	if err := r.tx.Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to get permissions: %w", err)
+	}
+	for _, p := range existing {
+		if declared[p.Name] {
+			continue
+		}
+		id := p.ID
+		err := r.record(Change{Action: ChangeDelete, Kind: "permission", Name: p.Name}, func() error {
+			if err := r.tx.Where("permission_id = ?", id).Delete(&models.RolePermission{}).Error; err != nil {
+				return err
+			}
+			return r.tx.Delete(&models.Permission{}, id).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findRole(roles []models.Role, spec RoleSpec) *models.Role {
+	for i := range roles {
+		if spec.ID != 0 && roles[i].ID == spec.ID {
+			return &roles[i]
+		}
+	}
+	if spec.ID != 0 {
+		return nil
+	}
+	for i := range roles {
+		if roles[i].Name == spec.Name {
+			return &roles[i]
+		}
+	}
+	return nil
+}
+
+func (r *reconciler) role(spec RoleSpec, role *models.Role, permissionIDs map[string]uint) error {
+	granted := make(map[string]uint)
+
+	if role == nil {
+		created := models.Role{ID: spec.ID, Name: spec.Name, Description: spec.Description, RequiresMFA: spec.RequiresMFA, IsSystem: spec.System, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		err := r.record(Change{Action: ChangeCreate, Kind: "role", Name: spec.Name}, func() error {
+			if err := r.tx.Create(&created).Error; err != nil {
+				return fmt.Errorf("failed to create role %s: %w", spec.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		role = &created
+	} else {
+		var details []string
+		if role.Name != spec.Name {
+			details = append(details, fmt.Sprintf("name %q -> %q", role.Name, spec.Name))
+		}
+		if role.Description != spec.Description {
+			details = append(details, fmt.Sprintf("description %q -> %q", role.Description, spec.Description))
+		}
+		if role.RequiresMFA != spec.RequiresMFA {
+			details = append(details, fmt.Sprintf("requiresMfa %v -> %v", role.RequiresMFA, spec.RequiresMFA))
+		}
+		if role.IsSystem != spec.System {
+			details = append(details, fmt.Sprintf("system %v -> %v", role.IsSystem, spec.System))
+		}
+		if len(details) > 0 {
+			id := role.ID
+			err := r.record(Change{Action: ChangeUpdate, Kind: "role", Name: spec.Name, Detail: strings.Join(details, ", ")}, func() error {
+				return r.tx.Model(&models.Role{}).Where("id = ?", id).Updates(map[string]interface{}{
+					"name":         spec.Name,
+					"description":  spec.Description,
+					"requires_mfa": spec.RequiresMFA,
+					"is_system":    spec.System,
+					"updated_at":   time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, rp := range role.Permissions {
+			granted[rp.Permission.Name] = rp.ID
+		}
+	}
+
+	r.roleIDs[spec.Name] = role.ID
+
+	desired := make(map[string]bool, len(spec.Permissions))
+	for _, name := range spec.Permissions {
+		desired[name] = true
+		if _, ok := granted[name]; ok {
+			continue
+		}
+		err := r.record(Change{Action: ChangeGrant, Kind: "role", Name: spec.Name, Detail: name}, func() error {
+			grant := models.RolePermission{RoleID: role.ID, PermissionID: permissionIDs[name], CreatedAt: time.Now(), UpdatedAt: time.Now()}
+			return r.tx.Create(&grant).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	extra := make([]string, 0)
+	for name := range granted {
+		if !desired[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		id := granted[name]
+		err := r.record(Change{Action: ChangeRevoke, Kind: "role", Name: spec.Name, Detail: name}, func() error {
+			return r.tx.Delete(&models.RolePermission{}, id).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inheritance adds and removes parent roles of the file's roles. Like
+// grants, parents listed in the file are authoritative.
+func (r *reconciler) inheritance(file *PolicyFile, roles []models.Role) error {
+	var edges []models.RoleInheritance
+	if err := r.tx.Find(&edges).Error; err != nil {
+		return fmt.Errorf("failed to get role inheritance: %w", err)
+	}
+
+	names := make(map[uint]string, len(roles))
+	for _, role := range roles {
+		names[role.ID] = role.Name
+	}
+	for name, id := range r.roleIDs {
+		if id != 0 {
+			names[id] = name
+		}
+	}
+
+	current := make(map[string]map[string]uint)
+	for _, edge := range edges {
+		child := names[edge.RoleID]
+		if current[child] == nil {
+			current[child] = make(map[string]uint)
+		}
+		current[child][names[edge.ParentRoleID]] = edge.ID
+	}
+
+	for _, spec := range file.Roles {
+		existing := current[spec.Name]
+		desired := make(map[string]bool, len(spec.Inherits))
+		for _, parent := range spec.Inherits {
+			desired[parent] = true
+			if _, ok := existing[parent]; ok {
+				continue
+			}
+			err := r.record(Change{Action: ChangeInherit, Kind: "role", Name: spec.Name, Detail: parent}, func() error {
+				edge := models.RoleInheritance{RoleID: r.roleIDs[spec.Name], ParentRoleID: r.roleIDs[parent], CreatedAt: time.Now()}
+				return r.tx.Create(&edge).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		extra := make([]string, 0)
+		for parent := range existing {
+			if !desired[parent] {
+				extra = append(extra, parent)
+			}
+		}
+		sort.Strings(extra)
+		for _, parent := range extra {
+			id := existing[parent]
+			err := r.record(Change{Action: ChangeUninherit, Kind: "role", Name: spec.Name, Detail: parent}, func() error {
+				return r.tx.Delete(&models.RoleInheritance{}, id).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteRole(tx *gorm.DB, id uint) error {
+	if err := tx.Where("role_id = ? OR parent_role_id = ?", id, id).Delete(&models.RoleInheritance{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("role_id = ?", id).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("role_id = ?", id).Delete(&models.UserRole{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("role_id = ?", id).Delete(&models.RoleApprover{}).Error; err != nil {
+		return err
+	}
+	err := tx.Model(&models.AccessRequest{}).
+		Where("role_id = ? AND status = ?", id, models.AccessRequestPending).
+		Updates(map[string]interface{}{"status": models.AccessRequestCancelled, "updated_at": time.Now()}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Delete(&models.Role{}, id).Error
+}
@@ -0,0 +1,137 @@
+package permissions
+
+import (
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newRBACService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Expected no error opening database, got %v", err)
+	}
+	if err := db.AutoMigrate(&models.Role{}, &models.Permission{}, &models.RolePermission{}, &models.UserRole{}, &models.RoleInheritance{}, &models.User{}, &models.RoleApprover{}, &models.AccessRequest{}); err != nil {
+		t.Fatalf("Expected no error migrating, got %v", err)
+	}
+	return NewService(db), db
+}
+
+func hasChange(changes []Change, want Change) bool {
+	for _, c := range changes {
+		if c == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParsePolicyFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"wrong version", "version: 2\n"},
+		{"bad permission name", "version: 1\npermissions:\n  - name: nocolon\n"},
+		{"undeclared grant", "version: 1\nroles:\n  - name: R\n    permissions: [user:read]\n"},
+		{"duplicate role", "version: 1\nroles:\n  - name: R\n  - name: R\n"},
+		{"undeclared parent", "version: 1\nroles:\n  - name: R\n    inherits: [S]\n"},
+		{"inheritance cycle", "version: 1\nroles:\n  - name: R\n    inherits: [S]\n  - name: S\n    inherits: [T]\n  - name: T\n    inherits: [R]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicyFile([]byte(tt.data)); err == nil {
+				t.Fatal("Expected an error")
+			}
+		})
+	}
+
+	file, err := ParsePolicyFile([]byte(`{"version": 1, "permissions": [{"name": "a:b"}], "roles": [{"name": "R", "permissions": ["a:b"]}]}`))
+	if err != nil || len(file.Roles) != 1 {
+		t.Fatalf("Expected JSON to parse, got %v", err)
+	}
+
+	if _, err := LoadPolicyFile(""); err != nil {
+		t.Fatalf("Expected the embedded policy to be valid, got %v", err)
+	}
+}
+
+func TestReconcileAndDrift(t *testing.T) {
+	svc, db := newRBACService(t)
+	file, _ := LoadPolicyFile("")
+
+	changes, err := svc.Reconcile(file, ReconcileOptions{DryRun: true})
+	if err != nil || len(changes) == 0 {
+		t.Fatalf("Expected dry run on an empty database to plan changes, got %d (%v)", len(changes), err)
+	}
+	var count int64
+	db.Model(&models.Role{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("Expected dry run to change nothing, got %d roles", count)
+	}
+
+	if _, err := svc.Reconcile(file, ReconcileOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if changes, _ := svc.Drift(file); len(changes) != 0 {
+		t.Fatalf("Expected no drift after reconcile, got %v", changes)
+	}
+
+	// Changes an administrator could make through the API.
+	custom, _ := svc.CreateRole("Auditor", "Reads the audit log")
+	var audit, system models.Permission
+	db.Where("name = ?", PermissionAuditRead).First(&audit)
+	db.Where("name = ?", PermissionSystemAdmin).First(&system)
+	svc.AssignPermissionToRole(ROLE_ID_USER, system.ID)
+	svc.RemovePermissionFromRole(ROLE_ID_ADMIN, audit.ID)
+	svc.UpdateRole(ROLE_ID_TEAM_ACCOUNT, "Team Account", "Renamed")
+	svc.SetRoleRequiresMFA(ROLE_ID_SUPER_ADMIN, true)
+	db.Create(&models.UserRole{UserID: 7, RoleID: custom.ID})
+	svc.RemoveParentRole(ROLE_ID_ADMIN, ROLE_ID_TEAM_ACCOUNT)
+	svc.AddParentRole(ROLE_ID_USER, custom.ID)
+
+	changes, err = svc.Drift(file)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, want := range []Change{
+		{Action: ChangeRevoke, Kind: "role", Name: UserRoleName, Detail: PermissionSystemAdmin},
+		{Action: ChangeGrant, Kind: "role", Name: AdminRoleName, Detail: PermissionAuditRead},
+		{Action: ChangeUpdate, Kind: "role", Name: TeamAccountRoleName, Detail: `description "Renamed" -> "Team member with report and user management permissions"`},
+		{Action: ChangeUpdate, Kind: "role", Name: SuperAdminRoleName, Detail: "requiresMfa true -> false"},
+		{Action: ChangeInherit, Kind: "role", Name: AdminRoleName, Detail: TeamAccountRoleName},
+		{Action: ChangeUninherit, Kind: "role", Name: UserRoleName, Detail: "Auditor"},
+		{Action: ChangeDelete, Kind: "role", Name: "Auditor"},
+	} {
+		if !hasChange(changes, want) {
+			t.Fatalf("Expected drift to include %v, got %v", want, changes)
+		}
+	}
+	if len(changes) != 7 {
+		t.Fatalf("Expected 7 changes, got %v", changes)
+	}
+
+	changes, err = svc.Reconcile(file, ReconcileOptions{})
+	if err != nil || len(changes) != 6 {
+		t.Fatalf("Expected 6 changes without prune, got %v (%v)", changes, err)
+	}
+	if changes, _ := svc.Drift(file); len(changes) != 1 || changes[0].Name != "Auditor" {
+		t.Fatalf("Expected only the unmanaged role left, got %v", changes)
+	}
+
+	if _, err := svc.Reconcile(file, ReconcileOptions{Prune: true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if changes, _ := svc.Drift(file); len(changes) != 0 {
+		t.Fatalf("Expected no drift after prune, got %v", changes)
+	}
+	db.Model(&models.UserRole{}).Where("role_id = ?", custom.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("Expected pruned role's assignments to be removed, got %d", count)
+	}
+}
@@ -1,2 +1,168 @@
 package permissions
 
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSystemRole          = errors.New("system roles cannot be renamed or deleted")
+	ErrRoleInUse           = errors.New("role is still assigned to users")
+	ErrInvalidReassignment = errors.New("users cannot be reassigned to the role being deleted")
+	ErrLastAdmin           = errors.New("at least one active user must keep system:admin")
+	// ErrLastOrganizationAdmin is returned instead when the organization
+	// would be left without anyone able to manage it.
+	ErrLastOrganizationAdmin = errors.New("at least one member must keep org:write in the organization")
+)
+
+// GuardLastAdmin runs change in a transaction and rolls it back with
+// ErrLastAdmin when it leaves no active user holding system:admin through a
+// global role. When db's context carries an organization, it also rolls back
+// with ErrLastOrganizationAdmin when no active user is left holding org:write
+// through a role in that organization. Changes made while there is no such
+// user are allowed, so a fresh install or organization can still be set up.
+//
+// The rows that grant those permissions are locked before counting, so
+// concurrent changes can't each leave one administrator and together leave
+// none.
+func GuardLastAdmin(db *gorm.DB, change func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		guards := []adminGuard{{permission: PermissionSystemAdmin, err: ErrLastAdmin}}
+		if _, ok := tenancy.OrganizationID(tx.Statement.Context); ok {
+			guards = append(guards, adminGuard{permission: PermissionOrgWrite, organization: true, err: ErrLastOrganizationAdmin})
+		}
+
+		before := make([]int64, len(guards))
+		for i, guard := range guards {
+			count, err := guard.count(tx, true)
+			if err != nil {
+				return err
+			}
+			before[i] = count
+		}
+
+		if err := change(tx); err != nil {
+			return err
+		}
+
+		for i, guard := range guards {
+			if before[i] == 0 {
+				continue
+			}
+			after, err := guard.count(tx, false)
+			if err != nil {
+				return err
+			}
+			if after == 0 {
+				return guard.err
+			}
+		}
+		return nil
+	})
+}
+
+// adminGuard is one permission GuardLastAdmin keeps held, by global roles or
+// by roles in the organization in the transaction's context.
+type adminGuard struct {
+	permission   string
+	organization bool
+	err          error
+}
+
+// count counts active users whose current roles grant the permission,
+// directly, by wildcard or through inheritance. With lock set, the granting
+// rows and their users are locked first.
+func (g adminGuard) count(db *gorm.DB, lock bool) (int64, error) {
+	paths, err := grantPaths(db, g.permission)
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, nil
+	}
+
+	roleIDs := make([]uint, 0, len(paths))
+	for roleID := range paths {
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	holders := func() *gorm.DB {
+		query := db.Model(&models.UserRole{}).
+			Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL AND users.is_active = ?", true).
+			Where("user_roles.role_id IN ?", roleIDs)
+		if g.organization {
+			return query.Scopes(tenancy.Scope)
+		}
+		return query.Where("user_roles.organization_id IS NULL")
+	}
+
+	if lock {
+		var ids []uint
+		if err := holders().Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("user_roles.id", &ids).Error; err != nil {
+			return 0, fmt.Errorf("failed to lock administrators: %w", err)
+		}
+	}
+
+	var count int64
+	err = activeUserRoles(holders(), time.Now()).
+		Distinct("user_roles.user_id").
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count administrators: %w", err)
+	}
+	return count, nil
+}
+
+// reassignRole moves every assignment of one role to another, keeping its
+// organization and validity window. Users who already hold the target role
+// in the same scope keep that assignment instead.
+func reassignRole(tx *gorm.DB, fromID, toID uint) error {
+	if fromID == toID {
+		return ErrInvalidReassignment
+	}
+	var count int64
+	if err := tx.Model(&models.Role{}).Where("id = ?", toID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to find role: %w", err)
+	}
+	if count == 0 {
+		return ErrRoleNotFound
+	}
+
+	var userRoles []models.UserRole
+	if err := tx.Where("role_id = ?", fromID).Find(&userRoles).Error; err != nil {
+		return fmt.Errorf("failed to get role holders: %w", err)
+	}
+	for _, userRole := range userRoles {
+		existing := tx.Model(&models.UserRole{}).Where("user_id = ? AND role_id = ?", userRole.UserID, toID)
+		if userRole.OrganizationID != nil {
+			existing = existing.Where("organization_id = ?", *userRole.OrganizationID)
+		} else {
+			existing = existing.Where("organization_id IS NULL")
+		}
+		var held int64
+		if err := existing.Count(&held).Error; err != nil {
+			return fmt.Errorf("failed to check user role: %w", err)
+		}
+
+		if held > 0 {
+			if err := tx.Delete(&models.UserRole{}, userRole.ID).Error; err != nil {
+				return fmt.Errorf("failed to reassign role: %w", err)
+			}
+			continue
+		}
+		err := tx.Model(&models.UserRole{}).Where("id = ?", userRole.ID).Updates(map[string]interface{}{
+			"role_id":    toID,
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to reassign role: %w", err)
+		}
+	}
+	return nil
+}
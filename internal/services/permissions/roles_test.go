@@ -0,0 +1,161 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestDeleteRole(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	if err := svc.DeleteRole(ROLE_ID_SUPER_ADMIN, nil); !errors.Is(err, ErrSystemRole) {
+		t.Fatalf("Expected ErrSystemRole, got %v", err)
+	}
+	if _, err := svc.UpdateRole(ROLE_ID_USER, "Member", ""); !errors.Is(err, ErrSystemRole) {
+		t.Fatalf("Expected renaming a system role to fail, got %v", err)
+	}
+	if _, err := svc.UpdateRole(ROLE_ID_USER, UserRoleName, "Updated"); err != nil {
+		t.Fatalf("Expected a system role's description to be editable, got %v", err)
+	}
+
+	auditor, _ := svc.CreateRole("Auditor", "")
+	reviewer, _ := svc.CreateRole("Reviewer", "")
+	orgID := uint(5)
+	db.Create(&models.UserRole{UserID: 1, RoleID: auditor.ID})
+	db.Create(&models.UserRole{UserID: 2, RoleID: auditor.ID, OrganizationID: &orgID})
+	db.Create(&models.UserRole{UserID: 2, RoleID: reviewer.ID})
+	db.Create(&models.UserRole{UserID: 3, RoleID: auditor.ID})
+	db.Create(&models.UserRole{UserID: 3, RoleID: reviewer.ID})
+	db.Create(&models.RoleApprover{RoleID: auditor.ID, UserID: 9})
+	db.Create(&models.AccessRequest{UserID: 4, RoleID: auditor.ID, Justification: "needed", Status: models.AccessRequestPending})
+	svc.AddParentRole(auditor.ID, ROLE_ID_USER)
+
+	if err := svc.DeleteRole(auditor.ID, nil); !errors.Is(err, ErrRoleInUse) {
+		t.Fatalf("Expected ErrRoleInUse, got %v", err)
+	}
+	if err := svc.DeleteRole(auditor.ID, &auditor.ID); !errors.Is(err, ErrInvalidReassignment) {
+		t.Fatalf("Expected ErrInvalidReassignment, got %v", err)
+	}
+	missing := uint(999)
+	if err := svc.DeleteRole(auditor.ID, &missing); !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("Expected ErrRoleNotFound, got %v", err)
+	}
+	if err := svc.DeleteRole(auditor.ID, &reviewer.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var moved []models.UserRole
+	db.Where("role_id = ?", reviewer.ID).Order("user_id, id").Find(&moved)
+	if len(moved) != 4 {
+		t.Fatalf("Expected four reviewer assignments, got %+v", moved)
+	}
+	if moved[1].UserID != 2 || moved[1].OrganizationID == nil || *moved[1].OrganizationID != orgID {
+		t.Fatalf("Expected the organization assignment to keep its scope, got %+v", moved[1])
+	}
+
+	for _, model := range []interface{}{&models.UserRole{}, &models.RoleApprover{}, &models.RoleInheritance{}} {
+		var count int64
+		db.Model(model).Where("role_id = ?", auditor.ID).Count(&count)
+		if count != 0 {
+			t.Fatalf("Expected %T rows for the deleted role to be removed, got %d", model, count)
+		}
+	}
+	var request models.AccessRequest
+	db.First(&request)
+	if request.Status != models.AccessRequestCancelled {
+		t.Fatalf("Expected the pending request to be cancelled, got %s", request.Status)
+	}
+}
+
+func TestGuardLastAdmin(t *testing.T) {
+	svc, db := newRBACService(t)
+	if err := svc.SeedDefaultData(); err != nil {
+		t.Fatalf("Expected no error seeding, got %v", err)
+	}
+
+	// Without any administrator nothing is blocked.
+	if err := svc.RemoveRoleFromUser(1, ROLE_ID_USER); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	root := models.User{Name: "Root", Email: "root@example.com", Password: "x", IsActive: true}
+	other := models.User{Name: "Other", Email: "other@example.com", Password: "x", IsActive: true}
+	db.Create(&root)
+	db.Create(&other)
+	svc.AssignRoleToUser(root.ID, ROLE_ID_SUPER_ADMIN)
+
+	if err := svc.RemoveRoleFromUser(root.ID, ROLE_ID_SUPER_ADMIN); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("Expected ErrLastAdmin, got %v", err)
+	}
+	var system models.Permission
+	db.Where("name = ?", PermissionSystemAdmin).First(&system)
+	if err := svc.RemovePermissionFromRole(ROLE_ID_SUPER_ADMIN, system.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("Expected removing system:admin to fail, got %v", err)
+	}
+	if perms, _ := svc.GetUserPermissions(root.ID); !HasPermission(perms, PermissionSystemAdmin) {
+		t.Fatal("Expected the refused change to be rolled back")
+	}
+
+	// A second administrator, here through a wildcard grant, lifts the guard
+	// once they are active.
+	wildcard := models.Permission{Name: "*:*"}
+	db.Create(&wildcard)
+	custom, _ := svc.CreateRole("Operator", "")
+	svc.AssignPermissionToRole(custom.ID, wildcard.ID)
+	svc.AssignRoleToUser(other.ID, custom.ID)
+	db.Model(&other).Update("is_active", false)
+	if err := svc.RemoveRoleFromUser(root.ID, ROLE_ID_SUPER_ADMIN); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("Expected a deactivated administrator not to count, got %v", err)
+	}
+	db.Model(&other).Update("is_active", true)
+	if err := svc.RemoveRoleFromUser(root.ID, ROLE_ID_SUPER_ADMIN); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	basic := uint(ROLE_ID_USER)
+	if err := svc.DeleteRole(custom.ID, &basic); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("Expected reassigning the last administrator away to fail, got %v", err)
+	}
+
+	t.Run("sso and rbac.yaml removals", func(t *testing.T) {
+		file, _ := LoadPolicyFile("")
+		if _, err := svc.Reconcile(file, ReconcileOptions{Prune: true}); !errors.Is(err, ErrLastAdmin) {
+			t.Fatalf("Expected pruning the last administrator's role to fail, got %v", err)
+		}
+
+		if _, err := svc.ReconcileExternalRoles(root.ID, RoleSourceSSO, []string{SuperAdminRoleName}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := svc.RemoveRoleFromUser(other.ID, custom.ID); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := svc.ReconcileExternalRoles(root.ID, RoleSourceSSO, nil); !errors.Is(err, ErrLastAdmin) {
+			t.Fatalf("Expected dropping the last administrator's mapped role to fail, got %v", err)
+		}
+		if perms, _ := svc.GetUserPermissions(root.ID); !HasPermission(perms, PermissionSystemAdmin) {
+			t.Fatal("Expected the refused change to be rolled back")
+		}
+	})
+
+	t.Run("organization roles", func(t *testing.T) {
+		db.AutoMigrate(&models.Organization{})
+		organization := models.Organization{Name: "Acme", Slug: "acme"}
+		db.Create(&organization)
+		ctx := tenancy.WithOrganization(context.Background(), organization.ID)
+		db.Create(&models.UserRole{UserID: other.ID, RoleID: ROLE_ID_ADMIN, OrganizationID: &organization.ID})
+
+		err := GuardLastAdmin(db.WithContext(ctx), func(tx *gorm.DB) error {
+			return tx.Scopes(tenancy.Scope).Where("user_id = ?", other.ID).Delete(&models.UserRole{}).Error
+		})
+		if !errors.Is(err, ErrLastOrganizationAdmin) {
+			t.Fatalf("Expected ErrLastOrganizationAdmin, got %v", err)
+		}
+	})
+}
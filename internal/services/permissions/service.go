@@ -30,6 +30,10 @@ func (s *Service) SeedDefaultData() error {
 		return fmt.Errorf("failed to assign role permissions: %w", err)
 	}
 
+	if err := s.seedRoleInheritance(); err != nil {
+		return fmt.Errorf("failed to seed role inheritance: %w", err)
+	}
+
 	return nil
 }
 
@@ -68,6 +72,11 @@ func (s *Service) seedRoles() error {
 			} else {
 				return fmt.Errorf("failed to check role %s: %w", role.Name, err)
 			}
+		} else if role.IsSystem && !existingRole.IsSystem {
+			// Flag built-in roles seeded before system roles existed.
+			if err := s.db.Model(&existingRole).Update("is_system", true).Error; err != nil {
+				return fmt.Errorf("failed to update role %s: %w", role.Name, err)
+			}
 		}
 	}
 	return nil
@@ -110,6 +119,27 @@ func (s *Service) assignRolePermissions() error {
 	return nil
 }
 
+func (s *Service) seedRoleInheritance() error {
+	for roleID, parentIDs := range GetDefaultRoleParents() {
+		for _, parentID := range parentIDs {
+			var existing models.RoleInheritance
+			err := s.db.Where("role_id = ? AND parent_role_id = ?", roleID, parentID).First(&existing).Error
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("failed to check role inheritance: %w", err)
+			}
+
+			edge := models.RoleInheritance{RoleID: roleID, ParentRoleID: parentID, CreatedAt: time.Now()}
+			if err := s.db.Create(&edge).Error; err != nil {
+				return fmt.Errorf("failed to make role %d inherit from role %d: %w", roleID, parentID, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Service) CreateRole(name, description string) (*models.Role, error) {
 	role := &models.Role{
 		Name:        name,
@@ -125,17 +155,41 @@ func (s *Service) CreateRole(name, description string) (*models.Role, error) {
 	return role, nil
 }
 
+// GetRoles returns every role with its direct permissions, parent roles and
+// inherited permissions.
 func (s *Service) GetRoles() ([]models.Role, error) {
 	var roles []models.Role
-	if err := s.db.Preload("Permissions.Permission").Find(&roles).Error; err != nil {
+	if err := s.db.Preload("Permissions.Permission").Preload("Parents.Parent").Find(&roles).Error; err != nil {
 		return nil, fmt.Errorf("failed to get roles: %w", err)
 	}
+
+	refs := make([]*models.Role, len(roles))
+	for i := range roles {
+		refs[i] = &roles[i]
+	}
+	if err := LoadInheritedPermissions(s.db, refs...); err != nil {
+		return nil, err
+	}
 	return roles, nil
 }
 
 func (s *Service) GetRoleByID(id uint) (*models.Role, error) {
 	var role models.Role
-	if err := s.db.Preload("Permissions.Permission").First(&role, id).Error; err != nil {
+	if err := s.db.Preload("Permissions.Permission").Preload("Parents.Parent").First(&role, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	if err := LoadInheritedPermissions(s.db, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (s *Service) GetRoleByName(name string) (*models.Role, error) {
+	var role models.Role
+	if err := s.db.Where("name = ?", name).First(&role).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
@@ -148,11 +202,15 @@ func (s *Service) UpdateRole(id uint, name, description string) (*models.Role, e
 	var role models.Role
 	if err := s.db.First(&role, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("role not found")
+			return nil, ErrRoleNotFound
 		}
 		return nil, fmt.Errorf("failed to find role: %w", err)
 	}
 
+	if role.IsSystem && role.Name != name {
+		return nil, ErrSystemRole
+	}
+
 	role.Name = name
 	role.Description = description
 	role.UpdatedAt = time.Now()
@@ -164,13 +222,58 @@ func (s *Service) UpdateRole(id uint, name, description string) (*models.Role, e
 	return &role, nil
 }
 
-func (s *Service) DeleteRole(id uint) error {
-	if err := s.db.Delete(&models.Role{}, id).Error; err != nil {
-		return fmt.Errorf("failed to delete role: %w", err)
+func (s *Service) SetRoleRequiresMFA(id uint, required bool) error {
+	res := s.db.Model(&models.Role{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"requires_mfa": required,
+		"updated_at":   time.Now(),
+	})
+	if res.Error != nil {
+		return fmt.Errorf("failed to update role: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrRoleNotFound
 	}
 	return nil
 }
 
+// DeleteRole removes a role along with its grants, inheritance, approvers
+// and pending access requests. Users holding the role are moved to
+// reassignTo; without it, deleting a role that is still held fails with
+// ErrRoleInUse.
+func (s *Service) DeleteRole(id uint, reassignTo *uint) error {
+	return GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.First(&role, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("failed to find role: %w", err)
+		}
+		if role.IsSystem {
+			return ErrSystemRole
+		}
+
+		if reassignTo != nil {
+			if err := reassignRole(tx, id, *reassignTo); err != nil {
+				return err
+			}
+		} else {
+			var holders int64
+			if err := tx.Model(&models.UserRole{}).Where("role_id = ?", id).Count(&holders).Error; err != nil {
+				return fmt.Errorf("failed to count role holders: %w", err)
+			}
+			if holders > 0 {
+				return ErrRoleInUse
+			}
+		}
+
+		if err := deleteRole(tx, id); err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		return nil
+	})
+}
+
 func (s *Service) GetPermissions() ([]models.Permission, error) {
 	var permissions []models.Permission
 	if err := s.db.Find(&permissions).Error; err != nil {
@@ -179,70 +282,63 @@ func (s *Service) GetPermissions() ([]models.Permission, error) {
 	return permissions, nil
 }
 
-func (s *Service) AssignRoleToUser(userID, roleID uint) error {
-	var existingUserRole models.UserRole
-	err := s.db.Where("user_id = ? AND role_id = ?", userID, roleID).First(&existingUserRole).Error
-	if err == nil {
-		return errors.New("user already has this role")
-	}
-	if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return fmt.Errorf("failed to check existing user role: %w", err)
-	}
-
-	userRole := models.UserRole{
-		UserID:    userID,
-		RoleID:    roleID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	if err := s.db.Create(&userRole).Error; err != nil {
-		return fmt.Errorf("failed to assign role to user: %w", err)
+func (s *Service) GetPermissionByID(id uint) (*models.Permission, error) {
+	var permission models.Permission
+	if err := s.db.First(&permission, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get permission: %w", err)
 	}
+	return &permission, nil
+}
 
-	return nil
+// AssignRoleToUser grants a permanent global role. Organization roles are
+// managed by the organizations service.
+func (s *Service) AssignRoleToUser(userID, roleID uint) error {
+	return s.GrantRole(userID, roleID, RoleGrant{})
 }
 
 func (s *Service) RemoveRoleFromUser(userID, roleID uint) error {
-	if err := s.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.UserRole{}).Error; err != nil {
-		return fmt.Errorf("failed to remove role from user: %w", err)
-	}
-	return nil
+	return GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND role_id = ? AND organization_id IS NULL", userID, roleID).Delete(&models.UserRole{}).Error; err != nil {
+			return fmt.Errorf("failed to remove role from user: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *Service) GetUserRoles(userID uint) ([]models.Role, error) {
 	var userRoles []models.UserRole
-	if err := s.db.Preload("Role").Where("user_id = ?", userID).Find(&userRoles).Error; err != nil {
+	err := s.db.Preload("Role").Where("user_id = ? AND organization_id IS NULL", userID).Find(&userRoles).Error
+	if err != nil {
 		return nil, fmt.Errorf("failed to get user roles: %w", err)
 	}
 
-	roles := make([]models.Role, len(userRoles))
-	for i, userRole := range userRoles {
-		roles[i] = userRole.Role
+	now := time.Now()
+	roles := make([]models.Role, 0, len(userRoles))
+	for _, userRole := range userRoles {
+		if userRole.ActiveAt(now) {
+			roles = append(roles, userRole.Role)
+		}
 	}
 
 	return roles, nil
 }
 
+// GetUserPermissions returns the permissions granted by the user's global
+// roles, including those the roles inherit.
 func (s *Service) GetUserPermissions(userID uint) ([]string, error) {
-	var userRoles []models.UserRole
-	if err := s.db.Preload("Role.Permissions.Permission").Where("user_id = ?", userID).Find(&userRoles).Error; err != nil {
+	user := models.User{ID: userID}
+	if err := s.db.Preload("Role.Permissions.Permission").Where("user_id = ? AND organization_id IS NULL", userID).Find(&user.UserRoles).Error; err != nil {
 		return nil, fmt.Errorf("failed to get user permissions: %w", err)
 	}
 
-	permissionsMap := make(map[string]bool)
-	for _, userRole := range userRoles {
-		for _, rolePermission := range userRole.Role.Permissions {
-			permissionsMap[rolePermission.Permission.Name] = true
-		}
+	if err := LoadUserInheritedPermissions(s.db, &user); err != nil {
+		return nil, err
 	}
 
-	permissions := make([]string, 0, len(permissionsMap))
-	for permission := range permissionsMap {
-		permissions = append(permissions, permission)
-	}
-
-	return permissions, nil
+	return user.GetPermissions(), nil
 }
 
 func (s *Service) AssignPermissionToRole(roleID, permissionID uint) error {
@@ -270,8 +366,108 @@ func (s *Service) AssignPermissionToRole(roleID, permissionID uint) error {
 }
 
 func (s *Service) RemovePermissionFromRole(roleID, permissionID uint) error {
-	if err := s.db.Where("role_id = ? AND permission_id = ?", roleID, permissionID).Delete(&models.RolePermission{}).Error; err != nil {
-		return fmt.Errorf("failed to remove permission from role: %w", err)
+	return GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ? AND permission_id = ?", roleID, permissionID).Delete(&models.RolePermission{}).Error; err != nil {
+			return fmt.Errorf("failed to remove permission from role: %w", err)
+		}
+		return nil
+	})
+}
+type RoleChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+func (c *RoleChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+// ReconcileExternalRoles makes the user's roles from source match roleNames.
+// Roles assigned from another source are left alone, and a role the user
+// already holds manually is not granted a second time. Grants that are not
+// in effect yet or any more don't count as held. Nothing changes when
+// the removals would leave no one holding system:admin.
+func (s *Service) ReconcileExternalRoles(userID uint, source string, roleNames []string) (*RoleChanges, error) {
+	changes := &RoleChanges{}
+
+	err := GuardLastAdmin(s.db, func(tx *gorm.DB) error {
+		var desired []models.Role
+		if len(roleNames) > 0 {
+			if err := tx.Where("name IN ?", roleNames).Find(&desired).Error; err != nil {
+				return fmt.Errorf("failed to find roles: %w", err)
+			}
+		}
+
+		desiredByID := make(map[uint]models.Role, len(desired))
+		found := make(map[string]bool, len(desired))
+		for _, role := range desired {
+			desiredByID[role.ID] = role
+			found[role.Name] = true
+		}
+		for _, name := range roleNames {
+			if !found[name] {
+				changes.Unknown = append(changes.Unknown, name)
+			}
+		}
+
+		var current []models.UserRole
+		if err := tx.Preload("Role").Where("user_id = ? AND organization_id IS NULL", userID).Find(&current).Error; err != nil {
+			return fmt.Errorf("failed to get user roles: %w", err)
+		}
+
+		now := time.Now()
+		held := make(map[uint]bool, len(current))
+		for _, userRole := range current {
+			if !userRole.ActiveAt(now) {
+				// An ended grant of a mapped role is replaced by a new one
+				// below; a scheduled one is left to start on its own.
+				_, wanted := desiredByID[userRole.RoleID]
+				if wanted && userRole.ValidUntil != nil && !now.Before(*userRole.ValidUntil) {
+					if err := tx.Delete(&models.UserRole{}, userRole.ID).Error; err != nil {
+						return fmt.Errorf("failed to remove expired role from user: %w", err)
+					}
+				}
+				continue
+			}
+			held[userRole.RoleID] = true
+
+			if userRole.Source != source {
+				continue
+			}
+			if _, ok := desiredByID[userRole.RoleID]; ok {
+				continue
+			}
+
+			if err := tx.Delete(&models.UserRole{}, userRole.ID).Error; err != nil {
+				return fmt.Errorf("failed to remove role from user: %w", err)
+			}
+			changes.Removed = append(changes.Removed, userRole.Role.Name)
+		}
+
+		for _, role := range desired {
+			if held[role.ID] {
+				continue
+			}
+
+			userRole := models.UserRole{
+				UserID:    userID,
+				RoleID:    role.ID,
+				Source:    source,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			if err := tx.Create(&userRole).Error; err != nil {
+				return fmt.Errorf("failed to assign role to user: %w", err)
+			}
+			changes.Added = append(changes.Added, role.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
\ No newline at end of file
+
+	return changes, nil
+}
@@ -0,0 +1,227 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const purgeBatchSize = 100
+
+// userOwnedModels holds every table keyed by user_id that is removed when a
+// user is erased. Audit logs and access review items are kept.
+var userOwnedModels = []interface{}{
+	&models.UserRole{},
+	&models.Session{},
+	&models.APIKey{},
+	&models.UserIdentity{},
+	&models.WebAuthnCredential{},
+	&models.WebAuthnChallenge{},
+	&models.BackupCode{},
+	&models.EmailOTP{},
+	&models.PasswordHistory{},
+	&models.Membership{},
+	&models.OrganizationInvitation{},
+	&models.RoleApprover{},
+	&models.AccessRequest{},
+	&models.AccessReviewer{},
+}
+
+// targetUser binds the :id path parameter and refuses to act on the caller's
+// own account.
+func targetUser(c echo.Context) (uint, error) {
+	var params validator.IDParam
+	if err := validator.BindAndValidate(c, &params); err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	if user, ok := c.Get("user").(*models.User); ok && user.ID == params.ID {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "You cannot change your own account status")
+	}
+
+	return params.ID, nil
+}
+
+// lastAdmin refuses a change that would leave no active administrator.
+func lastAdmin(c echo.Context) error {
+	return c.JSON(http.StatusConflict, map[string]string{"error": "At least one active user must keep system:admin"})
+}
+
+func revokeSessions(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// setActive flips IsActive and signs the user out when deactivating. It
+// returns false when the user does not exist.
+func (s *service) setActive(ctx context.Context, userID uint, active bool) (bool, error) {
+	var found bool
+	err := permissions.GuardLastAdmin(s.Database.Conn.WithContext(ctx), func(tx *gorm.DB) error {
+		res := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"is_active":  active,
+			"updated_at": time.Now(),
+		})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		found = true
+
+		if active {
+			return nil
+		}
+		return revokeSessions(tx, userID)
+	})
+
+	return found, err
+}
+
+func (s *service) PostDeactivateUser(c echo.Context) error {
+	return s.changeActive(c, false)
+}
+
+func (s *service) PostActivateUser(c echo.Context) error {
+	return s.changeActive(c, true)
+}
+
+func (s *service) changeActive(c echo.Context, active bool) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	userID, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	found, err := s.setActive(ctx, userID, active)
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		return lastAdmin(c)
+	}
+	if err != nil {
+		lgr.Error("failed to update user status", zap.Error(err), zap.Uint("userId", userID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	lgr.Info("user status changed", zap.Uint("userId", userID), zap.Bool("active", active))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "User status updated",
+		"isActive": active,
+	})
+}
+
+// DeleteUser soft-deletes the user and signs them out. The account can be
+// restored until PurgeDeletedUsers erases it.
+func (s *service) DeleteUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	userID, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	err = permissions.GuardLastAdmin(s.Database.Conn.WithContext(ctx), func(tx *gorm.DB) error {
+		res := tx.Delete(&models.User{}, userID)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		found = true
+		return revokeSessions(tx, userID)
+	})
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		return lastAdmin(c)
+	}
+	if err != nil {
+		lgr.Error("failed to delete user", zap.Error(err), zap.Uint("userId", userID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	lgr.Info("user deleted", zap.Uint("userId", userID))
+	return c.JSON(http.StatusOK, map[string]string{"message": "User deleted successfully"})
+}
+
+// PostRestoreUser undoes a soft delete. It fails with 409 when another
+// account has since signed up with the same email.
+func (s *service) PostRestoreUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	userID, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	db := s.Database.Conn.WithContext(ctx)
+
+	var user models.User
+	err = db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL AND erased_at IS NULL", userID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Deleted user not found"})
+	}
+	if err != nil {
+		lgr.Error("failed to find deleted user", zap.Error(err), zap.Uint("userId", userID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	existing, err := s.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		lgr.Error("failed to get user by email", zap.Error(err))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if existing != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Another account now uses this email address"})
+	}
+
+	err = db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"deleted_at": nil,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		lgr.Error("failed to restore user", zap.Error(err), zap.Uint("userId", userID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	lgr.Info("user restored", zap.Uint("userId", userID))
+	return c.JSON(http.StatusOK, map[string]string{"message": "User restored successfully"})
+}
+
+// PurgeDeletedUsers erases users soft-deleted more than the retention period
+// ago. See eraseUser for what is kept.
+func (s *service) PurgeDeletedUsers(ctx context.Context) error {
+	cutoff := time.Now().Add(-time.Duration(s.DeletedUserRetentionDays) * 24 * time.Hour)
+
+	for {
+		var ids []uint
+		err := s.Database.Conn.WithContext(ctx).Unscoped().Model(&models.User{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ? AND erased_at IS NULL", cutoff).
+			Limit(purgeBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := s.eraseUser(ctx, id); err != nil {
+				return err
+			}
+		}
+
+		logger.ContextLogger(ctx, s.Logger).Info("purged deleted users", zap.Int("count", len(ids)))
+	}
+}
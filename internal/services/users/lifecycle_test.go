@@ -0,0 +1,176 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+// callAs runs handler for caller with the :id parameter set and returns the
+// response status, including statuses returned as echo errors.
+func callAs(t *testing.T, handler echo.HandlerFunc, caller *models.User, id uint) int {
+	t.Helper()
+	e := echo.New()
+	e.Validator = validator.NewValidator()
+
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.FormatUint(uint64(id), 10))
+	c.Set("user", caller)
+
+	if err := handler(c); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code
+		}
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return rec.Code
+}
+
+// seedLifecycleUsers creates an administrator and a regular user with an
+// active session each.
+func seedLifecycleUsers(t *testing.T, service *service) (admin, user *models.User) {
+	t.Helper()
+	perms := permissions.NewService(service.Database.Conn)
+	if err := perms.SeedDefaultData(); err != nil {
+		t.Fatalf("Failed to seed roles: %v", err)
+	}
+
+	admin = &models.User{Name: "Admin", Email: "admin@example.com", Password: "x", IsActive: true}
+	user = &models.User{Name: "User", Email: "user@example.com", Password: "x", IsActive: true}
+	for _, u := range []*models.User{admin, user} {
+		if err := service.Database.Conn.Create(u).Error; err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		service.Database.Conn.Create(&models.Session{UserID: u.ID, AuthenticatedAt: time.Now(), LastSeenAt: time.Now()})
+	}
+	if err := perms.AssignRoleToUser(admin.ID, permissions.ROLE_ID_SUPER_ADMIN); err != nil {
+		t.Fatalf("Failed to assign role: %v", err)
+	}
+	return admin, user
+}
+
+func activeSessions(service *service, userID uint) int64 {
+	var count int64
+	service.Database.Conn.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count)
+	return count
+}
+
+func TestDeactivateUser(t *testing.T) {
+	service, database := setupTestService(t)
+	admin, user := seedLifecycleUsers(t, service)
+
+	if code := callAs(t, service.PostDeactivateUser, admin, admin.ID); code != http.StatusBadRequest {
+		t.Fatalf("Expected deactivating yourself to be refused, got %d", code)
+	}
+	if code := callAs(t, service.PostDeactivateUser, admin, 999); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown user, got %d", code)
+	}
+
+	if code := callAs(t, service.PostDeactivateUser, admin, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected deactivation to succeed, got %d", code)
+	}
+	found, _ := service.GetUserByID(context.Background(), user.ID)
+	if found.IsActive || activeSessions(service, user.ID) != 0 {
+		t.Fatalf("Expected the user to be inactive and signed out, got %+v", found)
+	}
+
+	if code := callAs(t, service.PostDeactivateUser, user, admin.ID); code != http.StatusConflict {
+		t.Fatalf("Expected deactivating the last administrator to be refused, got %d", code)
+	}
+	var stored models.User
+	database.Conn.First(&stored, admin.ID)
+	if !stored.IsActive || activeSessions(service, admin.ID) != 1 {
+		t.Fatal("Expected the refused deactivation to change nothing")
+	}
+
+	if code := callAs(t, service.PostActivateUser, admin, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected activation to succeed, got %d", code)
+	}
+	found, _ = service.GetUserByID(context.Background(), user.ID)
+	if !found.IsActive {
+		t.Fatal("Expected the user to be active again")
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	service, database := setupTestService(t)
+	admin, user := seedLifecycleUsers(t, service)
+	ctx := context.Background()
+
+	if code := callAs(t, service.DeleteUser, admin, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected delete to succeed, got %d", code)
+	}
+	if found, _ := service.GetUserByID(ctx, user.ID); found != nil {
+		t.Fatal("Expected a soft-deleted user to be hidden")
+	}
+	if activeSessions(service, user.ID) != 0 {
+		t.Fatal("Expected a soft-deleted user to be signed out")
+	}
+	var deleted models.User
+	if err := database.Conn.Unscoped().First(&deleted, user.ID).Error; err != nil || !deleted.DeletedAt.Valid {
+		t.Fatalf("Expected the row to be kept with deleted_at set, got %+v (%v)", deleted, err)
+	}
+
+	if code := callAs(t, service.DeleteUser, user, admin.ID); code != http.StatusConflict {
+		t.Fatalf("Expected deleting the last administrator to be refused, got %d", code)
+	}
+
+	t.Run("restore fails when the email was reused", func(t *testing.T) {
+		reused := &models.User{Name: "Reused", Email: user.Email, Password: "x", IsActive: true}
+		database.Conn.Create(reused)
+		t.Cleanup(func() { database.Conn.Unscoped().Delete(reused) })
+
+		if code := callAs(t, service.PostRestoreUser, admin, user.ID); code != http.StatusConflict {
+			t.Fatalf("Expected 409 when the email is taken, got %d", code)
+		}
+	})
+
+	if code := callAs(t, service.PostRestoreUser, admin, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected restore to succeed, got %d", code)
+	}
+	if found, _ := service.GetUserByID(ctx, user.ID); found == nil {
+		t.Fatal("Expected the restored user to be visible")
+	}
+	if code := callAs(t, service.PostRestoreUser, admin, user.ID); code != http.StatusNotFound {
+		t.Fatalf("Expected restoring an undeleted user to return 404, got %d", code)
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	service, database := setupTestService(t)
+	admin, user := seedLifecycleUsers(t, service)
+
+	recent := &models.User{Name: "Recent", Email: "recent@example.com", Password: "x", IsActive: true}
+	database.Conn.Create(recent)
+	database.Conn.Delete(recent)
+	database.Conn.Delete(user)
+	expired := time.Now().Add(-time.Duration(service.DeletedUserRetentionDays+1) * 24 * time.Hour)
+	database.Conn.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Update("deleted_at", expired)
+
+	if err := service.PurgeDeletedUsers(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var purged, kept models.User
+	database.Conn.Unscoped().First(&purged, user.ID)
+	database.Conn.Unscoped().First(&kept, recent.ID)
+	if purged.ErasedAt == nil || purged.Email == user.Email || purged.Name != erasedUserName {
+		t.Fatalf("Expected the expired user to be erased, got %+v", purged)
+	}
+	if kept.ErasedAt != nil || kept.Email != recent.Email {
+		t.Fatalf("Expected the recently deleted user to be kept, got %+v", kept)
+	}
+	if code := callAs(t, service.PostRestoreUser, admin, user.ID); code != http.StatusNotFound {
+		t.Fatalf("Expected an erased user not to be restorable, got %d", code)
+	}
+}
@@ -0,0 +1,290 @@
+package users
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/common/logger"
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const erasedUserName = "Erased user"
+
+// DataExport is everything stored about a user, as handed to them by
+// GetDataExport. Secrets such as password and key hashes are never included.
+type DataExport struct {
+	ExportedAt          time.Time                   `json:"exportedAt"`
+	Profile             models.User                 `json:"profile"`
+	Roles               []models.UserRole           `json:"roles"`
+	Memberships         []models.Membership         `json:"memberships"`
+	AccessRequests      []models.AccessRequest      `json:"accessRequests"`
+	Sessions            []models.Session            `json:"sessions"`
+	APIKeys             []models.APIKey             `json:"apiKeys"`
+	Identities          []models.UserIdentity       `json:"identities"`
+	WebAuthnCredentials []models.WebAuthnCredential `json:"webauthnCredentials"`
+	Security            SecurityExport              `json:"security"`
+	AuditEvents         []models.AuditLog           `json:"auditEvents"`
+}
+
+// SecurityExport summarizes credentials that are only stored as hashes.
+type SecurityExport struct {
+	TwoFactorEnabled     bool   `json:"twoFactorEnabled"`
+	TwoFactorMethod      string `json:"twoFactorMethod"`
+	UnusedBackupCodes    int64  `json:"unusedBackupCodes"`
+	PasswordHistoryCount int64  `json:"passwordHistoryCount"`
+}
+
+func (s *service) exportUserData(ctx context.Context, userID uint) (*DataExport, error) {
+	// Every query is limited to the user's own rows, in any organization.
+	db := s.Database.Conn.WithContext(tenancy.WithCrossTenant(ctx))
+	export := &DataExport{ExportedAt: time.Now()}
+
+	if err := db.First(&export.Profile, userID).Error; err != nil {
+		return nil, err
+	}
+
+	queries := []struct {
+		dest  interface{}
+		query *gorm.DB
+	}{
+		{&export.Roles, db.Preload("Role").Where("user_id = ?", userID)},
+		{&export.Memberships, db.Preload("Organization").Where("user_id = ?", userID)},
+		{&export.AccessRequests, db.Preload("Role").Where("user_id = ?", userID).Order("created_at desc")},
+		{&export.Sessions, db.Where("user_id = ?", userID).Order("created_at desc")},
+		{&export.APIKeys, db.Where("user_id = ?", userID).Order("created_at desc")},
+		{&export.Identities, db.Where("user_id = ?", userID)},
+		{&export.WebAuthnCredentials, db.Where("user_id = ?", userID)},
+		{&export.AuditEvents, db.Where("user_id = ? OR actor_id = ?", userID, userID).Order("created_at desc")},
+	}
+	for _, q := range queries {
+		if err := q.query.Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	export.Security.TwoFactorEnabled = export.Profile.TwoFactorEnabled
+	export.Security.TwoFactorMethod = export.Profile.TwoFactorMethod
+	err := db.Model(&models.BackupCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&export.Security.UnusedBackupCodes).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).Count(&export.Security.PasswordHistoryCount).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// writeExportZip writes each section of the export as its own JSON file.
+func writeExportZip(w *zip.Writer, export *DataExport) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"roles.json", export.Roles},
+		{"memberships.json", export.Memberships},
+		{"access_requests.json", export.AccessRequests},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.APIKeys},
+		{"identities.json", export.Identities},
+		{"webauthn_credentials.json", export.WebAuthnCredentials},
+		{"security.json", export.Security},
+		{"audit_events.json", export.AuditEvents},
+	}
+
+	for _, f := range files {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// GetDataExport returns the signed-in user's data as JSON, or as a ZIP of
+// JSON files with ?format=zip.
+func (s *service) GetDataExport(c echo.Context) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "zip" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Format must be json or zip")
+	}
+
+	export, err := s.exportUserData(ctx, user.ID)
+	if err != nil {
+		lgr.Error("failed to export user data", zap.Error(err), zap.Uint("userId", user.ID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	s.recordPrivacyEvent(c, audit.ActionUserExported, user.ID, map[string]interface{}{"format": format})
+
+	filename := fmt.Sprintf("user-%d-export-%s", user.ID, export.ExportedAt.Format("20060102"))
+	if format != "zip" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		return c.JSON(http.StatusOK, export)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	c.Response().WriteHeader(http.StatusOK)
+	if err := writeExportZip(zip.NewWriter(c.Response()), export); err != nil {
+		lgr.Error("failed to write export archive", zap.Error(err), zap.Uint("userId", user.ID))
+	}
+	return nil
+}
+
+// eraseUser anonymizes the user's row in place and deletes everything else
+// they own. The row itself is kept, deactivated and soft-deleted, so audit
+// entries and UserRole.AssignedBy keep pointing at a valid user ID.
+func (s *service) eraseUser(ctx context.Context, userID uint) (bool, error) {
+	var found bool
+	// Erasure reaches the user's rows in every organization.
+	ctx = tenancy.WithCrossTenant(ctx)
+	err := permissions.GuardLastAdmin(s.Database.Conn.WithContext(ctx), func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Unscoped().Where("id = ? AND erased_at IS NULL", userID).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		for _, model := range userOwnedModels {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&models.AuditLog{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"ip_address": "", "details": nil}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.AuditLog{}).Where("actor_id = ?", userID).Update("ip_address", "").Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		deletedAt := user.DeletedAt
+		if !deletedAt.Valid {
+			deletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		}
+
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"name":                      erasedUserName,
+			"email":                     fmt.Sprintf("erased-%d@erased.invalid", userID),
+			"password":                  "",
+			"last_login":                time.Time{},
+			"email_confirmed":           false,
+			"email_confirm_token":       "",
+			"password_reset_token":      "",
+			"password_reset_expires_at": time.Time{},
+			"is_active":                 false,
+			"two_factor_enabled":        false,
+			"two_factor_secret":         "",
+			"pending_two_factor_method": "",
+			"pending_two_factor_secret": "",
+			"two_factor_last_used_step": 0,
+			"erased_at":                 now,
+			"deleted_at":                deletedAt,
+			"updated_at":                now,
+		}).Error
+	})
+
+	return found, err
+}
+
+// PostEraseMe erases the signed-in user's account. It cannot be undone.
+func (s *service) PostEraseMe(c echo.Context) error {
+	user, ok := c.Get("user").(*models.User)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	return s.erase(c, user.ID)
+}
+
+// PostEraseUser erases another user's account, whether or not it has been
+// soft-deleted.
+func (s *service) PostEraseUser(c echo.Context) error {
+	userID, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	return s.erase(c, userID)
+}
+
+func (s *service) erase(c echo.Context, userID uint) error {
+	ctx := c.Request().Context()
+	lgr := logger.ContextLogger(ctx, s.Logger)
+
+	found, err := s.eraseUser(ctx, userID)
+	if errors.Is(err, permissions.ErrLastAdmin) {
+		return lastAdmin(c)
+	}
+	if err != nil {
+		lgr.Error("failed to erase user", zap.Error(err), zap.Uint("userId", userID))
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	s.recordPrivacyEvent(c, audit.ActionUserErased, userID, nil)
+
+	lgr.Info("user erased", zap.Uint("userId", userID))
+	return c.JSON(http.StatusOK, map[string]string{"message": "User data erased"})
+}
+
+func (s *service) recordPrivacyEvent(c echo.Context, action string, userID uint, details map[string]interface{}) {
+	ctx := c.Request().Context()
+
+	entry := &models.AuditLog{
+		UserID:     &userID,
+		Action:     action,
+		Resource:   audit.ResourceUser,
+		ResourceID: fmt.Sprint(userID),
+		Details:    details,
+	}
+	if actor, ok := c.Get("user").(*models.User); ok {
+		entry.ActorID = &actor.ID
+	}
+	// Users erasing themselves leave no IP address behind.
+	if action != audit.ActionUserErased || entry.ActorID == nil || *entry.ActorID != userID {
+		entry.IPAddress = c.RealIP()
+	}
+
+	if err := s.Audit.Record(ctx, entry); err != nil {
+		logger.ContextLogger(ctx, s.Logger).Error("failed to record audit log", zap.Error(err), zap.String("action", action))
+	}
+}
@@ -0,0 +1,165 @@
+package users
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/labstack/echo/v4"
+)
+
+func exportAs(t *testing.T, service *service, user *models.User, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/user/export?format="+format, nil), rec)
+	c.Set("user", user)
+
+	if err := service.GetDataExport(c); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			rec.Code = he.Code
+			return rec
+		}
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return rec
+}
+
+// seedUserData gives the user one row in each table the export and erasure
+// cover, with the tenant-owned ones in an organization of their own.
+func seedUserData(t *testing.T, service *service, user *models.User) {
+	t.Helper()
+	now := time.Now()
+
+	organization := &models.Organization{Name: "Acme", Slug: "acme"}
+	if err := service.Database.Conn.Create(organization).Error; err != nil {
+		t.Fatalf("Failed to seed organization: %v", err)
+	}
+	conn := service.Database.Conn.WithContext(tenancy.WithOrganization(context.Background(), organization.ID))
+
+	rows := []interface{}{
+		&models.APIKey{UserID: user.ID, Name: "CI", Prefix: "abc123", SecretHash: "secret-hash", Scopes: []string{"report:read"}},
+		&models.BackupCode{UserID: user.ID, CodeHash: "backup-hash", CreatedAt: now},
+		&models.PasswordHistory{UserID: user.ID, PasswordHash: "old-hash", CreatedAt: now},
+		&models.AuditLog{UserID: &user.ID, Action: "user.updated", Resource: audit.ResourceUser, IPAddress: "203.0.113.7", Details: map[string]interface{}{"field": "name"}},
+		&models.Membership{UserID: user.ID, CreatedAt: now},
+		&models.OrganizationInvitation{UserID: user.ID, ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+	}
+	for _, row := range rows {
+		if err := conn.Create(row).Error; err != nil {
+			t.Fatalf("Failed to seed %T: %v", row, err)
+		}
+	}
+}
+
+func TestDataExport(t *testing.T) {
+	service, database := setupTestService(t)
+	_, user := seedLifecycleUsers(t, service)
+	seedUserData(t, service, user)
+
+	rec := exportAs(t, service, user, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get(echo.HeaderContentDisposition), ".json") {
+		t.Fatalf("Expected a JSON attachment, got %d %v", rec.Code, rec.Header())
+	}
+	for _, secret := range []string{"secret-hash", "backup-hash", "old-hash", `"password"`} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Fatalf("Expected %s to be left out of the export", secret)
+		}
+	}
+
+	var export DataExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("Failed to unmarshal export: %v", err)
+	}
+	if export.Profile.Email != user.Email || len(export.Sessions) != 1 || len(export.APIKeys) != 1 || len(export.AuditEvents) != 1 || len(export.Memberships) != 1 {
+		t.Fatalf("Expected the user's profile, session, key, membership and audit event, got %+v", export)
+	}
+	if export.Security.UnusedBackupCodes != 1 || export.Security.PasswordHistoryCount != 1 {
+		t.Fatalf("Expected hashed credentials to be summarized, got %+v", export.Security)
+	}
+
+	var logged int64
+	database.Conn.Model(&models.AuditLog{}).Where("user_id = ? AND action = ?", user.ID, audit.ActionUserExported).Count(&logged)
+	if logged != 1 {
+		t.Fatalf("Expected the export to be audited once, got %d", logged)
+	}
+
+	t.Run("zip holds one file per section", func(t *testing.T) {
+		rec := exportAs(t, service, user, "zip")
+		if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "application/zip" {
+			t.Fatalf("Expected a zip archive, got %d %v", rec.Code, rec.Header())
+		}
+		archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		var names []string
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		if len(names) != 10 || names[0] != "access_requests.json" || names[len(names)-1] != "webauthn_credentials.json" {
+			t.Fatalf("Expected ten section files, got %v", names)
+		}
+	})
+
+	t.Run("unknown format is rejected", func(t *testing.T) {
+		if rec := exportAs(t, service, user, "csv"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", rec.Code)
+		}
+	})
+}
+
+func TestEraseUser(t *testing.T) {
+	service, database := setupTestService(t)
+	admin, user := seedLifecycleUsers(t, service)
+	seedUserData(t, service, user)
+
+	if code := callAs(t, service.PostEraseUser, admin, 999); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown user, got %d", code)
+	}
+	if code := callAs(t, service.PostEraseUser, user, admin.ID); code != http.StatusConflict {
+		t.Fatalf("Expected erasing the last administrator to be refused, got %d", code)
+	}
+
+	if code := callAs(t, service.PostEraseUser, admin, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected erasure to succeed, got %d", code)
+	}
+
+	var erased models.User
+	database.Conn.Unscoped().First(&erased, user.ID)
+	if erased.Name != erasedUserName || erased.Email == user.Email || erased.Password != "" || erased.IsActive || erased.ErasedAt == nil || !erased.DeletedAt.Valid {
+		t.Fatalf("Expected the user row to be anonymized, deactivated and deleted, got %+v", erased)
+	}
+
+	unscoped := database.Conn.WithContext(tenancy.WithCrossTenant(context.Background()))
+	for _, model := range userOwnedModels {
+		var count int64
+		unscoped.Model(model).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Fatalf("Expected %T rows to be deleted, got %d", model, count)
+		}
+	}
+
+	var entries []models.AuditLog
+	database.Conn.Where("user_id = ?", user.ID).Order("id").Find(&entries)
+	if len(entries) != 2 || entries[0].IPAddress != "" || entries[0].Details != nil {
+		t.Fatalf("Expected earlier audit entries to be scrubbed, got %+v", entries)
+	}
+	if entries[1].Action != audit.ActionUserErased || entries[1].ActorID == nil || *entries[1].ActorID != admin.ID {
+		t.Fatalf("Expected the erasure to be audited with the admin as actor, got %+v", entries[1])
+	}
+
+	if code := callAs(t, service.PostEraseUser, admin, user.ID); code != http.StatusNotFound {
+		t.Fatalf("Expected erasing twice to return 404, got %d", code)
+	}
+}
@@ -6,16 +6,22 @@ import (
 
 	"github.com/feezyhendrix/echoboilerplate/internal/db"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-type Config struct{}
+type Config struct {
+	DeletedUserRetentionDays int `envconfig:"USERS_DELETED_RETENTION_DAYS" default:"30"`
+	PurgeIntervalSecs        int `envconfig:"USERS_PURGE_INTERVAL_SEC" default:"3600"` // 1 hour default
+}
 
 type Dependencies struct {
 	Database db.DB
 	Logger   *zap.Logger
+	Audit    *audit.Service
 }
 
 type service struct {
@@ -31,6 +37,14 @@ type Service interface {
 	UpdateUserPassword(ctx context.Context, userID uint, hashedPassword string) error
 	UpdateUser2FA(ctx context.Context, userID uint, enabled bool, secret string) error
 	GetUserProfile(ctx echo.Context) error
+	PostDeactivateUser(c echo.Context) error
+	PostActivateUser(c echo.Context) error
+	DeleteUser(c echo.Context) error
+	PostRestoreUser(c echo.Context) error
+	PurgeDeletedUsers(ctx context.Context) error
+	GetDataExport(c echo.Context) error
+	PostEraseMe(c echo.Context) error
+	PostEraseUser(c echo.Context) error
 }
 
 func New(cfg *Config, deps *Dependencies) Service {
@@ -98,8 +112,27 @@ func (s *service) GetUserProfile(c echo.Context) error {
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user profile")
 	}
+	if err := permissions.LoadUserInheritedPermissions(s.Database.Conn, &fullUser); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user profile")
+	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"user": fullUser,
-	})
+	}
+	// Lets the SPA show that an administrator is acting as this user.
+	if impersonator, ok := c.Get("impersonator").(*models.User); ok {
+		impersonation := map[string]interface{}{
+			"impersonator": map[string]interface{}{
+				"id":    impersonator.ID,
+				"name":  impersonator.Name,
+				"email": impersonator.Email,
+			},
+		}
+		if session, ok := c.Get("session").(*models.Session); ok {
+			impersonation["expiresAt"] = session.ExpiresAt
+		}
+		response["impersonation"] = impersonation
+	}
+
+	return c.JSON(http.StatusOK, response)
 }
\ No newline at end of file
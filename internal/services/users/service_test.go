@@ -3,47 +3,55 @@ package users
 import (
 	"context"
 	"testing"
-	"time"
 
+	"github.com/feezyhendrix/echoboilerplate/internal/db"
+	"github.com/feezyhendrix/echoboilerplate/internal/db/tenancy"
 	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
-type mockDB struct {
-	db *gorm.DB
-}
-
-func (m *mockDB) Connection() *gorm.DB {
-	return m.db
-}
-
-func setupTestDB(t *testing.T) *mockDB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+// setupTestDB opens a migrated in-memory database. Each test gets its own.
+func setupTestDB(t *testing.T) db.DB {
+	conn, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
 	if err != nil {
 		t.Fatal("Failed to connect to test database:", err)
 	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
 
-	err = db.AutoMigrate(&models.User{})
-	if err != nil {
+	if err := tenancy.Register(conn); err != nil {
+		t.Fatal("Failed to register tenancy callbacks:", err)
+	}
+
+	database := db.DB{Conn: conn}
+	if err := database.MigrateAllFields(); err != nil {
 		t.Fatal("Failed to migrate test database:", err)
 	}
 
-	return &mockDB{db: db}
+	return database
 }
 
-func setupTestService(t *testing.T) (*service, *mockDB) {
+func setupTestService(t *testing.T) (*service, db.DB) {
 	logger := zap.NewNop()
-	mockDB := setupTestDB(t)
+	database := setupTestDB(t)
 
-	cfg := &Config{}
+	cfg := &Config{DeletedUserRetentionDays: 30}
 	deps := &Dependencies{
-		Database: mockDB,
+		Database: database,
 		Logger:   logger,
+		Audit:    audit.NewService(database.Conn),
 	}
 
-	return New(cfg, deps).(*service), mockDB
+	return New(cfg, deps).(*service), database
 }
 
 func TestGetUserByEmail(t *testing.T) {
@@ -56,7 +64,7 @@ func TestGetUserByEmail(t *testing.T) {
 		Password: "hashedpassword",
 		IsActive: true,
 	}
-	db.Connection().Create(user)
+	db.Conn.Create(user)
 
 	t.Run("user found", func(t *testing.T) {
 		result, err := service.GetUserByEmail(ctx, "test@example.com")
@@ -99,7 +107,7 @@ func TestGetUserByID(t *testing.T) {
 		Password: "hashedpassword",
 		IsActive: true,
 	}
-	db.Connection().Create(user)
+	db.Conn.Create(user)
 
 	t.Run("user found", func(t *testing.T) {
 		result, err := service.GetUserByID(ctx, user.ID)
@@ -155,7 +163,7 @@ func TestCreateUser(t *testing.T) {
 
 		// Verify user was created
 		var foundUser models.User
-		err = service.Database.Connection().Where("email = ?", "create@example.com").First(&foundUser).Error
+		err = service.Database.Conn.Where("email = ?", "create@example.com").First(&foundUser).Error
 		if err != nil {
 			t.Fatalf("User was not created: %v", err)
 		}
@@ -200,7 +208,7 @@ func TestUpdateUser(t *testing.T) {
 		Password: "hashedpassword",
 		IsActive: true,
 	}
-	db.Connection().Create(user)
+	db.Conn.Create(user)
 
 	t.Run("successful user update", func(t *testing.T) {
 		user.Name = "Updated Name"
@@ -212,7 +220,7 @@ func TestUpdateUser(t *testing.T) {
 		}
 
 		var foundUser models.User
-		err = service.Database.Connection().Where("id = ?", user.ID).First(&foundUser).Error
+		err = service.Database.Conn.Where("id = ?", user.ID).First(&foundUser).Error
 		if err != nil {
 			t.Fatalf("Failed to find updated user: %v", err)
 		}
@@ -237,7 +245,7 @@ func TestUpdateUserPassword(t *testing.T) {
 		Password: "oldhashedpassword",
 		IsActive: true,
 	}
-	db.Connection().Create(user)
+	db.Conn.Create(user)
 
 	t.Run("successful password update", func(t *testing.T) {
 		newPassword := "newhashedpassword"
@@ -248,7 +256,7 @@ func TestUpdateUserPassword(t *testing.T) {
 		}
 
 		var foundUser models.User
-		err = service.Database.Connection().Where("id = ?", user.ID).First(&foundUser).Error
+		err = service.Database.Conn.Where("id = ?", user.ID).First(&foundUser).Error
 		if err != nil {
 			t.Fatalf("Failed to find user: %v", err)
 		}
@@ -278,7 +286,7 @@ func TestUpdateUser2FA(t *testing.T) {
 		TwoFactorSecret:  "",
 		IsActive:         true,
 	}
-	db.Connection().Create(user)
+	db.Conn.Create(user)
 
 	t.Run("enable 2FA", func(t *testing.T) {
 		secret := "TESTSECRET123456"
@@ -289,7 +297,7 @@ func TestUpdateUser2FA(t *testing.T) {
 		}
 
 		var foundUser models.User
-		err = service.Database.Connection().Where("id = ?", user.ID).First(&foundUser).Error
+		err = service.Database.Conn.Where("id = ?", user.ID).First(&foundUser).Error
 		if err != nil {
 			t.Fatalf("Failed to find user: %v", err)
 		}
@@ -310,7 +318,7 @@ func TestUpdateUser2FA(t *testing.T) {
 		}
 
 		var foundUser models.User
-		err = service.Database.Connection().Where("id = ?", user.ID).First(&foundUser).Error
+		err = service.Database.Conn.Where("id = ?", user.ID).First(&foundUser).Error
 		if err != nil {
 			t.Fatalf("Failed to find user: %v", err)
 		}
@@ -330,4 +338,4 @@ func TestUpdateUser2FA(t *testing.T) {
 			t.Fatalf("Expected no error for non-existent user, got %v", err)
 		}
 	})
-}
\ No newline at end of file
+}
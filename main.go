@@ -7,12 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/feezyhendrix/echoboilerplate/internal/api"
 	environment "github.com/feezyhendrix/echoboilerplate/internal/common/environment"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/jobs"
+	"github.com/feezyhendrix/echoboilerplate/internal/common/passwords"
 	"github.com/feezyhendrix/echoboilerplate/internal/db"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessrequests"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/accessreviews"
+	"github.com/feezyhendrix/echoboilerplate/internal/models"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/audit"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/authentication"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/email"
+	"github.com/feezyhendrix/echoboilerplate/internal/services/organizations"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/permissions"
 	"github.com/feezyhendrix/echoboilerplate/internal/services/users"
 	"github.com/feezyhendrix/echoboilerplate/internal/common/validator"
@@ -29,6 +37,10 @@ type Config struct {
 	AuthenticationConfig *authentication.Config
 	EmailConfig          *email.Config
 	UserConfig           *users.Config
+	PermissionsConfig    *permissions.Config
+	AccessReviewsConfig  *accessreviews.Config
+	PasswordsConfig      *passwords.Config
+	PasswordPolicyConfig *passwords.PolicyConfig
 	LogLevel             string                      `envconfig:"LOG_LEVEL" default:"error"`
 	Environment          environment.EnvironmentType `envconfig:"ENVIRONMENT" default:"production"`
 }
@@ -64,23 +76,18 @@ func main() {
 
 	valdtr := validator.NewValidator()
 
+	auditSvc := audit.NewService(dbConn.Conn)
+
 	userSvc := users.New(cfg.UserConfig, &users.Dependencies{
 		Database: *dbConn,
 		Logger:   lgr,
+		Audit:    auditSvc,
 	})
 
 	emailSvc := email.New(cfg.EmailConfig, &email.Dependencies{
 		Logger: lgr,
 	})
 
-	authSvc := authentication.New(cfg.AuthenticationConfig, &authentication.Dependencies{
-		Logger:   lgr,
-		Validate: valdtr.Validator,
-		Database: *dbConn,
-		Users:    userSvc,
-		Email:    emailSvc,
-	})
-
 	permissionsSvc := permissions.NewService(dbConn.Conn)
 	
 	err = permissionsSvc.SeedDefaultData()
@@ -88,13 +95,63 @@ func main() {
 		lgr.Warn("failed to seed default permissions data", zap.Error(err))
 	}
 
+	hasher, err := passwords.New(*cfg.PasswordsConfig)
+	if err != nil {
+		lgr.Fatal("Failed to configure password hashing", zap.Error(err))
+	}
+
+	passwordPolicy, err := passwords.NewPolicy(*cfg.PasswordPolicyConfig)
+	if err != nil {
+		lgr.Fatal("Failed to configure password policy", zap.Error(err))
+	}
+
+	authSvc := authentication.New(cfg.AuthenticationConfig, &authentication.Dependencies{
+		Logger:         lgr,
+		Validate:       valdtr.Validator,
+		Database:       *dbConn,
+		Users:          userSvc,
+		Email:          emailSvc,
+		Permissions:    permissionsSvc,
+		Audit:          auditSvc,
+		Passwords:      hasher,
+		PasswordPolicy: passwordPolicy,
+	})
+
+	accessReviewsSvc := accessreviews.NewService(cfg.AccessReviewsConfig, &accessreviews.Dependencies{
+		DB:     dbConn.Conn,
+		Logger: lgr,
+		Audit:  auditSvc,
+	})
+
 	deps := &api.Dependencies{
 		Logger:            lgr,
 		Database:          *dbConn,
 		AuthenticationSvc: authSvc,
 		UsersSvc:          userSvc,
 		PermissionsSvc:    permissionsSvc,
+		AuditSvc:          auditSvc,
+		OrganizationsSvc:  organizations.NewService(dbConn.Conn),
+		AccessRequestsSvc: accessrequests.NewService(&accessrequests.Dependencies{
+			DB:     dbConn.Conn,
+			Logger: lgr,
+			Email:  emailSvc,
+			Audit:  auditSvc,
+		}),
+		AccessReviewsSvc: accessReviewsSvc,
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go jobs.Every(jobsCtx, lgr, "prune-revoked-tokens", time.Second*time.Duration(cfg.AuthenticationConfig.DenylistPruneIntervalSecs), authSvc.PruneRevokedTokens)
+	go jobs.Every(jobsCtx, lgr, "purge-deleted-users", time.Second*time.Duration(cfg.UserConfig.PurgeIntervalSecs), userSvc.PurgeDeletedUsers)
+
+	notifyRoleExpiry := func(ctx context.Context, userRole models.UserRole) error {
+		return emailSvc.SendRoleExpiringEmail(ctx, userRole.User.Email, userRole.User.Name, userRole.Role.Name, *userRole.ValidUntil)
 	}
+	expireRoles := permissionsSvc.ExpireRoleAssignments(time.Hour*time.Duration(cfg.PermissionsConfig.ExpiryNoticeHours), notifyRoleExpiry, auditSvc)
+	go jobs.Every(jobsCtx, lgr, "expire-role-assignments", time.Second*time.Duration(cfg.PermissionsConfig.ExpiryIntervalSecs), expireRoles)
+	go jobs.Every(jobsCtx, lgr, "close-due-access-reviews", time.Second*time.Duration(cfg.AccessReviewsConfig.CloseIntervalSecs), accessReviewsSvc.CloseDueCampaigns)
 
 	a := api.New(cfg.APIConfig, deps)
 	chn := make(chan os.Signal, 1)
@@ -108,6 +165,7 @@ func main() {
 	go func() {
 		sig := <-chn
 		lgr.Info("shutting down server", zap.String("os signal", sig.String()))
+		stopJobs()
 		err := apiServer.Shutdown(context.Background())
 		if err != nil {
 			lgr.Error("error occurred during shutdown: ", zap.Error(err))
@@ -19,6 +19,6 @@ export const signOutRequest = async () => {
     return axiosV1Public.post('/auth/logout');
 }
 
-export const verify2FARequest = async (data: {email: string, code: string}) => {
+export const verify2FARequest = async (data: {mfaToken: string, code: string}) => {
     return axiosV1Public.post('/auth/verify-2fa', data)
 }
\ No newline at end of file
@@ -20,6 +20,7 @@ const SignIn: React.FC = () => {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [twoFactorCode, setTwoFactorCode] = useState("");
+  const [mfaToken, setMfaToken] = useState("");
   const [showTwoFactor, setShowTwoFactor] = useState(false);
   const [isLoading, setIsLoading] = useState(false);
   const { toast } = useToast();
@@ -42,7 +43,7 @@ const SignIn: React.FC = () => {
           return;
         }
 
-        const { data } = await verify2FARequest({ email, code: twoFactorCode });
+        const { data } = await verify2FARequest({ mfaToken, code: twoFactorCode });
         if (data) {
           const userRes = await getUserRequest();
           const user = userRes?.data;
@@ -81,7 +82,8 @@ const SignIn: React.FC = () => {
             }
           }
         } catch (error: any) {
-          if (error.response?.status === 403) {
+          if (error.response?.status === 403 && error.response?.data?.twoFactorRequired) {
+            setMfaToken(error.response.data.mfaToken);
             setShowTwoFactor(true);
             toast({
               title: "2FA Required",
@@ -111,7 +111,7 @@ describe('SignIn Component', () => {
   it('shows 2FA form when 2FA is required', async () => {
     const user = userEvent.setup()
     const error = {
-      response: { status: 403 },
+      response: { status: 403, data: { twoFactorRequired: true, mfaToken: 'fake-mfa-token' } },
     }
     
     vi.mocked(authAPI.loginRequest).mockRejectedValue(error)
@@ -136,7 +136,7 @@ describe('SignIn Component', () => {
   it('handles 2FA verification', async () => {
     const user = userEvent.setup()
     
-    const loginError = { response: { status: 403 } }
+    const loginError = { response: { status: 403, data: { twoFactorRequired: true, mfaToken: 'fake-mfa-token' } } }
     vi.mocked(authAPI.loginRequest).mockRejectedValue(loginError)
     
     renderSignIn()
@@ -169,7 +169,7 @@ describe('SignIn Component', () => {
     
     await waitFor(() => {
       expect(authAPI.verify2FARequest).toHaveBeenCalledWith({
-        email: 'test@example.com',
+        mfaToken: 'fake-mfa-token',
         code: '123456',
       })
     })
@@ -182,7 +182,7 @@ describe('SignIn Component', () => {
   it('can go back from 2FA form to login form', async () => {
     const user = userEvent.setup()
     
-    const loginError = { response: { status: 403 } }
+    const loginError = { response: { status: 403, data: { twoFactorRequired: true, mfaToken: 'fake-mfa-token' } } }
     vi.mocked(authAPI.loginRequest).mockRejectedValue(loginError)
     
     renderSignIn()